@@ -14,7 +14,396 @@
 
 package api
 
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
 // SeriesList is a list of time series sharing the same time range.
 type SeriesList struct {
 	Series []Timeseries `json:"series"`
+	// Annotations are key-value pairs (such as a unit or a description) which
+	// accompany the list in query results. Most functions discard them.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Annotate returns a copy of the list with the given annotation added,
+// replacing any earlier annotation with the same key.
+func (list SeriesList) Annotate(key string, value string) SeriesList {
+	annotations := make(map[string]string, len(list.Annotations)+1)
+	for k, v := range list.Annotations {
+		annotations[k] = v
+	}
+	annotations[key] = value
+	list.Annotations = annotations
+	return list
+}
+
+// Validate checks that every series in the list has exactly one value for each
+// slot of the timerange, returning a descriptive error for the first which
+// doesn't. Only buggy functions produce such lists, so it's meant for strict
+// modes rather than the hot path.
+func (list SeriesList) Validate(timerange Timerange) error {
+	slots := timerange.Slots()
+	for i, series := range list.Series {
+		if len(series.Values) != slots {
+			return fmt.Errorf("series %d (%s) has %d values, but the timerange from %d to %d at resolution %dms has %d slots", i, series.TagSet.Serialize(), len(series.Values), timerange.StartMillis(), timerange.EndMillis(), timerange.ResolutionMillis(), slots)
+		}
+	}
+	return nil
+}
+
+// MapSeriesList applies `f` to every value of every series in the list. The
+// result has the same tagsets (copied, so they may be modified freely),
+// provenance and timerange; the given list isn't modified.
+func MapSeriesList(list SeriesList, f func(float64) float64) SeriesList {
+	result := SeriesList{
+		Series: make([]Timeseries, len(list.Series)),
+	}
+	for i, series := range list.Series {
+		values := make([]float64, len(series.Values))
+		for j, value := range series.Values {
+			values[j] = f(value)
+		}
+		result.Series[i] = Timeseries{
+			Values:     values,
+			TagSet:     series.TagSet.Clone(),
+			Provenance: series.Provenance,
+		}
+	}
+	return result
+}
+
+// ResampleMethod chooses how ResampleValues computes the points which fall
+// between the original values.
+type ResampleMethod int
+
+const (
+	// ResampleHold takes the closest earlier value.
+	ResampleHold ResampleMethod = iota
+	// ResampleNearest takes the closest value.
+	ResampleNearest
+	// ResampleLinear interpolates linearly between the neighbouring values (or
+	// is NaN, if either is NaN).
+	ResampleLinear
+)
+
+// ResampleValues resamples the values, which are evenly spaced over a
+// timerange, onto `points` evenly spaced points over the same timerange.
+func ResampleValues(values []float64, points int, method ResampleMethod) []float64 {
+	result := make([]float64, points)
+	for i := range result {
+		if len(values) == 0 {
+			result[i] = math.NaN()
+			continue
+		}
+		position := 0.0
+		if points > 1 {
+			position = float64(i) * float64(len(values)-1) / float64(points-1)
+		}
+		lower := int(math.Floor(position))
+		fraction := position - float64(lower)
+		switch {
+		case fraction == 0 || method == ResampleHold:
+			result[i] = values[lower]
+		case method == ResampleNearest:
+			result[i] = values[int(math.Floor(position+0.5))]
+		default:
+			result[i] = values[lower] + fraction*(values[lower+1]-values[lower])
+		}
+	}
+	return result
+}
+
+// ResampleToFinest resamples series with fewer points than the others (which
+// were fetched at a coarser resolution over the same timerange) onto the
+// finest grid among them, holding each coarse value until the next one. It
+// reports whether any series was resampled. It fails when a coarser grid
+// doesn't evenly divide the finest one, since their samples wouldn't line up.
+func ResampleToFinest(series []Timeseries) ([]Timeseries, bool, error) {
+	finest := 0
+	for _, s := range series {
+		if len(s.Values) > finest {
+			finest = len(s.Values)
+		}
+	}
+	resampled := false
+	result := make([]Timeseries, len(series))
+	for i, s := range series {
+		points := len(s.Values)
+		if points == finest {
+			result[i] = s
+			continue
+		}
+		if points < 2 || (finest-1)%(points-1) != 0 {
+			return nil, false, fmt.Errorf("cannot combine series of %d and %d points, since their resolutions don't align", points, finest)
+		}
+		result[i] = Timeseries{Values: ResampleValues(s.Values, finest, ResampleHold), TagSet: s.TagSet, Provenance: s.Provenance}
+		resampled = true
+	}
+	return result, resampled, nil
+}
+
+// Downsample reduces every series in the list, which covers the given
+// timerange, to at most maxPoints values. Consecutive values are combined into
+// buckets by the reducer (which is given every value in a bucket, including
+// NaNs). The returned timerange describes the buckets exactly: its resolution
+// is a whole multiple of the original and bucket i holds the values from
+// Start()+i*Resolution() up to (but excluding) the next bucket, so timestamps
+// reconstructed from its start and resolution are correct. The buckets are
+// aligned to their resolution, so the first and last may hold fewer values.
+// A list which already fits is returned unchanged.
+func (list SeriesList) Downsample(timerange Timerange, maxPoints int, reducer func([]float64) float64) (SeriesList, Timerange, error) {
+	if maxPoints < 1 {
+		return SeriesList{}, Timerange{}, fmt.Errorf("max points must be at least 1 (got %d)", maxPoints)
+	}
+	if err := list.Validate(timerange); err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	slots := timerange.Slots()
+	if slots <= maxPoints {
+		return list, timerange, nil
+	}
+	var downsampled Timerange
+	for factor := int64((slots + maxPoints - 1) / maxPoints); ; factor++ {
+		resolution := factor * timerange.resolution
+		downsampled = Timerange{
+			start:      floorTo(timerange.start, resolution),
+			end:        floorTo(timerange.end, resolution),
+			resolution: resolution,
+		}
+		if downsampled.Slots() <= maxPoints {
+			break
+		}
+	}
+	result := SeriesList{
+		Series:      make([]Timeseries, len(list.Series)),
+		Annotations: list.Annotations,
+	}
+	for i, series := range list.Series {
+		values := make([]float64, downsampled.Slots())
+		bucket := []float64{}
+		current := 0
+		for j, value := range series.Values {
+			index := int((timerange.start + int64(j)*timerange.resolution - downsampled.start) / downsampled.resolution)
+			if index != current {
+				values[current] = reducer(bucket)
+				bucket = bucket[:0]
+				current = index
+			}
+			bucket = append(bucket, value)
+		}
+		values[current] = reducer(bucket)
+		result.Series[i] = Timeseries{
+			Values:     values,
+			TagSet:     series.TagSet,
+			Provenance: series.Provenance,
+		}
+	}
+	return result, downsampled, nil
+}
+
+// Last returns the end of every series in the list, which covers the given
+// timerange, keeping only the values within the given duration of the end
+// (inclusive, so the last 15 minutes at a 1 minute resolution hold 16 values).
+// The returned timerange describes the kept values. A duration at least as long
+// as the timerange keeps the whole list.
+func (list SeriesList) Last(timerange Timerange, duration time.Duration) (SeriesList, Timerange, error) {
+	if duration < 0 {
+		return SeriesList{}, Timerange{}, fmt.Errorf("duration must not be negative (got %+v)", duration)
+	}
+	if err := list.Validate(timerange); err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	kept := int64(duration/time.Millisecond) / timerange.resolution
+	if kept >= int64(timerange.Slots()-1) {
+		return list, timerange, nil
+	}
+	last := Timerange{
+		start:      timerange.end - kept*timerange.resolution,
+		end:        timerange.end,
+		resolution: timerange.resolution,
+	}
+	skipped := timerange.Slots() - last.Slots()
+	result := SeriesList{
+		Series:      make([]Timeseries, len(list.Series)),
+		Annotations: list.Annotations,
+	}
+	for i, series := range list.Series {
+		series.Values = series.Values[skipped:]
+		result.Series[i] = series
+	}
+	return result, last, nil
+}
+
+// Decimate keeps only every factor-th value of every series in the list, which
+// covers the given timerange, dropping the rest rather than combining them.
+// The values kept are those whose timestamps are multiples of factor times
+// the resolution, so that the same points are kept as the timerange moves.
+// The returned timerange describes the kept values. If none of the timerange's
+// timestamps are such multiples (because it's shorter than factor slots), the
+// list is returned unchanged.
+func (list SeriesList) Decimate(timerange Timerange, factor int) (SeriesList, Timerange, error) {
+	if factor < 1 {
+		return SeriesList{}, Timerange{}, fmt.Errorf("factor must be at least 1 (got %d)", factor)
+	}
+	if err := list.Validate(timerange); err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	resolution := int64(factor) * timerange.resolution
+	decimated := Timerange{
+		start:      -floorTo(-timerange.start, resolution),
+		end:        floorTo(timerange.end, resolution),
+		resolution: resolution,
+	}
+	if factor == 1 || decimated.start > decimated.end {
+		return list, timerange, nil
+	}
+	skipped := int((decimated.start - timerange.start) / timerange.resolution)
+	result := SeriesList{
+		Series:      make([]Timeseries, len(list.Series)),
+		Annotations: list.Annotations,
+	}
+	for i, series := range list.Series {
+		values := make([]float64, decimated.Slots())
+		for j := range values {
+			values[j] = series.Values[skipped+j*factor]
+		}
+		series.Values = values
+		result.Series[i] = series
+	}
+	return result, decimated, nil
+}
+
+// FillTimerange makes every series in the list have exactly one value for
+// each slot of the timerange, as though the list were valid for it. A series'
+// values are taken to begin at its Start, or at the timerange's start if it
+// has none: values outside the timerange are dropped, and missing values
+// (whether leading or trailing) are NaN. A list which already matches is
+// returned unchanged.
+func (list SeriesList) FillTimerange(timerange Timerange) SeriesList {
+	if list.Validate(timerange) == nil && !list.hasStarts(timerange) {
+		return list
+	}
+	slots := timerange.Slots()
+	result := SeriesList{
+		Series:      make([]Timeseries, len(list.Series)),
+		Annotations: list.Annotations,
+	}
+	for i, series := range list.Series {
+		offset := 0 // the slot of the series' first value
+		if !series.Start.IsZero() {
+			offset = int((series.Start.UnixNano()/int64(time.Millisecond) - timerange.StartMillis()) / timerange.ResolutionMillis())
+		}
+		if offset != 0 || len(series.Values) != slots {
+			values := make([]float64, slots)
+			for j := range values {
+				values[j] = math.NaN()
+				if k := j - offset; k >= 0 && k < len(series.Values) {
+					values[j] = series.Values[k]
+				}
+			}
+			series.Values = values
+		}
+		series.Start = time.Time{}
+		result.Series[i] = series
+	}
+	return result
+}
+
+// hasStarts is true if a series in the list has a Start other than the
+// timerange's.
+func (list SeriesList) hasStarts(timerange Timerange) bool {
+	for _, series := range list.Series {
+		if !series.Start.IsZero() && !series.Start.Equal(timerange.Start()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge stitches together two lists covering timeranges at the same resolution
+// which don't overlap, such as adjacent windows fetched while paging through
+// history, in either order. The returned timerange spans both; any gap between
+// them, and the window of a series missing from one of the lists, is NaN.
+// Series are matched by tagset, keeping the order in which they first appear
+// (the earlier window's first), and the earlier list's annotations are kept.
+// A list with several series with the same tagset can't be merged.
+func (list SeriesList) Merge(timerange Timerange, other SeriesList, otherTimerange Timerange) (SeriesList, Timerange, error) {
+	if timerange.resolution != otherTimerange.resolution {
+		return SeriesList{}, Timerange{}, fmt.Errorf("cannot merge lists at resolutions %dms and %dms", timerange.resolution, otherTimerange.resolution)
+	}
+	if err := list.Validate(timerange); err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	if err := other.Validate(otherTimerange); err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	if otherTimerange.start < timerange.start {
+		list, other = other, list
+		timerange, otherTimerange = otherTimerange, timerange
+	}
+	if otherTimerange.start <= timerange.end {
+		return SeriesList{}, Timerange{}, fmt.Errorf("cannot merge overlapping timeranges from %d to %d and from %d to %d", timerange.start, timerange.end, otherTimerange.start, otherTimerange.end)
+	}
+	if (otherTimerange.start-timerange.start)%timerange.resolution != 0 {
+		return SeriesList{}, Timerange{}, fmt.Errorf("cannot merge timeranges starting at %d and %d, which aren't aligned to the resolution %dms", timerange.start, otherTimerange.start, timerange.resolution)
+	}
+	merged := Timerange{
+		start:      timerange.start,
+		end:        otherTimerange.end,
+		resolution: timerange.resolution,
+	}
+	offset := int((otherTimerange.start - timerange.start) / timerange.resolution)
+	firstIndex, err := list.IndexByTagSet()
+	if err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	secondIndex, err := other.IndexByTagSet()
+	if err != nil {
+		return SeriesList{}, Timerange{}, err
+	}
+	result := SeriesList{Annotations: list.Annotations}
+	// place copies the series' values into a new NaN series covering merged.
+	place := func(series Timeseries, offset int) Timeseries {
+		values := make([]float64, merged.Slots())
+		for i := range values {
+			values[i] = math.NaN()
+		}
+		copy(values[offset:], series.Values)
+		return Timeseries{
+			Values:     values,
+			TagSet:     series.TagSet,
+			Provenance: series.Provenance,
+		}
+	}
+	for _, series := range list.Series {
+		placed := place(series, 0)
+		if i, ok := secondIndex[series.TagSet.Serialize()]; ok {
+			copy(placed.Values[offset:], other.Series[i].Values)
+		}
+		result.Series = append(result.Series, placed)
+	}
+	for _, series := range other.Series {
+		if _, ok := firstIndex[series.TagSet.Serialize()]; !ok {
+			result.Series = append(result.Series, place(series, offset))
+		}
+	}
+	return result, merged, nil
+}
+
+// IndexByTagSet maps the serialized tagset of each series in the list to its
+// position, for matching the series to those of another list. It fails if
+// several series have the same tagset, since they couldn't be told apart.
+func (list SeriesList) IndexByTagSet() (map[string]int, error) {
+	index := make(map[string]int, len(list.Series))
+	for i, series := range list.Series {
+		key := series.TagSet.Serialize()
+		if _, ok := index[key]; ok {
+			return nil, fmt.Errorf("several series have the tagset {%s}", key)
+		}
+		index[key] = i
+	}
+	return index, nil
 }
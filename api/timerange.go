@@ -121,6 +121,29 @@ func NewSnappedTimerange(start, end, resolution int64) (Timerange, error) {
 	return Timerange{start: start, end: end, resolution: resolution}.Snap(), nil
 }
 
+// NewAlignedTimerange creates the smallest timerange with the given resolution
+// which covers the given start and end: the start is rounded down and the end
+// is rounded up to multiples of the resolution. Unlike NewSnappedTimerange, it
+// never leaves out part of the requested range.
+func NewAlignedTimerange(start, end, resolution int64) (Timerange, error) {
+	if resolution <= 0 {
+		return Timerange{}, fmt.Errorf("invalid resolution %d", resolution)
+	}
+	if start > end {
+		return Timerange{}, fmt.Errorf("start must be <= end (start=%d, end=%d)", start, end)
+	}
+	return Timerange{start: floorTo(start, resolution), end: -floorTo(-end, resolution), resolution: resolution}, nil
+}
+
+// floorTo rounds n down (towards -infinity) to a multiple of boundary.
+func floorTo(n, boundary int64) int64 {
+	remainder := n % boundary
+	if remainder < 0 {
+		remainder += boundary
+	}
+	return n - remainder
+}
+
 func snap(n, boundary int64) int64 {
 	if n < 0 {
 		return -snap(-n, boundary)
@@ -199,3 +222,63 @@ func (tr Timerange) IndexOfTime(point time.Time) int {
 func (tr Timerange) Interval() Interval {
 	return Interval{Start: tr.Start(), End: tr.End()}
 }
+
+// NiceResolutions are the resolutions QueryRange chooses between, finest
+// first. Each lands on round clock boundaries, so aligned timeranges line up
+// with wall-clock minutes, hours and days.
+var NiceResolutions = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// QueryRange creates the aligned timerange covering [start, end] (in
+// milliseconds) with the finest of the NiceResolutions which has at most
+// maxPoints slots. Ranges too long for any of them use a whole number of days.
+func QueryRange(start, end int64, maxPoints int) (Timerange, error) {
+	if maxPoints < 3 {
+		// An aligned timerange can have 3 slots, however coarse its resolution.
+		return Timerange{}, fmt.Errorf("max points must be at least 3 (got %d)", maxPoints)
+	}
+	if start > end {
+		return Timerange{}, fmt.Errorf("start must be <= end (start=%d, end=%d)", start, end)
+	}
+	for _, resolution := range NiceResolutions {
+		timerange, err := NewAlignedTimerange(start, end, int64(resolution/time.Millisecond))
+		if err != nil {
+			return Timerange{}, err
+		}
+		if timerange.Slots() <= maxPoints {
+			return timerange, nil
+		}
+	}
+	day := int64(24 * time.Hour / time.Millisecond)
+	days := (end - start) / (day * int64(maxPoints-2))
+	if days < 1 {
+		days = 1
+	}
+	for {
+		timerange, err := NewAlignedTimerange(start, end, days*day)
+		if err != nil {
+			return Timerange{}, err
+		}
+		if timerange.Slots() <= maxPoints {
+			return timerange, nil
+		}
+		days++
+	}
+}
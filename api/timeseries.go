@@ -19,12 +19,38 @@ import (
 	"encoding/json"
 	"math"
 	"strconv"
+	"time"
 )
 
 // Timeseries is a single time series, identified with the associated tagset.
 type Timeseries struct {
 	Values []float64 `json:"values"`
 	TagSet TagSet    `json:"tagset"`
+	// Provenance describes the fetches (such as `cpu[host = "a"]`) which the
+	// series was computed from. It isn't part of the series' identity.
+	Provenance string `json:"provenance,omitempty"`
+	// Start is the time of the first value, set by a storage backend whose
+	// values don't begin at the start of the requested timerange. It's zero
+	// otherwise. SeriesList.FillTimerange uses it to align the values.
+	Start time.Time `json:"-"`
+}
+
+// CombineProvenance joins the distinct provenances of the given series, in
+// order of appearance.
+func CombineProvenance(series []Timeseries) string {
+	seen := map[string]bool{}
+	var buffer bytes.Buffer
+	for _, s := range series {
+		if s.Provenance == "" || seen[s.Provenance] {
+			continue
+		}
+		seen[s.Provenance] = true
+		if buffer.Len() > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(s.Provenance)
+	}
+	return buffer.String()
 }
 
 // MarshalJSON exists to manually encode floats.
@@ -47,6 +73,15 @@ func (ts Timeseries) MarshalJSON() ([]byte, error) {
 		}
 		buffer.WriteString(strconv.FormatFloat(y, 'g', -1, 64))
 	}
-	buffer.WriteString("]}")
+	buffer.WriteString("]")
+	if ts.Provenance != "" {
+		buffer.WriteString(`,"provenance":`)
+		provenance, err := json.Marshal(ts.Provenance)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(provenance)
+	}
+	buffer.WriteString("}")
 	return buffer.Bytes(), nil
 }
@@ -17,7 +17,9 @@ package api
 import (
 	"encoding/json"
 	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/square/metrics/testing_support/assert"
 )
@@ -134,3 +136,493 @@ func TestTimeseries_MarshalJSON(t *testing.T) {
 		a.Eq(string(encoded), suite.expected)
 	}
 }
+
+func TestNewAlignedTimerange(t *testing.T) {
+	tests := []struct {
+		start, end, resolution int64
+		expectedStart          int64
+		expectedEnd            int64
+		fails                  bool
+	}{
+		{start: 0, end: 120, resolution: 30, expectedStart: 0, expectedEnd: 120},
+		{start: 10, end: 110, resolution: 30, expectedStart: 0, expectedEnd: 120},
+		{start: 29, end: 91, resolution: 30, expectedStart: 0, expectedEnd: 120},
+		{start: -10, end: -1, resolution: 30, expectedStart: -30, expectedEnd: 0},
+		{start: -30, end: 30, resolution: 30, expectedStart: -30, expectedEnd: 30},
+		{start: 15, end: 15, resolution: 30, expectedStart: 0, expectedEnd: 30},
+		{start: 20, end: 10, resolution: 30, fails: true},
+		{start: 0, end: 10, resolution: 0, fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("[%d, %d] at %d", test.start, test.end, test.resolution)
+		timerange, err := NewAlignedTimerange(test.start, test.end, test.resolution)
+		if test.fails {
+			if err == nil {
+				a.Errorf("expected an error but got %+v", timerange)
+			}
+			continue
+		}
+		a.CheckError(err)
+		a.Eq(timerange.StartMillis(), test.expectedStart)
+		a.Eq(timerange.EndMillis(), test.expectedEnd)
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	hour := int64(time.Hour / time.Millisecond)
+	day := 24 * hour
+	tests := []struct {
+		start, end int64
+		maxPoints  int
+		resolution time.Duration
+		fails      bool
+	}{
+		{start: 0, end: hour, maxPoints: 100, resolution: time.Minute},
+		{start: 0, end: hour, maxPoints: 1000, resolution: 5 * time.Second},
+		{start: 0, end: 7 * day, maxPoints: 200, resolution: time.Hour},
+		{start: 10, end: 59990, maxPoints: 3, resolution: 30 * time.Second},
+		{start: 0, end: 365 * day, maxPoints: 10, resolution: 45 * 24 * time.Hour},
+		{start: 0, end: hour, maxPoints: 2, fails: true},
+		{start: hour, end: 0, maxPoints: 100, fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("[%d, %d] in %d points", test.start, test.end, test.maxPoints)
+		timerange, err := QueryRange(test.start, test.end, test.maxPoints)
+		if test.fails {
+			if err == nil {
+				a.Errorf("expected an error but got %+v", timerange)
+			}
+			continue
+		}
+		a.CheckError(err)
+		a.Eq(timerange.Resolution(), test.resolution)
+		a.EqBool(timerange.Slots() <= test.maxPoints, true)
+		a.EqBool(timerange.StartMillis() <= test.start && test.end <= timerange.EndMillis(), true)
+	}
+}
+
+func TestMapSeriesList(t *testing.T) {
+	a := assert.New(t)
+	list := SeriesList{
+		Series: []Timeseries{
+			{Values: []float64{1, 2, math.NaN()}, TagSet: TagSet{"host": "a"}},
+			{Values: []float64{-3, 0, 4}, TagSet: TagSet{"host": "b"}},
+		},
+	}
+	result := MapSeriesList(list, func(x float64) float64 { return 2 * x })
+	a.EqInt(len(result.Series), 2)
+	a.EqFloatArray(result.Series[0].Values, []float64{2, 4, math.NaN()}, 0)
+	a.Eq(result.Series[0].TagSet, TagSet{"host": "a"})
+	a.EqFloatArray(result.Series[1].Values, []float64{-6, 0, 8}, 0)
+	a.Eq(result.Series[1].TagSet, TagSet{"host": "b"})
+
+	// Modifying the result leaves the input untouched.
+	result.Series[0].Values[0] = 100
+	result.Series[0].TagSet["host"] = "changed"
+	a.EqFloatArray(list.Series[0].Values, []float64{1, 2, math.NaN()}, 0)
+	a.Eq(list.Series[0].TagSet, TagSet{"host": "a"})
+	a.EqFloatArray(list.Series[1].Values, []float64{-3, 0, 4}, 0)
+
+	a.EqInt(len(MapSeriesList(SeriesList{}, math.Abs).Series), 0)
+}
+
+func TestResampleToFinest(t *testing.T) {
+	a := assert.New(t)
+	series := []Timeseries{
+		{Values: []float64{1, 2, 3, 4, 5}, TagSet: TagSet{"host": "fine"}},
+		{Values: []float64{10, 20, 30}, TagSet: TagSet{"host": "coarse"}},
+	}
+	result, resampled, err := ResampleToFinest(series)
+	a.CheckError(err)
+	a.EqBool(resampled, true)
+	a.EqFloatArray(result[0].Values, []float64{1, 2, 3, 4, 5}, 0)
+	a.EqFloatArray(result[1].Values, []float64{10, 10, 20, 20, 30}, 0)
+	a.Eq(result[1].TagSet, TagSet{"host": "coarse"})
+	a.EqFloatArray(series[1].Values, []float64{10, 20, 30}, 0)
+
+	_, resampled, err = ResampleToFinest(series[:1])
+	a.CheckError(err)
+	a.EqBool(resampled, false)
+
+	// 4 points can't be laid onto a grid of 5.
+	if _, _, err := ResampleToFinest([]Timeseries{series[0], {Values: []float64{1, 2, 3, 4}}}); err == nil {
+		a.Errorf("Expected an error for misaligned resolutions")
+	}
+}
+
+func TestResampleValues(t *testing.T) {
+	a := assert.New(t)
+	values := []float64{0, 10, math.NaN()}
+	a.EqFloatArray(ResampleValues(values, 5, ResampleHold), []float64{0, 0, 10, 10, math.NaN()}, 0)
+	a.EqFloatArray(ResampleValues(values, 5, ResampleNearest), []float64{0, 10, 10, math.NaN(), math.NaN()}, 0)
+	a.EqFloatArray(ResampleValues(values, 5, ResampleLinear), []float64{0, 5, 10, math.NaN(), math.NaN()}, 0)
+	a.EqFloatArray(ResampleValues([]float64{0, 4, 8}, 2, ResampleLinear), []float64{0, 8}, 0)
+	a.EqFloatArray(ResampleValues(nil, 2, ResampleLinear), []float64{math.NaN(), math.NaN()}, 0)
+}
+
+func TestTimeseriesProvenance(t *testing.T) {
+	a := assert.New(t)
+	series := []Timeseries{
+		{Values: []float64{1}, Provenance: "cpu"},
+		{Values: []float64{2}},
+		{Values: []float64{3}, Provenance: "mem"},
+		{Values: []float64{4}, Provenance: "cpu"},
+	}
+	a.EqString(CombineProvenance(series), "cpu, mem")
+	a.EqString(CombineProvenance(series[1:2]), "")
+	encoded, err := json.Marshal(series[0])
+	a.CheckError(err)
+	a.EqString(string(encoded), `{"tagset":null,"values":[1],"provenance":"cpu"}`)
+	encoded, err = json.Marshal(series[1])
+	a.CheckError(err)
+	a.EqString(string(encoded), `{"tagset":null,"values":[2]}`)
+}
+
+func TestSeriesListValidate(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := NewTimerange(0, 90, 30)
+	a.CheckError(err)
+	valid := SeriesList{Series: []Timeseries{
+		{Values: []float64{1, 2, 3, 4}, TagSet: TagSet{"host": "a"}},
+		{Values: []float64{math.NaN(), 2, 3, 4}, TagSet: TagSet{"host": "b"}},
+	}}
+	a.CheckError(valid.Validate(timerange))
+	a.CheckError(SeriesList{}.Validate(timerange))
+	for _, values := range [][]float64{{1, 2, 3}, {1, 2, 3, 4, 5}, nil} {
+		invalid := SeriesList{Series: []Timeseries{
+			{Values: []float64{1, 2, 3, 4}, TagSet: TagSet{"host": "a"}},
+			{Values: values, TagSet: TagSet{"host": "b"}},
+		}}
+		if err := invalid.Validate(timerange); err == nil {
+			a.Errorf("expected an error validating %d values", len(values))
+		} else if !strings.Contains(err.Error(), "series 1 (host=b)") {
+			a.Errorf("expected the error to identify the series but got %q", err.Error())
+		}
+	}
+}
+
+func TestSeriesListDownsample(t *testing.T) {
+	minimum := func(values []float64) float64 {
+		result := math.Inf(1)
+		for _, value := range values {
+			result = math.Min(result, value)
+		}
+		return result
+	}
+	tests := []struct {
+		start, end, resolution int64
+		maxPoints              int
+		expected               []float64 // when the buckets are easily worked out by hand
+	}{
+		{start: 0, end: 270, resolution: 30, maxPoints: 5, expected: []float64{0, 2, 4, 6, 8}},
+		{start: 30, end: 270, resolution: 30, maxPoints: 5, expected: []float64{0, 1, 3, 5, 7}},
+		{start: 0, end: 270, resolution: 30, maxPoints: 3},
+		{start: 90, end: 3000, resolution: 30, maxPoints: 7},
+		{start: 1000, end: 1000000, resolution: 1000, maxPoints: 100},
+		{start: 1000, end: 1000000, resolution: 1000, maxPoints: 1},
+		{start: 60, end: 60, resolution: 30, maxPoints: 1},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test)
+		timerange, err := NewTimerange(test.start, test.end, test.resolution)
+		a.CheckError(err)
+		values := make([]float64, timerange.Slots())
+		for i := range values {
+			values[i] = float64(i)
+		}
+		list := SeriesList{Series: []Timeseries{{Values: values, TagSet: TagSet{"host": "a"}}}}
+		result, downsampled, err := list.Downsample(timerange, test.maxPoints, minimum)
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		a.CheckError(result.Validate(downsampled))
+		if downsampled.Slots() > test.maxPoints {
+			a.Errorf("Expected at most %d points but got %d", test.maxPoints, downsampled.Slots())
+		}
+		if downsampled.ResolutionMillis()%timerange.ResolutionMillis() != 0 {
+			a.Errorf("Expected a multiple of the original resolution but got %dms", downsampled.ResolutionMillis())
+		}
+		if _, err := NewTimerange(downsampled.StartMillis(), downsampled.EndMillis(), downsampled.ResolutionMillis()); err != nil {
+			a.Errorf("Expected a valid timerange but got %s", err.Error())
+		}
+		if test.expected != nil {
+			a.EqFloatArray(result.Series[0].Values, test.expected, 0)
+		}
+		// Each bucket's timestamp, reconstructed from the start and resolution,
+		// is no later than its first value and after the previous bucket's last.
+		for k, value := range result.Series[0].Values {
+			bucketStart := downsampled.Start().Add(time.Duration(k) * downsampled.Resolution())
+			first := timerange.TimeOfIndex(int(value))
+			if first.Before(bucketStart) || !first.Before(bucketStart.Add(downsampled.Resolution())) {
+				a.Errorf("Bucket %d at %s holds a value from %s", k, bucketStart, first)
+			}
+			if value > 0 && !timerange.TimeOfIndex(int(value)-1).Before(bucketStart) {
+				a.Errorf("Bucket %d at %s doesn't hold the value from %s", k, bucketStart, timerange.TimeOfIndex(int(value)-1))
+			}
+		}
+	}
+
+	a := assert.New(t)
+	timerange, err := NewTimerange(0, 90, 30)
+	a.CheckError(err)
+	list := SeriesList{Series: []Timeseries{{Values: []float64{1, 2, 3, 4}}}}
+	result, unchanged, err := list.Downsample(timerange, 4, minimum)
+	a.CheckError(err)
+	a.Eq(unchanged, timerange)
+	a.EqFloatArray(result.Series[0].Values, []float64{1, 2, 3, 4}, 0)
+	if _, _, err := list.Downsample(timerange, 0, minimum); err == nil {
+		a.Errorf("Expected an error downsampling to no points")
+	}
+	if _, _, err := (SeriesList{Series: []Timeseries{{Values: []float64{1, 2}}}}).Downsample(timerange, 1, minimum); err == nil {
+		a.Errorf("Expected an error downsampling a list which doesn't match its timerange")
+	}
+}
+
+func TestSeriesListLast(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := NewTimerange(0, 150, 30)
+	a.CheckError(err)
+	list := SeriesList{Series: []Timeseries{{Values: []float64{1, 2, 3, 4, 5, 6}, TagSet: TagSet{"host": "a"}}}}
+
+	result, last, err := list.Last(timerange, 60*time.Millisecond)
+	a.CheckError(err)
+	a.EqInt(int(last.StartMillis()), 90)
+	a.EqInt(int(last.EndMillis()), 150)
+	a.EqInt(int(last.ResolutionMillis()), 30)
+	a.CheckError(result.Validate(last))
+	a.EqFloatArray(result.Series[0].Values, []float64{4, 5, 6}, 0)
+	a.Eq(result.Series[0].TagSet, TagSet{"host": "a"})
+
+	// Durations which aren't a multiple of the resolution are rounded down.
+	result, last, err = list.Last(timerange, 50*time.Millisecond)
+	a.CheckError(err)
+	a.EqInt(int(last.StartMillis()), 120)
+	a.EqFloatArray(result.Series[0].Values, []float64{5, 6}, 0)
+
+	result, last, err = list.Last(timerange, 0)
+	a.CheckError(err)
+	a.EqFloatArray(result.Series[0].Values, []float64{6}, 0)
+
+	// A duration longer than the timerange keeps everything.
+	result, last, err = list.Last(timerange, time.Hour)
+	a.CheckError(err)
+	a.Eq(last, timerange)
+	a.EqFloatArray(result.Series[0].Values, []float64{1, 2, 3, 4, 5, 6}, 0)
+
+	if _, _, err := list.Last(timerange, -time.Second); err == nil {
+		a.Errorf("Expected an error for a negative duration")
+	}
+	if _, _, err := (SeriesList{Series: []Timeseries{{Values: []float64{1, 2}}}}).Last(timerange, 0); err == nil {
+		a.Errorf("Expected an error for a list which doesn't match its timerange")
+	}
+}
+
+func TestSeriesListDecimate(t *testing.T) {
+	tests := []struct {
+		start, end, resolution int64
+		factor                 int
+		expected               []float64
+	}{
+		{start: 0, end: 270, resolution: 30, factor: 4, expected: []float64{0, 4, 8}},
+		{start: 30, end: 270, resolution: 30, factor: 4, expected: []float64{3, 7}},
+		{start: 60, end: 300, resolution: 30, factor: 3, expected: []float64{1, 4, 7}},
+		{start: 0, end: 90, resolution: 30, factor: 1, expected: []float64{0, 1, 2, 3}},
+		{start: 0, end: 90, resolution: 30, factor: 5, expected: []float64{0}},
+		{start: 30, end: 90, resolution: 30, factor: 5, expected: []float64{0, 1, 2}}, // no point is aligned
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test)
+		timerange, err := NewTimerange(test.start, test.end, test.resolution)
+		a.CheckError(err)
+		values := make([]float64, timerange.Slots())
+		for i := range values {
+			values[i] = float64(i)
+		}
+		list := SeriesList{Series: []Timeseries{{Values: values, TagSet: TagSet{"host": "a"}}}}
+		result, decimated, err := list.Decimate(timerange, test.factor)
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		a.CheckError(result.Validate(decimated))
+		a.EqFloatArray(result.Series[0].Values, test.expected, 0)
+		a.Eq(result.Series[0].TagSet, TagSet{"host": "a"})
+		if _, err := NewTimerange(decimated.StartMillis(), decimated.EndMillis(), decimated.ResolutionMillis()); err != nil {
+			a.Errorf("Expected a valid timerange but got %s", err.Error())
+		}
+		// Each kept value's timestamp, reconstructed from the decimated timerange,
+		// is the one it had originally.
+		for k, value := range result.Series[0].Values {
+			if !decimated.TimeOfIndex(k).Equal(timerange.TimeOfIndex(int(value))) {
+				a.Errorf("Value %d is at %s but came from %s", k, decimated.TimeOfIndex(k), timerange.TimeOfIndex(int(value)))
+			}
+		}
+	}
+
+	a := assert.New(t)
+	timerange, err := NewTimerange(0, 90, 30)
+	a.CheckError(err)
+	if _, _, err := (SeriesList{Series: []Timeseries{{Values: []float64{1, 2, 3, 4}}}}).Decimate(timerange, 0); err == nil {
+		a.Errorf("Expected an error for a factor of 0")
+	}
+	if _, _, err := (SeriesList{Series: []Timeseries{{Values: []float64{1, 2}}}}).Decimate(timerange, 2); err == nil {
+		a.Errorf("Expected an error for a list which doesn't match its timerange")
+	}
+}
+
+func TestSeriesListFillTimerange(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := NewTimerange(0, 90, 30)
+	a.CheckError(err)
+	nan := math.NaN()
+
+	matching := SeriesList{Series: []Timeseries{{Values: []float64{1, 2, 3, 4}, TagSet: TagSet{"host": "a"}}}}
+	filled := matching.FillTimerange(timerange)
+	filled.Series[0].Values[0] = 100 // the list is returned unchanged, so this is visible in it
+	a.EqFloat(matching.Series[0].Values[0], 100, 0)
+
+	ragged := SeriesList{
+		Series: []Timeseries{
+			{Values: []float64{1, 2}, TagSet: TagSet{"host": "short"}},
+			{Values: []float64{1, 2, 3, 4}, TagSet: TagSet{"host": "exact"}},
+			{Values: []float64{1, 2, 3, 4, 5, 6}, TagSet: TagSet{"host": "long"}},
+			{Values: nil, TagSet: TagSet{"host": "empty"}},
+		},
+		Annotations: map[string]string{"unit": "bytes"},
+	}
+	filled = ragged.FillTimerange(timerange)
+	a.CheckError(filled.Validate(timerange))
+	a.EqFloatArray(filled.Series[0].Values, []float64{1, 2, nan, nan}, 0)
+	a.EqFloatArray(filled.Series[1].Values, []float64{1, 2, 3, 4}, 0)
+	a.EqFloatArray(filled.Series[2].Values, []float64{1, 2, 3, 4}, 0)
+	a.EqFloatArray(filled.Series[3].Values, []float64{nan, nan, nan, nan}, 0)
+	a.Eq(filled.Series[2].TagSet, TagSet{"host": "long"})
+	a.Eq(filled.Annotations, map[string]string{"unit": "bytes"})
+	// The original list isn't modified.
+	a.EqInt(len(ragged.Series[0].Values), 2)
+	a.EqInt(len(ragged.Series[2].Values), 6)
+
+	// Series with a Start are aligned to it.
+	started := SeriesList{
+		Series: []Timeseries{
+			{Values: []float64{2, 3, 4}, TagSet: TagSet{"host": "late"}, Start: time.Unix(0, 30*int64(time.Millisecond))},
+			{Values: []float64{0, 1, 2, 3, 4}, TagSet: TagSet{"host": "early"}, Start: time.Unix(0, -30*int64(time.Millisecond))},
+			{Values: []float64{3}, TagSet: TagSet{"host": "middle"}, Start: time.Unix(0, 60*int64(time.Millisecond))},
+			{Values: []float64{1, 2, 3, 4}, TagSet: TagSet{"host": "exact"}, Start: time.Unix(0, 0)},
+		},
+	}
+	filled = started.FillTimerange(timerange)
+	a.CheckError(filled.Validate(timerange))
+	a.EqFloatArray(filled.Series[0].Values, []float64{nan, 2, 3, 4}, 0)
+	a.EqFloatArray(filled.Series[1].Values, []float64{1, 2, 3, 4}, 0)
+	a.EqFloatArray(filled.Series[2].Values, []float64{nan, nan, 3, nan}, 0)
+	a.EqFloatArray(filled.Series[3].Values, []float64{1, 2, 3, 4}, 0)
+	a.EqBool(filled.Series[0].Start.IsZero(), true)
+}
+
+func TestSeriesListMerge(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	first, err := NewTimerange(0, 60, 30)
+	a.CheckError(err)
+	adjacent, err := NewTimerange(90, 120, 30)
+	a.CheckError(err)
+	gapped, err := NewTimerange(150, 180, 30)
+	a.CheckError(err)
+
+	earlier := SeriesList{
+		Series: []Timeseries{
+			{Values: []float64{1, 2, 3}, TagSet: TagSet{"host": "a"}},
+			{Values: []float64{4, 5, 6}, TagSet: TagSet{"host": "b"}},
+		},
+		Annotations: map[string]string{"unit": "bytes"},
+	}
+	later := SeriesList{
+		Series: []Timeseries{
+			{Values: []float64{7, 8}, TagSet: TagSet{"host": "b"}},
+			{Values: []float64{9, 10}, TagSet: TagSet{"host": "c"}},
+		},
+	}
+
+	merged, timerange, err := earlier.Merge(first, later, adjacent)
+	a.CheckError(err)
+	a.Eq(timerange, Timerange{start: 0, end: 120, resolution: 30})
+	a.CheckError(merged.Validate(timerange))
+	a.EqInt(len(merged.Series), 3)
+	a.Eq(merged.Series[0].TagSet, TagSet{"host": "a"})
+	a.EqFloatArray(merged.Series[0].Values, []float64{1, 2, 3, nan, nan}, 0)
+	a.Eq(merged.Series[1].TagSet, TagSet{"host": "b"})
+	a.EqFloatArray(merged.Series[1].Values, []float64{4, 5, 6, 7, 8}, 0)
+	a.Eq(merged.Series[2].TagSet, TagSet{"host": "c"})
+	a.EqFloatArray(merged.Series[2].Values, []float64{nan, nan, nan, 9, 10}, 0)
+	a.Eq(merged.Annotations, map[string]string{"unit": "bytes"})
+
+	// The order of the windows doesn't matter.
+	reversed, reversedTimerange, err := later.Merge(adjacent, earlier, first)
+	a.CheckError(err)
+	a.Eq(reversedTimerange, timerange)
+	a.EqInt(len(reversed.Series), len(merged.Series))
+	for i := range merged.Series {
+		a.Eq(reversed.Series[i].TagSet, merged.Series[i].TagSet)
+		a.EqFloatArray(reversed.Series[i].Values, merged.Series[i].Values, 0)
+	}
+
+	merged, timerange, err = earlier.Merge(first, later, gapped)
+	a.CheckError(err)
+	a.Eq(timerange, Timerange{start: 0, end: 180, resolution: 30})
+	a.EqFloatArray(merged.Series[1].Values, []float64{4, 5, 6, nan, nan, 7, 8}, 0)
+	// The inputs aren't modified.
+	a.EqFloatArray(earlier.Series[1].Values, []float64{4, 5, 6}, 0)
+	a.EqFloatArray(later.Series[0].Values, []float64{7, 8}, 0)
+
+	overlapping, err := NewTimerange(60, 90, 30)
+	a.CheckError(err)
+	_, _, err = earlier.Merge(first, later, overlapping)
+	if err == nil {
+		t.Errorf("expected overlapping timeranges to be rejected")
+	}
+	coarser, err := NewTimerange(120, 180, 60)
+	a.CheckError(err)
+	_, _, err = earlier.Merge(first, later, coarser)
+	if err == nil {
+		t.Errorf("expected mismatched resolutions to be rejected")
+	}
+	_, _, err = earlier.Merge(first, later, first)
+	if err == nil {
+		t.Errorf("expected identical timeranges to be rejected")
+	}
+	duplicated := SeriesList{
+		Series: []Timeseries{
+			{Values: []float64{7, 8}, TagSet: TagSet{"host": "b"}},
+			{Values: []float64{9, 10}, TagSet: TagSet{"host": "b"}},
+		},
+	}
+	_, _, err = earlier.Merge(first, duplicated, adjacent)
+	if err == nil {
+		t.Errorf("expected duplicated tagsets to be rejected")
+	}
+}
+
+func TestSeriesListIndexByTagSet(t *testing.T) {
+	a := assert.New(t)
+	index, err := SeriesList{
+		Series: []Timeseries{
+			{TagSet: TagSet{"host": "a"}},
+			{TagSet: TagSet{"host": "b", "dc": "west"}},
+			{TagSet: TagSet{}},
+		},
+	}.IndexByTagSet()
+	a.CheckError(err)
+	a.Eq(index, map[string]int{"host=a": 0, "dc=west,host=b": 1, "": 2})
+	_, err = SeriesList{
+		Series: []Timeseries{
+			{TagSet: TagSet{"host": "a"}},
+			{TagSet: TagSet{"host": "b"}},
+			{TagSet: TagSet{"host": "a"}},
+		},
+	}.IndexByTagSet()
+	if err == nil {
+		t.Errorf("expected duplicated tagsets to be rejected")
+	}
+}
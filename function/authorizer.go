@@ -0,0 +1,24 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import "github.com/square/metrics/api"
+
+// An Authorizer decides which series each principal may see, such as limiting
+// each tenant to the series carrying its own tenant tag. Series which aren't
+// visible are dropped when fetching, as though they didn't exist.
+type Authorizer interface {
+	Visible(principal string, metric api.TaggedMetric) bool
+}
@@ -18,9 +18,12 @@ package aggregate
 // and produces an aggregated SeriesList with one list per group, each group having been aggregated into it.
 
 import (
+	"fmt"
 	"math"
+	"strings"
 
 	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
 )
 
 type group struct {
@@ -92,9 +95,17 @@ func filterTagSet(series api.Timeseries, tags []string, collapses bool) api.Time
 // groupBy breaks the given `list` into `groups` that all agree on each tag they have in `tags`.
 // if `collapses` is true, then it groups on all other tags instead.
 func groupBy(list api.SeriesList, tags []string, collapses bool) []group {
+	return groupByKey(list, func(series api.Timeseries) api.Timeseries {
+		return filterTagSet(series, tags, collapses)
+	})
+}
+
+// groupByKey breaks the given `list` into `groups` whose keys agree, where the
+// `key` of each series is a copy with its tagset replaced by the tags to group on.
+func groupByKey(list api.SeriesList, key func(api.Timeseries) api.Timeseries) []group {
 	result := []group{}
 	for _, series := range list.Series {
-		result = addToGroup(result, filterTagSet(series, tags, collapses))
+		result = addToGroup(result, key(series))
 	}
 	return result
 }
@@ -175,6 +186,18 @@ func Count(array []float64) float64 {
 	return float64(len(filterNaN(array)))
 }
 
+// Coalesce returns the first non-NaN value in the given slice, so that earlier
+// series take priority over later ones (such as a primary collector over its
+// backups). If every value is NaN, so is the result.
+func Coalesce(array []float64) float64 {
+	for _, v := range array {
+		if !math.IsNaN(v) {
+			return v
+		}
+	}
+	return math.NaN()
+}
+
 // applyAggregation takes an aggregation function ( [float64] => float64 ) and applies it to a given list of Timeseries
 // the list must be non-empty, or an error is returned
 func applyAggregation(group group, aggregator func([]float64) float64) api.Timeseries {
@@ -188,8 +211,9 @@ func applyAggregation(group group, aggregator func([]float64) float64) api.Times
 	}
 
 	result := api.Timeseries{
-		Values: make([]float64, len(list[0].Values)), // The first Series in the given list is used to determine this length
-		TagSet: tagSet,                               // The tagset is supplied by an argument (it will be the values grouped on)
+		Values:     make([]float64, len(list[0].Values)), // The first Series in the given list is used to determine this length
+		TagSet:     tagSet,                               // The tagset is supplied by an argument (it will be the values grouped on)
+		Provenance: api.CombineProvenance(list),
 	}
 
 	for i := range result.Values {
@@ -220,3 +244,27 @@ func By(list api.SeriesList, aggregator func([]float64) float64, tags []string,
 	}
 	return result
 }
+
+// CollapseTags aggregates away only the given tags, so that series which
+// agree on every other tag are aggregated together. For example,
+// `aggregate.collapse_tags(cpu, "sum", "instance,core")` is the same as
+// `aggregate.sum(cpu collapse by instance, core)`.
+var CollapseTags = function.MakeFunction(
+	"aggregate.collapse_tags",
+	func(list api.SeriesList, name string, tags string) (api.SeriesList, error) {
+		aggregator, err := aggregatorNamed("aggregate.collapse_tags", name)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		tagList := []string{}
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tagList = append(tagList, tag)
+			}
+		}
+		if len(tagList) == 0 {
+			return api.SeriesList{}, fmt.Errorf("aggregate.collapse_tags expected at least one tag to collapse")
+		}
+		return By(list, aggregator, tagList, true), nil
+	},
+)
@@ -233,6 +233,10 @@ func Test_applyAggregation(t *testing.T) {
 			Min,
 			[]float64{-1, -1, 0, 2},
 		},
+		{
+			Coalesce,
+			[]float64{0, 1, 2, 3},
+		},
 	}
 
 	for _, testCase := range aggregationTestCases {
@@ -640,3 +644,13 @@ func Test_AggregateBy(t *testing.T) {
 		}
 	}
 }
+
+func TestCoalesce(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	a.EqFloat(Coalesce([]float64{1, 2, 3}), 1, 0)
+	a.EqFloat(Coalesce([]float64{nan, 2, 3}), 2, 0)
+	a.EqFloat(Coalesce([]float64{nan, nan, -3}), -3, 0)
+	a.EqFloatArray([]float64{Coalesce([]float64{nan, nan})}, []float64{nan}, 0)
+	a.EqFloatArray([]float64{Coalesce([]float64{})}, []float64{nan}, 0)
+}
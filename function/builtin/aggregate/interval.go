@@ -0,0 +1,108 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// Aggregators maps the names accepted by aggregate.group_by_interval,
+// aggregate.collapse_tags and aggregate.group_by_template to the corresponding
+// aggregating functions.
+var Aggregators = map[string]func([]float64) float64{
+	"sum":   Sum,
+	"mean":  Mean,
+	"min":   Min,
+	"max":   Max,
+	"total": Total,
+	"count": Count,
+}
+
+// aggregatorNamed looks up the aggregator with the given name for the function.
+func aggregatorNamed(functionName string, name string) (func([]float64) float64, error) {
+	aggregator, ok := Aggregators[name]
+	if !ok {
+		names := make([]string, 0, len(Aggregators))
+		for name := range Aggregators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%s expected an aggregator (one of %s) but got %q", functionName, strings.Join(names, ", "), name)
+	}
+	return aggregator, nil
+}
+
+// ByInterval groups the list by the tags (as By does) and then aggregates
+// each group over consecutive buckets of `width` points, so that each point is
+// replaced by the aggregate of all of the group's values in its bucket. The
+// first bucket holds only `width - phase` points, which lets buckets line up
+// with some boundary other than the start of the list.
+func ByInterval(list api.SeriesList, aggregator func([]float64) float64, tags []string, collapses bool, width int, phase int) api.SeriesList {
+	groups := groupBy(list, tags, collapses)
+	result := api.SeriesList{
+		Series: make([]api.Timeseries, len(groups)),
+	}
+	for i, group := range groups {
+		length := len(group.List[0].Values)
+		values := make([]float64, length)
+		bucket := []float64{}
+		start := 0
+		for end := 0; end <= length; end++ {
+			if end < length && (end == start || (end+phase)%width != 0) {
+				continue
+			}
+			// The points [start, end) form one bucket.
+			bucket = bucket[:0]
+			for _, series := range group.List {
+				bucket = append(bucket, series.Values[start:end]...)
+			}
+			value := aggregator(bucket)
+			for j := start; j < end; j++ {
+				values[j] = value
+			}
+			start = end
+		}
+		result.Series[i] = api.Timeseries{Values: values, TagSet: group.TagSet}
+	}
+	return result
+}
+
+// GroupByInterval aggregates each group (given by the `group by` clause) into
+// buckets of the given interval in a single pass. Buckets are aligned to
+// multiples of the interval, so the first and last may be cut short by the
+// query's timerange. Each point holds the aggregate of its bucket.
+var GroupByInterval = function.MakeFunction(
+	"aggregate.group_by_interval",
+	func(list api.SeriesList, interval time.Duration, name string, groups function.Groups, timerange api.Timerange, context function.EvaluationContext) (api.SeriesList, error) {
+		aggregator, err := aggregatorNamed("aggregate.group_by_interval", name)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		if interval <= 0 || interval%timerange.Resolution() != 0 {
+			return api.SeriesList{}, fmt.Errorf("aggregate.group_by_interval expected an interval which is a positive multiple of the resolution %+v but got %+v", timerange.Resolution(), interval)
+		}
+		width := int(interval / timerange.Resolution())
+		intervalMillis := int64(interval / time.Millisecond)
+		phase := int((timerange.StartMillis() % intervalMillis) / timerange.ResolutionMillis())
+		groups.NoteAbsentTags(context, list)
+		return ByInterval(list, aggregator, groups.List, groups.Collapses, width, phase), nil
+	},
+)
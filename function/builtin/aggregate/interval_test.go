@@ -0,0 +1,55 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func Test_ByInterval(t *testing.T) {
+	nan := math.NaN()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"dc": "A", "host": "a"}},
+			{Values: []float64{10, nan, 30, 40, 50}, TagSet: api.TagSet{"dc": "A", "host": "b"}},
+			{Values: []float64{7, 7, 7, 7, 7}, TagSet: api.TagSet{"dc": "B", "host": "c"}},
+		},
+	}
+	tests := []struct {
+		aggregator func([]float64) float64
+		width      int
+		phase      int
+		expected   map[string][]float64 // by dc
+	}{
+		{Sum, 2, 0, map[string][]float64{"A": {13, 13, 77, 77, 55}, "B": {14, 14, 14, 14, 7}}},
+		{Sum, 2, 1, map[string][]float64{"A": {11, 35, 35, 99, 99}, "B": {7, 14, 14, 14, 14}}},
+		{Max, 3, 0, map[string][]float64{"A": {30, 30, 30, 50, 50}, "B": {7, 7, 7, 7, 7}}},
+		{Count, 5, 0, map[string][]float64{"A": {9, 9, 9, 9, 9}, "B": {5, 5, 5, 5, 5}}},
+		{Mean, 1, 0, map[string][]float64{"A": {5.5, 2, 16.5, 22, 27.5}, "B": {7, 7, 7, 7, 7}}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("width=%d phase=%d", test.width, test.phase)
+		result := ByInterval(list, test.aggregator, []string{"dc"}, false, test.width, test.phase)
+		a.EqInt(len(result.Series), len(test.expected))
+		for _, series := range result.Series {
+			a.EqFloatArray(series.Values, test.expected[series.TagSet["dc"]], epsilon)
+			a.EqInt(len(series.TagSet), 1)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// Reducers maps the names accepted by aggregate.reduce to the pointwise
+// function applied to the first and second role of each group.
+var Reducers = map[string]func(float64, float64) float64{
+	"add":      func(x, y float64) float64 { return x + y },
+	"subtract": func(x, y float64) float64 { return x - y },
+	"multiply": func(x, y float64) float64 { return x * y },
+	"divide":   func(x, y float64) float64 { return x / y },
+}
+
+// roleTag finds the tag which distinguishes the roles: the only tag for which
+// some series has the value `first` and some series has the value `second`.
+func roleTag(list api.SeriesList, first string, second string) (string, error) {
+	hasFirst := map[string]bool{}
+	hasSecond := map[string]bool{}
+	for _, series := range list.Series {
+		for tag, value := range series.TagSet {
+			if value == first {
+				hasFirst[tag] = true
+			}
+			if value == second {
+				hasSecond[tag] = true
+			}
+		}
+	}
+	candidates := []string{}
+	for tag := range hasFirst {
+		if hasSecond[tag] {
+			candidates = append(candidates, tag)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("aggregate.reduce found no tag with both the values %q and %q", first, second)
+	case 1:
+		return candidates[0], nil
+	default:
+		sort.Strings(candidates)
+		return "", fmt.Errorf("aggregate.reduce found several tags with both the values %q and %q: %s", first, second, strings.Join(candidates, ", "))
+	}
+}
+
+// Reduce groups the series in the list which agree on every tag except the
+// role tag, and combines each group's `first` and `second` series point by
+// point with the reducer. The role tag is the one tag taking both the value
+// `first` and the value `second` somewhere in the list; it is dropped from
+// the results. Series with any other role are ignored, as are groups missing
+// either role.
+func Reduce(list api.SeriesList, reducer func(float64, float64) float64, first string, second string) (api.SeriesList, error) {
+	if first == second {
+		return api.SeriesList{}, fmt.Errorf("aggregate.reduce given the same role %q twice", first)
+	}
+	tag, err := roleTag(list, first, second)
+	if err != nil {
+		return api.SeriesList{}, err
+	}
+	type roles struct {
+		tagSet api.TagSet
+		first  *api.Timeseries
+		second *api.Timeseries
+	}
+	groups := map[string]*roles{}
+	order := []string{}
+	for i := range list.Series {
+		series := &list.Series[i]
+		role := series.TagSet[tag]
+		if role != first && role != second {
+			continue
+		}
+		tagSet := series.TagSet.Clone()
+		delete(tagSet, tag)
+		key := tagSet.Serialize()
+		group, ok := groups[key]
+		if !ok {
+			group = &roles{tagSet: tagSet}
+			groups[key] = group
+			order = append(order, key)
+		}
+		slot := &group.first
+		if role == second {
+			slot = &group.second
+		}
+		if *slot != nil {
+			return api.SeriesList{}, fmt.Errorf("aggregate.reduce found more than one series with role %q for %s", role, key)
+		}
+		*slot = series
+	}
+	result := api.SeriesList{Series: []api.Timeseries{}}
+	for _, key := range order {
+		group := groups[key]
+		if group.first == nil || group.second == nil {
+			continue
+		}
+		values := make([]float64, len(group.first.Values))
+		for i := range values {
+			values[i] = reducer(group.first.Values[i], group.second.Values[i])
+		}
+		result.Series = append(result.Series, api.Timeseries{Values: values, TagSet: group.tagSet})
+	}
+	return result, nil
+}
+
+// ReduceFunction combines related series pairwise, in the manner of
+// Graphite's reduceSeries. For example, `aggregate.reduce(requests,
+// "divide", "errors", "total")` divides each series whose tags include the value "errors" by the series
+// that is tagged identically except with "total" in place of "errors".
+var ReduceFunction = function.MakeFunction(
+	"aggregate.reduce",
+	func(list api.SeriesList, name string, first string, second string) (api.SeriesList, error) {
+		reducer, ok := Reducers[name]
+		if !ok {
+			names := make([]string, 0, len(Reducers))
+			for name := range Reducers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return api.SeriesList{}, fmt.Errorf("aggregate.reduce expected a reducer (one of %s) but got %q", strings.Join(names, ", "), name)
+		}
+		return Reduce(list, reducer, first, second)
+	},
+)
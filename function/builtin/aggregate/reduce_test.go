@@ -0,0 +1,86 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func TestReduce(t *testing.T) {
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"dc": "A", "kind": "errors"}},
+			{Values: []float64{10, 10, 10}, TagSet: api.TagSet{"dc": "A", "kind": "total"}},
+			{Values: []float64{4, 4, 4}, TagSet: api.TagSet{"dc": "B", "kind": "errors"}},
+			{Values: []float64{8, 16, 32}, TagSet: api.TagSet{"dc": "B", "kind": "total"}},
+			{Values: []float64{5, 5, 5}, TagSet: api.TagSet{"dc": "B", "kind": "latency"}},
+			{Values: []float64{9, 9, 9}, TagSet: api.TagSet{"dc": "C", "kind": "errors"}},
+		},
+	}
+	tests := []struct {
+		reducer  func(float64, float64) float64
+		first    string
+		second   string
+		expected map[string][]float64 // by dc
+	}{
+		{Reducers["divide"], "errors", "total", map[string][]float64{"A": {0.1, 0.2, 0.3}, "B": {0.5, 0.25, 0.125}}},
+		{Reducers["subtract"], "total", "errors", map[string][]float64{"A": {9, 8, 7}, "B": {4, 12, 28}}},
+		{Reducers["add"], "latency", "errors", map[string][]float64{"B": {9, 9, 9}}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s and %s", test.first, test.second)
+		result, err := Reduce(list, test.reducer, test.first, test.second)
+		a.CheckError(err)
+		a.EqInt(len(result.Series), len(test.expected))
+		for _, series := range result.Series {
+			a.EqBool(series.TagSet.HasKey("kind"), false)
+			a.EqFloatArray(series.Values, test.expected[series.TagSet["dc"]], 1e-9)
+		}
+	}
+}
+
+func TestReduceErrors(t *testing.T) {
+	a := assert.New(t)
+	_, err := Reduce(api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1}, TagSet: api.TagSet{"dc": "A", "kind": "errors"}},
+		},
+	}, Reducers["divide"], "errors", "total")
+	if err == nil {
+		a.Errorf("expected an error when no tag has both roles")
+	}
+	_, err = Reduce(api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1}, TagSet: api.TagSet{"kind": "errors", "other": "total"}},
+			{Values: []float64{1}, TagSet: api.TagSet{"kind": "total", "other": "errors"}},
+		},
+	}, Reducers["divide"], "errors", "total")
+	if err == nil {
+		a.Errorf("expected an error when several tags have both roles")
+	}
+	_, err = Reduce(api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1}, TagSet: api.TagSet{"kind": "errors", "host": "a"}},
+			{Values: []float64{1}, TagSet: api.TagSet{"kind": "errors", "host": "a"}},
+			{Values: []float64{1}, TagSet: api.TagSet{"kind": "total", "host": "a"}},
+		},
+	}, Reducers["divide"], "errors", "total")
+	if err == nil {
+		a.Errorf("expected an error when a role appears twice in a group")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"math"
+
+	"github.com/square/metrics/api"
+)
+
+// A Fold computes an associative aggregate one value at a time, so that series
+// can be aggregated as they arrive rather than all at once. Folding every value
+// of a slice into Initial gives the same result as the corresponding aggregator.
+type Fold struct {
+	Initial float64
+	Combine func(accumulator float64, value float64) float64
+}
+
+// SumFold is the streaming equivalent of Sum.
+var SumFold = &Fold{
+	Initial: math.NaN(),
+	Combine: func(accumulator float64, value float64) float64 {
+		switch {
+		case math.IsNaN(value):
+			return accumulator
+		case math.IsNaN(accumulator):
+			return value
+		}
+		return accumulator + value
+	},
+}
+
+// MinFold is the streaming equivalent of Min.
+var MinFold = &Fold{
+	Initial: math.NaN(),
+	Combine: func(accumulator float64, value float64) float64 {
+		if math.IsNaN(accumulator) || value < accumulator {
+			return value
+		}
+		return accumulator
+	},
+}
+
+// MaxFold is the streaming equivalent of Max.
+var MaxFold = &Fold{
+	Initial: math.NaN(),
+	Combine: func(accumulator float64, value float64) float64 {
+		if math.IsNaN(accumulator) || value > accumulator {
+			return value
+		}
+		return accumulator
+	},
+}
+
+// CountFold is the streaming equivalent of Count.
+var CountFold = &Fold{
+	Initial: 0,
+	Combine: func(accumulator float64, value float64) float64 {
+		if math.IsNaN(value) {
+			return accumulator
+		}
+		return accumulator + 1
+	},
+}
+
+// TotalFold is the streaming equivalent of Total.
+var TotalFold = &Fold{
+	Initial: 0,
+	Combine: func(accumulator float64, value float64) float64 {
+		return accumulator + 1
+	},
+}
+
+// An Accumulator groups and aggregates series one at a time, holding only one
+// running series per group. Adding every series of a list and then taking the
+// Result is equivalent to By with the corresponding aggregator.
+type Accumulator struct {
+	fold      Fold
+	tags      []string
+	collapses bool
+	groups    []api.Timeseries // the running aggregate of each group, in order of appearance
+}
+
+// NewAccumulator creates an empty Accumulator which groups by the given tags.
+func NewAccumulator(fold Fold, tags []string, collapses bool) *Accumulator {
+	return &Accumulator{
+		fold:      fold,
+		tags:      tags,
+		collapses: collapses,
+		groups:    []api.Timeseries{},
+	}
+}
+
+// Add folds the series into the aggregate for its group. The series itself is
+// not retained.
+func (a *Accumulator) Add(series api.Timeseries) {
+	series = filterTagSet(series, a.tags, a.collapses)
+	for i, group := range a.groups {
+		if groupAccepts(group.TagSet, series.TagSet) {
+			for j := range group.Values {
+				group.Values[j] = a.fold.Combine(group.Values[j], series.Values[j])
+			}
+			a.groups[i] = group
+			return
+		}
+	}
+	values := make([]float64, len(series.Values))
+	for j := range values {
+		values[j] = a.fold.Combine(a.fold.Initial, series.Values[j])
+	}
+	a.groups = append(a.groups, api.Timeseries{Values: values, TagSet: series.TagSet, Provenance: series.Provenance})
+}
+
+// Result returns the aggregated series for each group seen so far.
+func (a *Accumulator) Result() api.SeriesList {
+	return api.SeriesList{Series: a.groups}
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func Test_Accumulator(t *testing.T) {
+	nan := math.NaN()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, nan, 3, nan}, TagSet: api.TagSet{"dc": "A", "env": "production", "host": "a"}},
+			{Values: []float64{-4, 5, nan, nan}, TagSet: api.TagSet{"dc": "A", "env": "staging", "host": "b"}},
+			{Values: []float64{7, 8, 9, nan}, TagSet: api.TagSet{"dc": "B", "env": "production", "host": "c"}},
+			{Values: []float64{2, -2, 0, nan}, TagSet: api.TagSet{"dc": "A", "env": "production", "host": "d"}},
+			{Values: []float64{nan, nan, nan, nan}, TagSet: api.TagSet{"env": "staging", "host": "e"}},
+		},
+	}
+	folds := []struct {
+		name       string
+		aggregator func([]float64) float64
+		fold       *Fold
+	}{
+		{"sum", Sum, SumFold},
+		{"min", Min, MinFold},
+		{"max", Max, MaxFold},
+		{"count", Count, CountFold},
+		{"total", Total, TotalFold},
+	}
+	groupings := []struct {
+		tags      []string
+		collapses bool
+	}{
+		{[]string{}, false},
+		{[]string{"dc"}, false},
+		{[]string{"dc", "env"}, false},
+		{[]string{"host"}, true},
+	}
+	for _, fold := range folds {
+		for _, grouping := range groupings {
+			a := assert.New(t).Contextf("%s by %v (collapses=%t)", fold.name, grouping.tags, grouping.collapses)
+			accumulator := NewAccumulator(*fold.fold, grouping.tags, grouping.collapses)
+			for _, series := range list.Series {
+				accumulator.Add(series)
+			}
+			streamed := accumulator.Result()
+			expected := By(list, fold.aggregator, grouping.tags, grouping.collapses)
+			a.EqInt(len(streamed.Series), len(expected.Series))
+			for i := range expected.Series {
+				a.Eq(streamed.Series[i].TagSet, expected.Series[i].TagSet)
+				a.EqFloatArray(streamed.Series[i].Values, expected.Series[i].Values, epsilon)
+			}
+		}
+	}
+	// The accumulator doesn't modify the series given to it.
+	a := assert.New(t)
+	a.EqFloatArray(list.Series[0].Values, []float64{1, nan, 3, nan}, 0)
+	a.Eq(list.Series[0].TagSet, api.TagSet{"dc": "A", "env": "production", "host": "a"})
+}
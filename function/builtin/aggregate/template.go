@@ -0,0 +1,114 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// keyTemplate computes a group key from a series' tags, such as
+// "{region}-{tier}". Tags which a series lacks render as empty.
+type keyTemplate struct {
+	literals []string // the text around each tag; there's one more than there are tags
+	tags     []string
+}
+
+// parseKeyTemplate parses a template whose tags are named in braces.
+func parseKeyTemplate(template string) (keyTemplate, error) {
+	result := keyTemplate{}
+	rest := template
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			result.literals = append(result.literals, rest)
+			break
+		}
+		if rest[open] == '}' {
+			return keyTemplate{}, fmt.Errorf("unmatched '}' in key template %q", template)
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] == '{' {
+			return keyTemplate{}, fmt.Errorf("unmatched '{' in key template %q", template)
+		}
+		tag := strings.TrimSpace(rest[open+1 : open+1+end])
+		if tag == "" {
+			return keyTemplate{}, fmt.Errorf("empty tag name in key template %q", template)
+		}
+		result.literals = append(result.literals, rest[:open])
+		result.tags = append(result.tags, tag)
+		rest = rest[open+1+end+1:]
+	}
+	if len(result.tags) == 0 {
+		return keyTemplate{}, fmt.Errorf("key template %q names no tags", template)
+	}
+	return result, nil
+}
+
+// render computes the key for the tagset.
+func (t keyTemplate) render(tagSet api.TagSet) string {
+	parts := make([]string, 0, len(t.literals)+len(t.tags))
+	for i, tag := range t.tags {
+		parts = append(parts, t.literals[i], tagSet[tag])
+	}
+	parts = append(parts, t.literals[len(t.literals)-1])
+	return strings.Join(parts, "")
+}
+
+// ByKey groups the list by the key which `key` computes from each series'
+// tags, and aggregates each group into a single series whose tagset holds
+// only the key, as `tag`.
+func ByKey(list api.SeriesList, aggregator func([]float64) float64, tag string, key func(api.TagSet) string) api.SeriesList {
+	groups := groupByKey(list, func(series api.Timeseries) api.Timeseries {
+		series.TagSet = api.TagSet{tag: key(series.TagSet)}
+		return series
+	})
+	result := api.SeriesList{
+		Series: make([]api.Timeseries, len(groups)),
+	}
+	for i, group := range groups {
+		result.Series[i] = applyAggregation(group, aggregator)
+	}
+	return result
+}
+
+// GroupByTemplate aggregates the series which agree on a key computed from
+// their tags by a template such as "{region}-{tier}", rather than on the raw
+// tags as a `group by` clause does. A tag which a series lacks renders as
+// empty. Each result is tagged with its key, as `tag` (by default, "group").
+var GroupByTemplate = function.MakeFunction(
+	"aggregate.group_by_template",
+	func(list api.SeriesList, name string, template string, tag *string) (api.SeriesList, error) {
+		aggregator, err := aggregatorNamed("aggregate.group_by_template", name)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		keys, err := parseKeyTemplate(template)
+		if err != nil {
+			return api.SeriesList{}, fmt.Errorf("aggregate.group_by_template: %s", err.Error())
+		}
+		keyTag := "group"
+		if tag != nil {
+			keyTag = *tag
+		}
+		if keyTag == "" {
+			return api.SeriesList{}, fmt.Errorf("aggregate.group_by_template given empty string for tag")
+		}
+		return ByKey(list, aggregator, keyTag, keys.render), nil
+	},
+)
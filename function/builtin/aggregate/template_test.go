@@ -0,0 +1,80 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func Test_parseKeyTemplate(t *testing.T) {
+	tagSet := api.TagSet{"region": "us-west", "tier": "web", "host": "web12.sfo"}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{region}-{tier}", "us-west-web"},
+		{"{ tier }", "web"},
+		{"pool:{tier}/{missing}!", "pool:web/!"},
+		{"{tier}{tier}", "webweb"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.template)
+		template, err := parseKeyTemplate(test.template)
+		if err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		a.EqString(template.render(tagSet), test.expected)
+	}
+	for _, invalid := range []string{"", "region", "{region", "region}", "{}", "{a{b}}", "{a}}"} {
+		if _, err := parseKeyTemplate(invalid); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func Test_ByKey(t *testing.T) {
+	nan := math.NaN()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"region": "west", "tier": "web", "host": "a"}},
+			{Values: []float64{10, nan, 30}, TagSet: api.TagSet{"region": "west", "tier": "web", "host": "b"}},
+			{Values: []float64{5, 5, 5}, TagSet: api.TagSet{"region": "west", "tier": "db", "host": "c"}},
+			{Values: []float64{7, 7, 7}, TagSet: api.TagSet{"tier": "db", "host": "d"}},
+		},
+	}
+	template, err := parseKeyTemplate("{region}-{tier}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	result := ByKey(list, Sum, "group", template.render)
+	expected := []api.Timeseries{
+		{Values: []float64{11, 2, 33}, TagSet: api.TagSet{"group": "west-web"}},
+		{Values: []float64{5, 5, 5}, TagSet: api.TagSet{"group": "west-db"}},
+		{Values: []float64{7, 7, 7}, TagSet: api.TagSet{"group": "-db"}},
+	}
+	a := assert.New(t)
+	a.EqInt(len(result.Series), len(expected))
+	for i := range expected {
+		if i < len(result.Series) {
+			a.Eq(result.Series[i].TagSet, expected[i].TagSet)
+			a.EqFloatArray(result.Series[i].Values, expected[i].Values, 0)
+		}
+	}
+}
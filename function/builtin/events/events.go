@@ -0,0 +1,61 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events contains functions which return events (such as deploys)
+// from the context's EventSource, to be drawn as markers on charts.
+package events
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// Fetch returns the events with the given name which happened during the
+// query's timerange, ordered by time. Like series, events are only returned if
+// the principal may see them: an event is visible if a series with the
+// event's name as its metric and the event's tags would be.
+var Fetch = function.MakeFunction(
+	"events.fetch",
+	func(context function.EvaluationContext, name string, timerange api.Timerange) (function.EventListValue, error) {
+		source := context.EventSource()
+		if source == nil {
+			return nil, fmt.Errorf("events.fetch cannot fetch %q events because no event source is configured", name)
+		}
+		events, err := source.FetchEvents(name, timerange)
+		if err != nil {
+			return nil, err
+		}
+		result := function.EventListValue{}
+		for _, event := range events {
+			if event.Timestamp < timerange.StartMillis() || event.Timestamp > timerange.EndMillis() {
+				continue
+			}
+			if !context.Visible(api.TaggedMetric{MetricKey: api.MetricKey(event.Name), TagSet: event.TagSet}) {
+				continue
+			}
+			result = append(result, event)
+		}
+		sort.Sort(byTimestamp(result))
+		return result, nil
+	},
+)
+
+type byTimestamp []function.Event
+
+func (events byTimestamp) Len() int           { return len(events) }
+func (events byTimestamp) Swap(i, j int)      { events[i], events[j] = events[j], events[i] }
+func (events byTimestamp) Less(i, j int) bool { return events[i].Timestamp < events[j].Timestamp }
@@ -0,0 +1,59 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fetch
+
+import (
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/expression"
+	"github.com/square/metrics/query/predicate"
+)
+
+// EstimateCost estimates the cost of evaluating an expression without fetching
+// any data. The metadata is consulted to count the series which each metric
+// fetch in the expression would match, giving the number of series fetched and
+// the number of points (series times slots in the timerange).
+//
+// The estimate ignores functions which change the timerange (such as
+// transform.timeshift) and fetches which functions perform internally.
+var EstimateCost = function.MakeFunction(
+	"fetch.estimate_cost",
+	func(context function.EvaluationContext, target function.Expression) (function.ScalarSet, error) {
+		series := 0
+		for _, fetch := range expression.Fetches(target) {
+			tagSets, err := context.MetricMetadataAPI().GetAllTags(api.MetricKey(fetch.MetricName), metadata.Context{
+				Profiler: context.Profiler(),
+			})
+			if _, ok := err.(metadata.NoSuchMetricError); ok {
+				// An unknown metric fetches no series, as in the query itself.
+				tagSets, err = nil, nil
+			}
+			if err != nil {
+				return nil, function.WrapBackendError("metadata", err)
+			}
+			p := predicate.All(fetch.Predicate, context.Predicate())
+			for _, tagSet := range tagSets {
+				if p.Apply(tagSet) && context.Visible(api.TaggedMetric{MetricKey: api.MetricKey(fetch.MetricName), TagSet: tagSet}) {
+					series++
+				}
+			}
+		}
+		return function.ScalarSet{
+			{TagSet: api.TagSet{"estimate": "series"}, Value: float64(series)},
+			{TagSet: api.TagSet{"estimate": "points"}, Value: float64(series * context.Timerange().Slots())},
+		}, nil
+	},
+)
@@ -0,0 +1,365 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fetch contains functions which fetch series from storage directly,
+// as an alternative to naming a metric in the query.
+package fetch
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/expression"
+	"github.com/square/metrics/query/predicate"
+	"github.com/square/metrics/timeseries"
+)
+
+// nameTag is the pseudo-tag used by matchers to refer to the metric name.
+const nameTag = "name"
+
+// A matcher is a single parsed constraint such as "dc=sfo" or "role=~web.*".
+type matcher struct {
+	Tag      string
+	Operator string
+	Value    string
+}
+
+// matcherOperators lists the supported operators. Longer operators come first
+// so that "!=" is not mistaken for "=" (and so on).
+var matcherOperators = []string{"!=", "=~", "!~", "="}
+
+// parseMatcher parses a matcher of the form `tag<op>value`.
+func parseMatcher(text string) (matcher, error) {
+	index := strings.IndexAny(text, "!=")
+	if index <= 0 {
+		return matcher{}, fmt.Errorf("fetch.by_tag expects matchers of the form `tag=value`, `tag!=value`, `tag=~regex` or `tag!~regex`, but got %q", text)
+	}
+	for _, operator := range matcherOperators {
+		if strings.HasPrefix(text[index:], operator) {
+			return matcher{
+				Tag:      text[:index],
+				Operator: operator,
+				Value:    text[index+len(operator):],
+			}, nil
+		}
+	}
+	return matcher{}, fmt.Errorf("fetch.by_tag given matcher %q with an unknown operator", text)
+}
+
+// texts returns the text of each matcher, as it was given.
+func texts(matchers []matcher) []string {
+	result := make([]string, len(matchers))
+	for i, m := range matchers {
+		result[i] = m.Tag + m.Operator + m.Value
+	}
+	return result
+}
+
+// positive is true if the matcher requires the tag to be present.
+func (m matcher) positive() bool {
+	return m.Operator == "=" || m.Operator == "=~"
+}
+
+// Predicate converts the matcher into the equivalent predicate.
+func (m matcher) Predicate() (predicate.Predicate, error) {
+	switch m.Operator {
+	case "=":
+		return predicate.ListMatcher{Tag: m.Tag, Values: []string{m.Value}}, nil
+	case "!=":
+		return predicate.NotPredicate{Predicate: predicate.ListMatcher{Tag: m.Tag, Values: []string{m.Value}}}, nil
+	}
+	regex, err := regexp.Compile(m.Value)
+	if err != nil {
+		return nil, fmt.Errorf("fetch.by_tag given invalid regex %q for tag %q: %s", m.Value, m.Tag, err.Error())
+	}
+	if m.Operator == "=~" {
+		return predicate.RegexMatcher{Tag: m.Tag, Regex: regex}, nil
+	}
+	return predicate.NotPredicate{Predicate: predicate.RegexMatcher{Tag: m.Tag, Regex: regex}}, nil
+}
+
+// candidateMetrics enumerates the metrics which could possibly satisfy the
+// matchers. Equality matchers on ordinary tags are used to narrow the search
+// via the metadata index; the metric's name is checked against namePredicate.
+func candidateMetrics(context function.EvaluationContext, matchers []matcher, namePredicate predicate.Predicate) ([]api.MetricKey, error) {
+	metadataContext := metadata.Context{Profiler: context.Profiler()}
+	var candidates map[api.MetricKey]bool
+	for _, m := range matchers {
+		if m.Operator != "=" {
+			continue
+		}
+		var metrics []api.MetricKey
+		if m.Tag == nameTag {
+			metrics = []api.MetricKey{api.MetricKey(m.Value)}
+		} else {
+			var err error
+			metrics, err = context.MetricMetadataAPI().GetMetricsForTag(m.Tag, m.Value, metadataContext)
+			if err != nil {
+				return nil, function.WrapBackendError("metadata", err)
+			}
+		}
+		next := map[api.MetricKey]bool{}
+		for _, metric := range metrics {
+			if candidates == nil || candidates[metric] {
+				next[metric] = true
+			}
+		}
+		candidates = next
+	}
+	if candidates == nil {
+		// No equality constraints, so every metric is a candidate.
+		metrics, err := context.MetricMetadataAPI().GetAllMetrics(metadataContext)
+		if err != nil {
+			return nil, function.WrapBackendError("metadata", err)
+		}
+		candidates = map[api.MetricKey]bool{}
+		for _, metric := range metrics {
+			candidates[metric] = true
+		}
+	}
+	result := []api.MetricKey{}
+	for metric := range candidates {
+		if namePredicate.Apply(api.TagSet{nameTag: string(metric)}) {
+			result = append(result, metric)
+		}
+	}
+	sort.Sort(api.MetricKeys(result))
+	return result, nil
+}
+
+// ByTag fetches every series whose tags satisfy all of the given matchers,
+// regardless of which metric they belong to. The pseudo-tag `name` matches
+// the metric name, and is added to the tagset of each resulting series.
+var ByTag = function.MetricFunction{
+	FunctionName: "fetch.by_tag",
+	MinArguments: 1,
+	MaxArguments: -1,
+	Compute: func(context function.EvaluationContext, arguments []function.Expression, groups function.Groups) (function.Value, error) {
+		matchers := make([]matcher, len(arguments))
+		anyPositive := false
+		for i, argument := range arguments {
+			text, err := function.EvaluateToString(argument, context)
+			if err != nil {
+				return nil, err
+			}
+			matchers[i], err = parseMatcher(text)
+			if err != nil {
+				return nil, err
+			}
+			anyPositive = anyPositive || matchers[i].positive()
+		}
+		if !anyPositive {
+			return nil, fmt.Errorf("fetch.by_tag requires at least one `=` or `=~` matcher")
+		}
+
+		namePredicates := []predicate.Predicate{}
+		tagPredicates := []predicate.Predicate{}
+		for _, m := range matchers {
+			p, err := m.Predicate()
+			if err != nil {
+				return nil, err
+			}
+			if m.Tag == nameTag {
+				namePredicates = append(namePredicates, p)
+			} else {
+				tagPredicates = append(tagPredicates, p)
+			}
+		}
+
+		metrics, err := candidateMetrics(context, matchers, predicate.All(namePredicates...))
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := fetchMetrics(context, metrics, predicate.All(tagPredicates...), nameTag)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Series) == 0 {
+			if err := context.CheckEmptyResult(fmt.Sprintf("fetch.by_tag(%q)", strings.Join(texts(matchers), `", "`))); err != nil {
+				return nil, err
+			}
+		}
+		return function.SeriesListValue(result), nil
+	},
+}
+
+// fetchMetrics fetches the series of each metric which satisfy the predicate,
+// and unions them into one list, setting `tag` to the name of the metric that
+// each series came from. Each fetch counts against the fetch limit. Metrics
+// with no matching series are expected, so the caller applies the context's
+// EmptyResultPolicy to the union instead.
+func fetchMetrics(context function.EvaluationContext, metrics []api.MetricKey, p predicate.Predicate, tag string) (api.SeriesList, error) {
+	result := api.SeriesList{Series: []api.Timeseries{}}
+	context = context.WithEmptyResultPolicy(function.AllowEmptyResults)
+	for _, metric := range metrics {
+		fetch := &expression.MetricFetchExpression{
+			MetricName: string(metric),
+			Predicate:  p,
+		}
+		value, err := context.EvaluateMemoized(fetch)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		list, convErr := value.ToSeriesList(context.Timerange())
+		if convErr != nil {
+			return api.SeriesList{}, convErr.WithContext(fetch.ExpressionString(function.StringQuery))
+		}
+		for _, series := range list.Series {
+			series.TagSet = series.TagSet.Clone()
+			series.TagSet[tag] = string(metric)
+			result.Series = append(result.Series, series)
+		}
+	}
+	return result, nil
+}
+
+// Matching fetches every series of every metric whose name matches the glob
+// (such as "requests.*"), unioned into one list. The name of the metric that
+// each series came from is stored in `tag` (by default "name").
+var Matching = function.MakeFunction(
+	"fetch.matching",
+	func(context function.EvaluationContext, glob string, tag *string) (api.SeriesList, error) {
+		name := nameTag
+		if tag != nil {
+			name = *tag
+		}
+		if name == "" {
+			return api.SeriesList{}, fmt.Errorf("fetch.matching given empty string for tag")
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return api.SeriesList{}, fmt.Errorf("fetch.matching given invalid glob %q: %s", glob, err.Error())
+		}
+		metrics, err := metadata.GetMetricsMatching(context.MetricMetadataAPI(), glob, metadata.Context{Profiler: context.Profiler()})
+		if err != nil {
+			return api.SeriesList{}, function.WrapBackendError("metadata", err)
+		}
+		result, err := fetchMetrics(context, metrics, predicate.All(), name)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		if len(result.Series) == 0 {
+			if err := context.CheckEmptyResult(fmt.Sprintf("fetch.matching(%q)", glob)); err != nil {
+				return api.SeriesList{}, err
+			}
+		}
+		return result, nil
+	},
+)
+
+// Unbounded evaluates its argument without the high-cardinality tag guard, so
+// that a query can deliberately fetch every series of a metric.
+var Unbounded = function.MakeFunction(
+	"fetch.unbounded",
+	func(target function.Expression, context function.EvaluationContext) (function.Value, error) {
+		return target.Evaluate(context.WithUnboundedFetches())
+	},
+)
+
+// sampleMethods maps the names accepted by fetch.as to their sample methods.
+var sampleMethods = map[string]timeseries.SampleMethod{
+	"max":  timeseries.SampleMax,
+	"min":  timeseries.SampleMin,
+	"mean": timeseries.SampleMean,
+}
+
+// As evaluates its argument with the given sample method ("max", "min" or
+// "mean") in place of the query's, so that within one query a counter and a
+// gauge can each be consolidated to the requested resolution appropriately.
+var As = function.MakeFunction(
+	"fetch.as",
+	func(target function.Expression, name string, context function.EvaluationContext) (function.Value, error) {
+		method, ok := sampleMethods[name]
+		if !ok {
+			return nil, fmt.Errorf("fetch.as expected sample method 'max', 'min', or 'mean' but got %q", name)
+		}
+		return target.Evaluate(context.WithSampleMethod(method))
+	},
+)
+
+// allNaN is true if the series has no data at all.
+func allNaN(series api.Timeseries) bool {
+	for _, value := range series.Values {
+		if !math.IsNaN(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Coalesce eases the renaming of a metric: it evaluates the primary
+// expression, and wherever that has no data, substitutes the fallback. The
+// fallback's series replace the primary's series that have no data, matched
+// by tagset, and any fallback series without a primary counterpart are added.
+// A missing primary metric counts as having no series (whatever the context's
+// EmptyResultPolicy). The fallback is only evaluated when it's needed, and a
+// note is added when it's used. Lists with several series with the same tagset
+// are rejected, since their series can't be matched.
+var Coalesce = function.MakeFunction(
+	"fetch.coalesce",
+	func(primary function.Expression, fallback function.Expression, context function.EvaluationContext) (api.SeriesList, error) {
+		primaryList, err := function.EvaluateToSeriesList(primary, context.WithEmptyResultPolicy(function.AllowEmptyResults))
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		primaryIndex, err := primaryList.IndexByTagSet()
+		if err != nil {
+			return api.SeriesList{}, fmt.Errorf("fetch.coalesce: %s", err.Error())
+		}
+		complete := len(primaryList.Series) > 0
+		for _, series := range primaryList.Series {
+			complete = complete && !allNaN(series)
+		}
+		if complete {
+			return primaryList, nil
+		}
+
+		fallbackList, err := function.EvaluateToSeriesList(fallback, context)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		fallbackIndex, err := fallbackList.IndexByTagSet()
+		if err != nil {
+			return api.SeriesList{}, fmt.Errorf("fetch.coalesce: %s", err.Error())
+		}
+		result := api.SeriesList{Series: []api.Timeseries{}}
+		used := 0 // the number of series taken from the fallback
+		for _, series := range primaryList.Series {
+			if allNaN(series) {
+				if i, ok := fallbackIndex[series.TagSet.Serialize()]; ok {
+					series = fallbackList.Series[i]
+					used++
+				}
+			}
+			result.Series = append(result.Series, series)
+		}
+		for _, series := range fallbackList.Series {
+			if _, ok := primaryIndex[series.TagSet.Serialize()]; !ok {
+				result.Series = append(result.Series, series)
+				used++
+			}
+		}
+		if used > 0 {
+			context.AddNote(fmt.Sprintf("fetch.coalesce used %s for %d series", fallback.ExpressionString(function.StringQuery), used))
+		}
+		return result, nil
+	},
+)
@@ -15,10 +15,13 @@
 package filter
 
 import (
+	"fmt"
 	"math"
 	"sort"
+	"strconv"
 
 	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
 )
 
 type filterList struct {
@@ -117,3 +120,270 @@ func ThresholdByRecent(list api.SeriesList, threshold float64, summary func([]fl
 		Series: result,
 	}
 }
+
+// Current summarizes a series by its most recent value which isn't NaN, for use
+// with ThresholdByRecent or ByRecent. It's NaN if there are no such values.
+func Current(values []float64) float64 {
+	for i := len(values) - 1; i >= 0; i-- {
+		if !math.IsNaN(values[i]) {
+			return values[i]
+		}
+	}
+	return math.NaN()
+}
+
+type byTagSet []api.Timeseries
+
+func (list byTagSet) Len() int {
+	return len(list)
+}
+func (list byTagSet) Less(i, j int) bool {
+	return list[i].TagSet.Serialize() < list[j].TagSet.Serialize()
+}
+func (list byTagSet) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+// Head orders the series in the `list` by their serialized tagsets, so that
+// the result is the same every time, and keeps the first `count` of them.
+func Head(list api.SeriesList, count int) api.SeriesList {
+	sorted := make([]api.Timeseries, len(list.Series))
+	copy(sorted, list.Series)
+	sort.Sort(byTagSet(sorted))
+	if count < len(sorted) {
+		sorted = sorted[:count]
+	}
+	return api.SeriesList{
+		Series: sorted,
+	}
+}
+
+// Limit keeps at most the given number of series, without ranking them (as
+// filter.highest_max and the like do). The series are ordered by tagset first,
+// as Head does, so that the same series are kept every time the query is run,
+// although their original order is lost. A note is added if any were dropped.
+var Limit = function.MakeFunction(
+	"filter.limit",
+	func(list api.SeriesList, countFloat float64, context function.EvaluationContext) (api.SeriesList, error) {
+		count := int(countFloat)
+		if count < 0 || float64(count) != countFloat {
+			return api.SeriesList{}, fmt.Errorf("filter.limit expected a non-negative whole number of series but got %+v", countFloat)
+		}
+		result := Head(list, count)
+		if len(result.Series) < len(list.Series) {
+			context.AddNote(fmt.Sprintf("filter.limit kept %d of %d series", len(result.Series), len(list.Series)))
+		}
+		return result, nil
+	},
+)
+
+func finite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// finiteCount counts the values which are neither NaN nor infinite.
+func finiteCount(values []float64) int {
+	count := 0
+	for _, value := range values {
+		if finite(value) {
+			count++
+		}
+	}
+	return count
+}
+
+// Dedupe collapses the series in the `list` which have identical tagsets into
+// one. Each is based on the duplicate with the most finite values, with its
+// missing values filled in from the others (in their order in the list). The
+// order of the first occurrence of each tagset is kept. It also returns the
+// number of series which were collapsed away.
+func Dedupe(list api.SeriesList) (api.SeriesList, int) {
+	order := []string{}
+	groups := map[string][]api.Timeseries{}
+	for _, series := range list.Series {
+		key := series.TagSet.Serialize()
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], series)
+	}
+	result := api.SeriesList{
+		Series: make([]api.Timeseries, len(order)),
+	}
+	for i, key := range order {
+		group := groups[key]
+		if len(group) == 1 {
+			result.Series[i] = group[0]
+			continue
+		}
+		best := 0
+		for j := range group {
+			if finiteCount(group[j].Values) > finiteCount(group[best].Values) {
+				best = j
+			}
+		}
+		values := make([]float64, len(group[best].Values))
+		copy(values, group[best].Values)
+		for j := range values {
+			for _, other := range group {
+				if finite(values[j]) {
+					break
+				}
+				if j < len(other.Values) && finite(other.Values[j]) {
+					values[j] = other.Values[j]
+				}
+			}
+		}
+		result.Series[i] = api.Timeseries{
+			Values: values,
+			TagSet: group[best].TagSet,
+		}
+	}
+	return result, len(list.Series) - len(order)
+}
+
+// DedupeFunction collapses duplicate series, as described by Dedupe. A note is
+// added if any were collapsed.
+var DedupeFunction = function.MakeFunction(
+	"filter.dedupe",
+	func(list api.SeriesList, context function.EvaluationContext) api.SeriesList {
+		result, collapsed := Dedupe(list)
+		if collapsed > 0 {
+			context.AddNote(fmt.Sprintf("filter.dedupe collapsed %d duplicate series", collapsed))
+		}
+		return result
+	},
+)
+
+// The policies for series which appear with the same tagset in more than one
+// of the lists given to Union.
+const (
+	UnionTag    = "tag"    // tag each of them with the list it came from
+	UnionDedupe = "dedupe" // collapse them into one, as Dedupe does
+	UnionError  = "error"  // fail
+)
+
+// Union combines the lists into one. Series with the same tagset in more than
+// one list would be indistinguishable, so they're handled by the policy: with
+// UnionTag, each of them has `tag` set to the label of its list. Except with
+// UnionDedupe, a list may not itself have several series with the same tagset.
+// If the labels aren't distinct (as in union(x, x)), the lists' positions,
+// counting from 1, are used as their labels instead. It also returns the
+// number of tagsets which conflicted.
+func Union(lists []api.SeriesList, labels []string, policy string, tag string) (api.SeriesList, int, error) {
+	if !distinct(labels) {
+		labels = make([]string, len(lists))
+		for i := range labels {
+			labels[i] = strconv.Itoa(i + 1)
+		}
+	}
+	sources := map[string]map[int]bool{} // the lists in which each tagset appears
+	order := []string{}
+	for i, list := range lists {
+		if policy != UnionDedupe {
+			// Series with the same tagset in one list would stay indistinguishable.
+			if _, err := list.IndexByTagSet(); err != nil {
+				return api.SeriesList{}, 0, fmt.Errorf("%s: %s", labels[i], err.Error())
+			}
+		}
+		for _, series := range list.Series {
+			key := series.TagSet.Serialize()
+			if sources[key] == nil {
+				sources[key] = map[int]bool{}
+				order = append(order, key)
+			}
+			sources[key][i] = true
+		}
+	}
+	conflicts := []string{}
+	for _, key := range order {
+		if len(sources[key]) > 1 {
+			conflicts = append(conflicts, key)
+		}
+	}
+	result := api.SeriesList{Series: []api.Timeseries{}}
+	switch policy {
+	case UnionTag:
+		for i, list := range lists {
+			for _, series := range list.Series {
+				if len(sources[series.TagSet.Serialize()]) > 1 {
+					series.TagSet = series.TagSet.Clone()
+					series.TagSet[tag] = labels[i]
+				}
+				result.Series = append(result.Series, series)
+			}
+		}
+	case UnionDedupe:
+		for _, list := range lists {
+			result.Series = append(result.Series, list.Series...)
+		}
+		result, _ = Dedupe(result)
+	case UnionError:
+		if len(conflicts) > 0 {
+			return api.SeriesList{}, 0, fmt.Errorf("series with tagset {%s} appear in more than one list", conflicts[0])
+		}
+		for _, list := range lists {
+			result.Series = append(result.Series, list.Series...)
+		}
+	default:
+		return api.SeriesList{}, 0, fmt.Errorf("unknown union policy %q; expected %q, %q or %q", policy, UnionTag, UnionDedupe, UnionError)
+	}
+	return result, len(conflicts), nil
+}
+
+// distinct reports whether no two of the labels are the same.
+func distinct(labels []string) bool {
+	seen := map[string]bool{}
+	for _, label := range labels {
+		if seen[label] {
+			return false
+		}
+		seen[label] = true
+	}
+	return true
+}
+
+// UnionFunction combines two lists into one, as described by Union. Conflicting
+// series are tagged (by default with "metric") with the expression they came
+// from, unless the policy is "dedupe" or "error". A note is added if any
+// tagsets conflicted. It takes exactly two lists, since functions can't take a
+// variable number of arguments; more can be combined by nesting it.
+var UnionFunction = function.MakeFunction(
+	"filter.union",
+	func(left function.Expression, right function.Expression, policy *string, tag *string, context function.EvaluationContext) (api.SeriesList, error) {
+		chosenPolicy := UnionTag
+		if policy != nil {
+			chosenPolicy = *policy
+		}
+		chosenTag := "metric"
+		if tag != nil {
+			chosenTag = *tag
+		}
+		if chosenTag == "" {
+			return api.SeriesList{}, fmt.Errorf("filter.union given empty string for tag")
+		}
+		lists := make([]api.SeriesList, 2)
+		labels := make([]string, 2)
+		for i, expression := range []function.Expression{left, right} {
+			list, err := function.EvaluateToSeriesList(expression, context)
+			if err != nil {
+				return api.SeriesList{}, err
+			}
+			lists[i] = list
+			labels[i] = expression.ExpressionString(function.StringName)
+		}
+		result, conflicts, err := Union(lists, labels, chosenPolicy, chosenTag)
+		if err != nil {
+			return api.SeriesList{}, fmt.Errorf("filter.union: %s", err.Error())
+		}
+		if conflicts > 0 {
+			switch chosenPolicy {
+			case UnionTag:
+				context.AddNote(fmt.Sprintf("filter.union found %d tagsets in both lists; their series were tagged with %q", conflicts, chosenTag))
+			case UnionDedupe:
+				context.AddNote(fmt.Sprintf("filter.union found %d tagsets in both lists; their series were collapsed", conflicts))
+			}
+		}
+		return result, nil
+	},
+)
@@ -319,3 +319,149 @@ func TestFilterListNaN(t *testing.T) {
 	sort.Sort(array)
 	a.Eq(array.index, []int{4, 2, 5, 6, 11, 11})
 }
+
+func TestCurrent(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	a.EqFloat(Current([]float64{1, 2, 3}), 3, 0)
+	a.EqFloat(Current([]float64{1, 2, nan, nan}), 2, 0)
+	a.EqBool(math.IsNaN(Current([]float64{nan, nan})), true)
+	a.EqBool(math.IsNaN(Current([]float64{})), true)
+}
+
+func TestHead(t *testing.T) {
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1}, TagSet: api.TagSet{"host": "c"}},
+			{Values: []float64{2}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{3}, TagSet: api.TagSet{"host": "b", "dc": "sfo"}},
+			{Values: []float64{4}, TagSet: api.TagSet{"host": "b", "dc": "nyc"}},
+		},
+	}
+	tests := []struct {
+		count    int
+		expected []float64
+	}{
+		{count: 0, expected: []float64{}},
+		{count: 2, expected: []float64{4, 3}},
+		{count: 4, expected: []float64{4, 3, 2, 1}},
+		{count: 10, expected: []float64{4, 3, 2, 1}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("head %d", test.count)
+		result := Head(list, test.count)
+		values := []float64{}
+		for _, series := range result.Series {
+			values = append(values, series.Values[0])
+		}
+		a.EqFloatArray(values, test.expected, 0)
+	}
+	// The original list is left in its order.
+	assert.New(t).EqString(list.Series[0].TagSet["host"], "c")
+}
+
+func TestDedupe(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, nan, nan, nan}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{5, 5, 5, 5}, TagSet: api.TagSet{"host": "b"}},
+			{Values: []float64{nan, 2, 3, nan}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{9, 9, nan, 4}, TagSet: api.TagSet{"host": "a"}},
+		},
+	}
+	result, collapsed := Dedupe(list)
+	a.EqInt(collapsed, 2)
+	a.EqInt(len(result.Series), 2)
+	a.Eq(result.Series[0].TagSet, api.TagSet{"host": "a"})
+	// The series with the most finite values wins, and its gaps are filled from the others.
+	a.EqFloatArray(result.Series[0].Values, []float64{9, 9, 3, 4}, 0)
+	a.Eq(result.Series[1].TagSet, api.TagSet{"host": "b"})
+	a.EqFloatArray(result.Series[1].Values, []float64{5, 5, 5, 5}, 0)
+
+	_, collapsed = Dedupe(api.SeriesList{Series: list.Series[:2]})
+	a.EqInt(collapsed, 0)
+}
+
+func TestUnion(t *testing.T) {
+	nan := math.NaN()
+	left := api.SeriesList{Series: []api.Timeseries{
+		{Values: []float64{1, nan}, TagSet: api.TagSet{"host": "a"}},
+		{Values: []float64{2, 2}, TagSet: api.TagSet{"host": "b"}},
+	}}
+	right := api.SeriesList{Series: []api.Timeseries{
+		{Values: []float64{3, 3}, TagSet: api.TagSet{"host": "a"}},
+		{Values: []float64{4, 4}, TagSet: api.TagSet{"host": "c"}},
+	}}
+	lists := []api.SeriesList{left, right}
+	labels := []string{"cpu", "memory"}
+	tests := []struct {
+		policy    string
+		tagSets   []api.TagSet
+		values    [][]float64
+		conflicts int
+		fails     bool
+	}{
+		{
+			policy:    UnionTag,
+			tagSets:   []api.TagSet{{"host": "a", "source": "cpu"}, {"host": "b"}, {"host": "a", "source": "memory"}, {"host": "c"}},
+			values:    [][]float64{{1, nan}, {2, 2}, {3, 3}, {4, 4}},
+			conflicts: 1,
+		},
+		{
+			policy:    UnionDedupe,
+			tagSets:   []api.TagSet{{"host": "a"}, {"host": "b"}, {"host": "c"}},
+			values:    [][]float64{{3, 3}, {2, 2}, {4, 4}},
+			conflicts: 1,
+		},
+		{policy: UnionError, fails: true},
+		{policy: "merge", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.policy)
+		result, conflicts, err := Union(lists, labels, test.policy, "source")
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		a.CheckError(err)
+		a.EqInt(conflicts, test.conflicts)
+		a.EqInt(len(result.Series), len(test.tagSets))
+		for i := range result.Series {
+			a.Eq(result.Series[i].TagSet, test.tagSets[i])
+			a.EqFloatArray(result.Series[i].Values, test.values[i], 0)
+		}
+	}
+	// The given series' tagsets aren't modified.
+	a := assert.New(t)
+	a.Eq(left.Series[0].TagSet, api.TagSet{"host": "a"})
+
+	// Without conflicts, every policy concatenates the lists.
+	for _, policy := range []string{UnionTag, UnionDedupe, UnionError} {
+		result, conflicts, err := Union([]api.SeriesList{left, {Series: right.Series[1:]}}, labels, policy, "source")
+		a.CheckError(err)
+		a.EqInt(conflicts, 0)
+		a.EqInt(len(result.Series), 3)
+	}
+
+	// A list with a repeated tagset is only accepted when deduping.
+	repeated := api.SeriesList{Series: append(append([]api.Timeseries{}, left.Series...), left.Series[0])}
+	for _, policy := range []string{UnionTag, UnionError} {
+		if result, _, err := Union([]api.SeriesList{repeated, right}, labels, policy, "source"); err == nil {
+			a.Contextf("%s", policy).Errorf("Expected an error, but got %+v", result)
+		}
+	}
+	result, _, err := Union([]api.SeriesList{repeated, right}, labels, UnionDedupe, "source")
+	a.CheckError(err)
+	a.EqInt(len(result.Series), 3)
+
+	// Lists with the same label are told apart by their positions.
+	result, conflicts, err := Union([]api.SeriesList{left, left}, []string{"cpu", "cpu"}, UnionTag, "source")
+	a.CheckError(err)
+	a.EqInt(conflicts, 2)
+	a.Eq(result.Series[0].TagSet, api.TagSet{"host": "a", "source": "1"})
+	a.Eq(result.Series[2].TagSet, api.TagSet{"host": "a", "source": "2"})
+}
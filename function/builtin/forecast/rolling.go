@@ -87,6 +87,15 @@ func newCycle(rate float64, n int) cycle {
 // That is, if you double the period, it will take twice as long as before for the level and trend parameters to update.
 // This makes it easier to use with varying period values.
 func RollingMultiplicativeHoltWinters(ys []float64, period int, levelLearningRate float64, trendLearningRate float64, seasonalLearningRate float64) []float64 {
+	estimate, _ := RollingMultiplicativeHoltWintersDeviation(ys, period, levelLearningRate, trendLearningRate, seasonalLearningRate)
+	return estimate
+}
+
+// RollingMultiplicativeHoltWintersDeviation computes the same estimate as RollingMultiplicativeHoltWinters, and also
+// the typical deviation of the data from the model's predictions. Before each observation, the model's prediction
+// is compared against the observed value; the absolute difference is exponentially averaged (per position in the
+// season, using the seasonal learning rate) to produce the deviation.
+func RollingMultiplicativeHoltWintersDeviation(ys []float64, period int, levelLearningRate float64, trendLearningRate float64, seasonalLearningRate float64) ([]float64, []float64) {
 	// We'll interpret the rates as "the effective change per whole period" (so the seasonal learning rate is unchanged).
 	// The intensity of the old value after n iterations is (1-rate)^n. We want to find rate' such that
 	// 1 - rate = (1 - rate')^n
@@ -95,10 +104,12 @@ func RollingMultiplicativeHoltWinters(ys []float64, period int, levelLearningRat
 	levelLearningRate = 1 - math.Pow(1-levelLearningRate, 1/float64(period))
 	trendLearningRate = 1 - math.Pow(1-trendLearningRate, 1/float64(period))
 	estimate := make([]float64, len(ys))
+	deviation := make([]float64, len(ys))
 
 	level := newWeighted(levelLearningRate)
 	trend := newWeighted(trendLearningRate)
 	season := newCycle(seasonalLearningRate, period)
+	residual := newCycle(seasonalLearningRate, period)
 
 	// we need to initialize the season to '1':
 	for i := 0; i < period; i++ {
@@ -111,6 +122,9 @@ func RollingMultiplicativeHoltWinters(ys []float64, period int, levelLearningRat
 		oldTrend := trend.get()
 		oldSeason := season.get(i)
 
+		// Compare the prediction against the observation. If y is NaN, this skips.
+		residual.observe(i, math.Abs(y-(oldLevel+oldTrend)*oldSeason))
+
 		// Update the level, by increasing it by the estimate slope
 		level.boostAdd(oldTrend)
 		// Then observing the new y [if y is NaN, this skips, as desired]
@@ -131,8 +145,9 @@ func RollingMultiplicativeHoltWinters(ys []float64, period int, levelLearningRat
 
 		// Our estimate is the level times the seasonal component.
 		estimate[i] = level.get() * season.get(i)
+		deviation[i] = residual.get(i)
 	}
-	return estimate
+	return estimate, deviation
 }
 
 // RollingSeasonal estimates purely seasonal data without a trend or level component.
@@ -56,8 +56,9 @@ var FunctionRollingMultiplicativeHoltWinters = function.MakeFunction(
 
 		for seriesIndex, series := range seriesList.Series {
 			result.Series[seriesIndex] = api.Timeseries{
-				TagSet: series.TagSet,
-				Values: RollingMultiplicativeHoltWinters(series.Values, samples, levelLearningRate, trendLearningRate, seasonalLearningRate)[extraSlots:], // Slice to drop the first few extra slots from the result
+				TagSet:     series.TagSet,
+				Provenance: series.Provenance,
+				Values:     RollingMultiplicativeHoltWinters(series.Values, samples, levelLearningRate, trendLearningRate, seasonalLearningRate)[extraSlots:], // Slice to drop the first few extra slots from the result
 			}
 		}
 
@@ -97,8 +98,9 @@ var FunctionRollingSeasonal = function.MakeFunction(
 
 		for seriesIndex, series := range seriesList.Series {
 			result.Series[seriesIndex] = api.Timeseries{
-				TagSet: series.TagSet,
-				Values: RollingSeasonal(series.Values, samples, seasonalLearningRate)[extraSlots:], // Slice to drop the first few extra slots from the result
+				TagSet:     series.TagSet,
+				Provenance: series.Provenance,
+				Values:     RollingSeasonal(series.Values, samples, seasonalLearningRate)[extraSlots:], // Slice to drop the first few extra slots from the result
 			}
 		}
 
@@ -133,11 +135,71 @@ var FunctionLinear = function.MakeFunction(
 
 		for seriesIndex, series := range seriesList.Series {
 			result.Series[seriesIndex] = api.Timeseries{
-				TagSet: series.TagSet,
-				Values: Linear(series.Values)[extraSlots:], // Slice to drop the first few extra slots from the result
+				TagSet:     series.TagSet,
+				Provenance: series.Provenance,
+				Values:     Linear(series.Values)[extraSlots:], // Slice to drop the first few extra slots from the result
 			}
 		}
 
 		return result, nil
 	},
 )
+
+// FunctionRollingMultiplicativeHoltWintersBands computes confidence bands around the rolling multiplicative
+// Holt-Winters model. The bands lie the given number of deviations above and below the model's estimate, where
+// the deviation is the (seasonal) average of the differences between the data and the model's predictions.
+// Each series results in two series, whose `band` tag is "upper" and "lower" respectively.
+var FunctionRollingMultiplicativeHoltWintersBands = function.MakeFunction(
+	"forecast.rolling_multiplicative_holt_winters_bands",
+	func(context function.EvaluationContext, seriesExpression function.Expression, period time.Duration, levelLearningRate float64, trendLearningRate float64, seasonalLearningRate float64, deviations float64, optionalExtraTrainingTime *time.Duration) (api.SeriesList, error) {
+		extraTrainingTime := time.Duration(0)
+		if optionalExtraTrainingTime != nil {
+			extraTrainingTime = *optionalExtraTrainingTime
+		}
+		if extraTrainingTime < 0 {
+			return api.SeriesList{}, function.UserError{Message: fmt.Sprintf("extra training time must be non-negative, but got %s", extraTrainingTime.String())}
+		}
+		if deviations < 0 {
+			return api.SeriesList{}, function.UserError{Message: fmt.Sprintf("forecast.rolling_multiplicative_holt_winters_bands expects a non-negative number of deviations, but got %f", deviations)}
+		}
+
+		samples := int(period / context.Timerange().Resolution())
+		if samples <= 0 {
+			return api.SeriesList{}, function.UserError{Message: "forecast.rolling_multiplicative_holt_winters_bands expects the period parameter to mean at least one slot"}
+		}
+
+		newContext := context.WithTimerange(context.Timerange().ExtendBefore(extraTrainingTime))
+		extraSlots := newContext.Timerange().Slots() - context.Timerange().Slots()
+		seriesList, err := function.EvaluateToSeriesList(seriesExpression, newContext)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, 0, 2*len(seriesList.Series)),
+		}
+
+		for _, series := range seriesList.Series {
+			estimate, deviation := RollingMultiplicativeHoltWintersDeviation(series.Values, samples, levelLearningRate, trendLearningRate, seasonalLearningRate)
+			// Slice to drop the first few extra slots from the result
+			estimate = estimate[extraSlots:]
+			deviation = deviation[extraSlots:]
+			upper := make([]float64, len(estimate))
+			lower := make([]float64, len(estimate))
+			for i := range estimate {
+				upper[i] = estimate[i] + deviations*deviation[i]
+				lower[i] = estimate[i] - deviations*deviation[i]
+			}
+			upperTagSet := series.TagSet.Clone()
+			upperTagSet["band"] = "upper"
+			lowerTagSet := series.TagSet.Clone()
+			lowerTagSet["band"] = "lower"
+			result.Series = append(result.Series,
+				api.Timeseries{TagSet: upperTagSet, Values: upper, Provenance: series.Provenance},
+				api.Timeseries{TagSet: lowerTagSet, Values: lower, Provenance: series.Provenance},
+			)
+		}
+
+		return result, nil
+	},
+)
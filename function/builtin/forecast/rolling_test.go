@@ -375,3 +375,34 @@ func TestRollingAccuracy(t *testing.T) {
 		computeRMSEStatistics(t, test)
 	}
 }
+
+func TestRollingMultiplicativeHoltWintersDeviation(t *testing.T) {
+	period := 10
+	clean := make([]float64, 40*period)
+	noisy := make([]float64, len(clean))
+	for i := range clean {
+		clean[i] = 10 + 3*math.Sin(2*math.Pi*float64(i)/float64(period))
+		noisy[i] = clean[i] + float64(i%3-1) // deterministic noise of magnitude at most 1
+	}
+	noisy[len(noisy)-period/2] = math.NaN()
+
+	estimate, cleanDeviation := RollingMultiplicativeHoltWintersDeviation(clean, period, 0.5, 0.2, 0.5)
+	expected := RollingMultiplicativeHoltWinters(clean, period, 0.5, 0.2, 0.5)
+	for i := range estimate {
+		if estimate[i] != expected[i] {
+			t.Fatalf("Estimate differs from RollingMultiplicativeHoltWinters at index %d: %f vs %f", i, estimate[i], expected[i])
+		}
+	}
+	_, noisyDeviation := RollingMultiplicativeHoltWintersDeviation(noisy, period, 0.5, 0.2, 0.5)
+
+	// Once the model has been trained, the clean data should be predicted almost exactly,
+	// while the noisy data should deviate by around the magnitude of the noise.
+	for i := len(clean) - period; i < len(clean); i++ {
+		if cleanDeviation[i] < 0 || cleanDeviation[i] > 0.1 {
+			t.Errorf("Expected small deviation for clean data at index %d, but got %f", i, cleanDeviation[i])
+		}
+		if math.IsNaN(noisyDeviation[i]) || noisyDeviation[i] < 0.2 || noisyDeviation[i] > 2 {
+			t.Errorf("Expected deviation near the noise level for noisy data at index %d, but got %f", i, noisyDeviation[i])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generate contains functions which fabricate series from their
+// arguments and the query's timerange, rather than fetching them.
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// constantSeries returns a series holding `value` in every slot of the timerange.
+func constantSeries(value float64, timerange api.Timerange, tagSet api.TagSet) api.Timeseries {
+	values := make([]float64, timerange.Slots())
+	for i := range values {
+		values[i] = value
+	}
+	return api.Timeseries{
+		Values: values,
+		TagSet: tagSet,
+	}
+}
+
+// ConstantLine produces a single series with the given constant value over the
+// timerange. It's intended for drawing thresholds alongside real data. When
+// a name is supplied, it's attached to the series as its "name" tag.
+var ConstantLine = function.MakeFunction(
+	"generate.constant_line",
+	func(value float64, optionalName *string, timerange api.Timerange) (api.SeriesList, error) {
+		tagSet := api.NewTagSet()
+		if optionalName != nil {
+			if *optionalName == "" {
+				return api.SeriesList{}, fmt.Errorf("generate.constant_line given empty string for name")
+			}
+			tagSet["name"] = *optionalName
+		}
+		return api.SeriesList{
+			Series: []api.Timeseries{constantSeries(value, timerange, tagSet)},
+		}, nil
+	},
+)
+
+// Sine produces a sine wave with amplitude 1 and the given period. The phase
+// is measured from the epoch, so that shifted timeranges line up.
+var Sine = function.MakeFunction(
+	"generate.sine",
+	func(period time.Duration, timerange api.Timerange) (api.SeriesList, error) {
+		if period <= 0 {
+			return api.SeriesList{}, fmt.Errorf("generate.sine expects a positive period, but got %+v", period)
+		}
+		values := make([]float64, timerange.Slots())
+		for i := range values {
+			elapsed := timerange.StartMillis() + int64(i)*timerange.ResolutionMillis()
+			values[i] = math.Sin(2 * math.Pi * float64(elapsed) / float64(period/time.Millisecond))
+		}
+		return api.SeriesList{
+			Series: []api.Timeseries{{Values: values, TagSet: api.NewTagSet()}},
+		}, nil
+	},
+)
+
+// RandomWalk produces a random walk starting from 0 with normally-distributed
+// steps. The random source is seeded (by default with 0) so that the same query
+// always produces the same series.
+var RandomWalk = function.MakeFunction(
+	"generate.random_walk",
+	func(optionalSeed *float64, timerange api.Timerange) api.SeriesList {
+		seed := int64(0)
+		if optionalSeed != nil {
+			seed = int64(*optionalSeed)
+		}
+		random := rand.New(rand.NewSource(seed))
+		values := make([]float64, timerange.Slots())
+		current := 0.0
+		for i := range values {
+			values[i] = current
+			current += random.NormFloat64()
+		}
+		return api.SeriesList{
+			Series: []api.Timeseries{{Values: values, TagSet: api.NewTagSet()}},
+		}
+	},
+)
+
+// Time produces a series whose value at each point is that point's timestamp,
+// in seconds since the epoch.
+var Time = function.MakeFunction(
+	"generate.time",
+	func(timerange api.Timerange) api.SeriesList {
+		values := make([]float64, timerange.Slots())
+		for i := range values {
+			values[i] = float64(timerange.StartMillis()+int64(i)*timerange.ResolutionMillis()) / 1000
+		}
+		return api.SeriesList{
+			Series: []api.Timeseries{{Values: values, TagSet: api.NewTagSet()}},
+		}
+	},
+)
+
+// AsSeries turns each scalar of the set into a constant series over the
+// timerange, keeping its tagset. It's the inverse of the summarize functions,
+// so that computed values (such as per-group thresholds) can be drawn
+// alongside the data.
+var AsSeries = function.MakeFunction(
+	"generate.as_series",
+	func(scalars function.ScalarSet, timerange api.Timerange) api.SeriesList {
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, len(scalars)),
+		}
+		for i, scalar := range scalars {
+			result.Series[i] = constantSeries(scalar.Value, timerange, scalar.TagSet)
+		}
+		return result
+	},
+)
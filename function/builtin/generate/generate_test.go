@@ -0,0 +1,170 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generate
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/testing_support/assert"
+
+	"golang.org/x/net/context"
+)
+
+type literal struct {
+	value function.Value
+}
+
+func (lit literal) ExpressionString(mode function.DescriptionMode) string {
+	if mode == function.StringMemoization {
+		return fmt.Sprintf("%#v", lit)
+	}
+	return "<literal>"
+}
+func (lit literal) Evaluate(context function.EvaluationContext) (function.Value, error) {
+	return lit.value, nil
+}
+
+func evaluate(t *testing.T, fun function.Function, timerange api.Timerange, arguments ...function.Value) (api.SeriesList, error) {
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	expressions := make([]function.Expression, len(arguments))
+	for i := range arguments {
+		expressions[i] = literal{arguments[i]}
+	}
+	value, err := fun.Run(ctx, expressions, function.Groups{})
+	if err != nil {
+		return api.SeriesList{}, err
+	}
+	list, convErr := value.ToSeriesList(timerange)
+	if convErr != nil {
+		t.Fatalf("Conversion to series list failed: %s", convErr.WithContext("<literal>").Error())
+	}
+	return list, nil
+}
+
+func TestConstantLine(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+
+	list, err := evaluate(t, ConstantLine, timerange, function.ScalarValue(100))
+	a.CheckError(err)
+	a.Eq(list, api.SeriesList{
+		Series: []api.Timeseries{{Values: []float64{100, 100, 100, 100, 100}, TagSet: api.NewTagSet()}},
+	})
+
+	list, err = evaluate(t, ConstantLine, timerange, function.ScalarValue(-2.5), function.StringValue("threshold"))
+	a.CheckError(err)
+	a.Eq(list, api.SeriesList{
+		Series: []api.Timeseries{{Values: []float64{-2.5, -2.5, -2.5, -2.5, -2.5}, TagSet: api.TagSet{"name": "threshold"}}},
+	})
+
+	if _, err := evaluate(t, ConstantLine, timerange, function.ScalarValue(1), function.StringValue("")); err == nil {
+		t.Errorf("Expected an error for an empty name, but got none")
+	}
+}
+
+func TestSine(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 4*15000, 15000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+	list, err := evaluate(t, Sine, timerange, function.NewDurationValue("1m", time.Minute))
+	a.CheckError(err)
+	a.EqInt(len(list.Series), 1)
+	a.EqFloatArray(list.Series[0].Values, []float64{0, 1, 0, -1, 0}, 1e-10)
+
+	if _, err := evaluate(t, Sine, timerange, function.NewDurationValue("0s", 0)); err == nil {
+		t.Errorf("Expected an error for a zero period, but got none")
+	}
+}
+
+func TestRandomWalk(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 100*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+	first, err := evaluate(t, RandomWalk, timerange)
+	a.CheckError(err)
+	second, err := evaluate(t, RandomWalk, timerange)
+	a.CheckError(err)
+	a.Eq(first, second)
+	a.EqInt(len(first.Series[0].Values), timerange.Slots())
+	a.EqFloat(first.Series[0].Values[0], 0, 0)
+
+	seeded, err := evaluate(t, RandomWalk, timerange, function.ScalarValue(7))
+	a.CheckError(err)
+	a.EqBool(seeded.Series[0].Values[timerange.Slots()-1] == first.Series[0].Values[timerange.Slots()-1], false)
+	for _, value := range seeded.Series[0].Values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			t.Fatalf("Expected finite random walk values but got %+v", seeded.Series[0].Values)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(60000, 60000+3*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+	list, err := evaluate(t, Time, timerange)
+	a.CheckError(err)
+	a.EqFloatArray(list.Series[0].Values, []float64{60, 90, 120, 150}, 0)
+}
+
+func TestAsSeries(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 2*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+	nan := math.NaN()
+
+	list, err := evaluate(t, AsSeries, timerange, function.ScalarSet{
+		{TagSet: api.TagSet{"dc": "north"}, Value: 3},
+		{TagSet: api.TagSet{"dc": "south"}, Value: nan},
+	})
+	a.CheckError(err)
+	a.EqInt(len(list.Series), 2)
+	a.Eq(list.Series[0].TagSet, api.TagSet{"dc": "north"})
+	a.EqFloatArray(list.Series[0].Values, []float64{3, 3, 3}, 0)
+	a.Eq(list.Series[1].TagSet, api.TagSet{"dc": "south"})
+	a.EqFloatArray(list.Series[1].Values, []float64{nan, nan, nan}, 0)
+
+	// A plain scalar is a set with a single, untagged member.
+	list, err = evaluate(t, AsSeries, timerange, function.ScalarValue(7))
+	a.CheckError(err)
+	a.Eq(list, api.SeriesList{
+		Series: []api.Timeseries{{Values: []float64{7, 7, 7}, TagSet: api.TagSet{}}},
+	})
+
+	list, err = evaluate(t, AsSeries, timerange, function.ScalarSet{})
+	a.CheckError(err)
+	a.EqInt(len(list.Series), 0)
+
+	// Series lists aren't scalar sets.
+	if _, err := evaluate(t, AsSeries, timerange, function.SeriesListValue(list)); err == nil {
+		t.Errorf("Expected an error when given a series list")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package join
+
+import (
+	"fmt"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// Resample brings series fetched at different resolutions onto the finest
+// among them, noting when it does so.
+func Resample(context function.EvaluationContext, name string, series []api.Timeseries) ([]api.Timeseries, error) {
+	result, resampled, err := api.ResampleToFinest(series)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	if resampled {
+		context.AddNote(fmt.Sprintf("%s was given series at different resolutions; the coarser ones were resampled onto the finest", name))
+	}
+	return result, nil
+}
+
+// Combine joins the two lists and applies the operator to each pair of values
+// in every joined row.
+func Combine(context function.EvaluationContext, name string, leftList api.SeriesList, rightList api.SeriesList, operator func(float64, float64) float64) (api.SeriesList, error) {
+	series, err := Resample(context, name, append(append([]api.Timeseries{}, leftList.Series...), rightList.Series...))
+	if err != nil {
+		return api.SeriesList{}, err
+	}
+	leftList = api.SeriesList{Series: series[:len(leftList.Series)]}
+	rightList = api.SeriesList{Series: series[len(leftList.Series):]}
+	joined := Join([]api.SeriesList{leftList, rightList})
+
+	result := make([]api.Timeseries, len(joined.Rows))
+
+	for i, row := range joined.Rows {
+		left := row.Row[0]
+		right := row.Row[1]
+		array := make([]float64, len(left.Values))
+		for j := 0; j < len(left.Values); j++ {
+			array[j] = operator(left.Values[j], right.Values[j])
+		}
+		result[i] = api.Timeseries{Values: array, TagSet: row.TagSet, Provenance: api.CombineProvenance(row.Row)}
+	}
+
+	return api.SeriesList{
+		Series: result,
+	}, nil
+}
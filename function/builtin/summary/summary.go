@@ -15,11 +15,16 @@
 package summary
 
 import (
+	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/builtin/join"
 )
 
 var recentScaled = func(name string, summarizer func([]float64, api.Timerange) float64) function.MetricFunction {
@@ -56,56 +61,62 @@ var recent = func(name string, summarizer func([]float64) float64) function.Metr
 // Mean computes an average tagged scalar for each time series line.
 var Mean = recent(
 	"summarize.mean",
-	func(slice []float64) float64 {
-		sum := 0.0
-		count := 0
-		for i := range slice {
-			if math.IsNaN(slice[i]) {
-				continue
-			}
-			sum += slice[i]
-			count++
-		}
-		return sum / float64(count)
-	},
+	meanNotNaN,
 )
 
+func meanNotNaN(slice []float64) float64 {
+	sum := 0.0
+	count := 0
+	for i := range slice {
+		if math.IsNaN(slice[i]) {
+			continue
+		}
+		sum += slice[i]
+		count++
+	}
+	return sum / float64(count)
+}
+
 // Min computes a minimum tagged scalar for each time series line.
 var Min = recent(
 	"summarize.min",
-	func(slice []float64) float64 {
-		min := math.NaN()
-		for i := range slice {
-			if math.IsNaN(min) {
-				min = slice[i]
-			}
-			if math.IsNaN(slice[i]) {
-				continue
-			}
-			min = math.Min(min, slice[i])
-		}
-		return min
-	},
+	minNotNaN,
 )
 
+func minNotNaN(slice []float64) float64 {
+	min := math.NaN()
+	for i := range slice {
+		if math.IsNaN(min) {
+			min = slice[i]
+		}
+		if math.IsNaN(slice[i]) {
+			continue
+		}
+		min = math.Min(min, slice[i])
+	}
+	return min
+}
+
 // Max computes a maximum tagged scalar for each time series line.
 var Max = recent(
 	"summarize.max",
-	func(slice []float64) float64 {
-		max := math.NaN()
-		for i := range slice {
-			if math.IsNaN(max) {
-				max = slice[i]
-			}
-			if math.IsNaN(slice[i]) {
-				continue
-			}
-			max = math.Max(max, slice[i])
-		}
-		return max
-	},
+	maxNotNaN,
 )
 
+func maxNotNaN(slice []float64) float64 {
+	max := math.NaN()
+	for i := range slice {
+		if math.IsNaN(max) {
+			max = slice[i]
+		}
+		if math.IsNaN(slice[i]) {
+			continue
+		}
+		max = math.Max(max, slice[i])
+	}
+	return max
+}
+
 // Integral computes the (scaled) integral of the time series line.
 var Integral = recentScaled(
 	"summarize.integral",
@@ -124,18 +135,20 @@ var Integral = recentScaled(
 // Count computes the number of non-missing points in the line
 var Count = recent(
 	"summarize.count",
-	func(slice []float64) float64 {
-		count := 0
-		for i := range slice {
-			if math.IsNaN(slice[i]) {
-				continue
-			}
-			count++
-		}
-		return float64(count)
-	},
+	countNotNaN,
 )
 
+func countNotNaN(slice []float64) float64 {
+	count := 0
+	for i := range slice {
+		if math.IsNaN(slice[i]) {
+			continue
+		}
+		count++
+	}
+	return float64(count)
+}
+
 // Total computes the total number of points in the line
 var Total = recent(
 	"summarize.total",
@@ -160,16 +173,47 @@ var FirstNotNaN = recent(
 // LastNotNaN computes the last not NaN tagged scalar for each time series.
 var LastNotNaN = recent(
 	"summarize.last_not_nan",
-	func(slice []float64) float64 {
-		for i := range slice {
-			if !math.IsNaN(slice[len(slice)-1-i]) {
-				return slice[len(slice)-1-i]
-			}
+	lastNotNaN,
+)
+
+func lastNotNaN(slice []float64) float64 {
+	for i := range slice {
+		if !math.IsNaN(slice[len(slice)-1-i]) {
+			return slice[len(slice)-1-i]
 		}
-		return math.NaN()
-	},
+	}
+	return math.NaN()
+}
+
+// PercentChange computes, for each time series, how much it grew: the change
+// from its first finite value to its last finite value, as a percentage of the
+// first. A series which starts at zero, or has no finite values, is NaN.
+var PercentChange = recent(
+	"summarize.percent_change",
+	percentChange,
 )
 
+func percentChange(slice []float64) float64 {
+	first, last := math.NaN(), math.NaN()
+	for _, value := range slice {
+		if !finite(value) {
+			continue
+		}
+		if math.IsNaN(first) {
+			first = value
+		}
+		last = value
+	}
+	if first == 0 {
+		return math.NaN()
+	}
+	return (last - first) / first * 100
+}
+
+func finite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
 // Oldest computes the first tagged scalar for each time series.
 var Oldest = function.MakeFunction(
 	"summarize.oldest",
@@ -201,3 +245,318 @@ var Current = function.MakeFunction(
 		return result
 	},
 )
+
+// statistics are the summarizers which can be chosen by name with Stat.
+var statistics = map[string]func([]float64) float64{
+	"min":   minNotNaN,
+	"max":   maxNotNaN,
+	"mean":  meanNotNaN,
+	"avg":   meanNotNaN, // Graphite's name for the mean
+	"last":  lastNotNaN,
+	"count": countNotNaN,
+}
+
+// statisticNamed looks up the statistic with the given name for the function.
+func statisticNamed(functionName string, name string) (func([]float64) float64, error) {
+	summarizer, ok := statistics[name]
+	if !ok {
+		names := make([]string, 0, len(statistics))
+		for name := range statistics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%s expects one of %s as its statistic, but got %q", functionName, strings.Join(names, ", "), name)
+	}
+	return summarizer, nil
+}
+
+// Stat computes the named statistic (min, max, mean, last or count) over the
+// whole timerange for each time series line, ignoring missing points.
+var Stat = function.MakeFunction(
+	"summarize.stat",
+	func(list api.SeriesList, statistic string) (function.ScalarSet, error) {
+		summarizer, err := statisticNamed("summarize.stat", statistic)
+		if err != nil {
+			return nil, err
+		}
+		result := function.ScalarSet{}
+		for i := range list.Series {
+			result = append(result, function.TaggedScalar{
+				TagSet: list.Series[i].TagSet,
+				Value:  summarizer(list.Series[i].Values),
+			})
+		}
+		return result, nil
+	},
+)
+
+// CrossingsAbove counts, for each time series, how many times it crossed
+// above the threshold: a point at or below it followed immediately by one
+// above it. Missing points (NaN) break a crossing. This counts incidents
+// rather than the points spent above the threshold.
+var CrossingsAbove = function.MakeFunction(
+	"summarize.crossings_above",
+	func(list api.SeriesList, threshold float64) function.ScalarSet {
+		result := function.ScalarSet{}
+		for i := range list.Series {
+			values := list.Series[i].Values
+			crossings := 0
+			for j := 1; j < len(values); j++ {
+				if values[j-1] <= threshold && values[j] > threshold {
+					crossings++ // comparisons with NaN are always false
+				}
+			}
+			result = append(result, function.TaggedScalar{
+				TagSet: list.Series[i].TagSet,
+				Value:  float64(crossings),
+			})
+		}
+		return result
+	},
+)
+
+// maxHistogramBins bounds the number of bins in a histogram, since each
+// becomes a separate scalar in the result.
+const maxHistogramBins = 1000
+
+// ValueHistogram buckets, for each time series, its samples into `bins`
+// equal-width bins between its minimum and maximum, and counts the samples in
+// each. NaNs and infinities are excluded. Each bin is a half-open interval [low, high), except
+// for the last, which includes the maximum. Every bin is reported (even if it's
+// empty), with its edges in the "bin_low" and "bin_high" tags. A constant
+// series has a single bin holding all of its samples, and a series with no
+// samples has no bins at all.
+var ValueHistogram = function.MakeFunction(
+	"summarize.value_histogram",
+	func(list api.SeriesList, bins float64) (function.ScalarSet, error) {
+		if bins != math.Floor(bins) || bins < 1 || bins > maxHistogramBins {
+			return nil, fmt.Errorf("summarize.value_histogram expected a whole number of bins between 1 and %d but got %g", maxHistogramBins, bins)
+		}
+		result := function.ScalarSet{}
+		for _, series := range list.Series {
+			low, high := math.Inf(1), math.Inf(-1)
+			for _, value := range series.Values {
+				if finite(value) {
+					low = math.Min(low, value)
+					high = math.Max(high, value)
+				}
+			}
+			if low > high {
+				continue // no samples
+			}
+			n := int(bins)
+			if low == high {
+				n = 1
+			}
+			width := (high - low) / float64(n)
+			counts := make([]int, n)
+			for _, value := range series.Values {
+				if !finite(value) {
+					continue
+				}
+				// The maximum belongs to the last bin, as does any value whose
+				// position can't be computed (when the range overflows).
+				bin := n - 1
+				if position := (value - low) / width; width > 0 && position < float64(n-1) {
+					bin = int(math.Max(position, 0))
+				}
+				counts[bin]++
+			}
+			for i, count := range counts {
+				binHigh := low + float64(i+1)*width
+				if i == n-1 {
+					binHigh = high
+				}
+				tagSet := series.TagSet.Clone()
+				tagSet["bin_low"] = strconv.FormatFloat(low+float64(i)*width, 'g', -1, 64)
+				tagSet["bin_high"] = strconv.FormatFloat(binHigh, 'g', -1, 64)
+				result = append(result, function.TaggedScalar{
+					TagSet: tagSet,
+					Value:  float64(count),
+				})
+			}
+		}
+		return result, nil
+	},
+)
+
+// CrossCorrelation finds how one series leads or lags another. The two lists
+// are joined on their tags (as the arithmetic operators join them), and for
+// each pair it reports the Pearson correlation of the first series with the
+// second shifted by each whole number of buckets from -maxLag to maxLag, with
+// the shift in the "lag" tag. A positive lag correlates the first series with
+// later values of the second, so a peak at lag 3 means the first leads the
+// second by 3 buckets. Pairs of points with a NaN are excluded; a lag with
+// fewer than 2 pairs, or where either side is constant, has a NaN correlation.
+// The correlations are a scalar set rather than a series, since a series'
+// points are indexed by time rather than by lag.
+var CrossCorrelation = function.MakeFunction(
+	"summarize.cross_correlation",
+	func(leftList api.SeriesList, rightList api.SeriesList, maxLagFloat float64) (function.ScalarSet, error) {
+		if maxLagFloat != math.Floor(maxLagFloat) || maxLagFloat < 0 {
+			return nil, fmt.Errorf("summarize.cross_correlation expected a non-negative whole number of buckets for the maximum lag but got %g", maxLagFloat)
+		}
+		series, _, err := api.ResampleToFinest(append(append([]api.Timeseries{}, leftList.Series...), rightList.Series...))
+		if err != nil {
+			return nil, fmt.Errorf("summarize.cross_correlation: %s", err.Error())
+		}
+		leftList = api.SeriesList{Series: series[:len(leftList.Series)]}
+		rightList = api.SeriesList{Series: series[len(leftList.Series):]}
+		maxLag := int(maxLagFloat)
+		result := function.ScalarSet{}
+		for _, row := range join.Join([]api.SeriesList{leftList, rightList}).Rows {
+			left, right := row.Row[0].Values, row.Row[1].Values
+			if maxLag >= len(left) {
+				return nil, fmt.Errorf("summarize.cross_correlation expected a maximum lag shorter than the %d buckets of the timerange but got %d", len(left), maxLag)
+			}
+			for lag := -maxLag; lag <= maxLag; lag++ {
+				tagSet := row.TagSet.Clone()
+				tagSet["lag"] = strconv.Itoa(lag)
+				result = append(result, function.TaggedScalar{
+					TagSet: tagSet,
+					Value:  laggedCorrelation(left, right, lag),
+				})
+			}
+		}
+		return result, nil
+	},
+)
+
+// laggedCorrelation is the Pearson correlation of left[i] with right[i+lag],
+// over the indices where both are present.
+func laggedCorrelation(left []float64, right []float64, lag int) float64 {
+	xs, ys := []float64{}, []float64{}
+	for i := range left {
+		j := i + lag
+		if j < 0 || j >= len(right) || math.IsNaN(left[i]) || math.IsNaN(right[j]) {
+			continue
+		}
+		xs = append(xs, left[i])
+		ys = append(ys, right[j])
+	}
+	if len(xs) < 2 {
+		return math.NaN()
+	}
+	meanX, meanY := meanNotNaN(xs), meanNotNaN(ys)
+	var covariance, varianceX, varianceY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+	if varianceX == 0 || varianceY == 0 {
+		return math.NaN()
+	}
+	return covariance / math.Sqrt(varianceX*varianceY)
+}
+
+// ByTimeOfDay collapses each series into a profile of a single day: the
+// samples are grouped by the bucket of the day (such as the hour, for 1h
+// buckets) in which their wall-clock time falls, in the given timezone (or
+// else the context's), and each group is summarized by the named statistic.
+//
+// The result is a series list with one point per bucket, over a fixed day
+// which doesn't depend on the query: the day starting at the Unix epoch, with
+// the bucket size as its resolution, so that each point's timestamp (read in
+// UTC) is the time of day of its bucket. Since that day isn't part of the
+// query's timerange, the profile can't be combined with the query's series. A
+// bucket without samples has whatever the statistic gives for no values (NaN
+// for the mean). The timerange needn't cover whole days, so the buckets may
+// summarize different numbers of days. Buckets are by wall-clock time, so
+// across a daylight saving change the repeated hour's samples all fall in the
+// same bucket, and the skipped hour's bucket has none.
+var ByTimeOfDay = function.MakeFunction(
+	"summarize.by_time_of_day",
+	func(list api.SeriesList, bucket time.Duration, statistic string, zone *string, timerange api.Timerange, context function.EvaluationContext) (function.TrimmedSeriesListValue, error) {
+		if bucket < time.Minute || (24*time.Hour)%bucket != 0 {
+			return function.TrimmedSeriesListValue{}, fmt.Errorf("summarize.by_time_of_day expected a bucket of at least a minute which evenly divides a day, but got %+v", bucket)
+		}
+		summarizer, err := statisticNamed("summarize.by_time_of_day", statistic)
+		if err != nil {
+			return function.TrimmedSeriesListValue{}, err
+		}
+		location := context.Location()
+		if zone != nil {
+			location, err = time.LoadLocation(*zone)
+			if err != nil {
+				return function.TrimmedSeriesListValue{}, fmt.Errorf("summarize.by_time_of_day got an unknown timezone %q", *zone)
+			}
+		}
+		buckets := int(24 * time.Hour / bucket)
+		resolution := int64(bucket / time.Millisecond)
+		day, err := api.NewTimerange(0, int64(buckets-1)*resolution, resolution)
+		if err != nil {
+			return function.TrimmedSeriesListValue{}, err
+		}
+		// bucketOf[i] is the bucket of the day holding the i-th sample.
+		bucketOf := make([]int, timerange.Slots())
+		for i := range bucketOf {
+			hour, minute, second := timerange.TimeOfIndex(i).In(location).Clock()
+			clock := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+			bucketOf[i] = int(clock / bucket)
+		}
+		result := api.SeriesList{Series: make([]api.Timeseries, len(list.Series))}
+		for i, series := range list.Series {
+			grouped := make([][]float64, buckets)
+			for j, value := range series.Values {
+				if j < len(bucketOf) {
+					grouped[bucketOf[j]] = append(grouped[bucketOf[j]], value)
+				}
+			}
+			values := make([]float64, buckets)
+			for b := range grouped {
+				values[b] = summarizer(grouped[b])
+			}
+			result.Series[i] = api.Timeseries{
+				Values:     values,
+				TagSet:     series.TagSet,
+				Provenance: series.Provenance,
+			}
+		}
+		return function.TrimmedSeriesListValue{List: result, Timerange: day}, nil
+	},
+)
+
+// ValueAt reads the value of each series at the given offset before the end
+// of the timerange, rounded to the nearest point. If that point is missing,
+// the nearest point within the tolerance (by default, two resolutions) is used
+// instead, preferring the earlier one on ties; if there is none, it's NaN.
+var ValueAt = function.MakeFunction(
+	"summarize.value_at",
+	func(list api.SeriesList, offset time.Duration, optionalTolerance *time.Duration, timerange api.Timerange) (function.ScalarSet, error) {
+		resolution := timerange.Resolution()
+		tolerance := 2 * resolution
+		if optionalTolerance != nil {
+			tolerance = *optionalTolerance
+		}
+		if offset < 0 || offset > timerange.Duration() {
+			return nil, fmt.Errorf("summarize.value_at expected an offset within the timerange's duration %+v, but got %+v", timerange.Duration(), offset)
+		}
+		if tolerance < 0 {
+			return nil, fmt.Errorf("summarize.value_at expected a non-negative tolerance, but got %+v", tolerance)
+		}
+		target := timerange.Slots() - 1 - int((offset+resolution/2)/resolution)
+		if target < 0 {
+			target = 0
+		}
+		window := int(tolerance / resolution)
+		result := function.ScalarSet{}
+		for _, series := range list.Series {
+			value := math.NaN()
+			for distance := 0; distance <= window && math.IsNaN(value); distance++ {
+				for _, index := range []int{target - distance, target + distance} {
+					if index >= 0 && index < len(series.Values) && !math.IsNaN(series.Values[index]) {
+						value = series.Values[index]
+						break
+					}
+				}
+			}
+			result = append(result, function.TaggedScalar{
+				TagSet: series.TagSet,
+				Value:  value,
+			})
+		}
+		return result, nil
+	},
+)
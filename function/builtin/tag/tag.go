@@ -16,9 +16,11 @@ package tag
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
+	"github.com/square/metrics/query/natural_sort"
 )
 
 // dropTagSeries returns a copy of the timeseries where the given `dropTag` has been removed from its TagSet.
@@ -101,6 +103,123 @@ func CopyTag(list api.SeriesList, target string, source string) (api.SeriesList,
 	}, nil
 }
 
+// ProvenanceTag returns a copy of the series list where `tag` (by default
+// "provenance") is set to the fetches that each series was computed from.
+// Series whose provenance is unknown are left untagged.
+func ProvenanceTag(list api.SeriesList, tag *string) (api.SeriesList, error) {
+	name := "provenance"
+	if tag != nil {
+		name = *tag
+	}
+	if name == "" {
+		return api.SeriesList{}, fmt.Errorf("tag.provenance given empty string for tag")
+	}
+	series := make([]api.Timeseries, len(list.Series))
+	for i := range series {
+		series[i] = list.Series[i]
+		if series[i].Provenance != "" {
+			series[i] = setTagSeries(series[i], name, series[i].Provenance)
+		}
+	}
+	return api.SeriesList{
+		Series: series,
+	}, nil
+}
+
+// GroupKeys returns the distinct keys of the groups that the series list falls
+// into, in natural order. The groups are given either by `tag` or by the
+// query's `group by` clause. A series without a tag has an empty value for it.
+// With a single tag, each key is that tag's value; otherwise, it's the
+// serialized tagset of the grouped tags (e.g. "dc=north,env=production").
+func GroupKeys(list api.SeriesList, tag *string, groups function.Groups) (function.StringSetValue, error) {
+	tags := groups.List
+	if tag != nil {
+		tags = []string{*tag}
+	} else if groups.Collapses {
+		return nil, fmt.Errorf("tag.group_keys can't be used with a collapse by clause")
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("tag.group_keys requires a tag or a group by clause")
+	}
+	seen := map[string]bool{}
+	keys := function.StringSetValue{}
+	for _, series := range list.Series {
+		key := series.TagSet[tags[0]]
+		if len(tags) > 1 {
+			grouped := api.NewTagSet()
+			for _, tag := range tags {
+				grouped[tag] = series.TagSet[tag]
+			}
+			key = grouped.Serialize()
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	natural_sort.Sort(keys)
+	return keys, nil
+}
+
+// Pivot reshapes the series list so that every group of series (those which
+// agree on all tags other than `tag`) has one series for each distinct value
+// of `tag` across the whole list, in the same natural order. A group which
+// lacks some value gets a series of NaNs in its place, so that the groups can
+// be laid out as rows of a table. Groups are also in natural order of their
+// serialized tagsets.
+func Pivot(list api.SeriesList, tag string, timerange api.Timerange) (api.SeriesList, error) {
+	if tag == "" {
+		return api.SeriesList{}, fmt.Errorf("tag.pivot given empty string for tag")
+	}
+	values := []string{}
+	seen := map[string]bool{}
+	keys := []string{}
+	groups := map[string]api.TagSet{}               // group key => the group's tags, other than `tag`
+	cells := map[string]map[string]api.Timeseries{} // group key => value => series
+	for _, series := range list.Series {
+		value, ok := series.TagSet[tag]
+		if !ok {
+			return api.SeriesList{}, fmt.Errorf("tag.pivot given series %s which has no tag %q", series.TagSet.Serialize(), tag)
+		}
+		rest := series.TagSet.Clone()
+		delete(rest, tag)
+		key := rest.Serialize()
+		if _, ok := groups[key]; !ok {
+			groups[key] = rest
+			cells[key] = map[string]api.Timeseries{}
+			keys = append(keys, key)
+		}
+		if _, ok := cells[key][value]; ok {
+			return api.SeriesList{}, fmt.Errorf("tag.pivot given several series with tags %s", series.TagSet.Serialize())
+		}
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+		cells[key][value] = series
+	}
+	natural_sort.Sort(keys)
+	natural_sort.Sort(values)
+	result := make([]api.Timeseries, 0, len(keys)*len(values))
+	for _, key := range keys {
+		for _, value := range values {
+			series, ok := cells[key][value]
+			if !ok {
+				nans := make([]float64, timerange.Slots())
+				for i := range nans {
+					nans[i] = math.NaN()
+				}
+				series = api.Timeseries{Values: nans}
+			}
+			series.TagSet = setTagSeries(api.Timeseries{TagSet: groups[key]}, tag, value).TagSet
+			result = append(result, series)
+		}
+	}
+	return api.SeriesList{
+		Series: result,
+	}, nil
+}
+
 // DropFunction wraps up DropTag into a Function called "tag.drop"
 var DropFunction = function.MakeFunction("tag.drop", DropTag)
 
@@ -109,3 +228,12 @@ var SetFunction = function.MakeFunction("tag.set", SetTag)
 
 // CopyFunction wraps up CopyTag into a Function called "tag.copy"
 var CopyFunction = function.MakeFunction("tag.copy", CopyTag)
+
+// ProvenanceFunction wraps up ProvenanceTag into a Function called "tag.provenance"
+var ProvenanceFunction = function.MakeFunction("tag.provenance", ProvenanceTag)
+
+// GroupKeysFunction wraps up GroupKeys into a Function called "tag.group_keys"
+var GroupKeysFunction = function.MakeFunction("tag.group_keys", GroupKeys)
+
+// PivotFunction wraps up Pivot into a Function called "tag.pivot"
+var PivotFunction = function.MakeFunction("tag.pivot", Pivot)
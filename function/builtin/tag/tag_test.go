@@ -481,3 +481,51 @@ func TestCopy(t *testing.T) {
 		}
 	}
 }
+
+func TestPivot(t *testing.T) {
+	timerange, err := api.NewTimerange(0, 90, 30)
+	if err != nil {
+		t.Fatalf("unexpected error creating timerange: %s", err.Error())
+	}
+	nan := math.NaN()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{5, 6, 7, 8}, TagSet: api.TagSet{"host": "b", "status": "500"}},
+			{Values: []float64{1, 2, 3, 4}, TagSet: api.TagSet{"host": "a", "status": "200"}},
+			{Values: []float64{9, 9, 9, 9}, TagSet: api.TagSet{"host": "b", "status": "200"}},
+			{Values: []float64{0, 0, 1, 0}, TagSet: api.TagSet{"host": "a", "status": "404"}},
+		},
+	}
+	result, err := Pivot(list, "status", timerange)
+	if err != nil {
+		t.Fatalf("unexpected error from Pivot: %s", err.Error())
+	}
+	expected := []api.Timeseries{
+		{Values: []float64{1, 2, 3, 4}, TagSet: api.TagSet{"host": "a", "status": "200"}},
+		{Values: []float64{0, 0, 1, 0}, TagSet: api.TagSet{"host": "a", "status": "404"}},
+		{Values: []float64{nan, nan, nan, nan}, TagSet: api.TagSet{"host": "a", "status": "500"}},
+		{Values: []float64{9, 9, 9, 9}, TagSet: api.TagSet{"host": "b", "status": "200"}},
+		{Values: []float64{nan, nan, nan, nan}, TagSet: api.TagSet{"host": "b", "status": "404"}},
+		{Values: []float64{5, 6, 7, 8}, TagSet: api.TagSet{"host": "b", "status": "500"}},
+	}
+	a := assert.New(t)
+	a.EqInt(len(result.Series), len(expected))
+	for i := range expected {
+		if i < len(result.Series) {
+			a.Contextf("series %d", i).Eq(result.Series[i].TagSet, expected[i].TagSet)
+			a.Contextf("series %d", i).EqFloatArray(result.Series[i].Values, expected[i].Values, 0)
+		}
+	}
+
+	for _, bad := range []api.SeriesList{
+		{Series: []api.Timeseries{{Values: []float64{1, 2, 3, 4}, TagSet: api.TagSet{"host": "a"}}}},
+		{Series: []api.Timeseries{
+			{Values: []float64{1, 2, 3, 4}, TagSet: api.TagSet{"host": "a", "status": "200"}},
+			{Values: []float64{1, 2, 3, 4}, TagSet: api.TagSet{"host": "a", "status": "200"}},
+		}},
+	} {
+		if _, err := Pivot(bad, "status", timerange); err == nil {
+			a.Errorf("expected an error pivoting %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"math"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// domainGuard wraps a mathematical function so that values outside of its
+// domain (such as the logarithm of 0 or a negative number) produce NaN rather
+// than an infinity. Infinite inputs are passed through unchanged.
+func domainGuard(fun func(float64) float64) func(float64) float64 {
+	return func(x float64) float64 {
+		if math.IsNaN(x) {
+			return math.NaN()
+		}
+		y := fun(x)
+		if math.IsInf(y, 0) && !math.IsInf(x, 0) {
+			return math.NaN()
+		}
+		return y
+	}
+}
+
+// Sqrt computes the square root of each value. Negative values become NaN.
+var Sqrt = MapMaker("transform.sqrt", domainGuard(math.Sqrt))
+
+// Log computes the base-10 logarithm of each value. Non-positive values become NaN.
+var Log = MapMaker("transform.log", domainGuard(math.Log10))
+
+// Log2 computes the base-2 logarithm of each value. Non-positive values become NaN.
+var Log2 = MapMaker("transform.log2", domainGuard(math.Log2))
+
+// Log10 computes the base-10 logarithm of each value. Non-positive values become NaN.
+var Log10 = MapMaker("transform.log10", domainGuard(math.Log10))
+
+// Exp computes e raised to each value. Results which overflow become NaN.
+var Exp = MapMaker("transform.exp", domainGuard(math.Exp))
+
+// Pow raises each value to the given power. Results which aren't real (such as
+// a fractional power of a negative number) or which are infinite (such as a
+// negative power of 0) become NaN.
+var Pow = function.MakeFunction(
+	"transform.pow",
+	func(list api.SeriesList, exponent float64) api.SeriesList {
+		return api.MapSeriesList(list, domainGuard(func(x float64) float64 {
+			return math.Pow(x, exponent)
+		}))
+	},
+)
+
+// Sin computes the sine of each value, taken as radians.
+var Sin = MapMaker("transform.sin", math.Sin)
+
+// Cos computes the cosine of each value, taken as radians.
+var Cos = MapMaker("transform.cos", math.Cos)
+
+// Tan computes the tangent of each value, taken as radians.
+var Tan = MapMaker("transform.tan", math.Tan)
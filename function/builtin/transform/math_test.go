@@ -0,0 +1,116 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/testing_support/assert"
+
+	"golang.org/x/net/context"
+)
+
+func TestMathDomain(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 5*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+	tests := []struct {
+		fun        function.Function
+		values     []float64
+		parameters []function.Value
+		expected   []float64
+	}{
+		{
+			fun:      Sqrt,
+			values:   []float64{4, 0, -0.0001, -4, nan, 9},
+			expected: []float64{2, 0, nan, nan, nan, 3},
+		},
+		{
+			fun:      Log,
+			values:   []float64{100, 1, 0.001, 0, -1, nan},
+			expected: []float64{2, 0, -3, nan, nan, nan},
+		},
+		{
+			fun:      Log2,
+			values:   []float64{8, 1, 0.5, 0, -8, nan},
+			expected: []float64{3, 0, -1, nan, nan, nan},
+		},
+		{
+			fun:      Log10,
+			values:   []float64{1000, 1, 0.1, 0, -1000, nan},
+			expected: []float64{3, 0, -1, nan, nan, nan},
+		},
+		{
+			fun:      Exp,
+			values:   []float64{0, 1, -1000, 1000, nan, -1},
+			expected: []float64{1, math.E, 0, nan, nan, 1 / math.E},
+		},
+		{
+			fun:        Pow,
+			values:     []float64{2, 0, -2, 4, nan, 1},
+			parameters: []function.Value{function.ScalarValue(2)},
+			expected:   []float64{4, 0, 4, 16, nan, 1},
+		},
+		{
+			fun:        Pow,
+			values:     []float64{4, 0, -4, 1, nan, 0.25},
+			parameters: []function.Value{function.ScalarValue(-0.5)},
+			expected:   []float64{0.5, nan, nan, 1, nan, 2},
+		},
+		{
+			fun:      Sin,
+			values:   []float64{0, math.Pi / 2, math.Pi, -math.Pi / 2, nan, math.Inf(1)},
+			expected: []float64{0, 1, 0, -1, nan, nan},
+		},
+		{
+			fun:      Cos,
+			values:   []float64{0, math.Pi / 2, math.Pi, 2 * math.Pi, nan, math.Inf(-1)},
+			expected: []float64{1, 0, -1, 1, nan, nan},
+		},
+		{
+			fun:      Tan,
+			values:   []float64{0, math.Pi / 4, -math.Pi / 4, math.Pi, nan, math.Inf(1)},
+			expected: []float64{0, 1, -1, 0, nan, nan},
+		},
+	}
+	for i, test := range tests {
+		a := assert.New(t).Contextf("test %d (%s)", i, test.fun.Name())
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: test.values, TagSet: api.TagSet{"host": "a"}}},
+		}
+		arguments := []function.Expression{literal{function.SeriesListValue(list)}}
+		for _, parameter := range test.parameters {
+			arguments = append(arguments, literal{parameter})
+		}
+		result, err := test.fun.Run(ctx, arguments, function.Groups{})
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 1e-10)
+	}
+}
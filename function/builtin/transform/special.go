@@ -17,10 +17,14 @@ package transform
 import (
 	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/builtin/join"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/expression"
 )
 
 var Timeshift = function.MakeFunction(
@@ -31,24 +35,175 @@ var Timeshift = function.MakeFunction(
 	},
 )
 
+// LastDuration keeps only the end of each series, within the given duration
+// of the end of the query's timerange, without fetching again. The result
+// covers a correspondingly shorter timerange; combined with other series, it's
+// padded with NaN to cover the whole query. A duration longer than the query
+// keeps the whole series.
+var LastDuration = function.MakeFunction(
+	"transform.last_duration",
+	func(list api.SeriesList, duration time.Duration, timerange api.Timerange) (function.TrimmedSeriesListValue, error) {
+		if duration < 0 {
+			return function.TrimmedSeriesListValue{}, fmt.Errorf("transform.last_duration must be given a non-negative duration, but got %+v", duration)
+		}
+		last, lastTimerange, err := list.Last(timerange, duration)
+		if err != nil {
+			return function.TrimmedSeriesListValue{}, err
+		}
+		return function.TrimmedSeriesListValue{List: last, Timerange: lastTimerange}, nil
+	},
+)
+
+// Decimate keeps only every few values of each series, dropping the rest
+// without combining them, which cheaply reduces the size of results for
+// displays such as sparklines. The result has a correspondingly coarser
+// resolution; combined with other series, the dropped values are NaN. The kept
+// values are those whose timestamps are multiples of the new resolution.
+var Decimate = function.MakeFunction(
+	"transform.decimate",
+	func(list api.SeriesList, factor float64, timerange api.Timerange) (function.TrimmedSeriesListValue, error) {
+		if factor < 1 || factor != math.Floor(factor) {
+			return function.TrimmedSeriesListValue{}, fmt.Errorf("transform.decimate must be given a positive integer factor, but got %g", factor)
+		}
+		decimated, decimatedTimerange, err := list.Decimate(timerange, int(factor))
+		if err != nil {
+			return function.TrimmedSeriesListValue{}, err
+		}
+		return function.TrimmedSeriesListValue{List: decimated, Timerange: decimatedTimerange}, nil
+	},
+)
+
+// CompareToPast evaluates the list both over the query's timerange and over
+// the timerange the given duration earlier, shifted forward so that the two
+// overlay. The current series are tagged `period=current`, and the past series
+// are tagged with the (negated) offset, such as `period=-7d`. A `period` tag
+// already on the series is replaced.
+var CompareToPast = function.MakeFunction(
+	"transform.compare_to_past",
+	func(listExpression function.Expression, past time.Duration, context function.EvaluationContext) (api.SeriesList, error) {
+		if past <= 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.compare_to_past must be given a positive duration, but got %+v", past)
+		}
+		current, err := function.EvaluateToSeriesList(listExpression, context)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		shifted, err := function.EvaluateToSeriesList(listExpression, context.WithTimerange(context.Timerange().Shift(-past)))
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, 0, len(current.Series)+len(shifted.Series)),
+		}
+		for _, period := range []struct {
+			name string
+			list api.SeriesList
+		}{{"current", current}, {"-" + durationLabel(past), shifted}} {
+			for _, series := range period.list.Series {
+				tagSet := series.TagSet.Clone()
+				tagSet["period"] = period.name
+				result.Series = append(result.Series, api.Timeseries{
+					Values:     series.Values,
+					TagSet:     tagSet,
+					Provenance: series.Provenance,
+				})
+			}
+		}
+		return result, nil
+	},
+)
+
+// DiffFromBaseline evaluates the list both over the query's timerange and over
+// the timerange the given duration earlier (the baseline), and gives each
+// current series its difference from the baseline series with the same tags.
+// In "percent" mode, the difference is a percentage of the baseline's
+// magnitude instead, which is NaN where the baseline is zero. The results are
+// tagged `delta` with the mode and `baseline` with the (negated) offset, such as
+// `baseline=-1d`. A series without a baseline gives NaN.
+var DiffFromBaseline = function.MakeFunction(
+	"transform.diff_from_baseline",
+	func(listExpression function.Expression, past time.Duration, mode *string, context function.EvaluationContext) (api.SeriesList, error) {
+		if past <= 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.diff_from_baseline must be given a positive duration, but got %+v", past)
+		}
+		delta := "difference"
+		if mode != nil {
+			delta = *mode
+		}
+		if delta != "difference" && delta != "percent" {
+			return api.SeriesList{}, fmt.Errorf(`transform.diff_from_baseline expected mode "difference" or "percent" but got %q`, delta)
+		}
+		current, err := function.EvaluateToSeriesList(listExpression, context)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		shifted, err := function.EvaluateToSeriesList(listExpression, context.WithTimerange(context.Timerange().Shift(-past)))
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		baselines := map[string][]float64{}
+		for _, series := range shifted.Series {
+			baselines[series.TagSet.Serialize()] = series.Values
+		}
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, len(current.Series)),
+		}
+		for i, series := range current.Series {
+			baseline := baselines[series.TagSet.Serialize()]
+			values := make([]float64, len(series.Values))
+			for j, value := range series.Values {
+				values[j] = math.NaN()
+				if j >= len(baseline) {
+					continue
+				}
+				switch delta {
+				case "difference":
+					values[j] = value - baseline[j]
+				case "percent":
+					if baseline[j] != 0 {
+						values[j] = (value - baseline[j]) / math.Abs(baseline[j]) * 100
+					}
+				}
+			}
+			tagSet := series.TagSet.Clone()
+			tagSet["delta"] = delta
+			tagSet["baseline"] = "-" + durationLabel(past)
+			result.Series[i] = api.Timeseries{
+				Values:     values,
+				TagSet:     tagSet,
+				Provenance: series.Provenance,
+			}
+		}
+		return result, nil
+	},
+)
+
+// durationLabel writes the duration in the largest unit which divides it
+// evenly, as in "7d" or "90m".
+func durationLabel(duration time.Duration) string {
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	} {
+		if duration%unit.size == 0 {
+			return fmt.Sprintf("%d%s", duration/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dms", duration/time.Millisecond)
+}
+
 var MovingAverage = function.MakeFunction(
 	"transform.moving_average",
 	func(context function.EvaluationContext, listExpression function.Expression, size time.Duration) (api.SeriesList, error) {
 		if size < 0 {
 			return api.SeriesList{}, fmt.Errorf("transform.moving_average must be given a non-negative duration")
 		}
-		// Applying a similar trick as did TimeshiftFunction. It fetches data prior to the start of the timerange.
-		limit := int(float64(size)/float64(context.Timerange().Resolution()) + 0.5) // Limit is the number of items to include in the average
-		if limit < 1 {
-			// At least one value must be included at all times
-			limit = 1
-		}
-
-		timerange := context.Timerange()
-		newTimerange := timerange.ExtendBefore(time.Duration(limit-1) * timerange.Resolution())
-		newContext := context.WithTimerange(newTimerange)
-		// The new context has a timerange which is extended beyond the query's.
-		list, err := function.EvaluateToSeriesList(listExpression, newContext)
+		list, limit, err := evaluateWindowed(context, listExpression, size)
 		if err != nil {
 			return api.SeriesList{}, err
 		}
@@ -124,8 +279,9 @@ var ExponentialMovingAverage = function.MakeFunction(
 				values[t] = sum / weight
 			}
 			resultList.Series[i] = api.Timeseries{
-				Values: values[newTimerange.Slots()-timerange.Slots():],
-				TagSet: list.Series[i].TagSet,
+				Values:     values[newTimerange.Slots()-timerange.Slots():],
+				TagSet:     list.Series[i].TagSet,
+				Provenance: list.Series[i].Provenance,
 			}
 		}
 		return resultList, nil
@@ -134,9 +290,14 @@ var ExponentialMovingAverage = function.MakeFunction(
 
 // Derivative is special because it needs to get one extra data point to the left
 // This transform estimates the "change per second" between the two samples (scaled consecutive difference)
+// If a unit is given, the change is instead measured per that duration (e.g. 1m for "change per minute").
 var Derivative = function.MakeFunction(
 	"transform.derivative",
-	func(listExpression function.Expression, context function.EvaluationContext) (api.SeriesList, error) {
+	func(listExpression function.Expression, per *time.Duration, context function.EvaluationContext) (api.SeriesList, error) {
+		unit, err := rateUnit("transform.derivative", per)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
 		newContext := context.WithTimerange(context.Timerange().ExtendBefore(context.Timerange().Resolution()))
 		list, err := function.EvaluateToSeriesList(listExpression, newContext)
 		if err != nil {
@@ -152,11 +313,12 @@ var Derivative = function.MakeFunction(
 					continue
 				}
 				// Scaled difference
-				newValues[i-1] = (series.Values[i] - series.Values[i-1]) / context.Timerange().Resolution().Seconds()
+				newValues[i-1] = (series.Values[i] - series.Values[i-1]) / context.Timerange().Resolution().Seconds() * unit
 			}
 			resultList.Series[seriesIndex] = api.Timeseries{
-				Values: newValues,
-				TagSet: series.TagSet, // TODO: verify that these are immutable
+				Values:     newValues,
+				TagSet:     series.TagSet, // TODO: verify that these are immutable
+				Provenance: series.Provenance,
 			}
 		}
 		return resultList, nil
@@ -168,9 +330,152 @@ var Derivative = function.MakeFunction(
 // Specifically this function is designed for strictly increasing counters that
 // only decrease when reset to zero. That is, thie function returns consecutive
 // differences which are at least 0, or math.Max of the newly reported value and 0
+//
+// If a maximum gap is given, missing samples are skipped over: the rate is taken
+// from the most recent earlier sample, provided it's no more than the maximum gap
+// before, and is NaN otherwise. Without it, any missing sample results in NaN.
+//
+// The rate is per second; RatePer measures it per another unit.
 var Rate = function.MakeFunction(
 	"transform.rate",
+	func(listExpression function.Expression, maxGap *time.Duration, context function.EvaluationContext) (api.SeriesList, error) {
+		return rate("transform.rate", listExpression, maxGap, 1, context)
+	},
+)
+
+// RatePer is Rate measured per the given unit rather than per second, as in
+// transform.rate_per(series, 1m) for the change per minute. Its optional third
+// argument is the maximum gap, as for Rate.
+var RatePer = function.MakeFunction(
+	"transform.rate_per",
+	func(listExpression function.Expression, per time.Duration, maxGap *time.Duration, context function.EvaluationContext) (api.SeriesList, error) {
+		unit, err := rateUnit("transform.rate_per", &per)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		return rate("transform.rate_per", listExpression, maxGap, unit, context)
+	},
+)
+
+// rate evaluates the series of counters with the extra points they need, as
+// described by Rate, and returns their rates scaled by the unit (in seconds).
+func rate(name string, listExpression function.Expression, maxGap *time.Duration, unit float64, context function.EvaluationContext) (api.SeriesList, error) {
+	resolution := context.Timerange().Resolution()
+	lookback := 1 // the number of points before each one which may be used to compute its rate
+	if maxGap != nil {
+		if *maxGap < resolution {
+			return api.SeriesList{}, fmt.Errorf("%s expected a maximum gap of at least the resolution %+v but got %+v", name, resolution, *maxGap)
+		}
+		lookback = int(*maxGap / resolution)
+	}
+	newContext := context.WithTimerange(context.Timerange().ExtendBefore(time.Duration(lookback) * resolution))
+	list, err := function.EvaluateToSeriesList(listExpression, newContext)
+	if err != nil {
+		return api.SeriesList{}, err
+	}
+	resultList := api.SeriesList{
+		Series: make([]api.Timeseries, len(list.Series)),
+	}
+	for i, series := range list.Series {
+		resultList.Series[i] = rateSeries(context, series, lookback)
+		for j := range resultList.Series[i].Values {
+			resultList.Series[i].Values[j] *= unit
+		}
+	}
+	return resultList, nil
+}
+
+// rateUnit returns the number of seconds in the (optional) unit which a rate
+// is measured per, defaulting to 1.
+func rateUnit(name string, per *time.Duration) (float64, error) {
+	if per == nil {
+		return 1, nil
+	}
+	if *per <= 0 {
+		return 0, fmt.Errorf("%s expected a positive unit duration but got %+v", name, *per)
+	}
+	return per.Seconds(), nil
+}
+
+// rateSeries computes the rate of a counter series which was evaluated with
+// `lookback` extra points at its start, as described by Rate. The result
+// doesn't include the extra points.
+func rateSeries(context function.EvaluationContext, series api.Timeseries, lookback int) api.Timeseries {
+	resolution := context.Timerange().Resolution()
+	newValues := make([]float64, len(series.Values)-lookback)
+	for i := lookback; i < len(series.Values); i++ {
+		// j is the sample the rate is measured from; only a maximum gap lets it skip missing samples.
+		j := i - 1
+		for j > i-lookback && math.IsNaN(series.Values[j]) {
+			j--
+		}
+		seconds := float64(i-j) * resolution.Seconds()
+		// Scaled difference
+		newValues[i-lookback] = (series.Values[i] - series.Values[j]) / seconds
+		if newValues[i-lookback] < 0 {
+			newValues[i-lookback] = 0
+		}
+		if i+1 < len(series.Values) && series.Values[j] > series.Values[i] && series.Values[i] <= series.Values[i+1] {
+			// Downsampling may cause a drop from 1000 to 0 to look like [1000, 500, 0] instead of [1000, 1001, 0].
+			// So we check the next, in addition to the previous.
+			context.AddNote(fmt.Sprintf("Rate(%v): The underlying counter reset between %f, %f\n", series.TagSet, series.Values[j], series.Values[i]))
+			// values[i] is our best approximatation of the delta between j and i
+			// Why? This should only be used on counters, so if v[i] - v[j] < 0 then
+			// the counter has reset, and we know *at least* v[i] increments have happened
+			newValues[i-lookback] = math.Max(series.Values[i], 0) / seconds
+		}
+	}
+	return api.Timeseries{
+		Values:     newValues,
+		TagSet:     series.TagSet, // TODO: verify that these are immutable
+		Provenance: series.Provenance,
+	}
+}
+
+// metricNameTag is the tag which fetch.by_tag sets to each series' metric name.
+const metricNameTag = "name"
+
+// AutoRate applies Rate to the series of counters, and passes the series of
+// gauges through unchanged, according to the kinds recorded by the metadata
+// API. Each series' metric is the one named by the fetch, if the argument is a
+// plain fetch, or else the one in its `name` tag (as set by fetch.by_tag).
+// Series whose kind is unknown are passed through unchanged, with a note, as
+// are those of any kind other than a counter or a gauge.
+var AutoRate = function.MakeFunction(
+	"transform.auto_rate",
 	func(listExpression function.Expression, context function.EvaluationContext) (api.SeriesList, error) {
+		fetchedMetric := ""
+		if actual, ok := function.Unmemoize(listExpression); ok {
+			if fetch, ok := actual.(*expression.MetricFetchExpression); ok {
+				fetchedMetric = fetch.MetricName
+			}
+		}
+		kindAPI, _ := context.MetricMetadataAPI().(metadata.MetricKindAPI)
+		kinds := map[string]metadata.MetricKind{}
+		kindOf := func(metric string) (metadata.MetricKind, error) {
+			if kind, ok := kinds[metric]; ok {
+				return kind, nil
+			}
+			kind := metadata.KindUnknown
+			if kindAPI != nil && metric != "" {
+				var err error
+				kind, err = kindAPI.GetMetricKind(api.MetricKey(metric), metadata.Context{Profiler: context.Profiler()})
+				if err != nil {
+					return "", err
+				}
+			}
+			if kind != metadata.KindCounter && kind != metadata.KindGauge {
+				if metric == "" {
+					context.AddNote("transform.auto_rate: some series have no known metric, so they were passed through unchanged")
+				} else {
+					context.AddNote(fmt.Sprintf("transform.auto_rate: the kind of metric %q is unknown, so its series were passed through unchanged", metric))
+				}
+			}
+			kinds[metric] = kind
+			return kind, nil
+		}
+
+		// One extra point is fetched at the start for the rates of counters.
 		newContext := context.WithTimerange(context.Timerange().ExtendBefore(context.Timerange().Resolution()))
 		list, err := function.EvaluateToSeriesList(listExpression, newContext)
 		if err != nil {
@@ -179,32 +484,598 @@ var Rate = function.MakeFunction(
 		resultList := api.SeriesList{
 			Series: make([]api.Timeseries, len(list.Series)),
 		}
-		for seriesIndex, series := range list.Series {
-			newValues := make([]float64, len(series.Values)-1)
-			for i := range series.Values {
-				if i == 0 {
-					continue
+		for i, series := range list.Series {
+			metric := fetchedMetric
+			if metric == "" {
+				metric = series.TagSet[metricNameTag]
+			}
+			kind, err := kindOf(metric)
+			if err != nil {
+				return api.SeriesList{}, err
+			}
+			if kind == metadata.KindCounter {
+				resultList.Series[i] = rateSeries(context, series, 1)
+				continue
+			}
+			resultList.Series[i] = api.Timeseries{
+				Values:     series.Values[1:],
+				TagSet:     series.TagSet,
+				Provenance: series.Provenance,
+			}
+		}
+		return resultList, nil
+	},
+)
+
+// evaluateWindowed evaluates the list over a timerange extended back by the
+// size of the window, so that each point of the query's timerange has a full
+// window ending at it. It returns the number of values in each window.
+func evaluateWindowed(context function.EvaluationContext, listExpression function.Expression, size time.Duration) (api.SeriesList, int, error) {
+	// Applying a similar trick as did TimeshiftFunction. It fetches data prior to the start of the timerange.
+	limit := int(float64(size)/float64(context.Timerange().Resolution()) + 0.5) // Limit is the number of items in each window
+	if limit < 1 {
+		// At least one value must be included at all times
+		limit = 1
+	}
+	timerange := context.Timerange()
+	newContext := context.WithTimerange(timerange.ExtendBefore(time.Duration(limit-1) * timerange.Resolution()))
+	// The new context has a timerange which is extended beyond the query's.
+	list, err := function.EvaluateToSeriesList(listExpression, newContext)
+	if err != nil {
+		return api.SeriesList{}, 0, err
+	}
+	return list, limit, nil
+}
+
+// movingWindow replaces each value of the list with the result of `reduce`
+// applied to the window of values ending at that point.
+func movingWindow(context function.EvaluationContext, listExpression function.Expression, size time.Duration, reduce func([]float64) float64) (api.SeriesList, error) {
+	list, limit, err := evaluateWindowed(context, listExpression, size)
+	if err != nil {
+		return api.SeriesList{}, err
+	}
+	slots := context.Timerange().Slots()
+	return transformEach(list, func(values []float64) []float64 {
+		results := make([]float64, slots)
+		for i := range results {
+			results[i] = reduce(values[i : i+limit])
+		}
+		return results
+	}), nil
+}
+
+// windowExtreme returns the most extreme non-NaN value in the window according
+// to `pick`, or NaN if there are none.
+func windowExtreme(pick func(float64, float64) float64) func([]float64) float64 {
+	return func(window []float64) float64 {
+		result := math.NaN()
+		for _, value := range window {
+			if math.IsNaN(value) {
+				continue
+			}
+			if math.IsNaN(result) {
+				result = value
+				continue
+			}
+			result = pick(result, value)
+		}
+		return result
+	}
+}
+
+// Envelope computes the moving maximum and moving minimum of each series over
+// the given window. Each series produces two series, whose `envelope` tag is
+// "upper" and "lower" respectively.
+var Envelope = function.MakeFunction(
+	"transform.envelope",
+	func(context function.EvaluationContext, listExpression function.Expression, size time.Duration) (api.SeriesList, error) {
+		if size < 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.envelope must be given a non-negative duration")
+		}
+		upper, err := movingWindow(context, listExpression, size, windowExtreme(math.Max))
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		lower, err := movingWindow(context, listExpression, size, windowExtreme(math.Min))
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, 0, 2*len(upper.Series)),
+		}
+		for i := range upper.Series {
+			for _, bound := range []struct {
+				name   string
+				series api.Timeseries
+			}{{"upper", upper.Series[i]}, {"lower", lower.Series[i]}} {
+				tagSet := bound.series.TagSet.Clone()
+				tagSet["envelope"] = bound.name
+				result.Series = append(result.Series, api.Timeseries{
+					Values:     bound.series.Values,
+					TagSet:     tagSet,
+					Provenance: bound.series.Provenance,
+				})
+			}
+		}
+		return result, nil
+	},
+)
+
+// PercentileOverTime computes, for each point of each series, the given
+// percentile of the values in the window ending at that point. Each series is
+// treated on its own; values aren't combined across series. NaN values are
+// ignored, and a window without any other values gives NaN.
+var PercentileOverTime = function.MakeFunction(
+	"transform.percentile_over_time",
+	func(context function.EvaluationContext, listExpression function.Expression, size time.Duration, percentile float64) (api.SeriesList, error) {
+		if size < 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.percentile_over_time must be given a non-negative duration")
+		}
+		if !(0 <= percentile && percentile <= 100) {
+			return api.SeriesList{}, fmt.Errorf("transform.percentile_over_time must be given a percentile between 0 and 100, but got %f", percentile)
+		}
+		return movingWindow(context, listExpression, size, windowPercentile(percentile))
+	},
+)
+
+// windowPercentile returns the given percentile of the non-NaN values in the
+// window, interpolating linearly between the closest ranks, or NaN if there
+// are none.
+func windowPercentile(percentile float64) func([]float64) float64 {
+	return func(window []float64) float64 {
+		sorted := make([]float64, 0, len(window))
+		for _, value := range window {
+			if !math.IsNaN(value) {
+				sorted = append(sorted, value)
+			}
+		}
+		if len(sorted) == 0 {
+			return math.NaN()
+		}
+		sort.Float64s(sorted)
+		rank := percentile / 100 * float64(len(sorted)-1)
+		lower := int(math.Floor(rank))
+		if lower == len(sorted)-1 {
+			return sorted[lower]
+		}
+		fraction := rank - float64(lower)
+		return sorted[lower] + fraction*(sorted[lower+1]-sorted[lower])
+	}
+}
+
+// Splice combines two lists, preferring the values of the first (such as a
+// recent, high-resolution series) wherever they're finite, and falling back to
+// the values of the second (such as a historical backfill) elsewhere. Series
+// are paired by identical tagsets, and keep that tagset. Series without a
+// partner in the other list are included unchanged. A list with several series
+// with the same tagset is rejected, since they can't be paired.
+//
+// Both lists are evaluated over the query's timerange, so their points are
+// always aligned to the same resolution.
+var Splice = function.MakeFunction(
+	"transform.splice",
+	func(preferred api.SeriesList, fallback api.SeriesList) (api.SeriesList, error) {
+		preferredIndex, err := preferred.IndexByTagSet()
+		if err != nil {
+			return api.SeriesList{}, fmt.Errorf("transform.splice: %s", err.Error())
+		}
+		fallbackIndex, err := fallback.IndexByTagSet()
+		if err != nil {
+			return api.SeriesList{}, fmt.Errorf("transform.splice: %s", err.Error())
+		}
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, 0, len(preferred.Series)+len(fallback.Series)),
+		}
+		for _, series := range preferred.Series {
+			j, ok := fallbackIndex[series.TagSet.Serialize()]
+			if !ok {
+				result.Series = append(result.Series, series)
+				continue
+			}
+			other := fallback.Series[j]
+			values := make([]float64, len(series.Values))
+			for i, value := range series.Values {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					value = other.Values[i]
 				}
-				// Scaled difference
-				newValues[i-1] = (series.Values[i] - series.Values[i-1]) / context.Timerange().Resolution().Seconds()
-				if newValues[i-1] < 0 {
-					newValues[i-1] = 0
+				values[i] = value
+			}
+			result.Series = append(result.Series, api.Timeseries{
+				Values: values,
+				TagSet: series.TagSet,
+			})
+		}
+		for _, series := range fallback.Series {
+			if _, ok := preferredIndex[series.TagSet.Serialize()]; !ok {
+				result.Series = append(result.Series, series)
+			}
+		}
+		return result, nil
+	},
+)
+
+// scaleFactorFor finds the factor whose tagset matches the given one, which is
+// the most specific factor all of whose tags agree with it. ok is false when
+// none match.
+func scaleFactorFor(factors function.ScalarSet, tagSet api.TagSet) (factor float64, ok bool, err error) {
+	best := -1
+	for _, candidate := range factors {
+		matches := true
+		for key, value := range candidate.TagSet {
+			if tagSet[key] != value {
+				matches = false
+				break
+			}
+		}
+		if !matches || len(candidate.TagSet) < best {
+			continue
+		}
+		if len(candidate.TagSet) == best && candidate.Value != factor {
+			return 0, false, fmt.Errorf("transform.scale_by_tagset found several factors for the series %s", tagSet.Serialize())
+		}
+		best = len(candidate.TagSet)
+		factor = candidate.Value
+	}
+	return factor, best >= 0, nil
+}
+
+// ScaleByTagSet multiplies each series by the factor (from a scalar set, such
+// as the result of a grouped summary) whose tagset matches the series'. A
+// factor matches when each of its tags has the same value on the series; the
+// most specific match is used. Series without a factor are kept unchanged
+// (with a note), or dropped if `unmatched` is "drop".
+var ScaleByTagSet = function.MakeFunction(
+	"transform.scale_by_tagset",
+	func(list api.SeriesList, factors function.ScalarSet, unmatched *string, context function.EvaluationContext) (api.SeriesList, error) {
+		drop := false
+		if unmatched != nil {
+			switch *unmatched {
+			case "keep":
+			case "drop":
+				drop = true
+			default:
+				return api.SeriesList{}, fmt.Errorf(`transform.scale_by_tagset expected "keep" or "drop" for unmatched series but got %q`, *unmatched)
+			}
+		}
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, 0, len(list.Series)),
+		}
+		missing := 0
+		for _, series := range list.Series {
+			factor, ok, err := scaleFactorFor(factors, series.TagSet)
+			if err != nil {
+				return api.SeriesList{}, err
+			}
+			if !ok {
+				missing++
+				if !drop {
+					result.Series = append(result.Series, series)
+				}
+				continue
+			}
+			values := make([]float64, len(series.Values))
+			for i, value := range series.Values {
+				values[i] = value * factor
+			}
+			result.Series = append(result.Series, api.Timeseries{
+				Values: values,
+				TagSet: series.TagSet,
+			})
+		}
+		if missing > 0 && !drop {
+			context.AddNote(fmt.Sprintf("transform.scale_by_tagset found no factor for %d series, which were left unscaled", missing))
+		}
+		return result, nil
+	},
+)
+
+// RemoveOutliers replaces samples lying more than `threshold` median absolute
+// deviations from their series' median with NaN. NaNs are ignored when
+// computing the median and deviation; a series whose deviation is zero (such
+// as a constant one) is left unchanged.
+var RemoveOutliers = function.MakeFunction(
+	"transform.remove_outliers",
+	func(list api.SeriesList, threshold float64) (api.SeriesList, error) {
+		if !(threshold > 0) {
+			return api.SeriesList{}, fmt.Errorf("transform.remove_outliers expected a positive threshold but got %f", threshold)
+		}
+		median := windowPercentile(50)
+		return transformEach(list, func(values []float64) []float64 {
+			center := median(values)
+			deviations := make([]float64, len(values))
+			for i, value := range values {
+				deviations[i] = math.Abs(value - center)
+			}
+			deviation := median(deviations)
+			result := make([]float64, len(values))
+			for i, value := range values {
+				if deviation > 0 && deviations[i] > threshold*deviation {
+					value = math.NaN()
 				}
-				if i+1 < len(series.Values) && series.Values[i-1] > series.Values[i] && series.Values[i] <= series.Values[i+1] {
-					// Downsampling may cause a drop from 1000 to 0 to look like [1000, 500, 0] instead of [1000, 1001, 0].
-					// So we check the next, in addition to the previous.
-					context.AddNote(fmt.Sprintf("Rate(%v): The underlying counter reset between %f, %f\n", series.TagSet, series.Values[i-1], series.Values[i]))
-					// values[i] is our best approximatation of the delta between i-1 and i
-					// Why? This should only be used on counters, so if v[i] - v[i-1] < 0 then
-					// the counter has reset, and we know *at least* v[i] increments have happened
-					newValues[i-1] = math.Max(series.Values[i], 0) / context.Timerange().Resolution().Seconds()
+				result[i] = value
+			}
+			return result
+		}), nil
+	},
+)
+
+// Stack makes each series the running total of itself and every series before
+// it in the list, so that the last traces the total, as drawn by a stacked area
+// chart. NaNs count as zero, so every stacked point is defined.
+var Stack = function.MakeFunction(
+	"transform.stack",
+	func(list api.SeriesList) api.SeriesList {
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, len(list.Series)),
+		}
+		var total []float64
+		for i, series := range list.Series {
+			if total == nil {
+				total = make([]float64, len(series.Values))
+			}
+			values := make([]float64, len(series.Values))
+			for j, value := range series.Values {
+				if j < len(total) && !math.IsNaN(value) {
+					total[j] += value
+				}
+				if j < len(total) {
+					values[j] = total[j]
 				}
 			}
-			resultList.Series[seriesIndex] = api.Timeseries{
-				Values: newValues,
-				TagSet: series.TagSet, // TODO: verify that these are immutable
+			result.Series[i] = api.Timeseries{
+				Values: values,
+				TagSet: series.TagSet,
 			}
 		}
-		return resultList, nil
+		return result
+	},
+)
+
+// Rank replaces each value with its rank among the series of its group at the
+// same timestamp, where 1 is the highest value. The groups are given by the
+// `group by` (or `collapse by`) clause, or else the whole list is one group.
+// Tied values share the best of their ranks, so that 5, 5, 3 are ranked 1, 1,
+// 3. NaN values are unranked, and stay NaN.
+var Rank = function.MakeFunction(
+	"transform.rank",
+	func(list api.SeriesList, groups function.Groups, context function.EvaluationContext) api.SeriesList {
+		groups.NoteAbsentTags(context, list)
+		members := map[string][]int{} // group key => the indices of its series
+		keys := []string{}
+		for i, series := range list.Series {
+			grouped := api.NewTagSet()
+			if groups.Collapses {
+				grouped = series.TagSet.Clone()
+				for _, tag := range groups.List {
+					delete(grouped, tag)
+				}
+			} else {
+				for _, tag := range groups.List {
+					grouped[tag] = series.TagSet[tag]
+				}
+			}
+			key := grouped.Serialize()
+			if _, ok := members[key]; !ok {
+				keys = append(keys, key)
+			}
+			members[key] = append(members[key], i)
+		}
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, len(list.Series)),
+		}
+		for i, series := range list.Series {
+			values := make([]float64, len(series.Values))
+			for j := range values {
+				values[j] = math.NaN()
+			}
+			result.Series[i] = api.Timeseries{
+				Values: values,
+				TagSet: series.TagSet,
+			}
+		}
+		for _, key := range keys {
+			indices := members[key]
+			column := []float64{}
+			for j := 0; ; j++ {
+				column = column[:0]
+				present := false
+				for _, i := range indices {
+					if j < len(list.Series[i].Values) {
+						present = true
+						if value := list.Series[i].Values[j]; !math.IsNaN(value) {
+							column = append(column, value)
+						}
+					}
+				}
+				if !present {
+					break
+				}
+				sort.Float64s(column)
+				for _, i := range indices {
+					if j >= len(list.Series[i].Values) || math.IsNaN(list.Series[i].Values[j]) {
+						continue
+					}
+					value := list.Series[i].Values[j]
+					// The rank is one more than the number of greater values.
+					greater := len(column) - sort.Search(len(column), func(k int) bool { return column[k] > value })
+					result.Series[i].Values[j] = float64(greater + 1)
+				}
+			}
+		}
+		return result
+	},
+)
+
+// AlignTo resamples each series of the list onto the grid of the reference
+// list's series (such as a low-resolution target onto a high-resolution
+// actual). The method is "linear" (the default) or "nearest".
+var AlignTo = function.MakeFunction(
+	"transform.align_to",
+	func(list api.SeriesList, reference api.SeriesList, method *string) (api.SeriesList, error) {
+		resample := api.ResampleLinear
+		if method != nil {
+			switch *method {
+			case "linear":
+			case "nearest":
+				resample = api.ResampleNearest
+			default:
+				return api.SeriesList{}, fmt.Errorf(`transform.align_to expected "linear" or "nearest" but got %q`, *method)
+			}
+		}
+		if len(reference.Series) == 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.align_to was given an empty reference")
+		}
+		points := len(reference.Series[0].Values)
+		for _, series := range reference.Series {
+			if len(series.Values) != points {
+				return api.SeriesList{}, fmt.Errorf("transform.align_to was given a reference whose series have different resolutions")
+			}
+		}
+		return transformEach(list, func(values []float64) []float64 {
+			return api.ResampleValues(values, points, resample)
+		}), nil
+	},
+)
+
+// EWMA exponentially smooths each series, as y[t] = alpha*x[t] + (1-alpha)*y[t-1].
+// The first finite sample seeds the filter; NaN samples carry the previous
+// smoothed value (and are NaN until the filter is seeded). Unlike
+// transform.exponential_moving_average, no data before the timerange is fetched.
+var EWMA = function.MakeFunction(
+	"transform.ewma",
+	func(list api.SeriesList, alpha float64) (api.SeriesList, error) {
+		if !(alpha > 0 && alpha <= 1) {
+			return api.SeriesList{}, fmt.Errorf("transform.ewma expected alpha in the interval (0, 1] but got %f", alpha)
+		}
+		return transformEach(list, func(values []float64) []float64 {
+			result := make([]float64, len(values))
+			smoothed := math.NaN()
+			for i, value := range values {
+				switch {
+				case math.IsNaN(value):
+				case math.IsNaN(smoothed):
+					smoothed = value
+				default:
+					smoothed = alpha*value + (1-alpha)*smoothed
+				}
+				result[i] = smoothed
+			}
+			return result
+		}), nil
+	},
+)
+
+// RateLimit models applying a token-bucket rate limit to each series of
+// request counts. The bucket holds at most `burst` tokens (by default, `rate`)
+// and starts full; each point adds `rate` tokens, and then serves as many of
+// its requests as there are tokens. Requests which aren't served are carried
+// forward to be served by later points, so that the result is the number
+// served at each point. NaN samples have no requests.
+var RateLimit = function.MakeFunction(
+	"transform.rate_limit",
+	func(list api.SeriesList, rate float64, burstArgument *float64) (api.SeriesList, error) {
+		if !(rate > 0) {
+			return api.SeriesList{}, fmt.Errorf("transform.rate_limit expected a positive rate but got %f", rate)
+		}
+		burst := rate
+		if burstArgument != nil {
+			burst = *burstArgument
+		}
+		if !(burst >= rate) {
+			return api.SeriesList{}, fmt.Errorf("transform.rate_limit expected a burst of at least the rate %f but got %f", rate, burst)
+		}
+		return transformEach(list, func(values []float64) []float64 {
+			result := make([]float64, len(values))
+			tokens := burst - rate // the first point's refill fills the bucket
+			backlog := 0.0
+			for i, value := range values {
+				tokens = math.Min(tokens+rate, burst)
+				if !math.IsNaN(value) {
+					backlog += value
+				}
+				served := math.Min(backlog, tokens)
+				tokens -= served
+				backlog -= served
+				result[i] = served
+			}
+			return result
+		}), nil
+	},
+)
+
+// RequireFresh guards against evaluating stale data: a series is stale if its
+// most recent finite sample is older than maxAge at the end of the timerange
+// (or if it has no finite samples at all). The action for stale series is
+// "error" (the default), which fails the query, or "nan", which replaces their
+// values with NaN and adds a note.
+var RequireFresh = function.MakeFunction(
+	"transform.require_fresh",
+	func(context function.EvaluationContext, list api.SeriesList, maxAge time.Duration, action *string) (api.SeriesList, error) {
+		if maxAge < 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.require_fresh expected a non-negative age but got %+v", maxAge)
+		}
+		fail := true
+		if action != nil {
+			switch *action {
+			case "error":
+			case "nan":
+				fail = false
+			default:
+				return api.SeriesList{}, fmt.Errorf(`transform.require_fresh expected "error" or "nan" but got %q`, *action)
+			}
+		}
+		timerange := context.Timerange()
+		result := api.SeriesList{
+			Series: make([]api.Timeseries, len(list.Series)),
+		}
+		for i, series := range list.Series {
+			result.Series[i] = series
+			last := len(series.Values) - 1
+			for last >= 0 && math.IsNaN(series.Values[last]) {
+				last--
+			}
+			if last >= 0 && timerange.End().Sub(timerange.TimeOfIndex(last)) <= maxAge {
+				continue
+			}
+			description := "has no data"
+			if last >= 0 {
+				description = fmt.Sprintf("was last updated %+v before the end of the timerange", timerange.End().Sub(timerange.TimeOfIndex(last)))
+			}
+			if fail {
+				return api.SeriesList{}, fmt.Errorf("transform.require_fresh: series %s %s, which exceeds the maximum age of %+v", series.TagSet.Serialize(), description, maxAge)
+			}
+			context.AddNote(fmt.Sprintf("transform.require_fresh: series %s %s, which exceeds the maximum age of %+v; its values were replaced with NaN", series.TagSet.Serialize(), description, maxAge))
+			values := make([]float64, len(series.Values))
+			for j := range values {
+				values[j] = math.NaN()
+			}
+			result.Series[i].Values = values
+		}
+		return result, nil
+	},
+)
+
+// AssertClose compares two expressions point by point, joined as the binary
+// operators are, with 1 where they agree within the tolerance and 0 where they
+// don't. Two NaN values agree; a NaN and a number don't. Alerting when the
+// result drops below 1 detects drift between two pipelines computing the same thing.
+var AssertClose = function.MakeFunction(
+	"transform.assert_close",
+	func(leftList api.SeriesList, rightList api.SeriesList, tolerance float64, context function.EvaluationContext) (api.SeriesList, error) {
+		if math.IsNaN(tolerance) || tolerance < 0 {
+			return api.SeriesList{}, fmt.Errorf("transform.assert_close expected a non-negative tolerance but got %f", tolerance)
+		}
+		return join.Combine(context, "transform.assert_close", leftList, rightList, func(left float64, right float64) float64 {
+			if math.IsNaN(left) || math.IsNaN(right) {
+				if math.IsNaN(left) && math.IsNaN(right) {
+					return 1
+				}
+				return 0
+			}
+			if left == right || math.Abs(left-right) <= tolerance {
+				return 1
+			}
+			return 0
+		})
 	},
 )
@@ -19,6 +19,7 @@ package transform
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
@@ -30,23 +31,14 @@ func transformEach(list api.SeriesList, transformation func([]float64) []float64
 	}
 	for seriesIndex, series := range list.Series {
 		resultList.Series[seriesIndex] = api.Timeseries{
-			Values: transformation(series.Values),
-			TagSet: series.TagSet, // TODO: verify that these are immutable
+			Values:     transformation(series.Values),
+			TagSet:     series.TagSet, // TODO: verify that these are immutable
+			Provenance: series.Provenance,
 		}
 	}
 	return resultList
 }
 
-func mapper(list api.SeriesList, mapFunc func(float64) float64) api.SeriesList {
-	return transformEach(list, func(values []float64) []float64 {
-		result := make([]float64, len(values))
-		for i := range result {
-			result[i] = mapFunc(values[i])
-		}
-		return result
-	})
-}
-
 // Integral integrates a series whose values are "X per millisecond" to estimate "total X so far"
 // if the series represents "X in this sampling interval" instead, then you should use transformCumulative.
 var Integral = function.MakeFunction(
@@ -94,17 +86,25 @@ var Cumulative = function.MakeFunction(
 	},
 )
 
-// MapMaker can be used to use a function as a transform, such as 'math.Abs' (or similar):
-//  `MapMaker(math.Abs)` is a transform function which can be used, e.g. with ApplyTransform
-// The name is used for error-checking purposes.
-func MapMaker(name string, fun func(float64) float64) function.Function {
+// running makes a function which replaces each point by the combination of
+// every finite value up to and including it. Points before the first finite
+// value are NaN; later NaN values keep the running value.
+func running(name string, combine func(float64, float64) float64) function.MetricFunction {
 	return function.MakeFunction(
 		name,
-		func(list api.SeriesList, timerange api.Timerange) api.SeriesList {
+		func(list api.SeriesList) api.SeriesList {
 			return transformEach(list, func(values []float64) []float64 {
 				result := make([]float64, len(values))
-				for i := range values {
-					result[i] = fun(values[i])
+				current := math.NaN()
+				for i, value := range values {
+					if !math.IsNaN(value) && !math.IsInf(value, 0) {
+						if math.IsNaN(current) {
+							current = value
+						} else {
+							current = combine(current, value)
+						}
+					}
+					result[i] = current
 				}
 				return result
 			})
@@ -112,11 +112,29 @@ func MapMaker(name string, fun func(float64) float64) function.Function {
 	)
 }
 
+// RunningMax computes the largest value seen so far at each point.
+var RunningMax = running("transform.running_max", math.Max)
+
+// RunningMin computes the smallest value seen so far at each point.
+var RunningMin = running("transform.running_min", math.Min)
+
+// MapMaker can be used to use a function as a transform, such as 'math.Abs' (or similar):
+//  `MapMaker(math.Abs)` is a transform function which can be used, e.g. with ApplyTransform
+// The name is used for error-checking purposes.
+func MapMaker(name string, fun func(float64) float64) function.Function {
+	return function.MakeFunction(
+		name,
+		func(list api.SeriesList, timerange api.Timerange) api.SeriesList {
+			return api.MapSeriesList(list, fun)
+		},
+	)
+}
+
 // NaNFill will replacing missing data (NaN) with the `default` value supplied as a parameter.
 var NaNFill = function.MakeFunction(
 	"transform.nan_fill",
 	func(list api.SeriesList, defaultValue float64) api.SeriesList {
-		return mapper(list, func(value float64) float64 {
+		return api.MapSeriesList(list, func(value float64) float64 {
 			if math.IsNaN(value) {
 				return defaultValue
 			}
@@ -125,6 +143,15 @@ var NaNFill = function.MakeFunction(
 	},
 )
 
+// IsDefined replaces each finite value with 1 and each missing (or infinite)
+// value with 0. Summing the result counts the series reporting at each point.
+var IsDefined = MapMaker("transform.is_defined", func(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	return 1
+})
+
 // NaNKeepLast will replace missing NaN data with the data before it
 var NaNKeepLast = function.MakeFunction(
 	"transform.nan_keep_last",
@@ -163,7 +190,7 @@ var Bound = function.MakeFunction(
 		if lowerBound > upperBound {
 			return api.SeriesList{}, boundError{lowerBound, upperBound}
 		}
-		return mapper(list, func(value float64) float64 {
+		return api.MapSeriesList(list, func(value float64) float64 {
 			if value < lowerBound {
 				return lowerBound
 			}
@@ -179,7 +206,7 @@ var Bound = function.MakeFunction(
 var LowerBound = function.MakeFunction(
 	"transform.lower_bound",
 	func(list api.SeriesList, lowerBound float64) (api.SeriesList, error) {
-		return mapper(list, func(value float64) float64 {
+		return api.MapSeriesList(list, func(value float64) float64 {
 			if value < lowerBound {
 				return lowerBound
 			}
@@ -192,7 +219,7 @@ var LowerBound = function.MakeFunction(
 var UpperBound = function.MakeFunction(
 	"transform.upper_bound",
 	func(list api.SeriesList, upperBound float64) (api.SeriesList, error) {
-		return mapper(list, func(value float64) float64 {
+		return api.MapSeriesList(list, func(value float64) float64 {
 			if value > upperBound {
 				return upperBound
 			}
@@ -200,3 +227,156 @@ var UpperBound = function.MakeFunction(
 		}), nil
 	},
 )
+
+// Normalize linearly rescales each series so that its minimum over the timerange
+// becomes 0 and its maximum becomes 1. NaN values are ignored when finding the
+// extremes and remain NaN. A flat series (whose minimum equals its maximum)
+// becomes all zeros.
+var Normalize = function.MakeFunction(
+	"transform.normalize",
+	func(list api.SeriesList) api.SeriesList {
+		return transformEach(list, func(values []float64) []float64 {
+			min := math.Inf(1)
+			max := math.Inf(-1)
+			for _, value := range values {
+				if math.IsNaN(value) {
+					continue
+				}
+				min = math.Min(min, value)
+				max = math.Max(max, value)
+			}
+			result := make([]float64, len(values))
+			for i, value := range values {
+				switch {
+				case math.IsNaN(value):
+					result[i] = math.NaN()
+				case min == max:
+					result[i] = 0
+				default:
+					result[i] = (value - min) / (max - min)
+				}
+			}
+			return result
+		})
+	},
+)
+
+// Changed marks the samples which differ from the previous non-NaN sample
+// with 1, and every other sample (including the first, and NaN samples) with
+// 0. Summing the result over time counts the step changes of a discrete gauge.
+var Changed = function.MakeFunction(
+	"transform.changed",
+	func(list api.SeriesList) api.SeriesList {
+		return transformEach(list, func(values []float64) []float64 {
+			result := make([]float64, len(values))
+			previous := math.NaN()
+			for i, value := range values {
+				if math.IsNaN(value) {
+					continue
+				}
+				if !math.IsNaN(previous) && value != previous {
+					result[i] = 1
+				}
+				previous = value
+			}
+			return result
+		})
+	},
+)
+
+// Delay shifts each series' existing samples later by the given number of
+// buckets, without fetching any more data: the first buckets become NaN and
+// the last samples are dropped. A negative count shifts samples earlier.
+var Delay = function.MakeFunction(
+	"transform.delay",
+	func(list api.SeriesList, count float64) (api.SeriesList, error) {
+		if count != math.Trunc(count) {
+			return api.SeriesList{}, fmt.Errorf("transform.delay expected a whole number of buckets but got %+v", count)
+		}
+		shift := int(count)
+		return transformEach(list, func(values []float64) []float64 {
+			result := make([]float64, len(values))
+			for i := range result {
+				source := i - shift
+				if source < 0 || source >= len(values) {
+					result[i] = math.NaN()
+					continue
+				}
+				result[i] = values[source]
+			}
+			return result
+		}), nil
+	},
+)
+
+// parseClock parses a time of day such as "09:00" into its offset from midnight.
+func parseClock(clock string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("expected a time of day such as \"09:30\" but got %q", clock)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// TimeSlice keeps only the samples whose wall-clock time (in the given
+// timezone, or else the context's) falls in the daily window [from, to),
+// replacing the rest with NaN. If `to` is earlier than `from`, the window
+// crosses midnight.
+var TimeSlice = function.MakeFunction(
+	"transform.time_slice",
+	func(list api.SeriesList, from string, to string, zone *string, timerange api.Timerange, context function.EvaluationContext) (api.SeriesList, error) {
+		start, err := parseClock(from)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		if start == end {
+			return api.SeriesList{}, fmt.Errorf("transform.time_slice expected a non-empty window but got %s to %s", from, to)
+		}
+		location := context.Location()
+		if zone != nil {
+			location, err = time.LoadLocation(*zone)
+			if err != nil {
+				return api.SeriesList{}, fmt.Errorf("transform.time_slice got an unknown timezone %q", *zone)
+			}
+		}
+		inside := make([]bool, timerange.Slots())
+		for i := range inside {
+			hour, minute, second := timerange.TimeOfIndex(i).In(location).Clock()
+			clock := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+			if start < end {
+				inside[i] = start <= clock && clock < end
+			} else {
+				inside[i] = start <= clock || clock < end
+			}
+		}
+		return transformEach(list, func(values []float64) []float64 {
+			result := make([]float64, len(values))
+			for i := range values {
+				if i < len(inside) && inside[i] {
+					result[i] = values[i]
+				} else {
+					result[i] = math.NaN()
+				}
+			}
+			return result
+		}), nil
+	},
+)
+
+// Annotate attaches a key-value pair (such as a unit) to the list, which is
+// included alongside it in query results. Annotations accumulate when
+// nested, with an outer call replacing an inner one's value for the same key.
+// Functions which build new lists don't carry them through.
+var Annotate = function.MakeFunction(
+	"transform.annotate",
+	func(list api.SeriesList, key string, value string) (api.SeriesList, error) {
+		if key == "" {
+			return api.SeriesList{}, fmt.Errorf("transform.annotate given empty string for key")
+		}
+		return list.Annotate(key, value), nil
+	},
+)
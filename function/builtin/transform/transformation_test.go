@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
@@ -662,3 +663,861 @@ func TestTransformIdentity(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyNormalize(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 5*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	tests := []struct {
+		values   []float64
+		expected []float64
+	}{
+		{
+			values:   []float64{0, 1, 2, 3, 4, 5},
+			expected: []float64{0, 0.2, 0.4, 0.6, 0.8, 1},
+		},
+		{
+			values:   []float64{-10, 10, 0, nan, 5, -5},
+			expected: []float64{0, 1, 0.5, nan, 0.75, 0.25},
+		},
+		{
+			values:   []float64{3, 3, nan, 3, 3, 3},
+			expected: []float64{0, 0, nan, 0, 0, 0},
+		},
+		{
+			values:   []float64{nan, nan, nan, nan, nan, nan},
+			expected: []float64{nan, nan, nan, nan, nan, nan},
+		},
+	}
+	for i, test := range tests {
+		a := assert.New(t).Contextf("test %d", i)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: test.values, TagSet: api.TagSet{"host": "a"}}},
+		}
+		result, err := Normalize.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}}, function.Groups{})
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 1e-10)
+	}
+}
+
+func TestApplyDelay(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	tests := []struct {
+		count    float64
+		expected []float64
+		fails    bool
+	}{
+		{count: 0, expected: []float64{1, 2, 3, 4, 5}},
+		{count: 2, expected: []float64{nan, nan, 1, 2, 3}},
+		{count: -1, expected: []float64{2, 3, 4, 5, nan}},
+		{count: 4, expected: []float64{nan, nan, nan, nan, 1}},
+		{count: 5, expected: []float64{nan, nan, nan, nan, nan}},
+		{count: 100, expected: []float64{nan, nan, nan, nan, nan}},
+		{count: -100, expected: []float64{nan, nan, nan, nan, nan}},
+		{count: 1.5, fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("delay %+v", test.count)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"host": "a"}}},
+		}
+		result, err := Delay.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(test.count)}}, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 0)
+	}
+}
+
+func TestApplyIsDefined(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 5*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, nan, 0, -3, math.Inf(1), nan}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{nan, nan, nan, nan, nan, nan}, TagSet: api.TagSet{"host": "b"}},
+		},
+	}
+	result, err := IsDefined.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}}, function.Groups{})
+	a.CheckError(err)
+	resultList, convErr := result.ToSeriesList(timerange)
+	if convErr != nil {
+		t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+	}
+	a.EqInt(len(resultList.Series), 2)
+	a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+	a.EqFloatArray(resultList.Series[0].Values, []float64{1, 0, 1, 1, 0, 0}, 0)
+	a.Eq(resultList.Series[1].TagSet, api.TagSet{"host": "b"})
+	a.EqFloatArray(resultList.Series[1].Values, []float64{0, 0, 0, 0, 0, 0}, 0)
+}
+
+func TestApplyChanged(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 6*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{3, 3, 4, 4, 3, 3, 3}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{nan, 2, nan, 2, nan, 5, nan}, TagSet: api.TagSet{"host": "b"}},
+		},
+	}
+	result, err := Changed.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}}, function.Groups{})
+	a.CheckError(err)
+	resultList, convErr := result.ToSeriesList(timerange)
+	if convErr != nil {
+		t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+	}
+	a.EqInt(len(resultList.Series), 2)
+	a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+	a.EqFloatArray(resultList.Series[0].Values, []float64{0, 0, 1, 0, 1, 0, 0}, 0)
+	a.Eq(resultList.Series[1].TagSet, api.TagSet{"host": "b"})
+	a.EqFloatArray(resultList.Series[1].Values, []float64{0, 0, 0, 0, 0, 1, 0}, 0)
+}
+
+func TestApplyRunning(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 6*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{nan, 3, 1, nan, 4, math.Inf(1), 2}, TagSet: api.TagSet{"host": "a"}},
+		},
+	}
+	tests := []struct {
+		function function.MetricFunction
+		expected []float64
+	}{
+		{RunningMax, []float64{nan, 3, 3, 3, 4, 4, 4}},
+		{RunningMin, []float64{nan, 3, 1, 1, 1, 1, 1}},
+	}
+	for _, test := range tests {
+		a := a.Contextf("%s", test.function.Name())
+		result, err := test.function.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}}, function.Groups{})
+		a.CheckError(err)
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+		}
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 0)
+	}
+}
+
+func TestApplyTimeSlice(t *testing.T) {
+	start := int64(1456790400000) // 2016-03-01 00:00 UTC
+	timerange, err := api.NewSnappedTimerange(start, start+23*3600000, 3600000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	values := make([]float64, 24)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatalf("Error loading timezone: %s", err.Error())
+	}
+	// kept lists the hours (UTC) which should remain.
+	tests := []struct {
+		parameters []function.Value
+		location   *time.Location // the context's default timezone
+		kept       []int
+		fails      bool
+	}{
+		{
+			parameters: []function.Value{function.StringValue("09:00"), function.StringValue("17:00")},
+			kept:       []int{9, 10, 11, 12, 13, 14, 15, 16},
+		},
+		{
+			parameters: []function.Value{function.StringValue("22:00"), function.StringValue("02:00")},
+			kept:       []int{0, 1, 22, 23},
+		},
+		{
+			parameters: []function.Value{function.StringValue("09:30"), function.StringValue("11:00"), function.StringValue("UTC")},
+			kept:       []int{10},
+		},
+		{
+			// Los Angeles is 8 hours behind UTC on this day.
+			parameters: []function.Value{function.StringValue("09:00"), function.StringValue("17:00"), function.StringValue("America/Los_Angeles")},
+			kept:       []int{0, 17, 18, 19, 20, 21, 22, 23},
+		},
+		{
+			// Without an explicit timezone, the context's default is used.
+			parameters: []function.Value{function.StringValue("09:00"), function.StringValue("17:00")},
+			location:   losAngeles,
+			kept:       []int{0, 17, 18, 19, 20, 21, 22, 23},
+		},
+		{
+			// An explicit timezone overrides the context's default.
+			parameters: []function.Value{function.StringValue("09:00"), function.StringValue("17:00"), function.StringValue("UTC")},
+			location:   losAngeles,
+			kept:       []int{9, 10, 11, 12, 13, 14, 15, 16},
+		},
+		{
+			parameters: []function.Value{function.StringValue("9am"), function.StringValue("17:00")},
+			fails:      true,
+		},
+		{
+			parameters: []function.Value{function.StringValue("09:00"), function.StringValue("09:00")},
+			fails:      true,
+		},
+		{
+			parameters: []function.Value{function.StringValue("09:00"), function.StringValue("17:00"), function.StringValue("Mars/Olympus_Mons")},
+			fails:      true,
+		},
+	}
+	for i, test := range tests {
+		a := assert.New(t).Contextf("test %d", i)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Location: test.location, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: values, TagSet: api.TagSet{"host": "a"}}},
+		}
+		arguments := []function.Expression{literal{function.SeriesListValue(list)}}
+		for _, parameter := range test.parameters {
+			arguments = append(arguments, literal{parameter})
+		}
+		result, err := TimeSlice.Run(ctx, arguments, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		expected := make([]float64, 24)
+		for j := range expected {
+			expected[j] = math.NaN()
+		}
+		for _, hour := range test.kept {
+			expected[hour] = float64(hour)
+		}
+		a.EqFloatArray(resultList.Series[0].Values, expected, 0)
+	}
+}
+
+func TestApplySplice(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	recent := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{nan, nan, 3, math.Inf(1), 5}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{nan, nan, nan, 1, 1}, TagSet: api.TagSet{"host": "b"}},
+		},
+	}
+	historical := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{10, 20, 30, 40, nan}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{7, 7, 7, 7, 7}, TagSet: api.TagSet{"host": "c"}},
+		},
+	}
+	result, err := Splice.Run(ctx, []function.Expression{literal{function.SeriesListValue(recent)}, literal{function.SeriesListValue(historical)}}, function.Groups{})
+	a.CheckError(err)
+	resultList, convErr := result.ToSeriesList(timerange)
+	if convErr != nil {
+		t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+	}
+	a.EqInt(len(resultList.Series), 3)
+	a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+	a.EqFloatArray(resultList.Series[0].Values, []float64{10, 20, 3, 40, 5}, 0)
+	a.Eq(resultList.Series[1].TagSet, api.TagSet{"host": "b"})
+	a.EqFloatArray(resultList.Series[1].Values, []float64{nan, nan, nan, 1, 1}, 0)
+	a.Eq(resultList.Series[2].TagSet, api.TagSet{"host": "c"})
+	a.EqFloatArray(resultList.Series[2].Values, []float64{7, 7, 7, 7, 7}, 0)
+
+	// Series with the same tagset can't be paired.
+	duplicated := api.SeriesList{Series: append(historical.Series, historical.Series[0])}
+	if _, err := Splice.Run(ctx, []function.Expression{literal{function.SeriesListValue(recent)}, literal{function.SeriesListValue(duplicated)}}, function.Groups{}); err == nil {
+		t.Errorf("Expected duplicated tagsets to be rejected")
+	}
+}
+
+func TestDurationLabel(t *testing.T) {
+	a := assert.New(t)
+	a.EqString(durationLabel(7*24*time.Hour), "7d")
+	a.EqString(durationLabel(36*time.Hour), "36h")
+	a.EqString(durationLabel(90*time.Minute), "90m")
+	a.EqString(durationLabel(30*time.Second), "30s")
+	a.EqString(durationLabel(1500*time.Millisecond), "1500ms")
+}
+
+func TestApplyScaleByTagSet(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 2*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"dc": "east", "host": "a"}},
+			{Values: []float64{4, 5, 6}, TagSet: api.TagSet{"dc": "west", "host": "b"}},
+			{Values: []float64{7, 8, 9}, TagSet: api.TagSet{"dc": "north", "host": "c"}},
+		},
+	}
+	factors := function.ScalarSet{
+		{TagSet: api.TagSet{"dc": "east"}, Value: 10},
+		{TagSet: api.TagSet{"dc": "west"}, Value: 0.5},
+		{TagSet: api.TagSet{"dc": "west", "host": "b"}, Value: 2}, // more specific
+	}
+	tests := []struct {
+		factors   function.ScalarSet
+		unmatched []function.Value
+		expected  []api.Timeseries
+		notes     int
+		fails     bool
+	}{
+		{
+			factors: factors,
+			expected: []api.Timeseries{
+				{Values: []float64{10, 20, 30}, TagSet: api.TagSet{"dc": "east", "host": "a"}},
+				{Values: []float64{8, 10, 12}, TagSet: api.TagSet{"dc": "west", "host": "b"}},
+				{Values: []float64{7, 8, 9}, TagSet: api.TagSet{"dc": "north", "host": "c"}},
+			},
+			notes: 1,
+		},
+		{
+			factors:   factors,
+			unmatched: []function.Value{function.StringValue("drop")},
+			expected: []api.Timeseries{
+				{Values: []float64{10, 20, 30}, TagSet: api.TagSet{"dc": "east", "host": "a"}},
+				{Values: []float64{8, 10, 12}, TagSet: api.TagSet{"dc": "west", "host": "b"}},
+			},
+		},
+		{
+			// An empty tagset matches every series.
+			factors: function.ScalarSet{{TagSet: api.TagSet{}, Value: -1}},
+			expected: []api.Timeseries{
+				{Values: []float64{-1, -2, -3}, TagSet: api.TagSet{"dc": "east", "host": "a"}},
+				{Values: []float64{-4, -5, -6}, TagSet: api.TagSet{"dc": "west", "host": "b"}},
+				{Values: []float64{-7, -8, -9}, TagSet: api.TagSet{"dc": "north", "host": "c"}},
+			},
+		},
+		{
+			factors:   factors,
+			unmatched: []function.Value{function.StringValue("ignore")},
+			fails:     true,
+		},
+		{
+			// Two equally specific factors disagree.
+			factors: function.ScalarSet{{TagSet: api.TagSet{"dc": "east"}, Value: 1}, {TagSet: api.TagSet{"host": "a"}, Value: 2}},
+			fails:   true,
+		},
+	}
+	for i, test := range tests {
+		a := assert.New(t).Contextf("test %d", i)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, EvaluationNotes: new(function.EvaluationNotes), Ctx: context.Background()}.Build()
+		arguments := []function.Expression{literal{function.SeriesListValue(list)}, literal{test.factors}}
+		for _, value := range test.unmatched {
+			arguments = append(arguments, literal{value})
+		}
+		result, err := ScaleByTagSet.Run(ctx, arguments, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.EqInt(len(resultList.Series), len(test.expected))
+		for j := range test.expected {
+			if j < len(resultList.Series) {
+				a.Eq(resultList.Series[j].TagSet, test.expected[j].TagSet)
+				a.EqFloatArray(resultList.Series[j].Values, test.expected[j].Values, 0)
+			}
+		}
+		a.EqInt(len(ctx.Notes()), test.notes)
+	}
+}
+
+func TestApplyRemoveOutliers(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 6*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	tests := []struct {
+		values    []float64
+		threshold float64
+		expected  []float64
+		fails     bool
+	}{
+		// The median is 10 and the deviation is 1, so the spike is removed.
+		{values: []float64{10, 11, 9, 10, 500, 11, 9}, threshold: 3, expected: []float64{10, 11, 9, 10, nan, 11, 9}},
+		{values: []float64{10, 11, 9, 10, 14, 11, 9}, threshold: 3, expected: []float64{10, 11, 9, 10, nan, 11, 9}},
+		{values: []float64{10, 11, 9, 10, 14, 11, 9}, threshold: 4, expected: []float64{10, 11, 9, 10, 14, 11, 9}},
+		// NaNs are ignored.
+		{values: []float64{nan, 10, 11, nan, 9, -400, 10}, threshold: 3, expected: []float64{nan, 10, 11, nan, 9, nan, 10}},
+		// A constant series has no deviation, so nothing is removed.
+		{values: []float64{5, 5, 5, 5, 5, 5, 5}, threshold: 1, expected: []float64{5, 5, 5, 5, 5, 5, 5}},
+		{values: []float64{5, 5, 5, 5, 5, 5, 50}, threshold: 1, expected: []float64{5, 5, 5, 5, 5, 5, 50}},
+		{values: []float64{nan, nan, nan, nan, nan, nan, nan}, threshold: 3, expected: []float64{nan, nan, nan, nan, nan, nan, nan}},
+		{values: []float64{1, 2, 3, 4, 5, 6, 7}, threshold: 0, fails: true},
+	}
+	for i, test := range tests {
+		a := assert.New(t).Contextf("test %d", i)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: test.values, TagSet: api.TagSet{"host": "a"}}},
+		}
+		result, err := RemoveOutliers.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(test.threshold)}}, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 0)
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+	}
+}
+
+func TestApplyStack(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 3*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 2, nan, 4}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{10, nan, nan, 40}, TagSet: api.TagSet{"host": "b"}},
+			{Values: []float64{100, 200, 300, 400}, TagSet: api.TagSet{"host": "c"}},
+		},
+	}
+	result, err := Stack.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}}, function.Groups{})
+	a.CheckError(err)
+	resultList, convErr := result.ToSeriesList(timerange)
+	if convErr != nil {
+		t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+	}
+	a.EqInt(len(resultList.Series), 3)
+	a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+	a.EqFloatArray(resultList.Series[0].Values, []float64{1, 2, 0, 4}, 0)
+	a.Eq(resultList.Series[1].TagSet, api.TagSet{"host": "b"})
+	a.EqFloatArray(resultList.Series[1].Values, []float64{11, 2, 0, 44}, 0)
+	a.Eq(resultList.Series[2].TagSet, api.TagSet{"host": "c"})
+	a.EqFloatArray(resultList.Series[2].Values, []float64{111, 202, 300, 444}, 0)
+	// The input is unchanged.
+	a.EqFloatArray(list.Series[0].Values, []float64{1, 2, nan, 4}, 0)
+}
+
+func TestApplyAlignTo(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	reference := api.SeriesList{
+		Series: []api.Timeseries{{Values: []float64{0, 0, 0, 0, 0}, TagSet: api.TagSet{"name": "actual"}}},
+	}
+	tests := []struct {
+		values    []float64
+		reference api.SeriesList
+		method    []function.Value
+		expected  []float64
+		fails     bool
+	}{
+		{values: []float64{10, 20, 40}, reference: reference, expected: []float64{10, 15, 20, 30, 40}},
+		{values: []float64{10, 20, 40}, reference: reference, method: []function.Value{function.StringValue("linear")}, expected: []float64{10, 15, 20, 30, 40}},
+		{values: []float64{10, 20, 40}, reference: reference, method: []function.Value{function.StringValue("nearest")}, expected: []float64{10, 20, 20, 40, 40}},
+		{values: []float64{10, nan, 40}, reference: reference, expected: []float64{10, nan, nan, nan, 40}},
+		{values: []float64{1, 2, 3, 4, 5}, reference: reference, expected: []float64{1, 2, 3, 4, 5}},
+		// Downsampling to a coarser reference.
+		{
+			values:    []float64{1, 2, 3, 4, 5},
+			reference: api.SeriesList{Series: []api.Timeseries{{Values: []float64{0, 0, 0}}}},
+			expected:  []float64{1, 3, 5},
+		},
+		{values: []float64{10, 20, 40}, reference: api.SeriesList{}, fails: true},
+		{values: []float64{10, 20, 40}, reference: reference, method: []function.Value{function.StringValue("cubic")}, fails: true},
+	}
+	for i, test := range tests {
+		a := assert.New(t).Contextf("test %d", i)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: test.values, TagSet: api.TagSet{"name": "target"}}},
+		}
+		arguments := []function.Expression{literal{function.SeriesListValue(list)}, literal{function.SeriesListValue(test.reference)}}
+		for _, value := range test.method {
+			arguments = append(arguments, literal{value})
+		}
+		result, err := AlignTo.Run(ctx, arguments, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		resultList := api.SeriesList(result.(function.SeriesListValue))
+		a.EqInt(len(resultList.Series), 1)
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 0)
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"name": "target"})
+	}
+}
+
+func TestApplyEWMA(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 5*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{10, 20, nan, 20, 0, 10}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{nan, nan, 4, 8, nan, 8}, TagSet: api.TagSet{"host": "b"}},
+		},
+	}
+	tests := []struct {
+		alpha    float64
+		expected [][]float64
+	}{
+		{0.5, [][]float64{{10, 15, 15, 17.5, 8.75, 9.375}, {nan, nan, 4, 6, 6, 7}}},
+		// An alpha of 1 doesn't smooth at all, apart from carrying values over NaNs.
+		{1, [][]float64{{10, 20, 20, 20, 0, 10}, {nan, nan, 4, 8, 8, 8}}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("alpha %f", test.alpha)
+		result, err := EWMA.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(test.alpha)}}, function.Groups{})
+		a.CheckError(err)
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+		}
+		a.EqInt(len(resultList.Series), 2)
+		for i := range test.expected {
+			a.Eq(resultList.Series[i].TagSet, list.Series[i].TagSet)
+			a.EqFloatArray(resultList.Series[i].Values, test.expected[i], 1e-9)
+		}
+	}
+	for _, alpha := range []float64{0, -0.5, 1.5, nan} {
+		_, err := EWMA.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(alpha)}}, function.Groups{})
+		if err == nil {
+			t.Errorf("Expected an error for alpha %f", alpha)
+		}
+	}
+}
+
+func TestApplyRequireFresh(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"host": "a"}},
+			{Values: []float64{1, 2, 3, nan, nan}, TagSet: api.TagSet{"host": "b"}},
+			{Values: []float64{nan, nan, nan, nan, nan}, TagSet: api.TagSet{"host": "c"}},
+		},
+	}
+	tests := []struct {
+		maxAge   time.Duration
+		action   string
+		expected [][]float64 // nil if the query should fail
+		notes    int
+	}{
+		{maxAge: time.Minute, expected: nil},
+		{maxAge: time.Minute, action: "error", expected: nil},
+		{maxAge: time.Minute, action: "nan", expected: [][]float64{{1, 2, 3, 4, 5}, {1, 2, 3, nan, nan}, {nan, nan, nan, nan, nan}}, notes: 1},
+		{maxAge: 30 * time.Second, action: "nan", expected: [][]float64{{1, 2, 3, 4, 5}, {nan, nan, nan, nan, nan}, {nan, nan, nan, nan, nan}}, notes: 2},
+		{maxAge: 0, action: "nan", expected: [][]float64{{1, 2, 3, 4, 5}, {nan, nan, nan, nan, nan}, {nan, nan, nan, nan, nan}}, notes: 2},
+		{maxAge: time.Minute, action: "drop", expected: nil},
+		{maxAge: -time.Minute, action: "nan", expected: nil},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v with %q", test.maxAge, test.action)
+		ctx := function.EvaluationContextBuilder{
+			Timerange:       timerange,
+			EvaluationNotes: new(function.EvaluationNotes),
+			Ctx:             context.Background(),
+		}.Build()
+		arguments := []function.Expression{literal{function.SeriesListValue(list)}, literal{function.NewDurationValue("", test.maxAge)}}
+		if test.action != "" {
+			arguments = append(arguments, literal{function.StringValue(test.action)})
+		}
+		result, err := RequireFresh.Run(ctx, arguments, function.Groups{})
+		if test.expected == nil {
+			if err == nil {
+				a.Errorf("expected an error")
+			}
+			continue
+		}
+		a.CheckError(err)
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+		}
+		a.EqInt(len(resultList.Series), len(test.expected))
+		for i := range test.expected {
+			a.Eq(resultList.Series[i].TagSet, list.Series[i].TagSet)
+			a.EqFloatArray(resultList.Series[i].Values, test.expected[i], 0)
+		}
+		a.EqInt(len(ctx.Notes()), test.notes)
+	}
+	// The input is unchanged.
+	assert.New(t).EqFloatArray(list.Series[1].Values, []float64{1, 2, 3, nan, nan}, 0)
+}
+
+func TestApplyRank(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 3*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{1, 5, nan, 4}, TagSet: api.TagSet{"host": "a", "dc": "north"}},
+			{Values: []float64{2, 5, 7, 1}, TagSet: api.TagSet{"host": "b", "dc": "north"}},
+			{Values: []float64{3, 3, nan, 9}, TagSet: api.TagSet{"host": "c", "dc": "south"}},
+		},
+	}
+	tests := []struct {
+		groups   function.Groups
+		expected [][]float64
+	}{
+		{
+			groups: function.Groups{},
+			expected: [][]float64{
+				{3, 1, nan, 2},
+				{2, 1, 1, 3},
+				{1, 3, nan, 1},
+			},
+		},
+		{
+			groups: function.Groups{List: []string{"dc"}},
+			expected: [][]float64{
+				{2, 1, nan, 1},
+				{1, 1, 1, 2},
+				{1, 1, nan, 1},
+			},
+		},
+		{
+			groups: function.Groups{List: []string{"host"}, Collapses: true},
+			expected: [][]float64{
+				{2, 1, nan, 1},
+				{1, 1, 1, 2},
+				{1, 1, nan, 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test.groups)
+		result, err := Rank.Run(ctx, []function.Expression{literal{function.SeriesListValue(list)}}, test.groups)
+		a.CheckError(err)
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+		}
+		a.EqInt(len(resultList.Series), len(test.expected))
+		for i := range test.expected {
+			a.Eq(resultList.Series[i].TagSet, list.Series[i].TagSet)
+			a.Contextf("series %d", i).EqFloatArray(resultList.Series[i].Values, test.expected[i], 0)
+		}
+	}
+}
+
+func TestApplyRateLimit(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 5*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+	list := api.SeriesList{
+		Series: []api.Timeseries{
+			{Values: []float64{50, 250, nan, 0, 120, 0}, TagSet: api.TagSet{"host": "a"}},
+		},
+	}
+	burst := func(b float64) function.Expression { return literal{function.ScalarValue(b)} }
+	tests := []struct {
+		rate     float64
+		burst    function.Expression
+		expected []float64
+	}{
+		// Without a burst, each point serves at most the rate, and the excess waits.
+		{100, nil, []float64{50, 100, 100, 50, 100, 20}},
+		// Unused tokens accumulate up to the burst.
+		{100, burst(200), []float64{50, 200, 50, 0, 120, 0}},
+		{100, burst(150), []float64{50, 150, 100, 0, 120, 0}},
+		{1000, nil, []float64{50, 250, 0, 0, 120, 0}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("rate %f burst %+v", test.rate, test.burst)
+		arguments := []function.Expression{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(test.rate)}}
+		if test.burst != nil {
+			arguments = append(arguments, test.burst)
+		}
+		result, err := RateLimit.Run(ctx, arguments, function.Groups{})
+		a.CheckError(err)
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+		}
+		a.EqInt(len(resultList.Series), 1)
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 1e-9)
+	}
+	for _, arguments := range [][]function.Expression{
+		{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(0)}},
+		{literal{function.SeriesListValue(list)}, literal{function.ScalarValue(100)}, burst(50)},
+	} {
+		if _, err := RateLimit.Run(ctx, arguments, function.Groups{}); err == nil {
+			t.Errorf("expected an error for arguments %+v", arguments)
+		}
+	}
+}
+
+func TestApplyAssertClose(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	nan := math.NaN()
+	left := api.SeriesList{Series: []api.Timeseries{
+		{Values: []float64{1, 2, nan, nan, 5}, TagSet: api.TagSet{"host": "a"}},
+		{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"host": "b"}},
+	}}
+	right := api.SeriesList{Series: []api.Timeseries{
+		{Values: []float64{1.005, 2.5, nan, 4, 5}, TagSet: api.TagSet{"host": "a"}},
+		{Values: []float64{1, 0.995, 1.02, nan, 1}, TagSet: api.TagSet{"host": "b"}},
+	}}
+	tests := []struct {
+		tolerance float64
+		expected  map[string][]float64
+		fails     bool
+	}{
+		{
+			tolerance: 0.01,
+			expected: map[string][]float64{
+				"a": {1, 0, 1, 0, 1},
+				"b": {1, 1, 0, 0, 1},
+			},
+		},
+		{
+			tolerance: 0,
+			expected: map[string][]float64{
+				"a": {0, 0, 1, 0, 1},
+				"b": {1, 0, 0, 0, 1},
+			},
+		},
+		{
+			tolerance: 1,
+			expected: map[string][]float64{
+				"a": {1, 1, 1, 0, 1},
+				"b": {1, 1, 1, 0, 1},
+			},
+		},
+		{tolerance: -1, fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("tolerance %f", test.tolerance)
+		ctx := function.EvaluationContextBuilder{
+			Timerange:       timerange,
+			EvaluationNotes: new(function.EvaluationNotes),
+			Ctx:             context.Background(),
+		}.Build()
+		arguments := []function.Expression{
+			literal{function.SeriesListValue(left)},
+			literal{function.SeriesListValue(right)},
+			literal{function.ScalarValue(test.tolerance)},
+		}
+		result, err := AssertClose.Run(ctx, arguments, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		list, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.EqInt(len(list.Series), len(test.expected))
+		for _, series := range list.Series {
+			a.Contextf("host %s", series.TagSet["host"]).EqFloatArray(series.Values, test.expected[series.TagSet["host"]], 0)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"fmt"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// A Unit is a multiple of the base unit of some dimension. Values can only be
+// converted between units of the same dimension.
+type Unit struct {
+	Dimension string  // e.g. "time" or "data"
+	Factor    float64 // the number of base units in one of this unit
+}
+
+// Units holds the units understood by transform.convert, by name. Names are
+// case-sensitive, so that "b" (bits) and "B" (bytes) can be told apart.
+// More units can be added here before any queries are evaluated.
+var Units = map[string]Unit{
+	// time, in seconds
+	"ns":  {"time", 1e-9},
+	"us":  {"time", 1e-6},
+	"ms":  {"time", 1e-3},
+	"s":   {"time", 1},
+	"min": {"time", 60},
+	"h":   {"time", 60 * 60},
+	"d":   {"time", 24 * 60 * 60},
+	"w":   {"time", 7 * 24 * 60 * 60},
+
+	// data, in bits, with SI (powers of 1000) and binary (powers of 1024) prefixes
+	"b":   {"data", 1},
+	"kb":  {"data", 1e3},
+	"Mb":  {"data", 1e6},
+	"Gb":  {"data", 1e9},
+	"Tb":  {"data", 1e12},
+	"B":   {"data", 8},
+	"kB":  {"data", 8e3},
+	"MB":  {"data", 8e6},
+	"GB":  {"data", 8e9},
+	"TB":  {"data", 8e12},
+	"KiB": {"data", 8 << 10},
+	"MiB": {"data", 8 << 20},
+	"GiB": {"data", 8 << 30},
+	"TiB": {"data", 8 << 40},
+
+	// plain quantities, with SI prefixes
+	"1":       {"count", 1},
+	"k":       {"count", 1e3},
+	"M":       {"count", 1e6},
+	"G":       {"count", 1e9},
+	"percent": {"count", 1e-2},
+}
+
+// ConversionFactor returns the number that values in the `from` unit must be
+// multiplied by to express them in the `to` unit.
+func ConversionFactor(from string, to string) (float64, error) {
+	fromUnit, ok := Units[from]
+	if !ok {
+		return 0, fmt.Errorf("transform.convert doesn't know the unit %q", from)
+	}
+	toUnit, ok := Units[to]
+	if !ok {
+		return 0, fmt.Errorf("transform.convert doesn't know the unit %q", to)
+	}
+	if fromUnit.Dimension != toUnit.Dimension {
+		return 0, fmt.Errorf("transform.convert cannot convert %q (%s) to %q (%s)", from, fromUnit.Dimension, to, toUnit.Dimension)
+	}
+	return fromUnit.Factor / toUnit.Factor, nil
+}
+
+// Convert rescales each value from one unit to another of the same dimension,
+// e.g. transform.convert(latency, "ms", "s").
+var Convert = function.MakeFunction(
+	"transform.convert",
+	func(list api.SeriesList, from string, to string) (api.SeriesList, error) {
+		factor, err := ConversionFactor(from, to)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		return api.MapSeriesList(list, func(value float64) float64 {
+			return value * factor
+		}), nil
+	},
+)
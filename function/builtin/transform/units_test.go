@@ -0,0 +1,77 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/testing_support/assert"
+
+	"golang.org/x/net/context"
+)
+
+func TestConvert(t *testing.T) {
+	nan := math.NaN()
+	timerange, err := api.NewSnappedTimerange(0, 2*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating test timerange: %s", err.Error())
+	}
+	tests := []struct {
+		from     string
+		to       string
+		expected []float64
+		fails    bool
+	}{
+		{from: "ms", to: "s", expected: []float64{0.5, 2, nan}},
+		{from: "s", to: "ms", expected: []float64{500000, 2000000, nan}},
+		{from: "h", to: "min", expected: []float64{30000, 120000, nan}},
+		{from: "B", to: "b", expected: []float64{4000, 16000, nan}},
+		{from: "KiB", to: "B", expected: []float64{512000, 2048000, nan}},
+		{from: "kB", to: "MB", expected: []float64{0.5, 2, nan}},
+		{from: "percent", to: "1", expected: []float64{5, 20, nan}},
+		{from: "s", to: "s", expected: []float64{500, 2000, nan}},
+		{from: "B", to: "s", fails: true},
+		{from: "ms", to: "fortnight", fails: true},
+		{from: "MS", to: "s", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s to %s", test.from, test.to)
+		ctx := function.EvaluationContextBuilder{Timerange: timerange, Ctx: context.Background()}.Build()
+		list := api.SeriesList{
+			Series: []api.Timeseries{{Values: []float64{500, 2000, nan}, TagSet: api.TagSet{"host": "a"}}},
+		}
+		result, err := Convert.Run(ctx, []function.Expression{
+			literal{function.SeriesListValue(list)},
+			literal{function.StringValue(test.from)},
+			literal{function.StringValue(test.to)},
+		}, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			}
+			continue
+		}
+		a.CheckError(err)
+		resultList, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			t.Fatalf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+		}
+		a.Eq(resultList.Series[0].TagSet, api.TagSet{"host": "a"})
+		a.EqFloatArray(resultList.Series[0].Values, test.expected, 1e-9)
+	}
+}
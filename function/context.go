@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/inspect"
@@ -38,11 +39,22 @@ type EvaluationContextBuilder struct {
 	FetchLimit           FetchCounter            // A limit on the number of fetches which may be performed
 	Profiler             *inspect.Profiler       // A profiler pointer
 	EvaluationNotes      *EvaluationNotes        // Debug + numerical notes that can be added during evaluation
+	FetchTimeout         time.Duration           // A limit on the duration of each individual fetch (0 => no limit)
+	HighCardinalityTags  []string                // Tags which each fetch must constrain, unless UnboundedFetches is set
+	StreamAggregations   bool                    // Whether associative aggregations of a fetch fold each series in as it arrives
+	Authorizer           Authorizer              // Decides which series the Principal may see (nil => all of them)
+	Principal            string                  // Who the query is being evaluated for
+	EventSource          EventSource             // Source of events such as deploys (nil => no events)
+	Location             *time.Location          // Timezone of wall-clock functions not given one explicitly (nil => UTC)
+	LenientFetches       bool                    // Whether series which fail to fetch are left out (with a note) instead of failing the query
+	Provenance           bool                    // Whether functions which build new series give them their inputs' provenance
 	Ctx                  context.Context
 
 	// These may be changed in sub-contexts while evaluating the query.
-	Timerange api.Timerange       // Timerange to fetch data from
-	Predicate predicate.Predicate // Predicate to apply to TagSets prior to fetching
+	Timerange        api.Timerange       // Timerange to fetch data from
+	Predicate        predicate.Predicate // Predicate to apply to TagSets prior to fetching
+	UnboundedFetches bool                // Whether fetches may leave HighCardinalityTags unconstrained
+	EmptyResults     EmptyResultPolicy   // What to do when a fetch matches no series ("" => NoteEmptyResults)
 }
 
 // Build creates an evaluation context from the provided builder.
@@ -94,6 +106,78 @@ func (context EvaluationContext) FetchLimitConsume(n int) error {
 	return context.private.FetchLimit.Consume(n)
 }
 
+// FetchLimitTryConsume consumes the amount of resources from the limit only if
+// this wouldn't overdraw it, returning whether it did.
+func (context EvaluationContext) FetchLimitTryConsume(n int) bool {
+	return context.private.FetchLimit.TryConsume(n)
+}
+
+// FetchTimeout returns the maximum duration of a single fetch. If it's zero,
+// fetches are bounded only by the context's deadline.
+func (context EvaluationContext) FetchTimeout() time.Duration {
+	return context.private.FetchTimeout
+}
+
+// HighCardinalityTags returns the tags which a fetch must constrain, or nil
+// if unbounded fetches have been permitted in this context.
+func (context EvaluationContext) HighCardinalityTags() []string {
+	if context.private.UnboundedFetches {
+		return nil
+	}
+	return context.private.HighCardinalityTags
+}
+
+// StreamAggregations returns whether associative aggregations applied directly
+// to a fetch should fold in its series as they arrive, rather than holding the
+// entire fetched list in memory.
+func (context EvaluationContext) StreamAggregations() bool {
+	return context.private.StreamAggregations
+}
+
+// Visible returns whether the principal evaluating the query may see the series.
+func (context EvaluationContext) Visible(metric api.TaggedMetric) bool {
+	if context.private.Authorizer == nil {
+		return true
+	}
+	return context.private.Authorizer.Visible(context.private.Principal, metric)
+}
+
+// Location returns the timezone which wall-clock functions should use when
+// they aren't given one explicitly. The web server sets it from the request's
+// "timezone" parameter, or else its own configured timezone; it's UTC when
+// neither is set.
+func (context EvaluationContext) Location() *time.Location {
+	if context.private.Location == nil {
+		return time.UTC
+	}
+	return context.private.Location
+}
+
+// Provenance returns whether the provenance of function arguments should be
+// passed on to the series built from them. Fetched series always record it.
+func (context EvaluationContext) Provenance() bool {
+	return context.private.Provenance
+}
+
+// EmptyResultPolicy returns what should happen when a fetch matches no series.
+func (context EvaluationContext) EmptyResultPolicy() EmptyResultPolicy {
+	if context.private.EmptyResults == "" {
+		return NoteEmptyResults
+	}
+	return context.private.EmptyResults
+}
+
+// LenientFetches returns whether series which fail to fetch should be left
+// out of the fetch's result, with a note, instead of failing the query.
+func (context EvaluationContext) LenientFetches() bool {
+	return context.private.LenientFetches
+}
+
+// EventSource returns the source of events, which may be nil.
+func (context EvaluationContext) EventSource() EventSource {
+	return context.private.EventSource
+}
+
 // Ctx returns the underlying Context instance for the evaluation.
 func (context EvaluationContext) Ctx() context.Context {
 	return context.private.Ctx
@@ -121,6 +205,7 @@ func (context EvaluationContext) Notes() []string {
 }
 
 // EvaluationNotes holds notes that were recorded during evaluation.
+// A nil *EvaluationNotes is valid, and discards every note.
 type EvaluationNotes struct {
 	mutex sync.Mutex
 	notes []string
@@ -165,6 +250,39 @@ func (context EvaluationContext) WithAdditionalConstraint(p predicate.Predicate)
 	return context
 }
 
+// WithUnboundedFetches returns a new copy of the evaluation context in which
+// fetches need not constrain the high-cardinality tags.
+func (context EvaluationContext) WithUnboundedFetches() EvaluationContext {
+	if context.private.UnboundedFetches {
+		return context
+	}
+	context.private.UnboundedFetches = true
+	context.memoization = context.memoizationMap.get(context.private.memoizationIdentity())
+	return context
+}
+
+// WithEmptyResultPolicy returns a new copy of the evaluation context in which
+// fetches matching no series are handled according to the given policy.
+func (context EvaluationContext) WithEmptyResultPolicy(policy EmptyResultPolicy) EvaluationContext {
+	if context.EmptyResultPolicy() == policy {
+		return context
+	}
+	context.private.EmptyResults = policy
+	context.memoization = context.memoizationMap.get(context.private.memoizationIdentity())
+	return context
+}
+
+// WithSampleMethod returns a new copy of the evaluation context in which
+// fetches are consolidated to the requested resolution with the given method.
+func (context EvaluationContext) WithSampleMethod(method timeseries.SampleMethod) EvaluationContext {
+	if context.private.SampleMethod == method {
+		return context
+	}
+	context.private.SampleMethod = method
+	context.memoization = context.memoizationMap.get(context.private.memoizationIdentity())
+	return context
+}
+
 // EvaluateMemoized evaluates the given ActualExpression using the memoization
 // map internal to the context.
 func (context EvaluationContext) EvaluateMemoized(expression ActualExpression) (Value, error) {
@@ -201,14 +319,34 @@ func (c FetchCounter) Current() int {
 func (c FetchCounter) Consume(n int) error {
 	remaining := atomic.AddInt32(c.count, -int32(n))
 	if remaining < 0 {
-		return fmt.Errorf("performing fetch of %d additional series brings the total to %d, which exceeds the specified limit %d", n, c.limit-int(remaining), c.limit)
+		return NewLimitError(
+			fmt.Sprintf("performing fetch of %d additional series brings the total to %d, which exceeds the specified limit %d", n, c.limit-int(remaining), c.limit),
+			c.limit-int(remaining), c.limit)
 	}
 	return nil
 }
 
+// TryConsume consumes n from the counter only if that wouldn't overdraw it,
+// returning whether it did. Unlike Consume, a refusal leaves the counter as it
+// was, so that optional fetches can't starve the required ones.
+func (c FetchCounter) TryConsume(n int) bool {
+	for {
+		remaining := atomic.LoadInt32(c.count)
+		if remaining < int32(n) {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(c.count, remaining, remaining-int32(n)) {
+			return true
+		}
+	}
+}
+
 type contextIdentity struct {
-	Timerange      api.Timerange
-	PredicateQuery string
+	Timerange        api.Timerange
+	PredicateQuery   string
+	UnboundedFetches bool
+	EmptyResults     EmptyResultPolicy
+	SampleMethod     timeseries.SampleMethod
 }
 
 // memoizationIdentity is used to improve sharing between contexts
@@ -219,7 +357,10 @@ func (builder EvaluationContextBuilder) memoizationIdentity() contextIdentity {
 		predicate = builder.Predicate.Query()
 	}
 	return contextIdentity{
-		Timerange:      timerange,
-		PredicateQuery: predicate,
+		Timerange:        timerange,
+		PredicateQuery:   predicate,
+		UnboundedFetches: builder.UnboundedFetches,
+		EmptyResults:     builder.EmptyResults,
+		SampleMethod:     builder.SampleMethod,
 	}
 }
@@ -0,0 +1,68 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import "fmt"
+
+// EmptyResultPolicy determines what happens when a fetch matches no series.
+// Depending on the caller, that's either a sign of a mistake (such as a
+// misspelled metric or tag) or entirely expected (nothing has reported yet).
+type EmptyResultPolicy string
+
+const (
+	NoteEmptyResults   EmptyResultPolicy = "note"  // NoteEmptyResults returns no series, and adds a note (the default)
+	AllowEmptyResults  EmptyResultPolicy = "empty" // AllowEmptyResults quietly returns no series
+	RejectEmptyResults EmptyResultPolicy = "error" // RejectEmptyResults fails the query with an EmptyResultError
+)
+
+// ParseEmptyResultPolicy parses the name of a policy. The empty string is the default policy.
+func ParseEmptyResultPolicy(name string) (EmptyResultPolicy, error) {
+	switch policy := EmptyResultPolicy(name); policy {
+	case "":
+		return NoteEmptyResults, nil
+	case NoteEmptyResults, AllowEmptyResults, RejectEmptyResults:
+		return policy, nil
+	}
+	return "", fmt.Errorf(`unknown empty result policy %q; expected "note", "empty" or "error"`, name)
+}
+
+// EmptyResultError is returned by fetches which match no series when empty
+// results are rejected.
+type EmptyResultError struct {
+	Fetch string // a description of the fetch, such as its query
+}
+
+// Error describes the fetch which matched no series.
+func (err EmptyResultError) Error() string {
+	return fmt.Sprintf("%s matched no series", err.Fetch)
+}
+
+// Class returns UserErrorClass, since the query probably contains a mistake.
+func (err EmptyResultError) Class() ErrorClass {
+	return UserErrorClass
+}
+
+// CheckEmptyResult applies the context's EmptyResultPolicy to a fetch (given
+// by its description) which matched no series.
+func (context EvaluationContext) CheckEmptyResult(fetch string) error {
+	switch context.EmptyResultPolicy() {
+	case AllowEmptyResults:
+		return nil
+	case RejectEmptyResults:
+		return EmptyResultError{Fetch: fetch}
+	}
+	context.AddNote(fmt.Sprintf("%s matched no series", fetch))
+	return nil
+}
@@ -16,8 +16,135 @@ package function
 
 import (
 	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/timeseries"
+
+	netcontext "golang.org/x/net/context"
+)
+
+// ErrorClass identifies who is responsible for an error, so that servers can
+// respond appropriately (e.g. blaming the client or the backend). Its values
+// are stable, and are reported to clients.
+type ErrorClass string
+
+const (
+	UserErrorClass    ErrorClass = "user_error"     // UserErrorClass indicates that the query itself is invalid
+	BackendErrorClass ErrorClass = "backend_error"  // BackendErrorClass indicates that a storage or metadata backend failed
+	TimeoutErrorClass ErrorClass = "timeout"        // TimeoutErrorClass indicates that the query ran out of time
+	LimitErrorClass   ErrorClass = "limit_exceeded" // LimitErrorClass indicates that the query surpassed a configured limit
 )
 
+// ClassifiedError is an error which reports its own class.
+type ClassifiedError interface {
+	error
+	Class() ErrorClass
+}
+
+// ClassOf determines the class of the given error. Errors which aren't
+// recognized are assumed to be the user's fault.
+func ClassOf(err error) ErrorClass {
+	if class, ok := classify(err); ok {
+		return class
+	}
+	return UserErrorClass
+}
+
+func classify(err error) (ErrorClass, bool) {
+	switch err := err.(type) {
+	case ClassifiedError:
+		return err.Class(), true
+	case LimitError:
+		return LimitErrorClass, true
+	case ArgumentLengthError, metadata.NoSuchMetricError:
+		return UserErrorClass, true
+	case timeseries.Error:
+		switch err.Code {
+		case timeseries.FetchTimeoutError:
+			return TimeoutErrorClass, true
+		case timeseries.FetchIOError:
+			return BackendErrorClass, true
+		case timeseries.LimitError:
+			return LimitErrorClass, true
+		default:
+			return UserErrorClass, true
+		}
+	case timeseries.FetchError:
+		// A FetchError reports its own HTTP status code.
+		if err.ErrorCode() >= http.StatusInternalServerError {
+			return BackendErrorClass, true
+		}
+		return UserErrorClass, true
+	}
+	if err == netcontext.DeadlineExceeded {
+		return TimeoutErrorClass, true
+	}
+	return "", false
+}
+
+// UserError indicates that the query is invalid, and can't succeed unless it's changed.
+type UserError struct {
+	Message string
+}
+
+// Error returns the message for the error.
+func (err UserError) Error() string {
+	return err.Message
+}
+
+// Class returns UserErrorClass.
+func (err UserError) Class() ErrorClass {
+	return UserErrorClass
+}
+
+// BackendError indicates that a backend (e.g. the storage or metadata API)
+// failed, through no fault of the query.
+type BackendError struct {
+	Backend string // a description of the backend, such as "metadata"
+	Err     error  // the error reported by the backend
+}
+
+// Error describes the backend and its error.
+func (err BackendError) Error() string {
+	return fmt.Sprintf("%s backend error: %s", err.Backend, err.Err.Error())
+}
+
+// Class returns BackendErrorClass.
+func (err BackendError) Class() ErrorClass {
+	return BackendErrorClass
+}
+
+// WrapBackendError turns an error from the given backend into a BackendError,
+// unless it's already recognized as some other class (for example, a missing
+// metric is still the user's fault). A nil error is returned unchanged.
+func WrapBackendError(backend string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := classify(err); ok {
+		return err
+	}
+	return BackendError{Backend: backend, Err: err}
+}
+
+// TimeoutError indicates that the query (or part of it) ran out of time.
+type TimeoutError struct {
+	Message string
+	Timeout time.Duration
+}
+
+// Error describes the timeout.
+func (err TimeoutError) Error() string {
+	return fmt.Sprintf("%s (timeout=%v)", err.Message, err.Timeout)
+}
+
+// Class returns TimeoutErrorClass.
+func (err TimeoutError) Class() ErrorClass {
+	return TimeoutErrorClass
+}
+
 // LimitError is returned if an error occurs where limits are surpassed.
 type LimitError interface {
 	Actual() interface{} // actual from the system which triggered this error.
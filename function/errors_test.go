@@ -0,0 +1,64 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/timeseries"
+
+	netcontext "golang.org/x/net/context"
+)
+
+func TestClassOf(t *testing.T) {
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{fmt.Errorf("something went wrong"), UserErrorClass},
+		{UserError{Message: "bad query"}, UserErrorClass},
+		{ArgumentLengthError{Name: "f", ExpectedMin: 1, ExpectedMax: 1, Actual: 2}, UserErrorClass},
+		{metadata.NewNoSuchMetricError("cpu"), UserErrorClass},
+		{BackendError{Backend: "storage", Err: fmt.Errorf("connection refused")}, BackendErrorClass},
+		{TimeoutError{Message: "too slow", Timeout: time.Second}, TimeoutErrorClass},
+		{netcontext.DeadlineExceeded, TimeoutErrorClass},
+		{NewLimitError("too many series", 10, 5), LimitErrorClass},
+		{NewFetchCounter(1).Consume(2), LimitErrorClass},
+		{timeseries.Error{Code: timeseries.FetchTimeoutError}, TimeoutErrorClass},
+		{timeseries.Error{Code: timeseries.FetchIOError}, BackendErrorClass},
+		{timeseries.Error{Code: timeseries.LimitError}, LimitErrorClass},
+		{timeseries.Error{Code: timeseries.InvalidSeriesError}, UserErrorClass},
+		{timeseries.FetchError{Message: "bad series"}, UserErrorClass},
+		{timeseries.FetchError{Message: "unavailable", Code: http.StatusServiceUnavailable}, BackendErrorClass},
+	}
+	for _, test := range tests {
+		assert.New(t).Contextf("%+v", test.err).EqString(string(ClassOf(test.err)), string(test.class))
+	}
+}
+
+func TestWrapBackendError(t *testing.T) {
+	a := assert.New(t)
+	a.Eq(WrapBackendError("storage", nil), nil)
+	a.Eq(WrapBackendError("storage", fmt.Errorf("connection refused")), BackendError{Backend: "storage", Err: fmt.Errorf("connection refused")})
+	a.EqString(WrapBackendError("storage", fmt.Errorf("connection refused")).Error(), "storage backend error: connection refused")
+	// Errors which are already classified are left alone.
+	missing := metadata.NewNoSuchMetricError("cpu")
+	a.Eq(WrapBackendError("metadata", missing), missing)
+}
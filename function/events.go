@@ -0,0 +1,63 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import (
+	"time"
+
+	"github.com/square/metrics/api"
+)
+
+// An Event is something which happened at a single moment, such as a deploy.
+type Event struct {
+	Timestamp   int64      `json:"timestamp"` // in milliseconds since the epoch
+	Name        string     `json:"name"`
+	TagSet      api.TagSet `json:"tagset"`
+	Description string     `json:"description,omitempty"`
+}
+
+// An EventSource looks up the events with a given name, such as "deploy".
+type EventSource interface {
+	FetchEvents(name string, timerange api.Timerange) ([]Event, error)
+}
+
+// An EventListValue holds events, which are drawn as markers rather than as
+// series. It can't be converted to any other kind of value.
+type EventListValue []Event
+
+// ToSeriesList is a conversion function.
+func (events EventListValue) ToSeriesList(timerange api.Timerange) (api.SeriesList, *ConversionFailure) {
+	return api.SeriesList{}, &ConversionFailure{"events", "SeriesList"}
+}
+
+// ToString is a conversion function.
+func (events EventListValue) ToString() (string, *ConversionFailure) {
+	return "", &ConversionFailure{"events", "string"}
+}
+
+// ToScalar is a conversion function.
+func (events EventListValue) ToScalar() (float64, *ConversionFailure) {
+	return 0, &ConversionFailure{"events", "scalar"}
+}
+
+// ToScalarSet is a conversion function.
+func (events EventListValue) ToScalarSet() (ScalarSet, *ConversionFailure) {
+	return nil, &ConversionFailure{"events", "scalar set"}
+}
+
+// ToDuration is a conversion function.
+func (events EventListValue) ToDuration() (time.Duration, *ConversionFailure) {
+	return 0, &ConversionFailure{"events", "duration"}
+}
@@ -112,9 +112,15 @@ func EvaluateToString(e Expression, context EvaluationContext) (string, error) {
 	return value, nil
 }
 
+// maxConcurrentEvaluations bounds the goroutines used by each EvaluateMany,
+// so that long lists (such as generated queries selecting hundreds of
+// expressions) don't start hundreds of evaluations at once.
+const maxConcurrentEvaluations = 16
+
 // EvaluateMany evaluates a list of expressions using a single EvaluationContext.
 // If any evaluation errors, EvaluateMany will propagate that error. The resulting values
 // will be in the order corresponding to the provided expressions.
+// At most maxConcurrentEvaluations of the expressions are evaluated at once.
 func EvaluateMany(context EvaluationContext, expressions []Expression) ([]Value, error) {
 	type result struct {
 		index int
@@ -132,13 +138,24 @@ func EvaluateMany(context EvaluationContext, expressions []Expression) ([]Value,
 		}
 		return []Value{result}, nil
 	}
-	// concurrent evaluations
+	// concurrent evaluations, at most maxConcurrentEvaluations at once
 	results := make(chan result, length)
-	for i, expr := range expressions {
-		go func(i int, expr Expression) {
-			value, err := expr.Evaluate(context)
-			results <- result{i, err, value}
-		}(i, expr)
+	indices := make(chan int, length)
+	for i := range expressions {
+		indices <- i
+	}
+	close(indices)
+	workers := length
+	if workers > maxConcurrentEvaluations {
+		workers = maxConcurrentEvaluations
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range indices {
+				value, err := expressions[i].Evaluate(context)
+				results <- result{i, err, value}
+			}
+		}()
 	}
 	array := make([]Value, length)
 	for i := 0; i < length; i++ {
@@ -15,7 +15,10 @@
 package function
 
 import (
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/square/metrics/testing_support/assert"
 )
@@ -36,3 +39,96 @@ func Test_FetchCounter(t *testing.T) {
 	}
 	a.EqInt(c.Current(), 11)
 }
+
+// describedExpression counts how many times it's described.
+type describedExpression struct {
+	name      string
+	inner     Expression
+	described *int
+}
+
+func (e describedExpression) ActualEvaluate(context EvaluationContext) (Value, error) {
+	return ScalarValue(0), nil
+}
+
+func (e describedExpression) ExpressionString(mode DescriptionMode) string {
+	*e.described++
+	if e.inner == nil {
+		return e.name
+	}
+	return e.inner.ExpressionString(mode) + " + " + e.name
+}
+
+func TestMemoizeDescribesOnce(t *testing.T) {
+	a := assert.New(t)
+	described := 0
+	var chain Expression
+	for i := 0; i < 100; i++ {
+		chain = Memoize(describedExpression{name: "x", inner: chain, described: &described})
+	}
+	first := chain.ExpressionString(StringQuery)
+	a.EqInt(described, 100) // once for each link of the chain, rather than quadratically many
+	a.EqString(chain.ExpressionString(StringQuery), first)
+	a.EqInt(described, 100)
+	chain.ExpressionString(StringMemoization)
+	a.EqInt(described, 200) // each mode is described separately
+}
+
+func TestMemoizationIdentity(t *testing.T) {
+	a := assert.New(t)
+	a.EqString(memoizationIdentity("metric_a"), "metric_a")
+	long := strings.Repeat("metric_a + ", 100)
+	a.EqInt(len(memoizationIdentity(long)), 65)
+	a.EqString(memoizationIdentity(long), memoizationIdentity(long))
+	a.EqBool(memoizationIdentity(long) == memoizationIdentity(long+"metric_b"), false)
+}
+
+// concurrentExpression tracks the number of evaluations running at once.
+type concurrentExpression struct {
+	index   int
+	tracker *concurrencyTracker
+}
+
+type concurrencyTracker struct {
+	sync.Mutex
+	running int
+	most    int
+}
+
+func (e concurrentExpression) Evaluate(context EvaluationContext) (Value, error) {
+	e.tracker.Lock()
+	e.tracker.running++
+	if e.tracker.running > e.tracker.most {
+		e.tracker.most = e.tracker.running
+	}
+	e.tracker.Unlock()
+	time.Sleep(time.Millisecond)
+	e.tracker.Lock()
+	e.tracker.running--
+	e.tracker.Unlock()
+	return ScalarValue(e.index), nil
+}
+
+func (e concurrentExpression) ExpressionString(mode DescriptionMode) string {
+	return "concurrent"
+}
+
+func TestEvaluateManyBounded(t *testing.T) {
+	a := assert.New(t)
+	tracker := &concurrencyTracker{}
+	expressions := make([]Expression, 200)
+	for i := range expressions {
+		expressions[i] = concurrentExpression{index: i, tracker: tracker}
+	}
+	values, err := EvaluateMany(EvaluationContext{}, expressions)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	a.EqInt(len(values), len(expressions))
+	for i, value := range values {
+		a.Contextf("value %d", i).Eq(value, ScalarValue(i))
+	}
+	if tracker.most > maxConcurrentEvaluations {
+		a.Errorf("Expected at most %d concurrent evaluations, but got %d", maxConcurrentEvaluations, tracker.most)
+	}
+}
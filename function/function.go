@@ -14,7 +14,12 @@
 
 package function
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/square/metrics/api"
+)
 
 // The Function interface defines a metric function.
 // It is given several (unevaluated) expressions as input, and evaluates to a Value.
@@ -36,6 +41,30 @@ type Groups struct {
 	Collapses bool     // whether to "collapse by" instead of "group by"
 }
 
+// NoteAbsentTags adds a note for each tag of a `group by` clause which none of
+// the series have, since grouping by it quietly puts them all together. Either
+// the series being grouped or the grouped results can be checked, since the
+// results hold the tags which are grouped by (empty where they're absent).
+// "collapse by" clauses aren't checked, since collapsing by a tag which no
+// series has changes nothing.
+func (groups Groups) NoteAbsentTags(context EvaluationContext, list api.SeriesList) {
+	if groups.Collapses || len(list.Series) == 0 {
+		return
+	}
+	for _, tag := range groups.List {
+		present := false
+		for _, series := range list.Series {
+			if series.TagSet[tag] != "" {
+				present = true
+				break
+			}
+		}
+		if !present {
+			context.AddNote(fmt.Sprintf("group-by tag '%s' not present on any series; results collapsed", tag))
+		}
+	}
+}
+
 // MetricFunction holds a generic function object with information about its parameters.
 type MetricFunction struct {
 	FunctionName  string // Name is the name of the function, used in its registration.
@@ -43,6 +72,46 @@ type MetricFunction struct {
 	MaxArguments  int    // MaxArguments is the maximum number of arguments the function allows. -1 indicates an unlimited number.
 	AllowsGroupBy bool   // Whether the function allows a 'group by' clause.
 	Compute       func(EvaluationContext, []Expression, Groups) (Value, error)
+
+	// ArgumentTypes describes the type of each argument (such as "series list"
+	// or "duration"), and ReturnType that of the result. They're filled in by
+	// MakeFunction, and are only used to describe the function to users.
+	ArgumentTypes []string
+	ReturnType    string
+}
+
+// Description summarizes the function's arguments and result, such as
+// "takes a series list and an optional duration; returns a series list".
+// It's empty if the types aren't known.
+func (f MetricFunction) Description() string {
+	if f.ReturnType == "" {
+		return ""
+	}
+	arguments := make([]string, len(f.ArgumentTypes))
+	for i, argumentType := range f.ArgumentTypes {
+		if i >= f.MinArguments {
+			arguments[i] = "an optional " + argumentType
+		} else {
+			arguments[i] = withArticle(argumentType)
+		}
+	}
+	takes := "takes no arguments"
+	switch len(arguments) {
+	case 0:
+	case 1:
+		takes = "takes " + arguments[0]
+	default:
+		takes = "takes " + strings.Join(arguments[:len(arguments)-1], ", ") + " and " + arguments[len(arguments)-1]
+	}
+	return takes + "; returns " + withArticle(f.ReturnType)
+}
+
+// withArticle prefixes the noun with "a" or "an".
+func withArticle(noun string) string {
+	if noun != "" && strings.IndexByte("aeiou", noun[0]) >= 0 {
+		return "an " + noun
+	}
+	return "a " + noun
 }
 
 // Name returns the MetricFunction's name.
@@ -47,30 +47,77 @@ func MakeFunction(name string, function interface{}) MetricFunction {
 		panic("MakeFunction's argument function's second return type must convertible be `error`.")
 	}
 
+	// The per-argument type dispatch depends only on funcType, so it's done once
+	// here rather than on every invocation of Compute.
+	extractors := make([]argumentExtractor, funcType.NumIn())
 	requiredArgumentCount := 0
 	optionalArgumentCount := 0
 	allowsGroupBy := false
-	for i := 0; i < funcType.NumIn(); i++ {
+	argumentTypes := []string{}
+	for i := range extractors {
 		argType := funcType.In(i)
 		switch argType {
-		case contextType, timerangeType:
-			// Asks for part of context.
+		case contextType:
+			extractors[i] = argumentExtractor{extract: func(context EvaluationContext, arguments []Expression, groups Groups) (reflect.Value, error) {
+				return reflect.ValueOf(context), nil
+			}}
+		case timerangeType:
+			extractors[i] = argumentExtractor{extract: func(context EvaluationContext, arguments []Expression, groups Groups) (reflect.Value, error) {
+				return reflect.ValueOf(context.Timerange()), nil
+			}}
 		case groupsType:
-			// asks for groups
 			allowsGroupBy = true
+			extractors[i] = argumentExtractor{extract: func(context EvaluationContext, arguments []Expression, groups Groups) (reflect.Value, error) {
+				return reflect.ValueOf(groups), nil
+			}}
 		case stringType, scalarType, scalarSetType, durationType, timeseriesType, valueType, expressionType:
 			// An ordinary argument.
 			if optionalArgumentCount > 0 {
 				panic("Non-optional arguments cannot occur after optional ones.")
 			}
+			index := requiredArgumentCount
+			evaluate := evaluatorFor(argType)
+			extractors[i] = argumentExtractor{evaluates: true, extract: func(context EvaluationContext, arguments []Expression, groups Groups) (reflect.Value, error) {
+				result, err := evaluate(arguments[index], context)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				return reflect.ValueOf(result), nil
+			}}
 			requiredArgumentCount++
+			argumentTypes = append(argumentTypes, typeNames[argType])
 		case reflect.PtrTo(stringType), reflect.PtrTo(scalarType), reflect.PtrTo(scalarSetType), reflect.PtrTo(durationType), reflect.PtrTo(timeseriesType), reflect.PtrTo(valueType), reflect.PtrTo(expressionType):
 			// An optional argument
+			index := requiredArgumentCount + optionalArgumentCount
+			evaluate := evaluatorFor(argType.Elem())
+			zero := reflect.Zero(argType)
+			elemType := argType.Elem()
+			extractors[i] = argumentExtractor{evaluates: true, extract: func(context EvaluationContext, arguments []Expression, groups Groups) (reflect.Value, error) {
+				if index >= len(arguments) {
+					return zero, nil
+				}
+				result, err := evaluate(arguments[index], context)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				ptr := reflect.New(elemType)
+				ptr.Elem().Set(reflect.ValueOf(result))
+				return ptr, nil
+			}}
 			optionalArgumentCount++
+			argumentTypes = append(argumentTypes, typeNames[argType.Elem()])
 		default:
 			panic(fmt.Sprintf("MetricFunction function argument asks for unsupported type: cannot supply argument %d of type %+v.", i, argType))
 		}
 	}
+	convertOutput := outputConverterFor(funcType.Out(0))
+	lastEvaluated := -1
+	for i, extractor := range extractors {
+		if extractor.evaluates {
+			lastEvaluated = i
+		}
+	}
+
 	// The function has been checked and inspected.
 	// Now, generate the corresponding MetricFunction.
 
@@ -79,113 +126,38 @@ func MakeFunction(name string, function interface{}) MetricFunction {
 		MinArguments:  requiredArgumentCount,
 		MaxArguments:  requiredArgumentCount + optionalArgumentCount,
 		AllowsGroupBy: allowsGroupBy,
-		// Compute does a lot of reflection to get this to work.
+		ArgumentTypes: argumentTypes,
+		ReturnType:    returnTypeName(funcType.Out(0)),
 		Compute: func(context EvaluationContext, arguments []Expression, groups Groups) (Value, error) {
+			argValues := make([]reflect.Value, len(extractors))
 
-			// nextArgument will extract the next argument from the expression list `arguments`.
-			// if there are not more to return, it will return nil.
-			expressionArgument := 0
-			nextArgument := func() Expression {
-				if expressionArgument >= len(arguments) {
-					return nil
-				}
-				arg := arguments[expressionArgument]
-				expressionArgument++
-				return arg
-			}
-
-			// evalTo takes an expression and a reflect.Type and evaluates to the appropriate type.
-			// If an Expression is requested, it just returns it.
-			evalTo := func(expression Expression, resultType reflect.Type) (interface{}, error) {
-				switch resultType {
-				case expressionType:
-					return expression, nil
-				case stringType:
-					return EvaluateToString(expression, context)
-				case scalarType:
-					return EvaluateToScalar(expression, context)
-				case scalarSetType:
-					return EvaluateToScalarSet(expression, context)
-				case durationType:
-					return EvaluateToDuration(expression, context)
-				case timeseriesType:
-					return EvaluateToSeriesList(expression, context)
-				case valueType:
-					return expression.Evaluate(context)
+			// Arguments which require evaluation are evaluated in parallel; the
+			// rest are cheap and are extracted directly. The last evaluated
+			// argument is handled by this goroutine rather than a new one.
+			waiter := sync.WaitGroup{}
+			errors := make(chan error, len(extractors))
+			evaluate := func(i int) {
+				arg, err := extractors[i].extract(context, arguments, groups)
+				if err != nil {
+					errors <- err
+					return
 				}
-				panic(fmt.Sprintf("Unreachable :: Attempting to evaluate to unknown type %+v", resultType))
+				argValues[i] = arg
 			}
-
-			// argumentFuncs holds functions to obtain the Value arguments.
-			argumentFuncs := make([]func() (interface{}, error), funcType.NumIn())
-
-			// provideValue takes any value, and returns a function that returns it.
-			provideValue := func(x interface{}) func() (interface{}, error) {
-				return func() (interface{}, error) {
-					return x, nil
+			for i, extractor := range extractors {
+				if !extractor.evaluates {
+					argValues[i], _ = extractor.extract(context, arguments, groups)
+					continue
 				}
-			}
-
-			// provideZeroValue takes a type, and returns a function that returns the zero-value for that type.
-			provideZeroValue := func(t reflect.Type) func() (interface{}, error) {
-				return provideValue(reflect.Zero(t).Interface())
-			}
-
-			// ptrTo takes a value and returns a pointer to that value.
-			ptrTo := func(x interface{}) interface{} {
-				ptr := reflect.New(reflect.TypeOf(x))
-				ptr.Elem().Set(reflect.ValueOf(x))
-				return ptr.Interface()
-			}
-
-			for i := range argumentFuncs {
-				argType := funcType.In(i)
-				switch argType {
-				case contextType:
-					argumentFuncs[i] = provideValue(context)
-				case timerangeType:
-					argumentFuncs[i] = provideValue(context.Timerange())
-				case groupsType:
-					argumentFuncs[i] = provideValue(groups)
-				case stringType, scalarType, scalarSetType, durationType, timeseriesType, valueType, expressionType:
-					arg := nextArgument()
-					argumentFuncs[i] = func() (interface{}, error) {
-						return evalTo(arg, argType)
-					}
-				case reflect.PtrTo(stringType), reflect.PtrTo(scalarType), reflect.PtrTo(scalarSetType), reflect.PtrTo(durationType), reflect.PtrTo(timeseriesType), reflect.PtrTo(valueType), reflect.PtrTo(expressionType):
-					arg := nextArgument()
-					if arg == nil {
-						argumentFuncs[i] = provideZeroValue(argType)
-					} else {
-						argumentFuncs[i] = func() (interface{}, error) {
-							resultI, err := evalTo(arg, argType.Elem())
-							if err != nil {
-								return nil, err
-							}
-							return ptrTo(resultI), nil
-						}
-					}
-				default:
-					panic(fmt.Sprintf("Unreachable :: Argument to MakeFunction requests invalid type %+v.", argType))
+				if i == lastEvaluated {
+					evaluate(i)
+					continue
 				}
-			}
-
-			// Now we evaluate the functions in parallel.
-
-			waiter := sync.WaitGroup{}
-			argValues := make([]reflect.Value, funcType.NumIn())
-			errors := make(chan error, funcType.NumIn())
-			for i := range argValues {
 				i := i
 				waiter.Add(1)
 				go func() {
 					defer waiter.Done()
-					arg, err := argumentFuncs[i]()
-					if err != nil {
-						errors <- err
-						return
-					}
-					argValues[i] = reflect.ValueOf(arg)
+					evaluate(i)
 				}()
 			}
 			waiter.Wait() // Wait for all the arguments to be evaluated.
@@ -199,29 +171,170 @@ func MakeFunction(name string, function interface{}) MetricFunction {
 			if len(output) == 2 && output[1].Interface() != nil {
 				return nil, output[1].Interface().(error)
 			}
-			switch funcType.Out(0) {
-			case stringType:
-				return StringValue(output[0].Interface().(string)), nil
-			case scalarType:
-				return ScalarValue(output[0].Interface().(float64)), nil
-			case scalarSetType:
-				return output[0].Interface().(ScalarSet), nil
-			case durationType:
-				return DurationValue{"", output[0].Interface().(time.Duration)}, nil
-			case timeseriesType:
-				return SeriesListValue(output[0].Interface().(api.SeriesList)), nil
-			default:
-				return output[0].Interface().(Value), nil
+			result := convertOutput(output[0])
+			if list, ok := result.(SeriesListValue); ok && context.Provenance() {
+				result = SeriesListValue(inheritProvenance(api.SeriesList(list), argValues))
 			}
+			return result, nil
 		},
 	}
 }
 
+// inheritProvenance gives each output series which has no provenance that of
+// the input series with the same tagset, or else the combined provenance of
+// every input series. Functions which build new series therefore needn't copy
+// their inputs' provenance themselves, unless (like those taking Expressions)
+// they evaluate their inputs directly.
+func inheritProvenance(output api.SeriesList, arguments []reflect.Value) api.SeriesList {
+	missing := false
+	for _, series := range output.Series {
+		if series.Provenance == "" {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return output
+	}
+	inputs := []api.Timeseries{}
+	for _, argument := range arguments {
+		if argument.Kind() == reflect.Ptr {
+			if argument.IsNil() {
+				continue
+			}
+			argument = argument.Elem()
+		}
+		if list, ok := argument.Interface().(api.SeriesList); ok {
+			inputs = append(inputs, list.Series...)
+		}
+	}
+	combined := api.CombineProvenance(inputs)
+	if combined == "" {
+		return output
+	}
+	byTagSet := map[string]string{}
+	for _, series := range inputs {
+		if series.Provenance != "" {
+			byTagSet[series.TagSet.Serialize()] = series.Provenance
+		}
+	}
+	result := api.SeriesList{
+		Series:      make([]api.Timeseries, len(output.Series)),
+		Annotations: output.Annotations,
+	}
+	for i, series := range output.Series {
+		if series.Provenance == "" {
+			if provenance, ok := byTagSet[series.TagSet.Serialize()]; ok {
+				series.Provenance = provenance
+			} else {
+				series.Provenance = combined
+			}
+		}
+		result.Series[i] = series
+	}
+	return result
+}
+
+// An argumentExtractor obtains the value of a single parameter of a function
+// wrapped by MakeFunction, given the arguments of one invocation.
+type argumentExtractor struct {
+	evaluates bool // whether the extractor evaluates one of the arguments (as opposed to reading the context)
+	extract   func(context EvaluationContext, arguments []Expression, groups Groups) (reflect.Value, error)
+}
+
+// evaluatorFor returns a function which evaluates an expression to the given type.
+// If an Expression is requested, the expression itself is returned.
+func evaluatorFor(resultType reflect.Type) func(Expression, EvaluationContext) (interface{}, error) {
+	switch resultType {
+	case expressionType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return expression, nil
+		}
+	case stringType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return EvaluateToString(expression, context)
+		}
+	case scalarType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return EvaluateToScalar(expression, context)
+		}
+	case scalarSetType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return EvaluateToScalarSet(expression, context)
+		}
+	case durationType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return EvaluateToDuration(expression, context)
+		}
+	case timeseriesType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return EvaluateToSeriesList(expression, context)
+		}
+	case valueType:
+		return func(expression Expression, context EvaluationContext) (interface{}, error) {
+			return expression.Evaluate(context)
+		}
+	}
+	panic(fmt.Sprintf("Unreachable :: Attempting to evaluate to unknown type %+v", resultType))
+}
+
+// outputConverterFor returns a function which converts the result of a
+// function wrapped by MakeFunction into a Value.
+func outputConverterFor(outputType reflect.Type) func(reflect.Value) Value {
+	switch outputType {
+	case stringType:
+		return func(output reflect.Value) Value {
+			return StringValue(output.Interface().(string))
+		}
+	case scalarType:
+		return func(output reflect.Value) Value {
+			return ScalarValue(output.Interface().(float64))
+		}
+	case scalarSetType:
+		return func(output reflect.Value) Value {
+			return output.Interface().(ScalarSet)
+		}
+	case durationType:
+		return func(output reflect.Value) Value {
+			return DurationValue{"", output.Interface().(time.Duration)}
+		}
+	case timeseriesType:
+		return func(output reflect.Value) Value {
+			return SeriesListValue(output.Interface().(api.SeriesList))
+		}
+	default:
+		return func(output reflect.Value) Value {
+			return output.Interface().(Value)
+		}
+	}
+}
+
+// returnTypeName describes the result of a function wrapped by MakeFunction.
+func returnTypeName(outputType reflect.Type) string {
+	if name, ok := typeNames[outputType]; ok {
+		return name
+	}
+	return typeNames[valueType]
+}
+
+// typeNames describes the types of arguments and results to users.
+var typeNames = map[reflect.Type]string{
+	stringType:     "string",
+	scalarType:     "scalar",
+	scalarSetType:  "scalar set",
+	durationType:   "duration",
+	timeseriesType: "series list",
+	valueType:      "value",
+	expressionType: "value",
+	trimmedType:    "series list",
+}
+
 var stringType = reflect.TypeOf("")
 var scalarType = reflect.TypeOf(float64(0.0))
 var scalarSetType = reflect.TypeOf(ScalarSet{})
 var durationType = reflect.TypeOf(time.Duration(0))
 var timeseriesType = reflect.TypeOf(api.SeriesList{})
+var trimmedType = reflect.TypeOf(TrimmedSeriesListValue{})
 var valueType = reflect.TypeOf((*Value)(nil)).Elem()
 var expressionType = reflect.TypeOf((*Expression)(nil)).Elem()
 var groupsType = reflect.TypeOf(Groups{})
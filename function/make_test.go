@@ -0,0 +1,139 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+)
+
+type literal struct {
+	value Value
+}
+
+func (lit literal) Evaluate(context EvaluationContext) (Value, error) {
+	return lit.value, nil
+}
+
+func (lit literal) ExpressionString(DescriptionMode) string {
+	return fmt.Sprintf("%+v", lit.value)
+}
+
+var makeTestFunction = MakeFunction("test.make", func(x float64, name string, duration *time.Duration, expression *Expression, timerange api.Timerange, groups Groups) (StringValue, error) {
+	if x < 0 {
+		return StringValue(""), fmt.Errorf("negative x")
+	}
+	durationString := "none"
+	if duration != nil {
+		durationString = duration.String()
+	}
+	expressionString := "none"
+	if expression != nil {
+		expressionString = (*expression).ExpressionString(StringQuery)
+	}
+	return StringValue(fmt.Sprintf("%g %s %s %s %d %v", x, name, durationString, expressionString, timerange.Slots(), groups.List)), nil
+})
+
+func TestMakeFunction(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	context := EvaluationContextBuilder{Timerange: timerange}.Build()
+	if makeTestFunction.MinArguments != 2 || makeTestFunction.MaxArguments != 4 || !makeTestFunction.AllowsGroupBy {
+		t.Fatalf("Unexpected signature for function: %+v", makeTestFunction)
+	}
+	if description := makeTestFunction.Description(); description != "takes a scalar, a string, an optional duration and an optional value; returns a value" {
+		t.Errorf("Unexpected description for function: %q", description)
+	}
+	tests := []struct {
+		arguments []Expression
+		expected  string
+		fails     bool
+	}{
+		{
+			arguments: []Expression{literal{ScalarValue(3)}, literal{StringValue("foo")}},
+			expected:  "3 foo none none 5 [dc]",
+		},
+		{
+			arguments: []Expression{literal{ScalarValue(3)}, literal{StringValue("foo")}, literal{NewDurationValue("5m", 5*time.Minute)}},
+			expected:  "3 foo 5m0s none 5 [dc]",
+		},
+		{
+			arguments: []Expression{literal{ScalarValue(3)}, literal{StringValue("foo")}, literal{NewDurationValue("5m", 5*time.Minute)}, literal{ScalarValue(7)}},
+			expected:  "3 foo 5m0s 7 5 [dc]",
+		},
+		{
+			arguments: []Expression{literal{ScalarValue(-1)}, literal{StringValue("foo")}},
+			fails:     true,
+		},
+		{
+			arguments: []Expression{literal{StringValue("foo")}, literal{StringValue("foo")}},
+			fails:     true,
+		},
+		{
+			arguments: []Expression{literal{ScalarValue(3)}},
+			fails:     true,
+		},
+	}
+	for _, test := range tests {
+		result, err := makeTestFunction.Run(context, test.arguments, Groups{List: []string{"dc"}})
+		if test.fails {
+			if err == nil {
+				t.Errorf("Expected failure for arguments %+v but got %+v", test.arguments, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for arguments %+v: %s", test.arguments, err.Error())
+			continue
+		}
+		if result != StringValue(test.expected) {
+			t.Errorf("Expected %q but got %+v", test.expected, result)
+		}
+	}
+}
+
+func BenchmarkMakeFunctionScalar(b *testing.B) {
+	add := MakeFunction("test.add", func(x float64, y float64) ScalarValue {
+		return ScalarValue(x + y)
+	})
+	context := EvaluationContextBuilder{}.Build()
+	arguments := []Expression{literal{ScalarValue(1)}, literal{ScalarValue(2)}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := add.Run(context, arguments, Groups{}); err != nil {
+			b.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+}
+
+func BenchmarkMakeFunctionMixed(b *testing.B) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		b.Fatalf("Error creating timerange for benchmark: %s", err.Error())
+	}
+	context := EvaluationContextBuilder{Timerange: timerange}.Build()
+	arguments := []Expression{literal{ScalarValue(3)}, literal{StringValue("foo")}, literal{NewDurationValue("5m", 5*time.Minute)}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := makeTestFunction.Run(context, arguments, Groups{}); err != nil {
+			b.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+}
@@ -14,7 +14,11 @@
 
 package function
 
-import "sync"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
 
 // memoized is a synchronized container for the results of an evaluation.
 // In order to use it, acquire the lock and then check whether "done" is true.
@@ -53,7 +57,7 @@ func (m *memoization) evaluate(e ActualExpression, context EvaluationContext) (V
 		return e.ActualEvaluate(context)
 	}
 	m.Lock()
-	memoIdentity := e.ExpressionString(StringMemoization)
+	memoIdentity := memoizationIdentity(e.ExpressionString(StringMemoization))
 	ptr, ok := m.memoized[memoIdentity]
 	if !ok {
 		ptr = new(memoized)
@@ -63,6 +67,22 @@ func (m *memoization) evaluate(e ActualExpression, context EvaluationContext) (V
 	return ptr.compute(e, context)
 }
 
+// maxIdentityLength is the longest memoization description used as it is.
+const maxIdentityLength = 128
+
+// memoizationIdentity shortens long memoization descriptions to a digest.
+// Since a memoized expression describes itself this way for memoization, the
+// descriptions of the expressions containing it stay short as well, so that a
+// long chain such as `a + b + ... + z` doesn't build quadratically many bytes
+// of descriptions in order to memoize each of its links.
+func memoizationIdentity(description string) string {
+	if len(description) <= maxIdentityLength {
+		return description
+	}
+	digest := sha256.Sum256([]byte(description))
+	return "#" + hex.EncodeToString(digest[:])
+}
+
 func newMemo() *memoization {
 	return &memoization{
 		memoized: make(map[string]*memoized),
@@ -73,11 +93,34 @@ func newMemo() *memoization {
 // memoized.
 type memoizedExpression struct {
 	Expression ActualExpression
+	strings    *expressionStrings
+}
+
+// expressionStrings caches the descriptions of an expression. Describing an
+// expression describes every expression inside it, and the description is
+// needed to memoize each of them; without the cache, a long chain such as
+// `a + b + ... + z` would take quadratic time to evaluate.
+type expressionStrings struct {
+	sync.Mutex
+	described map[DescriptionMode]string
 }
 
 // Memoize takes an ordinary actual expression and turns it into a memoized expression.
+// The expression must not be modified afterwards, since its descriptions are cached.
 func Memoize(expression ActualExpression) Expression {
-	return memoizedExpression{Expression: expression}
+	return memoizedExpression{
+		Expression: expression,
+		strings:    &expressionStrings{described: map[DescriptionMode]string{}},
+	}
+}
+
+// Unmemoize returns the ActualExpression underlying a memoized expression, if
+// the given expression is one.
+func Unmemoize(expression Expression) (ActualExpression, bool) {
+	if m, ok := expression.(memoizedExpression); ok {
+		return m.Expression, true
+	}
+	return nil, false
 }
 
 // Evaluate calls EvaluateMemoized on the underlying expression.
@@ -85,9 +128,24 @@ func (m memoizedExpression) Evaluate(context EvaluationContext) (Value, error) {
 	return context.EvaluateMemoized(m.Expression)
 }
 
-// ExpressionString behaves identically to the underlying expression
+// ExpressionString behaves identically to the underlying expression, but only
+// describes it once for each mode. Long memoization descriptions are shortened
+// by memoizationIdentity.
 func (m memoizedExpression) ExpressionString(mode DescriptionMode) string {
-	return m.Expression.ExpressionString(mode)
+	if m.strings == nil {
+		return m.Expression.ExpressionString(mode)
+	}
+	m.strings.Lock()
+	defer m.strings.Unlock()
+	if description, ok := m.strings.described[mode]; ok {
+		return description
+	}
+	description := m.Expression.ExpressionString(mode)
+	if mode == StringMemoization {
+		description = memoizationIdentity(description)
+	}
+	m.strings.described[mode] = description
+	return description
 }
 
 // memoization map holds a collection of memoization points.
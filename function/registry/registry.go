@@ -23,14 +23,24 @@ import (
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
 	"github.com/square/metrics/function/builtin/aggregate"
+	"github.com/square/metrics/function/builtin/events"
+	"github.com/square/metrics/function/builtin/fetch"
 	"github.com/square/metrics/function/builtin/filter"
 	"github.com/square/metrics/function/builtin/forecast"
+	"github.com/square/metrics/function/builtin/generate"
 	"github.com/square/metrics/function/builtin/join"
 	"github.com/square/metrics/function/builtin/summary"
 	"github.com/square/metrics/function/builtin/tag"
 	"github.com/square/metrics/function/builtin/transform"
+	"github.com/square/metrics/query/expression"
 )
 
+// Builtins are registered as "package.name", with the name in snake_case, so
+// that every function says where it lives (transform.auto_rate, rather than a
+// bare "auto"). The one exception is a name from another query language, such
+// as Graphite's "averageAbove", which is registered verbatim so that ported
+// dashboards keep working. Such a name is always an alias for a prefixed
+// builtin, and never the only way to call it.
 func init() {
 	// Arithmetic operators
 	MustRegister(NewOperator("+", func(x float64, y float64) float64 { return x + y }))
@@ -38,22 +48,44 @@ func init() {
 	MustRegister(NewOperator("*", func(x float64, y float64) float64 { return x * y }))
 	MustRegister(NewOperator("/", func(x float64, y float64) float64 { return x / y }))
 	// Aggregates
-	MustRegister(NewAggregate("aggregate.max", aggregate.Max))
-	MustRegister(NewAggregate("aggregate.min", aggregate.Min))
-	MustRegister(NewAggregate("aggregate.mean", aggregate.Mean))
-	MustRegister(NewAggregate("aggregate.sum", aggregate.Sum))
-	MustRegister(NewAggregate("aggregate.total", aggregate.Total))
-	MustRegister(NewAggregate("aggregate.count", aggregate.Count))
+	MustRegister(NewAggregate("aggregate.max", aggregate.Max, aggregate.MaxFold))
+	MustRegister(NewAggregate("aggregate.min", aggregate.Min, aggregate.MinFold))
+	MustRegister(NewAggregate("aggregate.mean", aggregate.Mean, nil))
+	MustRegister(NewAggregate("aggregate.sum", aggregate.Sum, aggregate.SumFold))
+	MustRegister(NewAggregate("aggregate.total", aggregate.Total, aggregate.TotalFold))
+	MustRegister(NewAggregate("aggregate.count", aggregate.Count, aggregate.CountFold))
+	MustRegister(NewAggregate("aggregate.coalesce", aggregate.Coalesce, nil))
+	MustRegister(aggregate.GroupByInterval)
+	MustRegister(aggregate.CollapseTags)
+	MustRegister(aggregate.GroupByTemplate)
+	MustRegister(aggregate.ReduceFunction)
 	// Transformations
 	MustRegister(transform.Integral)
 	MustRegister(transform.Cumulative)
+	MustRegister(transform.RunningMax)
+	MustRegister(transform.RunningMin)
 	MustRegister(transform.NaNFill)
+	MustRegister(transform.IsDefined)
 	MustRegister(transform.MapMaker("transform.abs", math.Abs))
-	MustRegister(transform.MapMaker("transform.log", math.Log10))
+	MustRegister(transform.Log)
+	MustRegister(transform.Log2)
+	MustRegister(transform.Log10)
+	MustRegister(transform.Sqrt)
+	MustRegister(transform.Exp)
+	MustRegister(transform.Pow)
+	MustRegister(transform.Sin)
+	MustRegister(transform.Cos)
+	MustRegister(transform.Tan)
 	MustRegister(transform.NaNKeepLast)
 	MustRegister(transform.Bound)
 	MustRegister(transform.LowerBound)
 	MustRegister(transform.UpperBound)
+	MustRegister(transform.Normalize)
+	MustRegister(transform.Convert)
+	MustRegister(transform.Delay)
+	MustRegister(transform.Changed)
+	MustRegister(transform.TimeSlice)
+	MustRegister(transform.Annotate)
 
 	// Filter
 	MustRegister(NewFilterCount("filter.highest_mean", aggregate.Mean, false))
@@ -72,27 +104,85 @@ func init() {
 	MustRegister(NewFilterThreshold("filter.max_below", aggregate.Max, true))
 	MustRegister(NewFilterThreshold("filter.min_below", aggregate.Min, true))
 
+	MustRegister(NewFilterThreshold("filter.current_above", filter.Current, false))
+	MustRegister(NewFilterThreshold("filter.current_below", filter.Current, true))
+
+	// Graphite's names for the threshold filters above, so that dashboards
+	// ported from it keep working.
+	MustRegister(NewFilterThreshold("averageAbove", aggregate.Mean, false))
+	MustRegister(NewFilterThreshold("averageBelow", aggregate.Mean, true))
+	MustRegister(NewFilterThreshold("maximumAbove", aggregate.Max, false))
+	MustRegister(NewFilterThreshold("maximumBelow", aggregate.Max, true))
+	MustRegister(NewFilterThreshold("minimumAbove", aggregate.Min, false))
+	MustRegister(NewFilterThreshold("minimumBelow", aggregate.Min, true))
+	MustRegister(NewFilterThreshold("currentAbove", filter.Current, false))
+	MustRegister(NewFilterThreshold("currentBelow", filter.Current, true))
+
+	MustRegister(filter.Limit)
+	MustRegister(filter.DedupeFunction)
+	MustRegister(filter.UnionFunction)
+
 	// Weird ones
 	MustRegister(transform.Derivative)
 	MustRegister(transform.MovingAverage)
 	MustRegister(transform.ExponentialMovingAverage)
+	MustRegister(transform.Envelope)
+	MustRegister(transform.PercentileOverTime)
+	MustRegister(transform.Splice)
+	MustRegister(transform.ScaleByTagSet)
+	MustRegister(transform.RemoveOutliers)
+	MustRegister(transform.Stack)
+	MustRegister(transform.Rank)
+	MustRegister(transform.AlignTo)
+	MustRegister(transform.EWMA)
+	MustRegister(transform.RequireFresh)
+	MustRegister(transform.RateLimit)
 	MustRegister(transform.Rate)
+	MustRegister(transform.RatePer)
+	MustRegister(transform.AutoRate)
 	MustRegister(transform.Timeshift)
+	MustRegister(transform.LastDuration)
+	MustRegister(transform.Decimate)
+	MustRegister(transform.CompareToPast)
+	MustRegister(transform.DiffFromBaseline)
+	MustRegister(transform.AssertClose)
 
 	// Tags
 	MustRegister(tag.DropFunction)
 	MustRegister(tag.SetFunction)
 	MustRegister(tag.CopyFunction)
+	MustRegister(tag.ProvenanceFunction)
+	MustRegister(tag.GroupKeysFunction)
+	MustRegister(tag.PivotFunction)
 
 	// Forecasting
 	MustRegister(forecast.FunctionRollingMultiplicativeHoltWinters)
 	MustRegister(forecast.FunctionAnomalyRollingMultiplicativeHoltWinters)
+	MustRegister(forecast.FunctionRollingMultiplicativeHoltWintersBands)
 	MustRegister(forecast.FunctionRollingSeasonal)
 	MustRegister(forecast.FunctionAnomalyRollingSeasonal)
 	MustRegister(forecast.FunctionLinear)
 
 	MustRegister(forecast.FunctionDrop)
 
+	// Generators
+	MustRegister(generate.ConstantLine)
+	MustRegister(generate.Sine)
+	MustRegister(generate.RandomWalk)
+	MustRegister(generate.Time)
+	MustRegister(generate.AsSeries)
+
+	// Fetching
+	MustRegister(fetch.ByTag)
+	MustRegister(fetch.EstimateCost)
+	MustRegister(fetch.Unbounded)
+	MustRegister(fetch.As)
+	MustRegister(fetch.Coalesce)
+	MustRegister(fetch.Matching)
+
+	// Events
+	MustRegister(events.Fetch)
+
 	// Summary
 	MustRegister(summary.Current)
 	MustRegister(summary.Oldest)
@@ -102,8 +192,15 @@ func init() {
 	MustRegister(summary.Integral)
 	MustRegister(summary.LastNotNaN)
 	MustRegister(summary.FirstNotNaN)
+	MustRegister(summary.PercentChange)
 	MustRegister(summary.Count)
 	MustRegister(summary.Total)
+	MustRegister(summary.Stat)
+	MustRegister(summary.CrossingsAbove)
+	MustRegister(summary.ValueHistogram)
+	MustRegister(summary.CrossCorrelation)
+	MustRegister(summary.ByTimeOfDay)
+	MustRegister(summary.ValueAt)
 }
 
 // StandardRegistry of a functions available in MQE.
@@ -196,13 +293,46 @@ func NewFilterThreshold(name string, summary func([]float64) float64, below bool
 }
 
 // NewAggregate takes a named aggregating function `[float64] => float64` and makes it into a MetricFunction.
-func NewAggregate(name string, aggregator func([]float64) float64) function.MetricFunction {
-	return function.MakeFunction(
+// If the aggregation is associative, its fold can be supplied so that, when the
+// context streams aggregations, a fetch is aggregated without holding all of its series.
+func NewAggregate(name string, aggregator func([]float64) float64, fold *aggregate.Fold) function.MetricFunction {
+	materialized := function.MakeFunction(
 		name,
-		func(seriesList api.SeriesList, groups function.Groups) api.SeriesList {
-			return aggregate.By(seriesList, aggregator, groups.List, groups.Collapses)
+		func(seriesList api.SeriesList, groups function.Groups, context function.EvaluationContext) (api.SeriesList, error) {
+			series, err := join.Resample(context, name, seriesList.Series)
+			if err != nil {
+				return api.SeriesList{}, err
+			}
+			seriesList = api.SeriesList{Series: series}
+			groups.NoteAbsentTags(context, seriesList)
+			return aggregate.By(seriesList, aggregator, groups.List, groups.Collapses), nil
 		},
 	)
+	if fold == nil {
+		return materialized
+	}
+	streaming := materialized
+	streaming.Compute = func(context function.EvaluationContext, arguments []function.Expression, groups function.Groups) (function.Value, error) {
+		if !context.StreamAggregations() {
+			return materialized.Compute(context, arguments, groups)
+		}
+		actual, ok := function.Unmemoize(arguments[0])
+		if !ok {
+			return materialized.Compute(context, arguments, groups)
+		}
+		fetch, ok := actual.(*expression.MetricFetchExpression)
+		if !ok {
+			return materialized.Compute(context, arguments, groups)
+		}
+		accumulator := aggregate.NewAccumulator(*fold, groups.List, groups.Collapses)
+		if err := fetch.Stream(context, accumulator.Add); err != nil {
+			return nil, err
+		}
+		result := accumulator.Result()
+		groups.NoteAbsentTags(context, result)
+		return function.SeriesListValue(result), nil
+	}
+	return streaming
 }
 
 // NewOperator creates a new binary operator function.
@@ -210,24 +340,8 @@ func NewAggregate(name string, aggregator func([]float64) float64) function.Metr
 func NewOperator(op string, operator func(float64, float64) float64) function.Function {
 	return function.MakeFunction(
 		op,
-		func(leftList api.SeriesList, rightList api.SeriesList, timerange api.Timerange) (api.SeriesList, error) {
-			joined := join.Join([]api.SeriesList{leftList, rightList})
-
-			result := make([]api.Timeseries, len(joined.Rows))
-
-			for i, row := range joined.Rows {
-				left := row.Row[0]
-				right := row.Row[1]
-				array := make([]float64, len(left.Values))
-				for j := 0; j < len(left.Values); j++ {
-					array[j] = operator(left.Values[j], right.Values[j])
-				}
-				result[i] = api.Timeseries{Values: array, TagSet: row.TagSet}
-			}
-
-			return api.SeriesList{
-				Series: result,
-			}, nil
+		func(leftList api.SeriesList, rightList api.SeriesList, context function.EvaluationContext) (api.SeriesList, error) {
+			return join.Combine(context, op, leftList, rightList, operator)
 		},
 	)
 }
@@ -16,10 +16,15 @@ package registry
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/square/metrics/api"
 	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/builtin/aggregate"
 	"github.com/square/metrics/testing_support/assert"
+	"golang.org/x/net/context"
 )
 
 var dummyCompute = func(function.EvaluationContext, []function.Expression, function.Groups) (function.Value, error) {
@@ -59,3 +64,92 @@ func Test_Registry_Error(t *testing.T) {
 		}
 	}
 }
+
+type literal struct {
+	value function.Value
+}
+
+func (lit literal) ExpressionString(mode function.DescriptionMode) string {
+	if mode == function.StringMemoization {
+		return fmt.Sprintf("%#v", lit)
+	}
+	return "<literal>"
+}
+func (lit literal) Evaluate(context function.EvaluationContext) (function.Value, error) {
+	return lit.value, nil
+}
+
+func TestMixedResolutions(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	fine := api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"host": "a"}}
+	coarse := api.Timeseries{Values: []float64{10, 20, 30}, TagSet: api.TagSet{"host": "a"}}
+	misaligned := api.Timeseries{Values: []float64{10, 20, 30, 40}, TagSet: api.TagSet{"host": "a"}}
+	tests := []struct {
+		name      string
+		function  function.Function
+		arguments []api.SeriesList
+		expected  []float64
+		fails     bool
+	}{
+		{
+			name:      "sum",
+			function:  NewAggregate("aggregate.sum", aggregate.Sum, aggregate.SumFold),
+			arguments: []api.SeriesList{{Series: []api.Timeseries{coarse, fine}}},
+			expected:  []float64{11, 12, 23, 24, 35},
+		},
+		{
+			name:      "plus",
+			function:  NewOperator("+", func(x float64, y float64) float64 { return x + y }),
+			arguments: []api.SeriesList{{Series: []api.Timeseries{fine}}, {Series: []api.Timeseries{coarse}}},
+			expected:  []float64{11, 12, 23, 24, 35},
+		},
+		{
+			name:      "misaligned sum",
+			function:  NewAggregate("aggregate.sum", aggregate.Sum, aggregate.SumFold),
+			arguments: []api.SeriesList{{Series: []api.Timeseries{fine, misaligned}}},
+			fails:     true,
+		},
+		{
+			name:      "misaligned plus",
+			function:  NewOperator("+", func(x float64, y float64) float64 { return x + y }),
+			arguments: []api.SeriesList{{Series: []api.Timeseries{misaligned}}, {Series: []api.Timeseries{fine}}},
+			fails:     true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.name)
+		ctx := function.EvaluationContextBuilder{
+			Timerange:       timerange,
+			EvaluationNotes: new(function.EvaluationNotes),
+			Ctx:             context.Background(),
+		}.Build()
+		arguments := []function.Expression{}
+		for _, list := range test.arguments {
+			arguments = append(arguments, literal{function.SeriesListValue(list)})
+		}
+		result, err := test.function.Run(ctx, arguments, function.Groups{})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected an error, but got %+v", result)
+			} else if !strings.Contains(err.Error(), "resolutions don't align") {
+				a.Errorf("Expected an error about resolutions, but got %s", err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		list, convErr := result.ToSeriesList(timerange)
+		if convErr != nil {
+			a.Errorf("Unexpected conversion error: %s", convErr.WithContext("result").Error())
+			continue
+		}
+		a.EqInt(len(list.Series), 1)
+		a.EqFloatArray(list.Series[0].Values, test.expected, 0)
+		a.EqInt(len(ctx.Notes()), 1)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import (
+	"time"
+
+	"github.com/square/metrics/api"
+)
+
+// A StringSetValue holds an ordered list of distinct strings, such as the
+// values of a tag. It can't be converted to any other kind of value.
+type StringSetValue []string
+
+// ToSeriesList is a conversion function.
+func (set StringSetValue) ToSeriesList(timerange api.Timerange) (api.SeriesList, *ConversionFailure) {
+	return api.SeriesList{}, &ConversionFailure{"string set", "SeriesList"}
+}
+
+// ToString is a conversion function.
+func (set StringSetValue) ToString() (string, *ConversionFailure) {
+	return "", &ConversionFailure{"string set", "string"}
+}
+
+// ToScalar is a conversion function.
+func (set StringSetValue) ToScalar() (float64, *ConversionFailure) {
+	return 0, &ConversionFailure{"string set", "scalar"}
+}
+
+// ToScalarSet is a conversion function.
+func (set StringSetValue) ToScalarSet() (ScalarSet, *ConversionFailure) {
+	return nil, &ConversionFailure{"string set", "scalar set"}
+}
+
+// ToDuration is a conversion function.
+func (set StringSetValue) ToDuration() (time.Duration, *ConversionFailure) {
+	return 0, &ConversionFailure{"string set", "duration"}
+}
@@ -16,6 +16,7 @@ package function
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"time"
@@ -87,6 +88,70 @@ func (list SeriesListValue) ToDuration() (time.Duration, *ConversionFailure) {
 	return 0, &ConversionFailure{"series list", "duration"}
 }
 
+// A TrimmedSeriesListValue holds a series list which covers only some of the
+// slots of the query's timerange: either only part of the timerange, such as
+// the result of transform.last_duration, or only every few slots, at a coarser
+// resolution, such as the result of transform.decimate.
+type TrimmedSeriesListValue struct {
+	List      api.SeriesList
+	Timerange api.Timerange // the slots of the query's timerange which the list covers
+}
+
+// ToSeriesList fills in the slots of the given timerange which the list
+// doesn't cover with NaN, so that the series can be combined with series
+// covering all of it. The list's own timerange must lie within the given one,
+// and its slots must be among the given timerange's.
+func (trimmed TrimmedSeriesListValue) ToSeriesList(timerange api.Timerange) (api.SeriesList, *ConversionFailure) {
+	if trimmed.Timerange == timerange {
+		return trimmed.List, nil
+	}
+	resolution := timerange.ResolutionMillis()
+	if trimmed.Timerange.ResolutionMillis()%resolution != 0 ||
+		trimmed.Timerange.StartMillis() < timerange.StartMillis() ||
+		trimmed.Timerange.EndMillis() > timerange.EndMillis() ||
+		(trimmed.Timerange.StartMillis()-timerange.StartMillis())%resolution != 0 {
+		return api.SeriesList{}, &ConversionFailure{"trimmed series list", "SeriesList"}
+	}
+	before := int((trimmed.Timerange.StartMillis() - timerange.StartMillis()) / resolution)
+	step := int(trimmed.Timerange.ResolutionMillis() / resolution)
+	result := api.SeriesList{
+		Series:      make([]api.Timeseries, len(trimmed.List.Series)),
+		Annotations: trimmed.List.Annotations,
+	}
+	for i, series := range trimmed.List.Series {
+		values := make([]float64, timerange.Slots())
+		for j := range values {
+			values[j] = math.NaN()
+		}
+		for j, value := range series.Values {
+			values[before+j*step] = value
+		}
+		series.Values = values
+		result.Series[i] = series
+	}
+	return result, nil
+}
+
+// ToString is a conversion function to implement the Value interface.
+func (trimmed TrimmedSeriesListValue) ToString() (string, *ConversionFailure) {
+	return "", &ConversionFailure{"series list", "string"}
+}
+
+// ToScalar is a conversion function to implement the Value interface.
+func (trimmed TrimmedSeriesListValue) ToScalar() (float64, *ConversionFailure) {
+	return 0, &ConversionFailure{"series list", "scalar"}
+}
+
+// ToScalarSet is a conversion function to implement the Value interface.
+func (trimmed TrimmedSeriesListValue) ToScalarSet() (ScalarSet, *ConversionFailure) {
+	return nil, &ConversionFailure{"series list", "scalar set"}
+}
+
+// ToDuration is a conversion function to implement the Value interface.
+func (trimmed TrimmedSeriesListValue) ToDuration() (time.Duration, *ConversionFailure) {
+	return 0, &ConversionFailure{"series list", "duration"}
+}
+
 // A StringValue holds a string
 type StringValue string
 
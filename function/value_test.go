@@ -15,8 +15,12 @@
 package function
 
 import (
+	"math"
 	"testing"
 	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
 )
 
 func TestToDuration(t *testing.T) {
@@ -51,3 +55,44 @@ func TestToDuration(t *testing.T) {
 	helper("-7y", -7000*60*60*24*365)
 	helper("-7yr", -7000*60*60*24*365)
 }
+
+func TestTrimmedSeriesListValue(t *testing.T) {
+	a := assert.New(t)
+	whole, err := api.NewTimerange(0, 120, 30)
+	a.CheckError(err)
+	last, err := api.NewTimerange(60, 120, 30)
+	a.CheckError(err)
+	trimmed := TrimmedSeriesListValue{
+		List:      api.SeriesList{Series: []api.Timeseries{{Values: []float64{3, 4, 5}, TagSet: api.TagSet{"host": "a"}}}},
+		Timerange: last,
+	}
+	list, failure := trimmed.ToSeriesList(last)
+	if failure != nil {
+		t.Fatalf("Unexpected failure: %+v", failure)
+	}
+	a.EqFloatArray(list.Series[0].Values, []float64{3, 4, 5}, 0)
+
+	list, failure = trimmed.ToSeriesList(whole)
+	if failure != nil {
+		t.Fatalf("Unexpected failure: %+v", failure)
+	}
+	a.EqFloatArray(list.Series[0].Values, []float64{math.NaN(), math.NaN(), 3, 4, 5}, 0)
+	a.Eq(list.Series[0].TagSet, api.TagSet{"host": "a"})
+
+	coarser, err := api.NewTimerange(0, 120, 60)
+	a.CheckError(err)
+	if _, failure := trimmed.ToSeriesList(coarser); failure == nil {
+		a.Errorf("Expected a failure padding to a coarser resolution")
+	}
+
+	// A list at a coarser resolution fills every few slots.
+	decimated := TrimmedSeriesListValue{
+		List:      api.SeriesList{Series: []api.Timeseries{{Values: []float64{1, 3, 5}}}},
+		Timerange: coarser,
+	}
+	list, failure = decimated.ToSeriesList(whole)
+	if failure != nil {
+		t.Fatalf("Unexpected failure: %+v", failure)
+	}
+	a.EqFloatArray(list.Series[0].Values, []float64{1, math.NaN(), 3, math.NaN(), 5}, 0)
+}
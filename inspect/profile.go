@@ -20,12 +20,16 @@ import (
 )
 
 // Profiler contains a sequence of profiles which are collected over the course of a query execution.
+// A nil *Profiler is valid, and records nothing: every method is a no-op (apart
+// from running the action given to Do), so that profiling can be skipped
+// without checking for nil at each use.
 type Profiler struct {
 	now      func() time.Time
 	mutex    sync.Mutex // Since profilers are only ever used as pointers, the mutex is not a pointer.
 	profiles []Profile
 }
 
+// New creates an empty profiler.
 func New() *Profiler {
 	return &Profiler{
 		now:      time.Now,
@@ -34,6 +38,7 @@ func New() *Profiler {
 	}
 }
 
+// AddProfile adds an already-measured profile. It acts in a threadsafe manner.
 func (p *Profiler) AddProfile(profile Profile) {
 	if p == nil {
 		return
@@ -79,6 +84,7 @@ func (p *Profiler) Do(name string, action func()) {
 	})
 }
 
+// RecordWithDescription is like Record, but the profile also holds the description.
 func (p *Profiler) RecordWithDescription(name string, description string) func() {
 	if p == nil {
 		// If the profiler instance doesn't exist, then don't attempt to operate on it.
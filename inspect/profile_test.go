@@ -85,3 +85,18 @@ func TestProfilerSimple(t *testing.T) {
 	flushed = profiler.Flush()
 	a.EqInt(len(flushed), 0)
 }
+
+func TestProfilerNil(t *testing.T) {
+	a := assert.New(t)
+	var profiler *Profiler
+	profiler.AddProfile(Profile{Name: "add"})
+	profiler.Record("record")()
+	profiler.RecordWithDescription("record", "with a description")()
+	ran := false
+	profiler.Do("do", func() {
+		ran = true
+	})
+	a.EqBool(ran, true)
+	a.EqInt(len(profiler.All()), 0)
+	a.EqInt(len(profiler.Flush()), 0)
+}
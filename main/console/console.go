@@ -29,6 +29,7 @@ import (
 	"github.com/square/metrics/query/command"
 	"github.com/square/metrics/query/parser"
 	"github.com/square/metrics/timeseries/blueflood"
+	"github.com/square/metrics/timeseries/recorded"
 	"github.com/square/metrics/util"
 
 	"golang.org/x/net/context"
@@ -49,10 +50,28 @@ func main() {
 		ConversionRulesPath string           `yaml:"conversion_rules_path"`
 		Cassandra           cassandra.Config `yaml:"cassandra"`
 		Blueflood           blueflood.Config `yaml:"blueflood"`
+		RecordedDir         string           `yaml:"recorded_dir"` // if set, queries run against the metrics recorded here instead
 	}{}
 
 	common.LoadConfig(&config)
 
+	if config.RecordedDir != "" {
+		recordedAPI, err := recorded.NewAPI(config.RecordedDir)
+		if err != nil {
+			common.ExitWithErrorMessage("Error loading recorded metrics: %s", err.Error())
+			return
+		}
+		repl(command.ExecutionContext{
+			MetricMetadataAPI:    recordedAPI,
+			TimeseriesStorageAPI: recordedAPI,
+			FetchLimit:           1500,
+			SlotLimit:            5000,
+			Registry:             registry.Default(),
+			Ctx:                  context.Background(),
+		})
+		return
+	}
+
 	cassandraAPI, err := cassandra.NewMetricMetadataAPI(config.Cassandra)
 	if err != nil {
 		common.ExitWithErrorMessage("Error loading Cassandra API: %s", err.Error())
@@ -77,7 +96,11 @@ func main() {
 		Registry:             registry.Default(),
 		Ctx:                  context.Background(),
 	}
+	repl(executionContext)
+}
 
+// repl reads queries from stdin and prints their results.
+func repl(executionContext command.ExecutionContext) {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
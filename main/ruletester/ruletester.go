@@ -87,7 +87,7 @@ func main() {
 		common.ExitWithErrorMessage(fmt.Sprintf("Error while reading rules: %s", err.Error()))
 	}
 
-	graphiteConverter := util.RuleBasedGraphiteConverter{Ruleset: ruleset}
+	graphiteConverter := &util.RuleBasedGraphiteConverter{Ruleset: ruleset}
 
 	metrics, err := ReadMetricsFile(*metricsFile)
 	if err != nil {
@@ -111,7 +111,7 @@ const (
 	ReverseChanged                         // ReverseChanged indicates a metric that was matched and reversed without error, but was changed through the round trip
 )
 
-func ClassifyMetric(metric string, graphiteConverter util.RuleBasedGraphiteConverter) ConversionStatus {
+func ClassifyMetric(metric string, graphiteConverter *util.RuleBasedGraphiteConverter) ConversionStatus {
 	graphiteMetric := util.GraphiteMetric(metric)
 	taggedMetric, err := graphiteConverter.ToTaggedName(graphiteMetric)
 	if err != nil {
@@ -127,7 +127,7 @@ func ClassifyMetric(metric string, graphiteConverter util.RuleBasedGraphiteConve
 	return Matched
 }
 
-func DoAnalysis(metrics []string, graphiteConverter util.RuleBasedGraphiteConverter) map[ConversionStatus][]string {
+func DoAnalysis(metrics []string, graphiteConverter *util.RuleBasedGraphiteConverter) map[ConversionStatus][]string {
 	graphiteConverter.EnableStats()
 
 	workQueue := make(chan string, 100)
@@ -209,7 +209,7 @@ func DoAnalysis(metrics []string, graphiteConverter util.RuleBasedGraphiteConver
 	return classifiedMetrics
 }
 
-func GenerateReport(unmatched []string, graphiteConverter util.RuleBasedGraphiteConverter) {
+func GenerateReport(unmatched []string, graphiteConverter *util.RuleBasedGraphiteConverter) {
 	err := os.RemoveAll("report")
 	if err != nil {
 		panic("Can't delete the report directory")
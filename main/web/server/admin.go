@@ -0,0 +1,126 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/log"
+	"github.com/square/metrics/metric_metadata"
+)
+
+// adminHandler guards an administrative handler: requests must use its method
+// (POST, unless another is given) and bear the configured admin token.
+type adminHandler struct {
+	token   string
+	method  string
+	handler http.Handler
+}
+
+func (h adminHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	method := h.method
+	if method == "" {
+		method = "POST"
+	}
+	if request.Method != method {
+		writer.Header().Set("Allow", method)
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		writer.Write(encodeError(fmt.Errorf("%s only accepts %s requests", request.URL.Path, method)))
+		return
+	}
+	presented := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(h.token)) != 1 {
+		writer.WriteHeader(http.StatusUnauthorized)
+		writer.Write(encodeError(errors.New("admin endpoints require a valid admin token")))
+		return
+	}
+	h.handler.ServeHTTP(writer, request)
+}
+
+// reloadRulesHandler reloads the Graphite conversion rules. If they can't be
+// loaded, the current rules remain in use.
+type reloadRulesHandler struct {
+	reload func() error
+}
+
+func (h reloadRulesHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if err := h.reload(); err != nil {
+		log.Errorf("Error reloading conversion rules: %s", err.Error())
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	log.Infof("Reloaded conversion rules")
+	encoded, err := json.Marshal(Response{
+		Success: true,
+		Message: "conversion rules reloaded",
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	writer.Write(encoded)
+}
+
+// metricKindHandler records the kind of the metric named by the request's
+// "metric" parameter, given by its "kind" parameter.
+type metricKindHandler struct {
+	kinds metadata.MetricKindUpdateAPI
+}
+
+func (h metricKindHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(err))
+		return
+	}
+	metric := request.Form.Get("metric")
+	if metric == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(errors.New("a metric must be given")))
+		return
+	}
+	kind, err := metadata.ParseMetricKind(request.Form.Get("kind"))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(err))
+		return
+	}
+	if err := h.kinds.SetMetricKind(api.MetricKey(metric), kind, metadata.Context{}); err != nil {
+		log.Errorf("Error recording the kind of metric %s: %s", metric, err.Error())
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	log.Infof("Recorded metric %s as a %s", metric, kind)
+	encoded, err := json.Marshal(Response{
+		Success: true,
+		Message: fmt.Sprintf("metric %s recorded as a %s", metric, kind),
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	writer.Write(encoded)
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+)
+
+func TestReloadRules(t *testing.T) {
+	reloads := 0
+	var failure error
+	hook := Hook{ReloadRules: func() error {
+		if failure != nil {
+			return failure
+		}
+		reloads++
+		return nil
+	}}
+	mux, err := NewMux(Config{AdminToken: "secret"}, command.ExecutionContext{}, hook)
+	if err != nil {
+		t.Fatalf("Unexpected error creating mux: %s", err.Error())
+	}
+	tests := []struct {
+		method        string
+		authorization string
+		failure       error
+		status        int
+		reloads       int
+	}{
+		{method: "POST", authorization: "Bearer secret", status: http.StatusOK, reloads: 1},
+		{method: "GET", authorization: "Bearer secret", status: http.StatusMethodNotAllowed, reloads: 1},
+		{method: "POST", status: http.StatusUnauthorized, reloads: 1},
+		{method: "POST", authorization: "Bearer wrong", status: http.StatusUnauthorized, reloads: 1},
+		{method: "POST", authorization: "Bearer secret", failure: errors.New("bad rules"), status: http.StatusInternalServerError, reloads: 1},
+		{method: "POST", authorization: "Bearer secret", status: http.StatusOK, reloads: 2},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s with %q (failure %v)", test.method, test.authorization, test.failure)
+		failure = test.failure
+		request := httptest.NewRequest(test.method, "/admin/reload-rules", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		a.EqInt(reloads, test.reloads)
+		if test.failure != nil && !strings.Contains(recorder.Body.String(), test.failure.Error()) {
+			a.Errorf("Expected the response to report the error, but got %s", recorder.Body.String())
+		}
+	}
+}
+
+func TestReloadRulesRequiresToken(t *testing.T) {
+	hook := Hook{ReloadRules: func() error {
+		t.Errorf("Rules shouldn't be reloaded without an admin token configured")
+		return nil
+	}}
+	mux, err := NewMux(Config{}, command.ExecutionContext{}, hook)
+	if err != nil {
+		t.Fatalf("Unexpected error creating mux: %s", err.Error())
+	}
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/reload-rules", nil))
+	if recorder.Code == http.StatusOK {
+		t.Errorf("Expected /admin/reload-rules not to be served, but got %d", recorder.Code)
+	}
+}
+
+func TestMetricKind(t *testing.T) {
+	kinds := mocks.NewFakeMetricMetadataAPI()
+	mux, err := NewMux(Config{AdminToken: "secret"}, command.ExecutionContext{}, Hook{MetricKinds: kinds})
+	if err != nil {
+		t.Fatalf("Unexpected error creating mux: %s", err.Error())
+	}
+	tests := []struct {
+		query         string
+		authorization string
+		status        int
+		expected      metadata.MetricKind // the kind of "requests" afterwards
+	}{
+		{query: "metric=requests&kind=counter", status: http.StatusUnauthorized, expected: metadata.KindUnknown},
+		{query: "metric=requests&kind=counter", authorization: "Bearer secret", status: http.StatusOK, expected: metadata.KindCounter},
+		{query: "metric=requests&kind=bogus", authorization: "Bearer secret", status: http.StatusBadRequest, expected: metadata.KindCounter},
+		{query: "metric=requests&kind=unknown", authorization: "Bearer secret", status: http.StatusBadRequest, expected: metadata.KindCounter},
+		{query: "kind=gauge", authorization: "Bearer secret", status: http.StatusBadRequest, expected: metadata.KindCounter},
+		{query: "metric=requests&kind=histogram", authorization: "Bearer secret", status: http.StatusOK, expected: metadata.KindHistogram},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s with %q", test.query, test.authorization)
+		request := httptest.NewRequest("POST", "/admin/metric-kind?"+test.query, nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		kind, err := kinds.GetMetricKind("requests", metadata.Context{})
+		a.CheckError(err)
+		a.EqString(string(kind), string(test.expected))
+	}
+}
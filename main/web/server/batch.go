@@ -0,0 +1,144 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/inspect"
+	"github.com/square/metrics/tasks"
+)
+
+// defaultBatchConcurrency is the number of queries in a batch which are
+// evaluated at once when the config doesn't say otherwise.
+const defaultBatchConcurrency = 4
+
+// BatchForm is a list of queries to evaluate in one request.
+type BatchForm struct {
+	Queries []QueryForm `json:"queries"`
+	Profile bool        `json:"profile"`
+}
+
+// BatchResponse holds one response for each query of the batch, in order.
+// A failed query's response has Success false and its error as the Message,
+// without failing the rest of the batch.
+type BatchResponse struct {
+	Success bool       `json:"success"`
+	Results []Response `json:"results"`
+}
+
+// batchHandler evaluates several queries for a single HTTP request (e.g. every
+// panel of a dashboard). The queries share one fetch limit, and run
+// concurrently so that identical fetches can be coalesced by the storage API.
+type batchHandler struct {
+	query queryHandler
+}
+
+func (b batchHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	batchForm := BatchForm{}
+	switch request.Header.Get("Content-Type") {
+	case "application/json": // assume the body is a JSON request
+		if err := json.NewDecoder(request.Body).Decode(&batchForm); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(encodeError(err))
+			return
+		}
+	default: // use the form parameters; each "query" is one query of the batch
+		if err := request.ParseForm(); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(encodeError(err))
+			return
+		}
+		for _, input := range request.Form["query"] {
+			batchForm.Queries = append(batchForm.Queries, QueryForm{Input: input})
+		}
+		batchForm.Profile, _ = strconv.ParseBool(request.Form.Get("profile"))
+	}
+	if len(batchForm.Queries) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(fmt.Errorf("batch contains no queries")))
+		return
+	}
+
+	context := b.query.hook.authorize(b.query.context, request)
+	if context.FetchCounter == nil {
+		counter := function.NewFetchCounter(context.ResolveLimits().FetchLimit)
+		context.FetchCounter = &counter
+	}
+
+	concurrency := b.query.config.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	responses := make([]Response, len(batchForm.Queries))
+	queue := tasks.NewParallelQueue(concurrency, context.Ctx)
+	for i := range batchForm.Queries {
+		i := i // Captures it in a new local for the closure.
+		queue.Do(func() error {
+			profile := batchForm.Profile || batchForm.Queries[i].Profile
+			var profiler *inspect.Profiler
+			if profile || b.query.hook.OnQuery != nil {
+				profiler = inspect.New()
+			}
+			responseMessage, err := b.query.process(profiler, batchForm.Queries[i], context)
+			if err != nil {
+				responses[i] = Response{Success: false, Message: err.Error(), Code: string(function.ClassOf(err))}
+			} else {
+				responses[i] = Response{Success: true, QueryResponse: responseMessage}
+			}
+			if profile {
+				responses[i].Profile = profiler.All()
+			}
+			if b.query.hook.OnQuery != nil {
+				go func() {
+					b.query.hook.OnQuery <- profiler
+				}()
+			}
+			return nil // Errors are reported for each query, rather than for the batch.
+		})
+	}
+	if err := queue.Wait(); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+
+	encoded, err := json.Marshal(BatchResponse{Success: true, Results: responses})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	if limit := b.query.config.MaxResponseBytes; limit > 0 && len(encoded) > limit {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(responseLimitError{count: len(encoded), limit: limit, unit: "bytes"}))
+		return
+	}
+	// The batch may be cached only if every query may be; failed queries have
+	// no timerange, so they aren't.
+	ends := make([]time.Time, len(responses))
+	for i := range responses {
+		ends[i] = responses[i].end
+	}
+	b.query.setCacheHeaders(writer, ends...)
+	writer.Write(encoded)
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestBatchHandler(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	a.CheckError(err)
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "memory", "host": "a"}},
+	)
+	handler := batchHandler{query: queryHandler{context: command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           3,
+		Ctx:                  context.Background(),
+	}}}
+	serve := func(request *http.Request) (int, BatchResponse) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		response := BatchResponse{}
+		if recorder.Code == http.StatusOK {
+			a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		}
+		return recorder.Code, response
+	}
+
+	// Results are in order, and a bad query doesn't fail the others.
+	form := url.Values{"query": {
+		"select memory from 0 to 120 resolution 30ms",
+		"select nonsense(",
+		"select aggregate.sum(cpu) from 0 to 120 resolution 30ms",
+	}}
+	request, err := http.NewRequest("GET", "/batch?"+form.Encode(), nil)
+	a.CheckError(err)
+	code, response := serve(request)
+	a.EqInt(code, http.StatusOK)
+	a.EqInt(len(response.Results), 3)
+	if len(response.Results) == 3 {
+		a.EqBool(response.Results[0].Success, true)
+		a.EqString(response.Results[0].Name, "select")
+		a.EqBool(response.Results[1].Success, false)
+		if response.Results[1].Message == "" {
+			a.Errorf("expected the failed query to have an error message")
+		}
+		a.EqBool(response.Results[2].Success, true)
+	}
+
+	// The queries share a single fetch limit: only one of these can fetch both cpu series.
+	body, err := json.Marshal(BatchForm{Queries: []QueryForm{
+		{Input: "select cpu from 0 to 120 resolution 30ms"},
+		{Input: "select cpu from 0 to 120 resolution 30ms"},
+	}})
+	a.CheckError(err)
+	request, err = http.NewRequest("POST", "/batch", bytes.NewReader(body))
+	a.CheckError(err)
+	request.Header.Set("Content-Type", "application/json")
+	code, response = serve(request)
+	a.EqInt(code, http.StatusOK)
+	failures := 0
+	for _, result := range response.Results {
+		if !result.Success {
+			failures++
+			if !strings.Contains(result.Message, "limit") {
+				a.Errorf("expected a fetch limit error but got %q", result.Message)
+			}
+		}
+	}
+	a.EqInt(failures, 1)
+
+	// An empty batch is rejected.
+	request, err = http.NewRequest("GET", "/batch", nil)
+	a.CheckError(err)
+	code, _ = serve(request)
+	a.EqInt(code, http.StatusBadRequest)
+}
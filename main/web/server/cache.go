@@ -0,0 +1,78 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/square/metrics/query/command"
+)
+
+// defaultCacheSettle is how long after a timerange ends before its data is
+// assumed to have settled, unless the config says otherwise.
+const defaultCacheSettle = 5 * time.Minute
+
+// timerangeEnd is the end of the timerange requested by a select command. Other
+// commands have no timerange, and give the zero time.
+func timerangeEnd(cmd command.Command) time.Time {
+	selectCommand, ok := cmd.(*command.SelectCommand)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, selectCommand.Context.End*int64(time.Millisecond))
+}
+
+// cacheControl chooses the Cache-Control header for a response to queries
+// whose timeranges end at the given times. A response may be cached for
+// CacheMaxAge only if every timerange ended at least CacheSettle before `now`,
+// so that its data won't change; "live" queries ending near now (and commands
+// without a timerange) get "no-cache" instead. A private response may only be
+// cached by the client which requested it.
+func (c Config) cacheControl(now time.Time, private bool, ends ...time.Time) string {
+	settle := time.Duration(c.CacheSettle) * time.Second
+	if settle == 0 {
+		settle = defaultCacheSettle
+	}
+	if len(ends) == 0 {
+		return "no-cache"
+	}
+	for _, end := range ends {
+		if end.IsZero() || end.Add(settle).After(now) {
+			return "no-cache"
+		}
+	}
+	if private {
+		return fmt.Sprintf("private, max-age=%d", c.CacheMaxAge)
+	}
+	return fmt.Sprintf("max-age=%d", c.CacheMaxAge)
+}
+
+// setCacheHeaders sets the Cache-Control header for a response to queries
+// whose timeranges end at the given times, if caching is configured. When an
+// Authorizer hides series from some principals, the response depends on who
+// asked for it, so it's private (and varies with the principal's header), to
+// keep shared caches from serving one principal's results to another.
+func (q queryHandler) setCacheHeaders(writer http.ResponseWriter, ends ...time.Time) {
+	if q.config.CacheMaxAge <= 0 {
+		return
+	}
+	private := q.hook.Authorizer != nil
+	if private && q.config.PrincipalHeader != "" {
+		writer.Header().Add("Vary", q.config.PrincipalHeader)
+	}
+	writer.Header().Set("Cache-Control", q.config.cacheControl(q.currentTime(), private, ends...))
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCacheControl(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tests := []struct {
+		config   Config
+		ends     []time.Time
+		expected string
+	}{
+		{Config{CacheMaxAge: 3600}, []time.Time{now.Add(-time.Hour)}, "max-age=3600"},
+		{Config{CacheMaxAge: 3600}, []time.Time{now.Add(-5 * time.Minute)}, "max-age=3600"},
+		{Config{CacheMaxAge: 3600}, []time.Time{now.Add(-4 * time.Minute)}, "no-cache"},
+		{Config{CacheMaxAge: 3600}, []time.Time{now}, "no-cache"},
+		{Config{CacheMaxAge: 3600}, []time.Time{now.Add(time.Hour)}, "no-cache"},
+		{Config{CacheMaxAge: 3600, CacheSettle: 60}, []time.Time{now.Add(-4 * time.Minute)}, "max-age=3600"},
+		// Every timerange must have settled.
+		{Config{CacheMaxAge: 60}, []time.Time{now.Add(-time.Hour), now.Add(-time.Minute)}, "no-cache"},
+		// Commands without a timerange aren't cached.
+		{Config{CacheMaxAge: 60}, []time.Time{{}}, "no-cache"},
+		{Config{CacheMaxAge: 60}, nil, "no-cache"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v with %+v", test.config, test.ends)
+		a.EqString(test.config.cacheControl(now, false, test.ends...), test.expected)
+	}
+	a := assert.New(t)
+	a.EqString(Config{CacheMaxAge: 60}.cacheControl(now, true, now.Add(-time.Hour)), "private, max-age=60")
+	a.EqString(Config{CacheMaxAge: 60}.cacheControl(now, true, now), "no-cache")
+}
+
+func TestQueryHandlerCacheControl(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	end := time.Unix(0, 120*int64(time.Millisecond))
+	tests := []struct {
+		query    string
+		config   Config
+		now      time.Time
+		expected string
+	}{
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{CacheMaxAge: 600}, now: end.Add(time.Hour), expected: "max-age=600"},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{CacheMaxAge: 600}, now: end.Add(time.Minute), expected: "no-cache"},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{}, now: end.Add(time.Hour), expected: ""},
+		{query: "describe cpu", config: Config{CacheMaxAge: 600}, now: end.Add(time.Hour), expected: "no-cache"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s at %s with %+v", test.query, test.now, test.config)
+		now := test.now
+		handler := queryHandler{context: executionContext, config: test.config, now: func() time.Time { return now }}
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/query?query="+url.QueryEscape(test.query), nil)
+		a.CheckError(err)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, http.StatusOK)
+		a.EqString(recorder.Header().Get("Cache-Control"), test.expected)
+	}
+}
+
+func TestQueryHandlerCacheControlAuthorizer(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "tenant": "a"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	now := time.Unix(0, 120*int64(time.Millisecond)).Add(time.Hour)
+	tests := []struct {
+		hook     Hook
+		config   Config
+		expected string
+		vary     string
+	}{
+		{config: Config{CacheMaxAge: 600, PrincipalHeader: "X-Tenant"}, expected: "max-age=600"},
+		{hook: Hook{Authorizer: tenantAuthorizer{}}, config: Config{CacheMaxAge: 600}, expected: "private, max-age=600"},
+		{hook: Hook{Authorizer: tenantAuthorizer{}}, config: Config{CacheMaxAge: 600, PrincipalHeader: "X-Tenant"}, expected: "private, max-age=600", vary: "X-Tenant"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test.config)
+		handler := queryHandler{context: executionContext, hook: test.hook, config: test.config, now: func() time.Time { return now }}
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/query?query="+url.QueryEscape("select cpu from 0 to 120 resolution 30ms"), nil)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, http.StatusOK)
+		a.EqString(recorder.Header().Get("Cache-Control"), test.expected)
+		a.EqString(recorder.Header().Get("Vary"), test.vary)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/square/metrics/metric_metadata/cardinality"
+)
+
+// cardinalityHandler serves the latest tag-cardinality survey of the metadata.
+type cardinalityHandler struct {
+	reporter *cardinality.Reporter
+}
+
+func (h cardinalityHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	encoded, err := json.Marshal(Response{
+		Success: true,
+		QueryResponse: QueryResponse{
+			Body: h.reporter.Report(),
+		},
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	writer.Write(encoded)
+}
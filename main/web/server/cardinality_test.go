@@ -0,0 +1,105 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/metric_metadata/cardinality"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+)
+
+func TestCardinalityHandler(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewTimerange(0, 0, 1)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{0}, TagSet: api.TagSet{"metric": "a", "host": "1"}},
+		api.Timeseries{Values: []float64{0}, TagSet: api.TagSet{"metric": "a", "host": "2"}},
+	)
+	reporter := cardinality.NewReporter(comboAPI, cardinality.Config{})
+	a.CheckError(reporter.Survey(metadata.Context{}))
+
+	recorder := httptest.NewRecorder()
+	cardinalityHandler{reporter: reporter}.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/cardinality", nil))
+	var response struct {
+		Success bool               `json:"success"`
+		Body    cardinality.Report `json:"body"`
+	}
+	a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	a.EqBool(response.Success, true)
+	a.EqInt(len(response.Body.Metrics), 2) // including the fake API's series_timeout
+	a.Eq(response.Body.Metrics[0].Metric, api.MetricKey("a"))
+	a.EqInt(response.Body.Metrics[0].Tags["host"], 2)
+}
+
+func TestCardinalityRequiresAdmin(t *testing.T) {
+	timerange, err := api.NewTimerange(0, 0, 1)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{0}, TagSet: api.TagSet{"metric": "a", "host": "1"}},
+	)
+	hook := Hook{Cardinality: cardinality.NewReporter(comboAPI, cardinality.Config{})}
+	mux, err := NewMux(Config{AdminToken: "secret"}, command.ExecutionContext{}, hook)
+	if err != nil {
+		t.Fatalf("Unexpected error creating mux: %s", err.Error())
+	}
+	tests := []struct {
+		method        string
+		path          string
+		authorization string
+		status        int
+	}{
+		{method: "GET", path: "/admin/cardinality", status: http.StatusUnauthorized},
+		{method: "GET", path: "/admin/cardinality", authorization: "Bearer wrong", status: http.StatusUnauthorized},
+		{method: "POST", path: "/admin/cardinality", authorization: "Bearer secret", status: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/admin/cardinality", authorization: "Bearer secret", status: http.StatusOK},
+		{method: "GET", path: "/cardinality", authorization: "Bearer secret", status: http.StatusTemporaryRedirect},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s %s with %q", test.method, test.path, test.authorization)
+		request := httptest.NewRequest(test.method, test.path, nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+	}
+
+	// Without an admin token, the survey isn't served at all.
+	mux, err = NewMux(Config{}, command.ExecutionContext{}, hook)
+	if err != nil {
+		t.Fatalf("Unexpected error creating mux: %s", err.Error())
+	}
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/cardinality", nil))
+	if recorder.Code == http.StatusOK {
+		t.Errorf("Expected /admin/cardinality not to be served, but got %d", recorder.Code)
+	}
+}
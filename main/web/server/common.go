@@ -23,13 +23,31 @@ import (
 	"reflect"
 	"strconv"
 
+	"github.com/square/metrics/function"
 	"github.com/square/metrics/log"
 )
 
+// errorStatus chooses the HTTP status code for an error, according to its
+// class. An HTTPError's own code takes precedence.
+func errorStatus(err error) int {
+	if errHTTP, ok := err.(HTTPError); ok {
+		return errHTTP.ErrorCode()
+	}
+	switch function.ClassOf(err) {
+	case function.BackendErrorClass:
+		return http.StatusBadGateway
+	case function.TimeoutErrorClass:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 func encodeError(err error) []byte {
 	encoded, err2 := json.MarshalIndent(Response{
 		Success: false,
 		Message: err.Error(),
+		Code:    string(function.ClassOf(err)),
 	}, "", "  ")
 	if err2 == nil {
 		return encoded
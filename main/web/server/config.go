@@ -14,7 +14,17 @@
 
 package server
 
-import "github.com/square/metrics/inspect"
+import (
+	"net/http"
+
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/inspect"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/metric_metadata/cardinality"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/macro"
+	"github.com/square/metrics/util"
+)
 
 type Config struct {
 	Port          int    `yaml:"port"`
@@ -22,8 +32,108 @@ type Config struct {
 	StaticDir     string `yaml:"static_dir"`
 	JSONIngestion bool   `yaml:"json_ingestion"`
 	HTTPIngestion bool   `yaml:"enable_http_ingestion"`
+
+	// Queries whose responses would exceed these limits fail instead of
+	// returning an unusably large payload. Zero means unlimited.
+	MaxResponseSeries int `yaml:"max_response_series"` // series (or scalars) across all results
+	MaxResponseBytes  int `yaml:"max_response_bytes"`  // size of the encoded JSON
+
+	BatchConcurrency int `yaml:"batch_concurrency"` // queries of a /batch request evaluated at once (0 => default 4)
+
+	// Timezone (e.g. "America/New_York") is used by wall-clock functions such
+	// as transform.time_slice unless the query names its own. Empty means UTC.
+	Timezone string `yaml:"timezone"`
+
+	// EmptyResults determines what happens when a fetch matches no series:
+	// "note" (the default) adds a note, "empty" returns nothing quietly, and
+	// "error" fails the query. Queries may override it.
+	EmptyResults string `yaml:"empty_results"`
+
+	// SlowQueries logs the queries which take too long, fetch too many series,
+	// or return too many series.
+	SlowQueries SlowQueryConfig `yaml:"slow_queries"`
+
+	// ValidateResults checks that every series in a response has a value for
+	// each slot of its timerange before it's sent, failing the query otherwise.
+	// It's meant for debugging functions, since it costs a pass over the results.
+	ValidateResults bool `yaml:"validate_results"`
+
+	// LenientFetches leaves out the series which the storage fails to fetch,
+	// noting them, instead of failing the whole query.
+	LenientFetches bool `yaml:"lenient_fetches"`
+
+	// Responses to queries whose timeranges ended at least CacheSettle seconds
+	// ago (0 => 5 minutes) hold settled data, so clients may cache them for
+	// CacheMaxAge seconds. Other responses are marked "no-cache". Zero max age
+	// leaves out the Cache-Control header. With an Authorizer, cacheable
+	// responses are private to the client which requested them.
+	CacheMaxAge int `yaml:"cache_max_age"`
+	CacheSettle int `yaml:"cache_settle"`
+
+	// AdminToken must be presented as a bearer token to the /admin endpoints,
+	// which aren't served without one.
+	AdminToken string `yaml:"admin_token"`
+
+	// Macros can be referred to in queries (as $name(arguments...)), which
+	// expand to their bodies before being parsed.
+	Macros []macro.Macro `yaml:"macros"`
+
+	// PrincipalHeader names the header identifying who made each request (for
+	// example, set by an authenticating proxy), unless the hook identifies
+	// them itself. The principal chooses the request's limits and which
+	// series it may see. The header is trusted as given, so it must only be
+	// used behind a proxy which sets it (replacing any value sent by the
+	// client); otherwise any client can claim to be any principal.
+	PrincipalHeader string `yaml:"principal_header"`
 }
 
 type Hook struct {
 	OnQuery chan<- *inspect.Profiler
+	CORS    CORSConfig // Cross-origin requests are rejected unless allowed here
+
+	// GraphiteConverter translates Graphite metric names. If it's set, then
+	// Graphite's render API is served at /render.
+	GraphiteConverter util.GraphiteConverter
+
+	// Authorizer, if set, hides the series which the request's principal may
+	// not see. Principal identifies who made each request (for example, from a
+	// header set by an authenticating proxy).
+	Authorizer function.Authorizer
+	Principal  func(request *http.Request) string
+
+	// ReloadRules, if set, reloads the Graphite conversion rules when an
+	// admin POSTs to /admin/reload-rules.
+	ReloadRules func() error
+
+	// MetricKinds, if set, records the kind of a metric when an admin POSTs
+	// its name and kind to /admin/metric-kind.
+	MetricKinds metadata.MetricKindUpdateAPI
+
+	// Cardinality, if set, is served to admins at /admin/cardinality, since
+	// it lists every metric regardless of who may see it.
+	Cardinality *cardinality.Reporter
+
+	// Middleware wraps the query handlers, in order from outermost to
+	// innermost. The UI's static files are served without it.
+	Middleware []Middleware
+}
+
+// authorize returns the context in which to execute the request on behalf of
+// its principal: restricted to the series which they may see, and with their
+// limits.
+func (hook Hook) authorize(context command.ExecutionContext, request *http.Request) command.ExecutionContext {
+	if hook.Principal != nil {
+		context.Principal = hook.Principal(request)
+	}
+	if hook.Authorizer != nil {
+		context.Authorizer = hook.Authorizer
+	}
+	return context
+}
+
+// CORSConfig lists the cross-origin requests which are permitted.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"` // "*" allows any origin
+	AllowedMethods []string `yaml:"allowed_methods"` // defaults to GET and POST
+	AllowedHeaders []string `yaml:"allowed_headers"` // defaults to Content-Type
 }
@@ -0,0 +1,90 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsHandler wraps a handler to emit CORS headers for allowed origins, and
+// answers preflight requests itself.
+type corsHandler struct {
+	config  CORSConfig
+	handler http.Handler
+}
+
+// enabled is true if any cross-origin requests are allowed.
+func (config CORSConfig) enabled() bool {
+	return len(config.AllowedOrigins) > 0
+}
+
+// wildcard is true if requests are allowed from any origin.
+func (config CORSConfig) wildcard() bool {
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (config CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (config CORSConfig) methods() string {
+	if len(config.AllowedMethods) == 0 {
+		return "GET, POST"
+	}
+	return strings.Join(config.AllowedMethods, ", ")
+}
+
+func (config CORSConfig) headers() string {
+	if len(config.AllowedHeaders) == 0 {
+		return "Content-Type"
+	}
+	return strings.Join(config.AllowedHeaders, ", ")
+}
+
+func (h corsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	if origin == "" || !h.config.allowsOrigin(origin) {
+		// Not a cross-origin request (or not an allowed one); the browser will
+		// refuse to expose the response without the headers.
+		h.handler.ServeHTTP(writer, request)
+		return
+	}
+	header := writer.Header()
+	if h.config.wildcard() {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+	if request.Method == "OPTIONS" && request.Header.Get("Access-Control-Request-Method") != "" {
+		// A preflight request.
+		header.Set("Access-Control-Allow-Methods", h.config.methods())
+		header.Set("Access-Control-Allow-Headers", h.config.headers())
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.handler.ServeHTTP(writer, request)
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func TestCORSHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("ok"))
+	})
+	tests := []struct {
+		name    string
+		config  CORSConfig
+		method  string
+		origin  string
+		headers map[string]string // expected response headers
+		status  int
+		body    string
+	}{
+		{
+			name:    "same-origin request",
+			config:  CORSConfig{AllowedOrigins: []string{"http://grafana.example.com"}},
+			method:  "GET",
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+			status:  http.StatusOK,
+			body:    "ok",
+		},
+		{
+			name:    "allowed origin",
+			config:  CORSConfig{AllowedOrigins: []string{"http://grafana.example.com"}},
+			method:  "GET",
+			origin:  "http://grafana.example.com",
+			headers: map[string]string{"Access-Control-Allow-Origin": "http://grafana.example.com", "Vary": "Origin"},
+			status:  http.StatusOK,
+			body:    "ok",
+		},
+		{
+			name:    "disallowed origin",
+			config:  CORSConfig{AllowedOrigins: []string{"http://grafana.example.com"}},
+			method:  "GET",
+			origin:  "http://evil.example.com",
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+			status:  http.StatusOK,
+			body:    "ok",
+		},
+		{
+			name:    "wildcard",
+			config:  CORSConfig{AllowedOrigins: []string{"*"}},
+			method:  "POST",
+			origin:  "http://anything.example.com",
+			headers: map[string]string{"Access-Control-Allow-Origin": "*", "Vary": ""},
+			status:  http.StatusOK,
+			body:    "ok",
+		},
+		{
+			name:   "preflight with defaults",
+			config: CORSConfig{AllowedOrigins: []string{"http://grafana.example.com"}},
+			method: "OPTIONS",
+			origin: "http://grafana.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "http://grafana.example.com",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type",
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "preflight with configured methods and headers",
+			config: CORSConfig{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET"},
+				AllowedHeaders: []string{"Content-Type", "Authorization"},
+			},
+			method: "OPTIONS",
+			origin: "http://grafana.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization",
+			},
+			status: http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.name)
+		request, err := http.NewRequest(test.method, "/query", nil)
+		if err != nil {
+			t.Fatalf("Unexpected error creating request: %s", err.Error())
+		}
+		if test.origin != "" {
+			request.Header.Set("Origin", test.origin)
+		}
+		if test.method == "OPTIONS" {
+			request.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		recorder := httptest.NewRecorder()
+		corsHandler{config: test.config, handler: ok}.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		a.EqString(recorder.Body.String(), test.body)
+		for header, expected := range test.headers {
+			a.Contextf("header %s", header).EqString(recorder.Header().Get(header), expected)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/registry"
+	"github.com/square/metrics/query/command"
+)
+
+// functionsHandler describes every registered function, for the editor's
+// autocompletion and inline documentation.
+type functionsHandler struct {
+	context command.ExecutionContext
+}
+
+// FunctionInfo describes a single function. Only the name is known for
+// functions which weren't made by function.MakeFunction.
+type FunctionInfo struct {
+	Name          string   `json:"name"`
+	MinArguments  int      `json:"min_arguments"`
+	MaxArguments  int      `json:"max_arguments"` // -1 for unlimited
+	AllowsGroupBy bool     `json:"allows_group_by"`
+	ArgumentTypes []string `json:"argument_types,omitempty"`
+	ReturnType    string   `json:"return_type,omitempty"`
+	Description   string   `json:"description,omitempty"`
+}
+
+// describe lists the registered functions, in order of name.
+func (h functionsHandler) describe() []FunctionInfo {
+	functions := h.context.Registry
+	if functions == nil {
+		functions = registry.Default()
+	}
+	infos := []FunctionInfo{}
+	for _, name := range functions.All() {
+		info := FunctionInfo{Name: name, MaxArguments: -1}
+		if fun, ok := functions.GetFunction(name); ok {
+			if metricFunction, ok := fun.(function.MetricFunction); ok {
+				info.MinArguments = metricFunction.MinArguments
+				info.MaxArguments = metricFunction.MaxArguments
+				info.AllowsGroupBy = metricFunction.AllowsGroupBy
+				info.ArgumentTypes = metricFunction.ArgumentTypes
+				info.ReturnType = metricFunction.ReturnType
+				info.Description = metricFunction.Description()
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (h functionsHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	encoded, err := json.Marshal(Response{
+		Success: true,
+		QueryResponse: QueryResponse{
+			Body: h.describe(),
+		},
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	writer.Write(encoded)
+}
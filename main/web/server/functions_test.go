@@ -0,0 +1,55 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/square/metrics/function/registry"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func TestFunctionsHandler(t *testing.T) {
+	a := assert.New(t)
+	handler := functionsHandler{context: command.ExecutionContext{
+		Registry: registry.Default(),
+	}}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/functions", nil))
+	a.EqInt(recorder.Code, http.StatusOK)
+	var response struct {
+		Body []FunctionInfo `json:"body"`
+	}
+	a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	a.EqInt(len(response.Body), len(registry.Default().All()))
+
+	infos := map[string]FunctionInfo{}
+	for _, info := range response.Body {
+		infos[info.Name] = info
+	}
+	a.Eq(infos["transform.moving_average"], FunctionInfo{
+		Name:          "transform.moving_average",
+		MinArguments:  2,
+		MaxArguments:  2,
+		ArgumentTypes: []string{"value", "duration"},
+		ReturnType:    "series list",
+		Description:   "takes a value and a duration; returns a series list",
+	})
+	a.Eq(infos["aggregate.sum"].AllowsGroupBy, true)
+}
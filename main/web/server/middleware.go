@@ -0,0 +1,45 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+)
+
+// Middleware wraps a handler to add behaviour around it (authentication,
+// rate limiting, tracing, compression, ...).
+type Middleware func(http.Handler) http.Handler
+
+// Chain combines the middleware into one. The first middleware is the
+// outermost: it sees each request first and each response last.
+func Chain(middleware ...Middleware) Middleware {
+	return func(handler http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			handler = middleware[i](handler)
+		}
+		return handler
+	}
+}
+
+// wrap applies the middleware requested by the hook to the handler. CORS is
+// outermost so that preflight requests are answered before any other
+// middleware runs.
+func (hook Hook) wrap(handler http.Handler) http.Handler {
+	handler = Chain(hook.Middleware...)(handler)
+	if hook.CORS.enabled() {
+		handler = corsHandler{config: hook.CORS, handler: handler}
+	}
+	return handler
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/square/metrics/testing_support/assert"
+)
+
+// recordingMiddleware appends its name to the trace on the way in and on the
+// way out of the handler.
+func recordingMiddleware(name string, trace *[]string) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			*trace = append(*trace, "enter "+name)
+			handler.ServeHTTP(writer, request)
+			*trace = append(*trace, "exit "+name)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	a := assert.New(t)
+	trace := []string{}
+	handler := Chain(
+		recordingMiddleware("a", &trace),
+		recordingMiddleware("b", &trace),
+		recordingMiddleware("c", &trace),
+	)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		trace = append(trace, "handler")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/query", nil))
+	a.Eq(trace, []string{"enter a", "enter b", "enter c", "handler", "exit c", "exit b", "exit a"})
+}
+
+func TestChainEmpty(t *testing.T) {
+	a := assert.New(t)
+	called := false
+	handler := Chain()(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		called = true
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/query", nil))
+	a.EqBool(called, true)
+}
+
+func TestHookWrapCORSOutermost(t *testing.T) {
+	a := assert.New(t)
+	reached := false
+	hook := Hook{
+		CORS: CORSConfig{AllowedOrigins: []string{"http://grafana.example.com"}},
+		Middleware: []Middleware{
+			func(handler http.Handler) http.Handler {
+				return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+					reached = true
+					writer.WriteHeader(http.StatusUnauthorized)
+				})
+			},
+		},
+	}
+	request := httptest.NewRequest("OPTIONS", "/query", nil)
+	request.Header.Set("Origin", "http://grafana.example.com")
+	request.Header.Set("Access-Control-Request-Method", "POST")
+	recorder := httptest.NewRecorder()
+	hook.wrap(http.NotFoundHandler()).ServeHTTP(recorder, request)
+	a.EqBool(reached, false)
+	a.EqString(recorder.Header().Get("Access-Control-Allow-Origin"), "http://grafana.example.com")
+}
@@ -20,7 +20,10 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
 	"github.com/square/metrics/inspect"
 	"github.com/square/metrics/log"
 	"github.com/square/metrics/query/command"
@@ -31,6 +34,7 @@ import (
 type Response struct {
 	Success bool   `json:"success"`
 	Message string `json:"message,omitempty"`
+	Code    string `json:"code,omitempty"` // the class of the error, e.g. "backend_error"
 	QueryResponse
 	Profile []inspect.Profile `json:"profile,omitempty"`
 }
@@ -39,11 +43,23 @@ type QueryResponse struct {
 	Name     string                 `json:"name,omitempty"`
 	Body     interface{}            `json:"body,omitempty"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	end time.Time // the end of the query's timerange, deciding whether it's cached
 }
 
 type queryHandler struct {
 	hook    Hook
 	context command.ExecutionContext
+	config  Config
+	now     func() time.Time // optional (nil => time.Now), for deciding whether responses are cached
+}
+
+// currentTime returns the handler's idea of the current time.
+func (q queryHandler) currentTime() time.Time {
+	if q.now == nil {
+		return time.Now()
+	}
+	return q.now()
 }
 
 type KeyIs struct {
@@ -171,24 +187,28 @@ func predicateFromConstraint(c Constraint) (predicate.Predicate, error) {
 }
 
 type QueryForm struct {
-	Input       string      `query:"query" json:"query"`     // query to execute.
-	Profile     bool        `query:"profile" json:"profile"` // if true, then profile information will be exposed to the user.
-	Constraints *Constraint `query:"-" json:"where"`
+	Input        string      `query:"query" json:"query"`     // query to execute.
+	Profile      bool        `query:"profile" json:"profile"` // if true, then profile information will be exposed to the user.
+	Constraints  *Constraint `query:"-" json:"where"`
+	Timezone     string      `query:"timezone" json:"timezone"`           // if set, overrides the server's default timezone for wall-clock functions.
+	Provenance   bool        `query:"provenance" json:"provenance"`       // if true, each series includes the fetches it was computed from.
+	EmptyResults string      `query:"empty_results" json:"empty_results"` // if set, overrides the server's policy for fetches matching no series.
 }
 
-func (q queryHandler) process(profiler *inspect.Profiler, parsedForm QueryForm) (QueryResponse, error) {
+func (q queryHandler) process(profiler *inspect.Profiler, parsedForm QueryForm, context command.ExecutionContext) (QueryResponse, error) {
 	log.Infof("INPUT: %+v\n", parsedForm)
+	input, err := context.Macros.Expand(parsedForm.Input)
+	if err != nil {
+		return QueryResponse{}, err
+	}
 	var rawCommand command.Command
-	var err error
 	profiler.Do("Parsing Query", func() {
-		rawCommand, err = parser.Parse(parsedForm.Input)
+		rawCommand, err = parser.Parse(input)
 	})
 	if err != nil {
 		return QueryResponse{}, err
 	}
 
-	context := q.context
-
 	if parsedForm.Constraints != nil {
 		predicate, err := predicateFromConstraint(*parsedForm.Constraints)
 		if err != nil {
@@ -197,6 +217,37 @@ func (q queryHandler) process(profiler *inspect.Profiler, parsedForm QueryForm)
 		context.AdditionalConstraints = predicate // Attach the predicate to the context.
 	}
 
+	context.Provenance = parsedForm.Provenance
+
+	if parsedForm.Timezone != "" {
+		location, err := time.LoadLocation(parsedForm.Timezone)
+		if err != nil {
+			return QueryResponse{}, fmt.Errorf("invalid timezone %q: %s", parsedForm.Timezone, err.Error())
+		}
+		context.Location = location
+	}
+
+	if parsedForm.EmptyResults != "" {
+		policy, err := function.ParseEmptyResultPolicy(parsedForm.EmptyResults)
+		if err != nil {
+			return QueryResponse{}, err
+		}
+		context.EmptyResults = policy
+	}
+
+	slowQueries := q.config.SlowQueries
+	if slowQueries.enabled() {
+		// The slow-query log needs the timing and fetch count even when the
+		// client didn't ask for them.
+		if profiler == nil {
+			profiler = inspect.New()
+		}
+		if context.FetchCounter == nil {
+			counter := function.NewFetchCounter(context.ResolveLimits().FetchLimit)
+			context.FetchCounter = &counter
+		}
+	}
+
 	profiledCommand := command.NewProfilingCommandWithProfiler(rawCommand, profiler)
 
 	result := command.Result{}
@@ -204,17 +255,109 @@ func (q queryHandler) process(profiler *inspect.Profiler, parsedForm QueryForm)
 	profiler.Do("Total Execution", func() {
 		result, err = profiledCommand.Execute(context)
 	})
+	if slowQueries.enabled() {
+		slowQueries.logSlowQuery(parsedForm.Input, rawCommand, queryStats{
+			Duration: totalExecution(profiler),
+			Fetches:  context.FetchCounter.Current(),
+			Series:   countSeries(result.Body),
+		})
+	}
 	if err != nil {
 		return QueryResponse{}, err
 	}
 
+	if limit := q.config.MaxResponseSeries; limit > 0 {
+		if count := countSeries(result.Body); count > limit {
+			return QueryResponse{}, responseLimitError{count: count, limit: limit, unit: "series"}
+		}
+	}
+
+	if q.config.ValidateResults {
+		if err := validateResults(result.Body); err != nil {
+			return QueryResponse{}, err
+		}
+	}
+
 	return QueryResponse{
 		Body:     result.Body,
 		Metadata: result.Metadata,
 		Name:     profiledCommand.Name(),
+		end:      timerangeEnd(rawCommand),
 	}, nil
 }
 
+// countSeries counts the series and scalars in the results of a select.
+// Other commands' results aren't counted.
+func countSeries(body interface{}) int {
+	results, ok := body.([]command.QueryResult)
+	if !ok {
+		return 0
+	}
+	count := 0
+	for _, result := range results {
+		count += len(result.Series) + len(result.Scalars)
+	}
+	return count
+}
+
+// validateResults checks that the series in the results of a select each have a
+// value for every slot of their timerange, so that a buggy function fails
+// loudly instead of sending malformed data to clients.
+func validateResults(body interface{}) error {
+	results, ok := body.([]command.QueryResult)
+	if !ok {
+		return nil
+	}
+	for _, result := range results {
+		if result.Type != "series" {
+			continue
+		}
+		if err := (api.SeriesList{Series: result.Series}).Validate(result.Timerange); err != nil {
+			return invalidResultError{query: result.Query, err: err}
+		}
+	}
+	return nil
+}
+
+// invalidResultError is returned for queries whose results are malformed.
+// Since that's a bug in the server, rather than the client's fault, it's
+// reported as a 500.
+type invalidResultError struct {
+	query string
+	err   error
+}
+
+func (err invalidResultError) Error() string {
+	return fmt.Sprintf("internal error: the result of %s is malformed: %s", err.query, err.err.Error())
+}
+
+func (err invalidResultError) ErrorCode() int {
+	return http.StatusInternalServerError
+}
+
+// responseLimitError is returned for queries whose responses are larger than
+// the configured limits allow. It's a function.LimitError, so clients see the
+// limit_exceeded code.
+type responseLimitError struct {
+	count int
+	limit int
+	unit  string
+}
+
+func (err responseLimitError) Error() string {
+	return fmt.Sprintf("the response would contain %d %s, which exceeds the limit of %d; narrow the query with a where clause or aggregate its results", err.count, err.unit, err.limit)
+}
+
+// Actual returns the size of the response.
+func (err responseLimitError) Actual() interface{} {
+	return err.count
+}
+
+// Limit returns the configured limit.
+func (err responseLimitError) Limit() interface{} {
+	return err.limit
+}
+
 // HTTPError indicates that an error should override the return code.
 type HTTPError interface {
 	error
@@ -223,7 +366,6 @@ type HTTPError interface {
 
 func (q queryHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Type", "application/json")
-	profiler := inspect.New()
 
 	queryForm := QueryForm{}
 
@@ -241,18 +383,23 @@ func (q queryHandler) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 		}
 		parseStruct(request.Form, &queryForm)
 	}
+	if profile, _ := strconv.ParseBool(request.URL.Query().Get("profile")); profile {
+		queryForm.Profile = true // ?profile=1 also applies to JSON requests
+	}
+
+	// Queries are only profiled on request (or when the hook wants every profile),
+	// since a nil profiler costs nothing.
+	var profiler *inspect.Profiler
+	if queryForm.Profile || q.hook.OnQuery != nil {
+		profiler = inspect.New()
+	}
 
 	// "process" does the hard work for the handler, but doesn't touch the HTTP details.
-	responseMessage, err := q.process(profiler, queryForm)
+	responseMessage, err := q.process(profiler, queryForm, q.hook.authorize(q.context, request))
 	if err != nil {
-		if errHTTP, ok := err.(HTTPError); ok {
-			// If an HTTPError is returned, then we use its reported code instead of
-			// StatusBadRequest. This can be used to identify errors as 500s instead
-			// of always blaming the client.
-			writer.WriteHeader(errHTTP.ErrorCode())
-		} else {
-			writer.WriteHeader(http.StatusBadRequest)
-		}
+		// Backend failures and timeouts aren't the client's fault, so they're
+		// reported as 502s and 504s rather than always blaming the client.
+		writer.WriteHeader(errorStatus(err))
 		writer.Write(encodeError(err))
 		return
 	}
@@ -262,7 +409,7 @@ func (q queryHandler) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 		QueryResponse: responseMessage,
 	}
 
-	if showProfile, _ := strconv.ParseBool(request.Form.Get("profile")); showProfile {
+	if queryForm.Profile {
 		responseJSON.Profile = profiler.All()
 	}
 
@@ -286,6 +433,12 @@ func (q queryHandler) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 		writer.Write(encodeError(err))
 		return
 	}
+	if limit := q.config.MaxResponseBytes; limit > 0 && len(encoded) > limit {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(responseLimitError{count: len(encoded), limit: limit, unit: "bytes"}))
+		return
+	}
 
+	q.setCacheHeaders(writer, responseMessage.end)
 	writer.Write(encoded)
 }
@@ -15,11 +15,26 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/registry"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/macro"
 	"github.com/square/metrics/query/predicate"
 	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
 )
 
 func TestPredicateFromConstraint(t *testing.T) {
@@ -202,3 +217,471 @@ func TestPredicateFromConstraint(t *testing.T) {
 		a.Contextf("test %d", i).Eq(result, test.result)
 	}
 }
+
+func TestQueryHandlerResponseLimits(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "host": "c"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	tests := []struct {
+		query   string
+		config  Config
+		status  int
+		message string
+	}{
+		{query: "select cpu from 0 to 120 resolution 30ms", status: http.StatusOK},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{MaxResponseSeries: 3}, status: http.StatusOK},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{MaxResponseSeries: 2}, status: http.StatusBadRequest, message: "3 series, which exceeds the limit of 2"},
+		{query: "select aggregate.sum(cpu) from 0 to 120 resolution 30ms", config: Config{MaxResponseSeries: 2}, status: http.StatusOK},
+		{query: "select cpu, cpu from 0 to 120 resolution 30ms", config: Config{MaxResponseSeries: 5}, status: http.StatusBadRequest, message: "6 series"},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{MaxResponseBytes: 100000}, status: http.StatusOK},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: Config{MaxResponseBytes: 100}, status: http.StatusBadRequest, message: "exceeds the limit of 100"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s with %+v", test.query, test.config)
+		handler := queryHandler{context: executionContext, config: test.config}
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/query?query="+url.QueryEscape(test.query), nil)
+		a.CheckError(err)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.message != "" && !strings.Contains(recorder.Body.String(), test.message) {
+			a.Errorf("expected the response to mention %q but got %s", test.message, recorder.Body.String())
+		}
+		if test.status != http.StatusOK && !strings.Contains(recorder.Body.String(), `"limit_exceeded"`) {
+			a.Errorf("expected the response to have the limit_exceeded code but got %s", recorder.Body.String())
+		}
+	}
+}
+
+func TestQueryHandlerProfile(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	handler := queryHandler{context: command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}}
+	query := "select aggregate.sum(cpu) from 0 to 120 resolution 30ms"
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		profile bool
+	}{
+		{
+			name: "form without profile",
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "/query?query="+url.QueryEscape(query), nil)
+			},
+		},
+		{
+			name: "form with profile",
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "/query?profile=1&query="+url.QueryEscape(query), nil)
+			},
+			profile: true,
+		},
+		{
+			name: "JSON with profile",
+			request: func() *http.Request {
+				request := httptest.NewRequest("POST", "/query", strings.NewReader(`{"query": "`+query+`", "profile": true}`))
+				request.Header.Set("Content-Type", "application/json")
+				return request
+			},
+			profile: true,
+		},
+		{
+			name: "JSON with profile parameter",
+			request: func() *http.Request {
+				request := httptest.NewRequest("POST", "/query?profile=true", strings.NewReader(`{"query": "`+query+`"}`))
+				request.Header.Set("Content-Type", "application/json")
+				return request
+			},
+			profile: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.name)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, test.request())
+		a.EqInt(recorder.Code, http.StatusOK)
+		var response struct {
+			Profile []struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+			} `json:"profile"`
+			Metadata map[string]interface{} `json:"metadata"`
+		}
+		a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		if !test.profile {
+			a.EqInt(len(response.Profile), 0)
+			if _, ok := response.Metadata["profile"]; ok {
+				a.Errorf("Expected no profile in the metadata")
+			}
+			continue
+		}
+		evaluated := false
+		for _, profile := range response.Profile {
+			if profile.Name == "aggregate.sum.Evaluate" && profile.Description == "aggregate.sum(cpu)" {
+				evaluated = true
+			}
+		}
+		a.EqBool(evaluated, true)
+	}
+}
+
+func TestQueryHandlerTimezone(t *testing.T) {
+	start := int64(1456790400000) // 2016-03-01 00:00 UTC
+	timerange, err := api.NewSnappedTimerange(start, start+23*3600000, 3600000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	values := make([]float64, 24)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: values, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	if _, err := NewMux(Config{Timezone: "Mars/Olympus_Mons"}, executionContext, Hook{}); err == nil {
+		t.Errorf("Expected an error for an unknown server timezone")
+	}
+	mux, err := NewMux(Config{Timezone: "America/Los_Angeles"}, executionContext, Hook{})
+	if err != nil {
+		t.Fatalf("Error creating mux: %s", err.Error())
+	}
+	between := fmt.Sprintf("from %d to %d resolution 1h", start, start+23*3600000)
+	// first is the first hour (UTC) kept by the slice.
+	tests := []struct {
+		query  string
+		zone   string
+		status int
+		first  int
+	}{
+		// The server's default timezone is Los Angeles, 8 hours behind UTC, so
+		// the slice keeps hours 0 and 17 to 23.
+		{query: "select transform.time_slice(cpu, '09:00', '17:00') " + between, status: http.StatusOK, first: 0},
+		// The query's timezone overrides the server's.
+		{query: "select transform.time_slice(cpu, '09:00', '17:00') " + between, zone: "UTC", status: http.StatusOK, first: 9},
+		// The function's own argument overrides both.
+		{query: "select transform.time_slice(cpu, '09:00', '17:00', 'America/Los_Angeles') " + between, zone: "UTC", status: http.StatusOK, first: 0},
+		{query: "select transform.time_slice(cpu, '09:00', '17:00') " + between, zone: "Mars/Olympus_Mons", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s in %q", test.query, test.zone)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/query?query="+url.QueryEscape(test.query)+"&timezone="+url.QueryEscape(test.zone), nil)
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.status != http.StatusOK {
+			continue
+		}
+		var response struct {
+			Body []struct {
+				Series []struct {
+					Values []*float64 `json:"values"`
+				} `json:"series"`
+			} `json:"body"`
+		}
+		a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		if len(response.Body) != 1 || len(response.Body[0].Series) != 1 {
+			a.Errorf("Expected a single series but got %s", recorder.Body.String())
+			continue
+		}
+		first := -1
+		for i, value := range response.Body[0].Series[0].Values {
+			if value != nil {
+				first = i
+				break
+			}
+		}
+		a.EqInt(first, test.first)
+	}
+}
+
+// failingStorage is a storage API whose fetches always fail with its error.
+type failingStorage struct {
+	mocks.FakeComboAPI
+	err error
+}
+
+func (f failingStorage) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	return api.SeriesList{}, f.err
+}
+
+func TestQueryHandlerErrorCodes(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+	)
+	tests := []struct {
+		query      string
+		err        error
+		fetchLimit int
+		status     int
+		code       string
+	}{
+		{query: "select cpu from 0 to 120 resolution 30ms", status: http.StatusOK},
+		{query: "select cpu from 0 to 120 resolution 30ms where", status: http.StatusBadRequest, code: "user_error"},
+		{query: "select transform.moving_average(cpu) from 0 to 120 resolution 30ms", status: http.StatusBadRequest, code: "user_error"},
+		{query: "select cpu from 0 to 120 resolution 30ms", err: fmt.Errorf("connection refused"), status: http.StatusBadGateway, code: "backend_error"},
+		{query: "select cpu from 0 to 120 resolution 30ms", err: timeseries.Error{Code: timeseries.FetchTimeoutError}, status: http.StatusGatewayTimeout, code: "timeout"},
+		{query: "select cpu from 0 to 120 resolution 30ms", err: timeseries.Error{Code: timeseries.LimitError}, status: http.StatusBadRequest, code: "limit_exceeded"},
+		{query: "select cpu from 0 to 120 resolution 30ms", fetchLimit: 1, status: http.StatusBadRequest, code: "limit_exceeded"},
+		// An HTTPError's own status code takes precedence.
+		{query: "select cpu from 0 to 120 resolution 30ms", err: timeseries.FetchError{Message: "unavailable", Code: http.StatusServiceUnavailable}, status: http.StatusServiceUnavailable, code: "backend_error"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s with %+v", test.query, test.err)
+		executionContext := command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		}
+		if test.fetchLimit != 0 {
+			executionContext.FetchLimit = test.fetchLimit
+		}
+		if test.err != nil {
+			executionContext.TimeseriesStorageAPI = failingStorage{FakeComboAPI: comboAPI, err: test.err}
+		}
+		handler := queryHandler{context: executionContext}
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/query?query="+url.QueryEscape(test.query), nil)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		var response Response
+		a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		a.EqString(response.Code, test.code)
+	}
+}
+
+func TestQueryHandlerEmptyResults(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	if _, err := NewMux(Config{EmptyResults: "sometimes"}, executionContext, Hook{}); err == nil {
+		t.Errorf("Expected an error for an unknown empty result policy")
+	}
+	mux, err := NewMux(Config{EmptyResults: "error"}, executionContext, Hook{})
+	if err != nil {
+		t.Fatalf("Error creating mux: %s", err.Error())
+	}
+	query := `select cpu[host = "b"] from 0 to 120 resolution 30ms`
+	tests := []struct {
+		policy string
+		status int
+	}{
+		{policy: "", status: http.StatusBadRequest}, // the server's policy
+		{policy: "note", status: http.StatusOK},
+		{policy: "empty", status: http.StatusOK},
+		{policy: "sometimes", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("policy %q", test.policy)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/query?query="+url.QueryEscape(query)+"&empty_results="+test.policy, nil)
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+	}
+}
+
+// truncatingRegistry adds a function which (wrongly) drops each series' last value.
+type truncatingRegistry struct {
+	function.Registry
+}
+
+func (r truncatingRegistry) GetFunction(name string) (function.Function, bool) {
+	if name == "test.truncate" {
+		return function.MakeFunction("test.truncate", func(list api.SeriesList) api.SeriesList {
+			result := api.SeriesList{Series: make([]api.Timeseries, len(list.Series))}
+			for i, series := range list.Series {
+				result.Series[i] = api.Timeseries{Values: series.Values[:len(series.Values)-1], TagSet: series.TagSet}
+			}
+			return result
+		}), true
+	}
+	return r.Registry.GetFunction(name)
+}
+
+func TestQueryHandlerValidateResults(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Registry:             truncatingRegistry{registry.Default()},
+		Ctx:                  context.Background(),
+	}
+	tests := []struct {
+		query    string
+		validate bool
+		status   int
+	}{
+		{query: "select cpu from 0 to 120 resolution 30ms", validate: true, status: http.StatusOK},
+		{query: "select test.truncate(cpu) from 0 to 120 resolution 30ms", validate: false, status: http.StatusOK},
+		{query: "select test.truncate(cpu) from 0 to 120 resolution 30ms", validate: true, status: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s validating %t", test.query, test.validate)
+		handler := queryHandler{context: executionContext, config: Config{ValidateResults: test.validate}}
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/query?query="+url.QueryEscape(test.query), nil)
+		a.CheckError(err)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.status != http.StatusOK && !strings.Contains(recorder.Body.String(), "has 4 values") {
+			a.Errorf("expected the response to describe the malformed series but got %s", recorder.Body.String())
+		}
+	}
+}
+
+func TestQueryHandlerMacros(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	badMacros := []macro.Macro{{Name: "total", Body: "$missing"}}
+	if _, err := NewMux(Config{Macros: badMacros}, executionContext, Hook{}); err == nil {
+		t.Errorf("Expected an error for an invalid macro")
+	}
+	macros := []macro.Macro{{Name: "total", Parameters: []string{"metric"}, Body: "aggregate.sum($metric)"}}
+	mux, err := NewMux(Config{Macros: macros}, executionContext, Hook{})
+	if err != nil {
+		t.Fatalf("Error creating mux: %s", err.Error())
+	}
+	tests := []struct {
+		query    string
+		status   int
+		expected []float64
+	}{
+		{query: `select $total(cpu) * 2 from 0 to 120 resolution 30ms`, status: http.StatusOK, expected: []float64{6, 8, 10, 12, 14}},
+		{query: `select $total(cpu[host = 'a']) from 0 to 120 resolution 30ms`, status: http.StatusOK, expected: []float64{1, 2, 3, 4, 5}},
+		{query: `select $unknown(cpu) from 0 to 120 resolution 30ms`, status: http.StatusBadRequest},
+		{query: `select $total(cpu) from 0 to 120 resolution 30ms`[:20], status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/query?query="+url.QueryEscape(test.query), nil)
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.status != http.StatusOK {
+			continue
+		}
+		var response struct {
+			Body []struct {
+				Series []api.Timeseries `json:"series"`
+			} `json:"body"`
+		}
+		a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		if len(response.Body) == 1 && len(response.Body[0].Series) == 1 {
+			a.EqFloatArray(response.Body[0].Series[0].Values, test.expected, 0)
+		} else {
+			a.Errorf("Expected a single series but got %s", recorder.Body.String())
+		}
+	}
+}
+
+func TestQueryHandlerTenantLimits(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		TenantLimits:         command.StaticLimits(map[string]command.Limits{"dashboards": {FetchLimit: 1}}, command.Limits{}),
+		Ctx:                  context.Background(),
+	}
+	// No Authorizer is configured; the principal still chooses the limits.
+	mux, err := NewMux(Config{PrincipalHeader: "X-Principal"}, executionContext, Hook{})
+	if err != nil {
+		t.Fatalf("Error creating mux: %s", err.Error())
+	}
+	tests := []struct {
+		principal string
+		status    int
+	}{
+		{principal: "", status: http.StatusOK},
+		{principal: "analysts", status: http.StatusOK},
+		{principal: "dashboards", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("principal %q", test.principal)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/query?query="+url.QueryEscape("select cpu from 0 to 120 resolution 30ms"), nil)
+		request.Header.Set("X-Principal", test.principal)
+		mux.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.status != http.StatusOK && !strings.Contains(recorder.Body.String(), "exceeds the specified limit 1") {
+			a.Errorf("Expected the response to mention the fetch limit but got %s", recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/expression"
+	"github.com/square/metrics/query/predicate"
+	"github.com/square/metrics/timeseries"
+	"github.com/square/metrics/util"
+)
+
+// renderHandler serves a subset of Graphite's render API, so that tools which
+// speak Graphite can read from MQE. Each target must be a plain Graphite
+// metric name (no wildcards or functions) which the converter can translate.
+type renderHandler struct {
+	hook      Hook
+	config    Config // for the response limits
+	context   command.ExecutionContext
+	converter util.GraphiteConverter
+	clock     util.Clock
+}
+
+// RenderTarget is one series in Graphite's render JSON format.
+type RenderTarget struct {
+	Target     string        `json:"target"`
+	Datapoints [][2]*float64 `json:"datapoints"` // each point is [value, unix seconds]; missing values are null
+}
+
+// graphiteUnits maps the units of Graphite's relative times to their durations.
+// Graphite accepts any unit which begins with one of these prefixes (e.g. "hours").
+var graphiteUnits = []struct {
+	prefix   string
+	duration time.Duration
+}{
+	{"s", time.Second},
+	{"min", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"mon", 30 * 24 * time.Hour},
+	{"y", 365 * 24 * time.Hour},
+}
+
+// parseGraphiteTime parses the forms of Graphite's from/until parameters that
+// are commonly used: "now", relative offsets such as "-1h" or "now-30min", and
+// unix timestamps in seconds.
+func parseGraphiteTime(value string, now time.Time) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	offset := strings.TrimPrefix(value, "now")
+	if offset == "" {
+		return now, nil
+	}
+	sign := time.Duration(1)
+	switch offset[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return time.Time{}, fmt.Errorf("unsupported Graphite time %q", value)
+	}
+	digits := 1
+	for digits < len(offset) && '0' <= offset[digits] && offset[digits] <= '9' {
+		digits++
+	}
+	count, err := strconv.ParseInt(offset[1:digits], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unsupported Graphite time %q", value)
+	}
+	unit := offset[digits:]
+	// As in Graphite, a bare "m" is rejected, since it could mean minutes or months.
+	for _, candidate := range graphiteUnits {
+		if strings.HasPrefix(unit, candidate.prefix) {
+			return now.Add(sign * time.Duration(count) * candidate.duration), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported unit %q in Graphite time %q", unit, value)
+}
+
+// renderCommand translates the Graphite targets into a select command.
+func (h renderHandler) renderCommand(targets []string, from time.Time, until time.Time) (*command.SelectCommand, error) {
+	expressions := make([]function.Expression, len(targets))
+	for i, target := range targets {
+		metric, err := h.converter.ToTaggedName(util.GraphiteMetric(target))
+		if err != nil {
+			return nil, fmt.Errorf("cannot translate Graphite target %q: %s", target, err.Error())
+		}
+		matchers := []predicate.Predicate{}
+		for tag, value := range metric.TagSet {
+			matchers = append(matchers, predicate.ListMatcher{Tag: tag, Values: []string{value}})
+		}
+		expressions[i] = function.Memoize(&expression.MetricFetchExpression{
+			MetricName: string(metric.MetricKey),
+			Predicate:  predicate.All(matchers...),
+		})
+	}
+	return &command.SelectCommand{
+		Expressions: expressions,
+		Context: command.SelectContext{
+			Start:        from.UnixNano() / int64(time.Millisecond),
+			End:          until.UnixNano() / int64(time.Millisecond),
+			Resolution:   30000, // the storage API coarsens this as needed for long ranges
+			SampleMethod: timeseries.SampleMean,
+		},
+	}, nil
+}
+
+func (h renderHandler) process(form renderForm, context command.ExecutionContext) ([]RenderTarget, error) {
+	if len(form.targets) == 0 {
+		return nil, fmt.Errorf("at least one target must be given")
+	}
+	if form.format != "" && form.format != "json" {
+		return nil, fmt.Errorf("only the json format is supported, not %q", form.format)
+	}
+	now := h.clock.Now()
+	from, err := parseGraphiteTime(form.from, now)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseGraphiteTime(form.until, now)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := h.renderCommand(form.targets, from, until)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cmd.Execute(context)
+	if err != nil {
+		return nil, err
+	}
+	if limit := h.config.MaxResponseSeries; limit > 0 {
+		if count := countSeries(result.Body); count > limit {
+			return nil, responseLimitError{count: count, limit: limit, unit: "series"}
+		}
+	}
+	targets := []RenderTarget{}
+	for i, queryResult := range result.Body.([]command.QueryResult) {
+		for _, series := range queryResult.Series {
+			targets = append(targets, h.renderSeries(form.targets[i], series, queryResult.Timerange))
+		}
+	}
+	return targets, nil
+}
+
+// renderSeries converts the series into Graphite's format, naming it with its
+// Graphite metric name where the converter can produce one.
+func (h renderHandler) renderSeries(target string, series api.Timeseries, timerange api.Timerange) RenderTarget {
+	metric, err := h.converter.ToTaggedName(util.GraphiteMetric(target))
+	if err == nil {
+		metric.TagSet = series.TagSet
+		if name, err := h.converter.ToGraphiteName(metric); err == nil {
+			target = string(name)
+		}
+	}
+	points := make([][2]*float64, len(series.Values))
+	for i, value := range series.Values {
+		timestamp := float64(timerange.TimeOfIndex(i).Unix())
+		points[i][1] = &timestamp
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			value := value
+			points[i][0] = &value
+		}
+	}
+	return RenderTarget{Target: target, Datapoints: points}
+}
+
+// renderForm holds the Graphite request parameters. Graphite's defaults are
+// used for missing times.
+type renderForm struct {
+	targets []string
+	from    string
+	until   string
+	format  string
+}
+
+func (h renderHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	if err := request.ParseForm(); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(err))
+		return
+	}
+	form := renderForm{
+		targets: request.Form["target"],
+		from:    request.Form.Get("from"),
+		until:   request.Form.Get("until"),
+		format:  request.Form.Get("format"),
+	}
+	if form.from == "" {
+		form.from = "-24h"
+	}
+	if form.until == "" {
+		form.until = "now"
+	}
+	targets, err := h.process(form, h.hook.authorize(h.context, request))
+	if err != nil {
+		writer.WriteHeader(errorStatus(err))
+		writer.Write(encodeError(err))
+		return
+	}
+	encoded, err := json.Marshal(targets)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	if limit := h.config.MaxResponseBytes; limit > 0 && len(encoded) > limit {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(responseLimitError{count: len(encoded), limit: limit, unit: "bytes"}))
+		return
+	}
+	writer.Write(encoded)
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/util"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseGraphiteTime(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	tests := []struct {
+		value    string
+		expected time.Time
+		fails    bool
+	}{
+		{value: "now", expected: now},
+		{value: "-1h", expected: now.Add(-time.Hour)},
+		{value: "-30min", expected: now.Add(-30 * time.Minute)},
+		{value: "now-2days", expected: now.Add(-48 * time.Hour)},
+		{value: "-1w", expected: now.Add(-7 * 24 * time.Hour)},
+		{value: "-3mon", expected: now.Add(-90 * 24 * time.Hour)},
+		{value: "+10s", expected: now.Add(10 * time.Second)},
+		{value: "123456", expected: time.Unix(123456, 0)},
+		{value: "-5m", fails: true},
+		{value: "-h", fails: true},
+		{value: "yesterday", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.value)
+		actual, err := parseGraphiteTime(test.value, now)
+		if test.fails {
+			if err == nil {
+				a.Errorf("expected an error but got %+v", actual)
+			}
+			continue
+		}
+		a.CheckError(err)
+		a.EqInt(int(actual.Unix()), int(test.expected.Unix()))
+	}
+}
+
+func TestRenderHandler(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(3480000, 3600000, 30000)
+	a.CheckError(err)
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+	)
+	rule, err := util.Compile(util.RawRule{Pattern: "servers.%host%.cpu", MetricKeyPattern: "cpu"})
+	a.CheckError(err)
+	handler := renderHandler{
+		context: command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		},
+		converter: &util.RuleBasedGraphiteConverter{Ruleset: util.RuleSet{Rules: []util.Rule{rule}}},
+		clock:     mocks.NewTestClock(time.Unix(3600, 0)),
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{
+			query:  "target=servers.a.cpu&from=-1min",
+			status: http.StatusOK,
+			body:   `[{"target":"servers.a.cpu","datapoints":[[3,3540],[4,3570],[5,3600]]}]`,
+		},
+		{
+			query:  "target=servers.b.cpu&target=servers.a.cpu&from=-30s&until=now",
+			status: http.StatusOK,
+			body:   `[{"target":"servers.b.cpu","datapoints":[[9,3570],[10,3600]]},{"target":"servers.a.cpu","datapoints":[[4,3570],[5,3600]]}]`,
+		},
+		{
+			query:  "target=servers.a.cpu&from=3660&until=3690",
+			status: http.StatusOK,
+			body:   `[{"target":"servers.a.cpu","datapoints":[[null,3660],[null,3690]]}]`,
+		},
+		{query: "from=-1min", status: http.StatusBadRequest},
+		{query: "target=hosts.a.memory&from=-1min", status: http.StatusBadRequest},
+		{query: "target=servers.a.cpu&from=-1m", status: http.StatusBadRequest},
+		{query: "target=servers.a.cpu&from=-1min&format=csv", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		a := a.Contextf("%s", test.query)
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/render?"+test.query, nil)
+		a.CheckError(err)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.body != "" {
+			a.EqString(recorder.Body.String(), test.body)
+		}
+	}
+}
+
+func TestRenderHandlerResponseLimits(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(3480000, 3600000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+	)
+	rule, err := util.Compile(util.RawRule{Pattern: "servers.%host%.cpu", MetricKeyPattern: "cpu"})
+	if err != nil {
+		t.Fatalf("Error compiling rule for test: %s", err.Error())
+	}
+	tests := []struct {
+		config  Config
+		status  int
+		message string
+	}{
+		{status: http.StatusOK},
+		{config: Config{MaxResponseSeries: 2}, status: http.StatusOK},
+		{config: Config{MaxResponseSeries: 1}, status: http.StatusBadRequest, message: "2 series, which exceeds the limit of 1"},
+		{config: Config{MaxResponseBytes: 100000}, status: http.StatusOK},
+		{config: Config{MaxResponseBytes: 50}, status: http.StatusBadRequest, message: "exceeds the limit of 50"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test.config)
+		handler := renderHandler{
+			config: test.config,
+			context: command.ExecutionContext{
+				TimeseriesStorageAPI: comboAPI,
+				MetricMetadataAPI:    comboAPI,
+				FetchLimit:           1000,
+				Ctx:                  context.Background(),
+			},
+			converter: &util.RuleBasedGraphiteConverter{Ruleset: util.RuleSet{Rules: []util.Rule{rule}}},
+			clock:     mocks.NewTestClock(time.Unix(3600, 0)),
+		}
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/render?target=servers.a.cpu&target=servers.b.cpu&from=-1min", nil)
+		a.CheckError(err)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, test.status)
+		if test.message != "" && !strings.Contains(recorder.Body.String(), test.message) {
+			a.Errorf("expected the response to mention %q but got %s", test.message, recorder.Body.String())
+		}
+		if test.status != http.StatusOK && !strings.Contains(recorder.Body.String(), `"limit_exceeded"`) {
+			a.Errorf("expected the response to have the limit_exceeded code but got %s", recorder.Body.String())
+		}
+	}
+}
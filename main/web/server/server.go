@@ -17,29 +17,102 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/square/metrics/function"
 	"github.com/square/metrics/metric_metadata"
 	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/macro"
+	"github.com/square/metrics/util"
 )
 
 func NewMux(config Config, context command.ExecutionContext, hook Hook) (*http.ServeMux, error) {
 	// Wrap the given API and Backend in their Profiling counterparts.
 	httpMux := http.NewServeMux()
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %s", config.Timezone, err.Error())
+		}
+		context.Location = location
+	}
+	policy, err := function.ParseEmptyResultPolicy(config.EmptyResults)
+	if err != nil {
+		return nil, err
+	}
+	context.EmptyResults = policy
+	context.LenientFetches = config.LenientFetches
+	if len(config.Macros) > 0 {
+		macros, err := macro.NewRegistry(config.Macros)
+		if err != nil {
+			return nil, err
+		}
+		context.Macros = macros
+	}
+	if hook.Principal == nil && config.PrincipalHeader != "" {
+		header := config.PrincipalHeader
+		hook.Principal = func(request *http.Request) string {
+			return request.Header.Get(header)
+		}
+	}
+	// handle registers the handler, wrapped in the middleware requested by the hook.
+	handle := func(pattern string, handler http.Handler) {
+		httpMux.Handle(pattern, hook.wrap(handler))
+	}
 	httpMux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		http.Redirect(writer, request, "/ui", http.StatusTemporaryRedirect)
 	})
 	httpMux.Handle("/ui", singleStaticHandler{config.StaticDir, "index.html"})
 	httpMux.Handle("/embed", singleStaticHandler{config.StaticDir, "embed.html"})
-	httpMux.Handle("/query", queryHandler{
+	query := queryHandler{
 		context: context,
 		hook:    hook,
+		config:  config,
+	}
+	handle("/query", query)
+	handle("/batch", batchHandler{query: query})
+	handle("/token", tokenHandler{
+		context: context,
+	})
+	handle("/suggest", suggestHandler{
+		context: context,
+		hook:    hook,
+		metrics: &metricListCache{clock: util.RealClock{}},
 	})
-	httpMux.Handle("/token", tokenHandler{
+	handle("/functions", functionsHandler{
 		context: context,
 	})
+	if hook.GraphiteConverter != nil {
+		handle("/render", renderHandler{
+			hook:      hook,
+			config:    config,
+			context:   context,
+			converter: hook.GraphiteConverter,
+			clock:     util.RealClock{},
+		})
+	}
+	if hook.ReloadRules != nil && config.AdminToken != "" {
+		handle("/admin/reload-rules", adminHandler{
+			token:   config.AdminToken,
+			handler: reloadRulesHandler{reload: hook.ReloadRules},
+		})
+	}
+	if hook.MetricKinds != nil && config.AdminToken != "" {
+		handle("/admin/metric-kind", adminHandler{
+			token:   config.AdminToken,
+			handler: metricKindHandler{kinds: hook.MetricKinds},
+		})
+	}
+	if hook.Cardinality != nil && config.AdminToken != "" {
+		handle("/admin/cardinality", adminHandler{
+			token:   config.AdminToken,
+			method:  "GET",
+			handler: cardinalityHandler{reporter: hook.Cardinality},
+		})
+	}
 	if config.HTTPIngestion {
 		if updateAPI, ok := context.MetricMetadataAPI.(metadata.MetricUpdateAPI); ok {
-			httpMux.Handle("/ingest", ingestHandler{
+			handle("/ingest", ingestHandler{
 				metricMetadataAPI: updateAPI,
 			})
 		} else {
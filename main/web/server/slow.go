@@ -0,0 +1,94 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/square/metrics/inspect"
+	"github.com/square/metrics/log"
+	"github.com/square/metrics/query/command"
+)
+
+// SlowQueryConfig holds the thresholds above which a query is logged as slow,
+// so that the most expensive dashboards can be found. A zero threshold is
+// disabled.
+type SlowQueryConfig struct {
+	Millis  int `yaml:"millis"`  // total evaluation time, in milliseconds
+	Fetches int `yaml:"fetches"` // fetched series (shared by the queries of a batch)
+	Series  int `yaml:"series"`  // returned series (or scalars)
+}
+
+// enabled is true if any threshold is set.
+func (c SlowQueryConfig) enabled() bool {
+	return c.Millis > 0 || c.Fetches > 0 || c.Series > 0
+}
+
+// queryStats describes the cost of evaluating a single query.
+type queryStats struct {
+	Duration time.Duration
+	Fetches  int
+	Series   int
+}
+
+// exceeded lists the thresholds which the stats exceed.
+func (c SlowQueryConfig) exceeded(stats queryStats) []string {
+	reasons := []string{}
+	if c.Millis > 0 && stats.Duration > time.Duration(c.Millis)*time.Millisecond {
+		reasons = append(reasons, "duration")
+	}
+	if c.Fetches > 0 && stats.Fetches > c.Fetches {
+		reasons = append(reasons, "fetches")
+	}
+	if c.Series > 0 && stats.Series > c.Series {
+		reasons = append(reasons, "series")
+	}
+	return reasons
+}
+
+// totalExecution finds how long the "Total Execution" profile recorded by
+// process took.
+func totalExecution(profiler *inspect.Profiler) time.Duration {
+	profiles := profiler.All()
+	for i := len(profiles) - 1; i >= 0; i-- {
+		if profiles[i].Name == "Total Execution" {
+			return profiles[i].Duration()
+		}
+	}
+	return 0
+}
+
+// describeTimerange describes the timerange requested by a select command.
+// Other commands have no timerange.
+func describeTimerange(cmd command.Command) string {
+	selectCommand, ok := cmd.(*command.SelectCommand)
+	if !ok {
+		return "none"
+	}
+	context := selectCommand.Context
+	return fmt.Sprintf("from %d to %d resolution %dms", context.Start, context.End, context.Resolution)
+}
+
+// logSlowQuery logs the query if its stats exceed any configured threshold.
+func (c SlowQueryConfig) logSlowQuery(query string, cmd command.Command, stats queryStats) {
+	reasons := c.exceeded(stats)
+	if len(reasons) == 0 {
+		return
+	}
+	log.Warningf("Slow query (exceeded %s): %q timerange: %s duration: %s fetches: %d series: %d",
+		strings.Join(reasons, ", "), query, describeTimerange(cmd), stats.Duration, stats.Fetches, stats.Series)
+}
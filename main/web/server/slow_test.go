@@ -0,0 +1,113 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/log"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+// recordingLogger keeps the warnings which are logged.
+type recordingLogger struct {
+	warnings []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {}
+func (l *recordingLogger) Infof(format string, args ...interface{})  {}
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {}
+func (l *recordingLogger) Fatalf(format string, args ...interface{}) {}
+
+func TestSlowQueryExceeded(t *testing.T) {
+	stats := queryStats{Duration: 2 * time.Second, Fetches: 10, Series: 100}
+	tests := []struct {
+		config   SlowQueryConfig
+		expected []string
+	}{
+		{config: SlowQueryConfig{}, expected: []string{}},
+		{config: SlowQueryConfig{Millis: 2000, Fetches: 10, Series: 100}, expected: []string{}},
+		{config: SlowQueryConfig{Millis: 1999}, expected: []string{"duration"}},
+		{config: SlowQueryConfig{Fetches: 9, Series: 99}, expected: []string{"fetches", "series"}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test.config)
+		a.Eq(test.config.exceeded(stats), test.expected)
+	}
+}
+
+func TestQueryHandlerSlowQueries(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "host": "c"}},
+	)
+	executionContext := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	}
+	tests := []struct {
+		query    string
+		config   SlowQueryConfig
+		expected string // empty if the query isn't slow
+	}{
+		{query: "select cpu from 0 to 120 resolution 30ms", config: SlowQueryConfig{}},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: SlowQueryConfig{Fetches: 3, Series: 3}},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: SlowQueryConfig{Series: 2}, expected: `(exceeded series): "select cpu from 0 to 120 resolution 30ms" timerange: from 0 to 120 resolution 30ms`},
+		{query: "select aggregate.sum(cpu) from 0 to 120 resolution 30ms", config: SlowQueryConfig{Series: 2}},
+		{query: "select aggregate.sum(cpu) from 0 to 120 resolution 30ms", config: SlowQueryConfig{Fetches: 2}, expected: "fetches: 3 series: 1"},
+		{query: "select cpu from 0 to 120 resolution 30ms", config: SlowQueryConfig{Millis: 1000 * 1000}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s with %+v", test.query, test.config)
+		logger := &recordingLogger{}
+		log.InitLogger(logger)
+		handler := queryHandler{context: executionContext, config: Config{SlowQueries: test.config}}
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/query?query="+url.QueryEscape(test.query), nil)
+		a.CheckError(err)
+		handler.ServeHTTP(recorder, request)
+		log.InitLogger(nil)
+		a.EqInt(recorder.Code, http.StatusOK)
+		if test.expected == "" {
+			a.EqInt(len(logger.warnings), 0)
+			continue
+		}
+		if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], test.expected) {
+			a.Errorf("expected a single slow query warning mentioning %q but got %q", test.expected, logger.warnings)
+		}
+	}
+}
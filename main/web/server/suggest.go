@@ -0,0 +1,327 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/registry"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/util"
+)
+
+// suggestHandler offers completions for the word at the cursor of a partial
+// query: functions and metrics in general, and tag keys or values inside a
+// metric's [predicate]. Like queries, suggestions only include the series
+// which the request's principal may see.
+type suggestHandler struct {
+	context command.ExecutionContext
+	hook    Hook
+	metrics *metricListCache
+}
+
+// defaultSuggestionLimit bounds the suggestions returned when no limit is given.
+const defaultSuggestionLimit = 100
+
+// metricListTTL is how long the list of all metrics is reused between
+// suggestions, since they're requested as the user types.
+const metricListTTL = time.Minute
+
+// metricListCache holds the list of all metrics for a while. A nil cache
+// fetches the list every time.
+type metricListCache struct {
+	clock util.Clock
+
+	mutex   sync.Mutex
+	metrics []api.MetricKey
+	fetched time.Time
+}
+
+// get returns the list of all metrics, fetching it if it's too old.
+func (c *metricListCache) get(metadataAPI metadata.MetricAPI) ([]api.MetricKey, error) {
+	if c == nil {
+		return metadataAPI.GetAllMetrics(metadata.Context{})
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.clock.Now()
+	if c.metrics != nil && now.Sub(c.fetched) < metricListTTL {
+		return c.metrics, nil
+	}
+	metrics, err := metadataAPI.GetAllMetrics(metadata.Context{})
+	if err != nil {
+		return nil, err
+	}
+	c.metrics = metrics
+	c.fetched = now
+	return metrics, nil
+}
+
+// Suggestion is a single completion.
+type Suggestion struct {
+	Text      string `json:"text"`
+	Kind      string `json:"kind"` // one of "function", "metric", "tag_key" or "tag_value"
+	Arguments *Arity `json:"arguments,omitempty"`
+}
+
+// Arity describes the number of arguments which a function accepts.
+type Arity struct {
+	Min int `json:"min"`
+	Max int `json:"max"` // -1 for unlimited
+}
+
+// completion describes what is being typed at the cursor.
+type completion struct {
+	prefix string // the partial word at the cursor
+	metric string // inside a predicate, the metric it constrains
+	tag    string // inside a predicate, the tag whose value is being typed
+	inside bool   // whether the cursor is inside a predicate
+}
+
+// isWordBreak reports whether the character can't be part of an identifier or value.
+func isWordBreak(c byte) bool {
+	return strings.IndexByte(" \t\n(),[]=!~'\"`", c) >= 0
+}
+
+// lastWord returns the identifier ending at the end of the text.
+func lastWord(text string) string {
+	text = strings.TrimRight(text, " \t\n")
+	start := len(text)
+	for start > 0 && !isWordBreak(text[start-1]) {
+		start--
+	}
+	return text[start:]
+}
+
+// completionAt finds what is being typed at the cursor. It doesn't parse the
+// query, so it only recognizes the common cases.
+func completionAt(query string, cursor int) completion {
+	if cursor < 0 || cursor > len(query) {
+		cursor = len(query)
+	}
+	before := query[:cursor]
+	start := len(before)
+	for start > 0 && !isWordBreak(before[start-1]) {
+		start--
+	}
+	result := completion{prefix: before[start:]}
+	if start > 0 && strings.IndexByte("'\"`", before[start-1]) >= 0 {
+		start-- // the word is quoted
+	}
+	rest := before[:start]
+	open := strings.LastIndex(rest, "[")
+	if open < 0 || open < strings.LastIndex(rest, "]") {
+		return result
+	}
+	result.inside = true
+	result.metric = lastWord(rest[:open])
+	inner := strings.TrimRight(rest[open+1:], " \t\n")
+	switch {
+	case strings.HasSuffix(inner, "!="):
+		result.tag = lastWord(inner[:len(inner)-2])
+	case strings.HasSuffix(inner, "="):
+		result.tag = lastWord(inner[:len(inner)-1])
+	case strings.HasSuffix(inner, " match"):
+		result.tag = lastWord(inner[:len(inner)-len(" match")])
+	case strings.HasSuffix(inner, "(") || strings.HasSuffix(inner, ","):
+		// Inside a list of values, as in `host in ("a", "b")`.
+		if in := strings.LastIndex(inner, " in "); in >= 0 && strings.LastIndex(inner, ")") < in {
+			result.tag = lastWord(inner[:in])
+		}
+	}
+	return result
+}
+
+// visibleTags returns the metric's tagsets which the context's principal may see.
+func visibleTags(context command.ExecutionContext, metric api.MetricKey) ([]api.TagSet, error) {
+	tagSets, err := context.MetricMetadataAPI.GetAllTags(metric, metadata.Context{})
+	if err != nil {
+		return nil, err
+	}
+	if context.Authorizer == nil {
+		return tagSets, nil
+	}
+	visible := []api.TagSet{}
+	for _, tagSet := range tagSets {
+		if context.Authorizer.Visible(context.Principal, api.TaggedMetric{MetricKey: metric, TagSet: tagSet}) {
+			visible = append(visible, tagSet)
+		}
+	}
+	return visible, nil
+}
+
+// suggest lists (up to the limit, if it's positive) the completions for the
+// cursor's position, in order.
+func (h suggestHandler) suggest(context command.ExecutionContext, target completion, limit int) ([]Suggestion, error) {
+	suggestions := []Suggestion{}
+	full := func() bool {
+		return limit > 0 && len(suggestions) >= limit
+	}
+	if target.inside {
+		if target.metric == "" {
+			return suggestions, nil
+		}
+		tagSets, err := visibleTags(context, api.MetricKey(target.metric))
+		if err != nil {
+			if _, ok := err.(metadata.NoSuchMetricError); ok {
+				return suggestions, nil
+			}
+			return nil, err
+		}
+		found := map[string]bool{}
+		for _, tagSet := range tagSets {
+			for key, value := range tagSet {
+				if target.tag == "" {
+					found[key] = true
+				} else if key == target.tag {
+					found[value] = true
+				}
+			}
+		}
+		kind := "tag_key"
+		if target.tag != "" {
+			kind = "tag_value"
+		}
+		for _, text := range sortedMatches(found, target.prefix) {
+			if full() {
+				break
+			}
+			suggestions = append(suggestions, Suggestion{Text: text, Kind: kind})
+		}
+		return suggestions, nil
+	}
+	functions := context.Registry
+	if functions == nil {
+		functions = registry.Default()
+	}
+	names := map[string]bool{}
+	for _, name := range functions.All() {
+		names[name] = true
+	}
+	for _, name := range sortedMatches(names, target.prefix) {
+		if full() {
+			return suggestions, nil
+		}
+		suggestion := Suggestion{Text: name, Kind: "function"}
+		if fun, ok := functions.GetFunction(name); ok {
+			if metricFunction, ok := fun.(function.MetricFunction); ok {
+				suggestion.Arguments = &Arity{Min: metricFunction.MinArguments, Max: metricFunction.MaxArguments}
+			}
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	metrics, err := h.metrics.get(context.MetricMetadataAPI)
+	if err != nil {
+		return nil, err
+	}
+	metricNames := map[string]bool{}
+	for _, metric := range metrics {
+		metricNames[string(metric)] = true
+	}
+	for _, metric := range sortedMatches(metricNames, target.prefix) {
+		if full() {
+			break
+		}
+		if context.Authorizer != nil {
+			// A metric is only suggested if some of its series are visible.
+			tagSets, err := visibleTags(context, api.MetricKey(metric))
+			if err != nil {
+				if _, ok := err.(metadata.NoSuchMetricError); ok {
+					continue
+				}
+				return nil, err
+			}
+			if len(tagSets) == 0 {
+				continue
+			}
+		}
+		suggestions = append(suggestions, Suggestion{Text: metric, Kind: "metric"})
+	}
+	return suggestions, nil
+}
+
+// sortedMatches returns the candidates beginning with the prefix, in order.
+func sortedMatches(candidates map[string]bool, prefix string) []string {
+	result := []string{}
+	for candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			result = append(result, candidate)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (h suggestHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	if err := request.ParseForm(); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(encodeError(err))
+		return
+	}
+	query := request.Form.Get("query")
+	cursor := len(query)
+	if raw := request.Form.Get("cursor"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(encodeError(err))
+			return
+		}
+		cursor = parsed
+	}
+	limit := defaultSuggestionLimit
+	if raw := request.Form.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(encodeError(err))
+			return
+		}
+		limit = parsed
+	}
+
+	target := completionAt(query, cursor)
+	suggestions, err := h.suggest(h.hook.authorize(h.context, request), target, limit)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	encoded, err := json.Marshal(Response{
+		Success: true,
+		QueryResponse: QueryResponse{
+			Body: map[string]interface{}{
+				"prefix":      target.prefix,
+				"suggestions": suggestions,
+			},
+		},
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(encodeError(err))
+		return
+	}
+	writer.Write(encoded)
+}
@@ -0,0 +1,254 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function/registry"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+)
+
+func TestCompletionAt(t *testing.T) {
+	tests := []struct {
+		query    string
+		cursor   int
+		expected completion
+	}{
+		{query: "select cp", cursor: -1, expected: completion{prefix: "cp"}},
+		{query: "select transform.", cursor: -1, expected: completion{prefix: "transform."}},
+		{query: "select aggregate.sum(", cursor: -1, expected: completion{prefix: ""}},
+		{query: "select cpu + mem from -1h to now", cursor: 9, expected: completion{prefix: "cp"}},
+		{query: "select cpu[h", cursor: -1, expected: completion{prefix: "h", metric: "cpu", inside: true}},
+		{query: "select cpu[host = 'a' and d", cursor: -1, expected: completion{prefix: "d", metric: "cpu", inside: true}},
+		{query: "select cpu[host = 'w", cursor: -1, expected: completion{prefix: "w", metric: "cpu", tag: "host", inside: true}},
+		{query: "select cpu[host != \"", cursor: -1, expected: completion{prefix: "", metric: "cpu", tag: "host", inside: true}},
+		{query: "select cpu[host match 'w", cursor: -1, expected: completion{prefix: "w", metric: "cpu", tag: "host", inside: true}},
+		{query: "select cpu[dc in ('east', 'w", cursor: -1, expected: completion{prefix: "w", metric: "cpu", tag: "dc", inside: true}},
+		{query: "select cpu[host = 'a'] + m", cursor: -1, expected: completion{prefix: "m"}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%q at %d", test.query, test.cursor)
+		a.Eq(completionAt(test.query, test.cursor), test.expected)
+	}
+}
+
+func TestSuggestHandler(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu.user", "host": "web1", "dc": "east"}},
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu.user", "host": "web2", "dc": "west"}},
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu.system", "host": "db1"}},
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "memory", "host": "web1"}},
+	)
+	handler := suggestHandler{context: command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		Registry:             registry.Default(),
+	}}
+	tests := []struct {
+		query    string
+		limit    string
+		expected []Suggestion
+	}{
+		{
+			query: "select cpu",
+			expected: []Suggestion{
+				{Text: "cpu.system", Kind: "metric"},
+				{Text: "cpu.user", Kind: "metric"},
+			},
+		},
+		{
+			query: "select transform.spl",
+			expected: []Suggestion{
+				{Text: "transform.splice", Kind: "function", Arguments: &Arity{Min: 2, Max: 2}},
+			},
+		},
+		{
+			query: "select cpu.user[",
+			expected: []Suggestion{
+				{Text: "dc", Kind: "tag_key"},
+				{Text: "host", Kind: "tag_key"},
+			},
+		},
+		{
+			query: "select cpu.user[host = 'w",
+			expected: []Suggestion{
+				{Text: "web1", Kind: "tag_value"},
+				{Text: "web2", Kind: "tag_value"},
+			},
+		},
+		{
+			query: "select cpu.user[host = 'w",
+			limit: "1",
+			expected: []Suggestion{
+				{Text: "web1", Kind: "tag_value"},
+			},
+		},
+		{
+			query:    "select unknown[host = '",
+			expected: []Suggestion{},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%q", test.query)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/suggest?query="+url.QueryEscape(test.query)+"&limit="+test.limit, nil)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, http.StatusOK)
+		var response struct {
+			Body struct {
+				Suggestions []Suggestion `json:"suggestions"`
+			} `json:"body"`
+		}
+		a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		a.Eq(response.Body.Suggestions, test.expected)
+	}
+}
+
+// tenantAuthorizer lets each principal see only the series tagged with its tenant.
+type tenantAuthorizer struct{}
+
+func (tenantAuthorizer) Visible(principal string, metric api.TaggedMetric) bool {
+	return metric.TagSet["tenant"] == principal
+}
+
+func TestSuggestHandlerAuthorizer(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu.user", "tenant": "a", "host": "a1"}},
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu.user", "tenant": "b", "host": "b1", "secret": "x"}},
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu.system", "tenant": "b", "host": "b1"}},
+	)
+	handler := suggestHandler{
+		context: command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			Registry:             registry.Default(),
+		},
+		hook: Hook{
+			Authorizer: tenantAuthorizer{},
+			Principal:  func(request *http.Request) string { return request.Header.Get("X-Tenant") },
+		},
+	}
+	tests := []struct {
+		tenant   string
+		query    string
+		expected []Suggestion
+	}{
+		{
+			tenant:   "a",
+			query:    "select cpu",
+			expected: []Suggestion{{Text: "cpu.user", Kind: "metric"}},
+		},
+		{
+			tenant: "b",
+			query:  "select cpu",
+			expected: []Suggestion{
+				{Text: "cpu.system", Kind: "metric"},
+				{Text: "cpu.user", Kind: "metric"},
+			},
+		},
+		{
+			tenant:   "",
+			query:    "select cpu",
+			expected: []Suggestion{},
+		},
+		{
+			tenant: "a",
+			query:  "select cpu.user[",
+			expected: []Suggestion{
+				{Text: "host", Kind: "tag_key"},
+				{Text: "tenant", Kind: "tag_key"},
+			},
+		},
+		{
+			tenant:   "a",
+			query:    "select cpu.user[host = '",
+			expected: []Suggestion{{Text: "a1", Kind: "tag_value"}},
+		},
+		{
+			tenant:   "a",
+			query:    "select cpu.system[",
+			expected: []Suggestion{},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%q for %q", test.query, test.tenant)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/suggest?query="+url.QueryEscape(test.query), nil)
+		request.Header.Set("X-Tenant", test.tenant)
+		handler.ServeHTTP(recorder, request)
+		a.EqInt(recorder.Code, http.StatusOK)
+		var response struct {
+			Body struct {
+				Suggestions []Suggestion `json:"suggestions"`
+			} `json:"body"`
+		}
+		a.CheckError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		a.Eq(response.Body.Suggestions, test.expected)
+	}
+}
+
+// countingMetadataAPI counts the requests for the list of all metrics.
+type countingMetadataAPI struct {
+	metadata.MetricAPI
+	calls int
+}
+
+func (c *countingMetadataAPI) GetAllMetrics(context metadata.Context) ([]api.MetricKey, error) {
+	c.calls++
+	return c.MetricAPI.GetAllMetrics(context)
+}
+
+func TestMetricListCache(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	counting := &countingMetadataAPI{MetricAPI: mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)}
+	clock := mocks.NewTestClock(time.Unix(0, 0))
+	cache := &metricListCache{clock: clock}
+	for i := 0; i < 3; i++ {
+		_, err := cache.get(counting)
+		a.CheckError(err)
+	}
+	a.EqInt(counting.calls, 1)
+	clock.Move(metricListTTL)
+	_, err = cache.get(counting)
+	a.CheckError(err)
+	a.EqInt(counting.calls, 2)
+}
@@ -374,6 +374,9 @@ module.controller("CommonController", function (
       bottom: $scope.applyDefault("marginbottom", (($scope.hidden.legend ? 15 : 25) + ($scope.hidden.xaxis ? 0 : 15)) + "px")
     },
     series: null,
+    annotations: {
+      style: "line"
+    },
     vAxes: {
       0: {
         title: ""
@@ -735,19 +738,40 @@ function convertProfileResponse(object) {
 function convertSelectResponse(object) {
   if (!(object && object.name == "select" &&
       object.body &&
-      object.body.length &&
-      object.body[0].series &&
-      object.body[0].series.length &&
-      object.body[0].timerange)) {
+      object.body.length)) {
     // invalid data.
     return null;
   }
+  // The rows of the chart are the slots of the first list with any series;
+  // events (or scalars) alone aren't charted.
+  var first = null;
+  for (var k = 0; k < object.body.length; k++) {
+    if (object.body[k].type == "series" &&
+        object.body[k].series &&
+        object.body[k].series.length &&
+        object.body[k].timerange) {
+      first = object.body[k];
+      break;
+    }
+  }
+  if (!first) {
+    return null;
+  }
   var seriesOptions = {};
   var series = [];
+  var events = [];
   var labels = ["Time"];
   var table = [labels];
   var onlySingleSeries = object.body.length === 1;
   for (var i = 0; i < object.body.length; i++) {
+    if (object.body[i].type == "events") {
+      // Events are drawn as vertical lines, rather than as series.
+      events = events.concat(object.body[i].events || []);
+      continue;
+    }
+    if (object.body[i].type != "series") {
+      continue;
+    }
     // Each of these is a list of series
     var serieslist = object.body[i];
     for (var j = 0; j < serieslist.series.length; j++) {
@@ -775,9 +799,16 @@ function convertSelectResponse(object) {
     }
   }
   // Next, add each row.
-  var timerange = object.body[0].timerange;
+  var timerange = first.timerange;
+  if (events.length) {
+    // An annotation on the time column is drawn as a vertical line at that time.
+    labels.splice(1, 0, {type: "string", role: "annotation"});
+  }
   for (var t = 0; t < series[0].values.length; t++) {
     var row = [dateFromIndex(t, timerange)];
+    if (events.length) {
+      row.push(eventsAtIndex(t, timerange, events));
+    }
     for (i = 0; i < series.length; i++) {
       var cell = series[i].values[t];
       if (cell === null) {
@@ -795,6 +826,19 @@ function convertSelectResponse(object) {
 }
 
 
+// eventsAtIndex labels the events which fall into the bucket at the index,
+// or returns null if there are none.
+function eventsAtIndex(index, timerange, events) {
+  var start = timerange.start + timerange.resolution * index;
+  var names = [];
+  for (var i = 0; i < events.length; i++) {
+    if (events[i].timestamp >= start && events[i].timestamp < start + timerange.resolution) {
+      names.push(events[i].description || events[i].name);
+    }
+  }
+  return names.length ? names.join(", ") : null;
+}
+
 function dateFromIndex(index, timerange) {
   return new Date(timerange.start + timerange.resolution * index);
 }
@@ -29,16 +29,20 @@ import (
 	"github.com/square/metrics/main/web/server"
 	"github.com/square/metrics/metric_metadata"
 	"github.com/square/metrics/metric_metadata/cached"
+	"github.com/square/metrics/metric_metadata/cardinality"
 	"github.com/square/metrics/metric_metadata/cassandra"
 	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/timeseries"
 	"github.com/square/metrics/timeseries/blueflood"
+	"github.com/square/metrics/timeseries/coalesced"
+	"github.com/square/metrics/timeseries/routed"
 	"github.com/square/metrics/util"
 
 	"golang.org/x/net/context"
 )
 
-func startServer(config server.Config, context command.ExecutionContext) error {
-	httpMux, err := server.NewMux(config, context, server.Hook{})
+func startServer(config server.Config, hook server.Hook, context command.ExecutionContext) error {
+	httpMux, err := server.NewMux(config, context, hook)
 	if err != nil {
 		return err
 	}
@@ -54,6 +58,14 @@ func startServer(config server.Config, context command.ExecutionContext) error {
 	return server.ListenAndServe()
 }
 
+// routingConfig describes a Blueflood cluster for each datacenter (or other
+// tag value), so that fetches go only to the cluster holding the series.
+type routingConfig struct {
+	Tag       string                      `yaml:"tag"`       // the tag which names each series' cluster, such as "dc"
+	Blueflood map[string]blueflood.Config `yaml:"blueflood"` // the cluster for each value of the tag
+	Compare   bool                        `yaml:"compare"`   // whether to note how much the clusters disagree on series fetched from several of them
+}
+
 func main() {
 	//Adding a signal handler to dump goroutines
 	sigs := make(chan os.Signal, 1)
@@ -66,10 +78,17 @@ func main() {
 	}()
 
 	config := struct {
-		ConversionRulesPath string           `yaml:"conversion_rules_path"`
-		Cassandra           cassandra.Config `yaml:"cassandra"`
-		Blueflood           blueflood.Config `yaml:"blueflood"`
-		Web                 server.Config    `yaml:"web"`
+		ConversionRulesPath string                    `yaml:"conversion_rules_path"`
+		Cassandra           cassandra.Config          `yaml:"cassandra"`
+		Blueflood           blueflood.Config          `yaml:"blueflood"`
+		Routing             routingConfig             `yaml:"routing"`
+		Web                 server.Config             `yaml:"web"`
+		CORS                server.CORSConfig         `yaml:"cors"`
+		HighCardinalityTags []string                  `yaml:"high_cardinality_tags"` // fetches must constrain these tags unless wrapped in fetch.unbounded
+		StreamAggregations  bool                      `yaml:"stream_aggregations"`   // aggregate.sum(metric) and similar fold in each series as it's fetched
+		Cardinality         cardinality.Config        `yaml:"cardinality"`           // surveys of metrics' tag cardinality, served to admins at /admin/cardinality
+		TenantLimits        map[string]command.Limits `yaml:"tenant_limits"`         // fetch and slot limits for each principal (named by web.principal_header), in place of the defaults
+		UnlistedLimits      command.Limits            `yaml:"unlisted_limits"`       // fetch and slot limits for principals not in tenant_limits, including requests without one
 	}{}
 
 	common.LoadConfig(&config)
@@ -86,9 +105,23 @@ func main() {
 		return
 	}
 
-	config.Blueflood.GraphiteMetricConverter = &util.RuleBasedGraphiteConverter{Ruleset: ruleset}
+	graphiteConverter := &util.RuleBasedGraphiteConverter{Ruleset: ruleset}
+	config.Blueflood.GraphiteMetricConverter = graphiteConverter
 
-	blueflood := blueflood.NewBlueflood(config.Blueflood)
+	var storageAPI timeseries.StorageAPI = blueflood.NewBlueflood(config.Blueflood)
+	if len(config.Routing.Blueflood) > 0 {
+		// Each series is fetched only from the cluster named by its tag; the default cluster isn't used.
+		backends := map[string]timeseries.StorageAPI{}
+		for name, bluefloodConfig := range config.Routing.Blueflood {
+			bluefloodConfig.GraphiteMetricConverter = config.Blueflood.GraphiteMetricConverter
+			backends[name] = blueflood.NewBlueflood(bluefloodConfig)
+		}
+		if config.Routing.Compare {
+			storageAPI = routed.NewComparingStorageAPI(backends, routed.ByTag(config.Routing.Tag))
+		} else {
+			storageAPI = routed.NewStorageAPI(backends, routed.ByTag(config.Routing.Tag))
+		}
+	}
 
 	optimizedMetadataAPI := cached.NewMetricMetadataAPI(metadataAPI, cached.Config{
 		TimeToLive:   time.Minute * 5, // Cache items invalidated after 5 minutes.
@@ -106,14 +139,32 @@ func main() {
 		}()
 	}
 
-	err = startServer(config.Web, command.ExecutionContext{
+	hook := server.Hook{CORS: config.CORS, GraphiteConverter: graphiteConverter}
+	hook.ReloadRules = func() error {
+		return graphiteConverter.Reload(config.ConversionRulesPath)
+	}
+	hook.MetricKinds = metadataAPI
+	if config.Cardinality.Interval > 0 {
+		// The survey reads the metadata directly, so that it doesn't crowd out the cache's updates.
+		hook.Cardinality = cardinality.NewReporter(metadataAPI, config.Cardinality)
+		go hook.Cardinality.Run()
+	}
+
+	executionContext := command.ExecutionContext{
 		MetricMetadataAPI:    optimizedMetadataAPI,
-		TimeseriesStorageAPI: blueflood,
+		TimeseriesStorageAPI: coalesced.NewStorageAPI(storageAPI), // Concurrent identical fetches (e.g. from dashboards) share one request.
 		FetchLimit:           1500,
 		SlotLimit:            5000,
 		Registry:             registry.Default(),
+		HighCardinalityTags:  config.HighCardinalityTags,
+		StreamAggregations:   config.StreamAggregations,
 		Ctx:                  context.Background(),
-	})
+	}
+	if len(config.TenantLimits) > 0 || config.UnlistedLimits != (command.Limits{}) {
+		executionContext.TenantLimits = command.StaticLimits(config.TenantLimits, config.UnlistedLimits)
+	}
+
+	err = startServer(config.Web, hook, executionContext)
 	if err != nil {
 		log.Infof(err.Error())
 	}
@@ -16,6 +16,8 @@
 package metadata
 
 import (
+	"time"
+
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/inspect"
 )
@@ -24,6 +26,9 @@ import (
 type Context struct {
 	// Profiler is used to record execution time for metadata queries.
 	Profiler *inspect.Profiler
+	// Deadline is when the caller stops waiting for the result (zero means
+	// never). Implementations which retry shouldn't do so past it.
+	Deadline time.Time
 }
 
 // MetricAPI is an interface for obtaining metric metadata for indexing in MQE.
@@ -69,6 +69,15 @@ func (c *metricMetadataAPI) AddMetrics(metrics []api.TaggedMetric, context metad
 	return c.metricMetadataAPI.(metadata.MetricUpdateAPI).AddMetrics(metrics, context)
 }
 
+// GetMetricKind returns the kind recorded by the underlying API, or KindUnknown
+// if it doesn't record kinds. Kinds aren't cached, since they're rarely read.
+func (c *metricMetadataAPI) GetMetricKind(metricKey api.MetricKey, context metadata.Context) (metadata.MetricKind, error) {
+	if kindAPI, ok := c.metricMetadataAPI.(metadata.MetricKindAPI); ok {
+		return kindAPI.GetMetricKind(metricKey, context)
+	}
+	return metadata.KindUnknown, nil
+}
+
 // Config stores data needed to instantiate a CachedMetricMetadataAPI.
 type Config struct {
 	Freshness    time.Duration
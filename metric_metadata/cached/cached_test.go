@@ -606,3 +606,25 @@ func TestQueueSize(t *testing.T) {
 
 	a.MustEqInt(cached.CurrentLiveRequests(), 0)
 }
+
+func TestCachedMetricKind(t *testing.T) {
+	a := assert.New(t)
+	underlying := mocks.NewFakeMetricMetadataAPI()
+	a.CheckError(underlying.SetMetricKind("requests", metadata.KindCounter, metadata.Context{}))
+	cached := NewMetricMetadataAPI(underlying, Config{TimeToLive: time.Minute, RequestLimit: 10})
+	kinds, ok := cached.(metadata.MetricKindAPI)
+	if !ok {
+		t.Fatalf("Expected the cached API to report metric kinds")
+	}
+	kind, err := kinds.GetMetricKind("requests", metadata.Context{})
+	a.CheckError(err)
+	a.EqString(string(kind), string(metadata.KindCounter))
+	kind, err = kinds.GetMetricKind("temperature", metadata.Context{})
+	a.CheckError(err)
+	a.EqString(string(kind), string(metadata.KindUnknown))
+
+	// Kinds are unknown if the underlying API doesn't record them.
+	kind, err = NewMetricMetadataAPI(&testAPI{}, Config{RequestLimit: 10}).(metadata.MetricKindAPI).GetMetricKind("requests", metadata.Context{})
+	a.CheckError(err)
+	a.EqString(string(kind), string(metadata.KindUnknown))
+}
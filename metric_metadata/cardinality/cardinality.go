@@ -0,0 +1,187 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cardinality periodically surveys the metadata store, estimating the
+// tag cardinality of each metric so that runaway metrics can be caught before
+// they slow down queries. The report is served as JSON by the web server (at
+// /admin/cardinality), since there's no exporter to publish it as gauges.
+package cardinality
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/log"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/util"
+)
+
+// Config determines how often, and how much of, the metadata is surveyed.
+type Config struct {
+	Interval   time.Duration `yaml:"interval"`    // the time between surveys (0 => disabled)
+	MaxMetrics int           `yaml:"max_metrics"` // metrics examined per survey, continuing from where the last stopped (0 => all)
+}
+
+// MetricCardinality is the cardinality of a single metric.
+type MetricCardinality struct {
+	Metric   api.MetricKey  `json:"metric"`
+	Series   int            `json:"series"`   // the number of tagsets
+	Tags     map[string]int `json:"tags"`     // the number of distinct values of each tag
+	Surveyed time.Time      `json:"surveyed"` // when the metric was last examined
+}
+
+// Report is the most recent cardinality of each metric, highest first.
+type Report struct {
+	Metrics []MetricCardinality `json:"metrics"`
+}
+
+// Reporter surveys the metadata and holds the latest report.
+type Reporter struct {
+	metadataAPI metadata.MetricAPI
+	config      Config
+	clock       util.Clock
+
+	mutex   sync.Mutex
+	metrics map[api.MetricKey]MetricCardinality
+	next    int // the index (in sorted order) of the next metric to survey
+}
+
+// NewReporter creates a Reporter for the given metadata. It doesn't survey
+// anything until Survey or Run are called.
+func NewReporter(metadataAPI metadata.MetricAPI, config Config) *Reporter {
+	return &Reporter{
+		metadataAPI: metadataAPI,
+		config:      config,
+		clock:       util.RealClock{},
+		metrics:     map[api.MetricKey]MetricCardinality{},
+	}
+}
+
+// Run surveys the metadata every interval, forever.
+func (r *Reporter) Run() {
+	for {
+		if err := r.Survey(metadata.Context{}); err != nil {
+			log.Errorf("Error surveying metric cardinality: %s", err.Error())
+		}
+		time.Sleep(r.config.Interval)
+	}
+}
+
+// Survey examines the next batch of metrics. Metrics which no longer exist
+// are dropped from the report.
+func (r *Reporter) Survey(context metadata.Context) error {
+	metrics, err := r.metadataAPI.GetAllMetrics(context)
+	if err != nil {
+		return err
+	}
+	sort.Sort(byKey(metrics))
+
+	r.mutex.Lock()
+	start := r.next
+	r.mutex.Unlock()
+	if start >= len(metrics) {
+		start = 0
+	}
+	count := len(metrics)
+	if r.config.MaxMetrics > 0 && r.config.MaxMetrics < count {
+		count = r.config.MaxMetrics
+	}
+
+	surveyed := map[api.MetricKey]MetricCardinality{}
+	for i := 0; i < count; i++ {
+		metric := metrics[(start+i)%len(metrics)]
+		tagSets, err := r.metadataAPI.GetAllTags(metric, context)
+		if err != nil {
+			if _, ok := err.(metadata.NoSuchMetricError); ok {
+				continue // it was removed since GetAllMetrics
+			}
+			return err
+		}
+		surveyed[metric] = measure(metric, tagSets, r.clock.Now())
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	existing := map[api.MetricKey]bool{}
+	for _, metric := range metrics {
+		existing[metric] = true
+	}
+	for metric := range r.metrics {
+		if !existing[metric] {
+			delete(r.metrics, metric)
+		}
+	}
+	for metric, cardinality := range surveyed {
+		r.metrics[metric] = cardinality
+	}
+	if len(metrics) > 0 {
+		r.next = (start + count) % len(metrics)
+	}
+	return nil
+}
+
+// measure counts the series and distinct tag values of the metric.
+func measure(metric api.MetricKey, tagSets []api.TagSet, now time.Time) MetricCardinality {
+	values := map[string]map[string]bool{}
+	for _, tagSet := range tagSets {
+		for key, value := range tagSet {
+			if values[key] == nil {
+				values[key] = map[string]bool{}
+			}
+			values[key][value] = true
+		}
+	}
+	tags := map[string]int{}
+	for key, distinct := range values {
+		tags[key] = len(distinct)
+	}
+	return MetricCardinality{
+		Metric:   metric,
+		Series:   len(tagSets),
+		Tags:     tags,
+		Surveyed: now,
+	}
+}
+
+// Report returns the latest cardinality of every surveyed metric, with the
+// highest series counts first.
+func (r *Reporter) Report() Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	report := Report{Metrics: make([]MetricCardinality, 0, len(r.metrics))}
+	for _, cardinality := range r.metrics {
+		report.Metrics = append(report.Metrics, cardinality)
+	}
+	sort.Sort(bySeries(report.Metrics))
+	return report
+}
+
+type byKey []api.MetricKey
+
+func (list byKey) Len() int           { return len(list) }
+func (list byKey) Less(i, j int) bool { return list[i] < list[j] }
+func (list byKey) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+
+type bySeries []MetricCardinality
+
+func (list bySeries) Len() int { return len(list) }
+func (list bySeries) Less(i, j int) bool {
+	if list[i].Series != list[j].Series {
+		return list[i].Series > list[j].Series
+	}
+	return list[i].Metric < list[j].Metric
+}
+func (list bySeries) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
@@ -0,0 +1,79 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cardinality
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+)
+
+func series(tags api.TagSet) api.Timeseries {
+	return api.Timeseries{Values: []float64{0}, TagSet: tags}
+}
+
+func newTestAPI() mocks.FakeComboAPI {
+	timerange, err := api.NewTimerange(0, 0, 1)
+	if err != nil {
+		panic(err)
+	}
+	return mocks.NewComboAPI(
+		timerange,
+		series(api.TagSet{"metric": "a", "host": "1", "dc": "east"}),
+		series(api.TagSet{"metric": "a", "host": "2", "dc": "east"}),
+		series(api.TagSet{"metric": "a", "host": "3", "dc": "west"}),
+		series(api.TagSet{"metric": "b", "host": "1"}),
+		series(api.TagSet{"metric": "c", "host": "1"}),
+		series(api.TagSet{"metric": "c", "host": "2"}),
+	)
+}
+
+func TestSurvey(t *testing.T) {
+	a := assert.New(t)
+	now := time.Unix(1000, 0)
+	reporter := NewReporter(newTestAPI(), Config{})
+	reporter.clock = mocks.NewTestClock(now)
+	a.CheckError(reporter.Survey(metadata.Context{}))
+
+	report := reporter.Report()
+	// series_timeout is always present in the fake API, with a single empty tagset.
+	a.EqInt(len(report.Metrics), 4)
+	a.Eq(report.Metrics[0], MetricCardinality{Metric: "a", Series: 3, Tags: map[string]int{"host": 3, "dc": 2}, Surveyed: now})
+	a.Eq(report.Metrics[1], MetricCardinality{Metric: "c", Series: 2, Tags: map[string]int{"host": 2}, Surveyed: now})
+	a.Eq(report.Metrics[2], MetricCardinality{Metric: "b", Series: 1, Tags: map[string]int{"host": 1}, Surveyed: now})
+}
+
+func TestSurveyMaxMetrics(t *testing.T) {
+	a := assert.New(t)
+	reporter := NewReporter(newTestAPI(), Config{MaxMetrics: 3})
+
+	surveyed := func() []api.MetricKey {
+		metrics := []api.MetricKey{}
+		for _, cardinality := range reporter.Report().Metrics {
+			metrics = append(metrics, cardinality.Metric)
+		}
+		return metrics
+	}
+
+	// Metrics are surveyed in order: a, b, c, series_timeout.
+	a.CheckError(reporter.Survey(metadata.Context{}))
+	a.Eq(surveyed(), []api.MetricKey{"a", "c", "b"})
+	a.CheckError(reporter.Survey(metadata.Context{}))
+	a.Eq(surveyed(), []api.MetricKey{"a", "c", "b", "series_timeout"})
+}
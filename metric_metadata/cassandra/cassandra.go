@@ -23,15 +23,24 @@ import (
 )
 
 type MetricMetadataAPI struct {
-	db cassandraDatabase
+	db    cassandraDatabase
+	retry retryPolicy
 }
 
 var _ metadata.MetricAPI = (*MetricMetadataAPI)(nil)
 var _ metadata.MetricUpdateAPI = (*MetricMetadataAPI)(nil)
+var _ metadata.MetricKindUpdateAPI = (*MetricMetadataAPI)(nil)
 
 type Config struct {
 	Hosts    []string `yaml:"hosts"`
 	Keyspace string   `yaml:"keyspace"`
+
+	// Reads which fail transiently (such as timeouts during compaction) are
+	// retried up to Retries times, waiting RetryBackoff before the first retry
+	// and twice as long before each one after, up to MaxRetryBackoff.
+	Retries         int           `yaml:"retries"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`     // 0 => 100ms
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"` // 0 => 2s
 }
 
 // NewMetricMetadataAPI creates a new instance of API from the given configuration.
@@ -46,7 +55,8 @@ func NewMetricMetadataAPI(config Config) (*MetricMetadataAPI, error) {
 		return nil, err
 	}
 	return &MetricMetadataAPI{
-		db: db,
+		db:    db,
+		retry: newRetryPolicy(config),
 	}, nil
 }
 
@@ -81,17 +91,49 @@ func (a *MetricMetadataAPI) AddMetrics(metrics []api.TaggedMetric, context metad
 
 func (a *MetricMetadataAPI) GetAllTags(metricKey api.MetricKey, context metadata.Context) ([]api.TagSet, error) {
 	defer context.Profiler.Record("Cassandra GetAllTags")()
-	return a.db.GetTagSet(metricKey)
+	var tagSets []api.TagSet
+	err := a.retry.do(context, func() (err error) {
+		tagSets, err = a.db.GetTagSet(metricKey)
+		return
+	})
+	return tagSets, err
 }
 
 func (a *MetricMetadataAPI) GetMetricsForTag(tagKey, tagValue string, context metadata.Context) ([]api.MetricKey, error) {
 	defer context.Profiler.Record("Cassandra GetMetricsForTag")()
-	return a.db.GetMetricKeys(tagKey, tagValue)
+	var keys []api.MetricKey
+	err := a.retry.do(context, func() (err error) {
+		keys, err = a.db.GetMetricKeys(tagKey, tagValue)
+		return
+	})
+	return keys, err
 }
 
 func (a *MetricMetadataAPI) GetAllMetrics(context metadata.Context) ([]api.MetricKey, error) {
 	defer context.Profiler.Record("Cassandra GetAllMetrics")()
-	return a.db.GetAllMetrics()
+	var keys []api.MetricKey
+	err := a.retry.do(context, func() (err error) {
+		keys, err = a.db.GetAllMetrics()
+		return
+	})
+	return keys, err
+}
+
+// GetMetricKind returns the kind recorded for the metric, or KindUnknown.
+func (a *MetricMetadataAPI) GetMetricKind(metricKey api.MetricKey, context metadata.Context) (metadata.MetricKind, error) {
+	defer context.Profiler.Record("Cassandra GetMetricKind")()
+	var kind metadata.MetricKind
+	err := a.retry.do(context, func() (err error) {
+		kind, err = a.db.GetMetricKind(metricKey)
+		return
+	})
+	return kind, err
+}
+
+// SetMetricKind records the kind of the metric.
+func (a *MetricMetadataAPI) SetMetricKind(metricKey api.MetricKey, kind metadata.MetricKind, context metadata.Context) error {
+	defer context.Profiler.Record("Cassandra SetMetricKind")()
+	return a.db.SetMetricKind(metricKey, kind)
 }
 
 // CheckHealthy checks if the underlying connection to Cassandra is healthy
@@ -228,6 +270,26 @@ func (db *cassandraDatabase) RemoveFromTagIndex(tagKey string, tagValue string,
 	).Exec()
 }
 
+func (db *cassandraDatabase) GetMetricKind(metricKey api.MetricKey) (metadata.MetricKind, error) {
+	var kind string
+	err := db.session.Query("SELECT kind FROM metric_kinds WHERE metric_key = ?", metricKey).Scan(&kind)
+	if err == gocql.ErrNotFound || (err == nil && kind == "") {
+		return metadata.KindUnknown, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return metadata.MetricKind(kind), nil
+}
+
+func (db *cassandraDatabase) SetMetricKind(metricKey api.MetricKey, kind metadata.MetricKind) error {
+	return db.session.Query(
+		"INSERT INTO metric_kinds (metric_key, kind) VALUES (?, ?)",
+		metricKey,
+		string(kind),
+	).Exec()
+}
+
 // CheckHealthy checks if the connection to Cassandra is healthy
 func (db *cassandraDatabase) CheckHealthy() error {
 	return db.session.Query("SELECT now() FROM system.local").Exec()
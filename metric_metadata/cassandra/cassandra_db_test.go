@@ -22,6 +22,7 @@ import (
 
 	"github.com/gocql/gocql"
 	"github.com/square/metrics/api"
+	"github.com/square/metrics/metric_metadata"
 	"github.com/square/metrics/testing_support/assert"
 )
 
@@ -39,7 +40,7 @@ func newDatabase(t *testing.T) *cassandraDatabase {
 		t.Errorf("Cannot connect to Cassandra")
 		return nil
 	}
-	tables := []string{"metric_names", "tag_index", "metric_name_set"}
+	tables := []string{"metric_names", "tag_index", "metric_name_set", "metric_kinds"}
 	for _, table := range tables {
 		if err := session.Query(fmt.Sprintf("TRUNCATE %s", table)).Exec(); err != nil {
 			t.Errorf("Cannot truncate %s: %s", table, err.Error())
@@ -185,3 +186,27 @@ func Test_TagIndex_DB(t *testing.T) {
 		a.EqString(string(rows[0]), "d.e.f")
 	}
 }
+
+func Test_MetricKind_DB(t *testing.T) {
+	a := assert.New(t)
+	db := newDatabase(t)
+	if db == nil {
+		return
+	}
+	defer cleanDatabase(t, db)
+
+	kind, err := db.GetMetricKind("requests")
+	a.CheckError(err)
+	a.EqString(string(kind), string(metadata.KindUnknown))
+
+	a.CheckError(db.SetMetricKind("requests", metadata.KindCounter))
+	a.CheckError(db.SetMetricKind("temperature", metadata.KindGauge))
+	kind, err = db.GetMetricKind("requests")
+	a.CheckError(err)
+	a.EqString(string(kind), string(metadata.KindCounter))
+
+	a.CheckError(db.SetMetricKind("requests", metadata.KindHistogram))
+	kind, err = db.GetMetricKind("requests")
+	a.CheckError(err)
+	a.EqString(string(kind), string(metadata.KindHistogram))
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cassandra
+
+import (
+	"net"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/square/metrics/metric_metadata"
+)
+
+// retryPolicy retries reads which fail transiently, with exponential backoff.
+type retryPolicy struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	sleep      func(time.Duration)
+	now        func() time.Time
+}
+
+func newRetryPolicy(config Config) retryPolicy {
+	policy := retryPolicy{
+		retries:    config.Retries,
+		backoff:    config.RetryBackoff,
+		maxBackoff: config.MaxRetryBackoff,
+		sleep:      time.Sleep,
+		now:        time.Now,
+	}
+	if policy.backoff <= 0 {
+		policy.backoff = 100 * time.Millisecond
+	}
+	if policy.maxBackoff <= 0 {
+		policy.maxBackoff = 2 * time.Second
+	}
+	return policy
+}
+
+// do performs the read, retrying it while it fails transiently. It gives up
+// early rather than wait past the context's deadline.
+func (p retryPolicy) do(context metadata.Context, read func() error) error {
+	backoff := p.backoff
+	for attempt := 0; ; attempt++ {
+		err := read()
+		if err == nil || attempt >= p.retries || !transient(err) {
+			return err
+		}
+		if !context.Deadline.IsZero() && p.now().Add(backoff).After(context.Deadline) {
+			return err
+		}
+		p.sleep(backoff)
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// transient reports whether the error may go away if the read is retried.
+func transient(err error) bool {
+	switch err := err.(type) {
+	case *gocql.RequestErrUnavailable, *gocql.RequestErrReadTimeout:
+		return true
+	case net.Error:
+		return err.Timeout()
+	}
+	return err == gocql.ErrTimeoutNoResponse || err == gocql.ErrConnectionClosed || err == gocql.ErrNoConnections
+}
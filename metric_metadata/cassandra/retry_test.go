@@ -0,0 +1,105 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cassandra
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+// flakySession fails its first reads with the given errors, then succeeds.
+type flakySession struct {
+	failures []error
+	reads    int
+}
+
+func (s *flakySession) read() error {
+	s.reads++
+	if s.reads <= len(s.failures) {
+		return s.failures[s.reads-1]
+	}
+	return nil
+}
+
+func TestRetryPolicy(t *testing.T) {
+	timeout := gocql.ErrTimeoutNoResponse
+	unavailable := &gocql.RequestErrUnavailable{}
+	syntax := errors.New("line 1:7 no viable alternative at input")
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name     string
+		failures []error
+		deadline time.Time
+		reads    int
+		waits    []time.Duration
+		fails    bool
+	}{
+		{name: "success", reads: 1},
+		{name: "transient", failures: []error{timeout, unavailable}, reads: 3, waits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}},
+		{
+			name:     "exhausted",
+			failures: []error{timeout, timeout, timeout, timeout, timeout},
+			reads:    4,
+			waits:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond},
+			fails:    true,
+		},
+		{name: "permanent", failures: []error{syntax}, reads: 1, fails: true},
+		{name: "not found", failures: []error{metadata.NewNoSuchMetricError("cpu")}, reads: 1, fails: true},
+		{
+			name:     "deadline",
+			failures: []error{timeout, timeout},
+			deadline: start.Add(250 * time.Millisecond),
+			reads:    2,
+			waits:    []time.Duration{100 * time.Millisecond},
+			fails:    true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.name)
+		now := start
+		waits := []time.Duration{}
+		policy := retryPolicy{
+			retries:    3,
+			backoff:    100 * time.Millisecond,
+			maxBackoff: 250 * time.Millisecond,
+			sleep: func(d time.Duration) {
+				waits = append(waits, d)
+				now = now.Add(d)
+			},
+			now: func() time.Time { return now },
+		}
+		session := &flakySession{failures: test.failures}
+		err := policy.do(metadata.Context{Deadline: test.deadline}, session.read)
+		a.EqBool(err != nil, test.fails)
+		a.EqInt(session.reads, test.reads)
+		if test.waits == nil {
+			test.waits = []time.Duration{}
+		}
+		a.Eq(waits, test.waits)
+	}
+}
+
+func TestNewRetryPolicyDefaults(t *testing.T) {
+	a := assert.New(t)
+	policy := newRetryPolicy(Config{Retries: 2})
+	a.EqInt(policy.retries, 2)
+	a.Eq(policy.backoff, 100*time.Millisecond)
+	a.Eq(policy.maxBackoff, 2*time.Second)
+}
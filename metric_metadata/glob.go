@@ -0,0 +1,60 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/square/metrics/api"
+)
+
+// MetricGlobAPI is implemented by MetricAPIs which can find the metrics whose
+// names match a glob more efficiently than enumerating every metric.
+type MetricGlobAPI interface {
+	// GetMetricsMatching returns the metrics whose names match the glob.
+	GetMetricsMatching(glob string, context Context) ([]api.MetricKey, error)
+}
+
+// GetMetricsMatching returns the metrics whose names match the glob, in sorted
+// order. The glob's syntax is that of path.Match, where `*` may match dots
+// (so "requests.*" matches "requests.http.count"). If the MetricAPI isn't a
+// MetricGlobAPI, every metric is enumerated and checked.
+func GetMetricsMatching(metricAPI MetricAPI, glob string, context Context) ([]api.MetricKey, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid metric glob %q: %s", glob, err.Error())
+	}
+	var metrics []api.MetricKey
+	if globAPI, ok := metricAPI.(MetricGlobAPI); ok {
+		var err error
+		metrics, err = globAPI.GetMetricsMatching(glob, context)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		all, err := metricAPI.GetAllMetrics(context)
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range all {
+			if matched, _ := path.Match(glob, string(metric)); matched {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	sort.Sort(api.MetricKeys(metrics))
+	return metrics, nil
+}
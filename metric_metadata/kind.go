@@ -0,0 +1,59 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+
+	"github.com/square/metrics/api"
+)
+
+// MetricKind describes how the values of a metric are to be interpreted.
+type MetricKind string
+
+const (
+	// KindUnknown is the kind of metrics whose kind hasn't been recorded.
+	KindUnknown MetricKind = "unknown"
+	// KindCounter is the kind of metrics which count up from their last reset.
+	KindCounter MetricKind = "counter"
+	// KindGauge is the kind of metrics which measure a current value.
+	KindGauge MetricKind = "gauge"
+	// KindHistogram is the kind of metrics which record a distribution of values.
+	KindHistogram MetricKind = "histogram"
+)
+
+// ParseMetricKind parses the name of a kind which can be recorded.
+func ParseMetricKind(name string) (MetricKind, error) {
+	switch kind := MetricKind(name); kind {
+	case KindCounter, KindGauge, KindHistogram:
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown metric kind %q (expected %q, %q or %q)", name, KindCounter, KindGauge, KindHistogram)
+}
+
+// MetricKindAPI is implemented by MetricAPIs which record the kind of each metric.
+// Implementing it is optional; to callers, the kind of every metric of a
+// MetricAPI which doesn't is unknown.
+type MetricKindAPI interface {
+	// GetMetricKind returns the kind of the metric, or KindUnknown if it hasn't been recorded.
+	GetMetricKind(metricKey api.MetricKey, context Context) (MetricKind, error)
+}
+
+// MetricKindUpdateAPI is implemented by MetricKindAPIs whose kinds can be recorded.
+type MetricKindUpdateAPI interface {
+	MetricKindAPI
+	// SetMetricKind records the kind of the metric, replacing any earlier kind.
+	SetMetricKind(metricKey api.MetricKey, kind MetricKind, context Context) error
+}
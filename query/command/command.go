@@ -25,6 +25,7 @@ import (
 	"github.com/square/metrics/function/registry"
 	"github.com/square/metrics/inspect"
 	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/macro"
 	"github.com/square/metrics/query/natural_sort"
 	"github.com/square/metrics/query/predicate"
 	"github.com/square/metrics/timeseries"
@@ -34,14 +35,27 @@ import (
 
 // ExecutionContext is the context supplied when invoking a command.
 type ExecutionContext struct {
-	TimeseriesStorageAPI  timeseries.StorageAPI // the backend
-	MetricMetadataAPI     metadata.MetricAPI    // the api
-	FetchLimit            int                   // the maximum number of fetches
-	Timeout               time.Duration         // optional
-	Registry              function.Registry     // optional
-	SlotLimit             int                   // optional (0 => default 1000)
-	Profiler              *inspect.Profiler     // optional
-	AdditionalConstraints predicate.Predicate   // optional. Additional contrains for describe and select commands
+	TimeseriesStorageAPI  timeseries.StorageAPI      // the backend
+	MetricMetadataAPI     metadata.MetricAPI         // the api
+	FetchLimit            int                        // the maximum number of fetches
+	Timeout               time.Duration              // optional
+	FetchTimeout          time.Duration              // optional (0 => same as Timeout)
+	Registry              function.Registry          // optional
+	SlotLimit             int                        // optional (0 => default 1000)
+	Profiler              *inspect.Profiler          // optional
+	AdditionalConstraints predicate.Predicate        // optional. Additional contrains for describe and select commands
+	HighCardinalityTags   []string                   // optional. Tags which every fetch must constrain
+	StreamAggregations    bool                       // optional. Fold fetched series into sums, counts, etc. as they arrive
+	Authorizer            function.Authorizer        // optional. Hides the series which the Principal may not see
+	Principal             string                     // optional. Who the command is executed for
+	FetchCounter          *function.FetchCounter     // optional. Shared by several commands in place of a new counter for FetchLimit
+	EventSource           function.EventSource       // optional. Source of events such as deploys
+	Location              *time.Location             // optional. Default timezone of wall-clock functions (nil => UTC)
+	Provenance            bool                       // optional. If true, series in the results include their provenance
+	EmptyResults          function.EmptyResultPolicy // optional. What to do when a fetch matches no series ("" => add a note)
+	TenantLimits          LimitsProvider             // optional. Overrides FetchLimit and SlotLimit for each Principal
+	LenientFetches        bool                       // optional. Leave out series which fail to fetch (with a note) instead of failing
+	Macros                *macro.Registry            // optional. Expands $macro references in query text before it's parsed
 
 	Ctx netcontext.Context
 }
@@ -109,7 +123,8 @@ func (cmd *DescribeCommand) Execute(context ExecutionContext) (Result, error) {
 	predicate := predicate.All(cmd.Predicate, context.AdditionalConstraints)
 	keyValueSets := map[string]map[string]bool{} // a map of tag_key => Set{tag_value}.
 	for _, tagset := range tagsets {
-		if predicate.Apply(tagset) {
+		visible := context.Authorizer == nil || context.Authorizer.Visible(context.Principal, api.TaggedMetric{MetricKey: cmd.MetricName, TagSet: tagset})
+		if visible && predicate.Apply(tagset) {
 			// Add each key as needed
 			for key, value := range tagset {
 				if keyValueSets[key] == nil {
@@ -148,6 +163,10 @@ func (cmd *DescribeAllCommand) Execute(context ExecutionContext) (Result, error)
 				filtered = append(filtered, row)
 			}
 		}
+		filtered, err = context.visibleMetrics(filtered, func(api.TagSet) bool { return true })
+		if err != nil {
+			return Result{}, err
+		}
 		sort.Sort(api.MetricKeys(filtered))
 		return Result{
 			Body: filtered,
@@ -171,6 +190,10 @@ func (cmd *DescribeMetricsCommand) Execute(context ExecutionContext) (Result, er
 	if err != nil {
 		return Result{}, err
 	}
+	data, err = context.visibleMetrics(data, func(tagSet api.TagSet) bool { return tagSet[cmd.TagKey] == cmd.TagValue })
+	if err != nil {
+		return Result{}, err
+	}
 	return Result{
 		Body: data,
 		Metadata: map[string]interface{}{
@@ -179,6 +202,31 @@ func (cmd *DescribeMetricsCommand) Execute(context ExecutionContext) (Result, er
 	}, nil
 }
 
+// visibleMetrics keeps the metrics which have a tagset accepted by `accept`
+// that the context's principal may see, so that describing metrics doesn't
+// reveal the names of those whose series are all hidden.
+func (context ExecutionContext) visibleMetrics(metrics []api.MetricKey, accept func(api.TagSet) bool) ([]api.MetricKey, error) {
+	if context.Authorizer == nil {
+		return metrics, nil
+	}
+	visible := make([]api.MetricKey, 0, len(metrics))
+	for _, metric := range metrics {
+		tagSets, err := context.MetricMetadataAPI.GetAllTags(metric, metadata.Context{
+			Profiler: context.Profiler,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, tagSet := range tagSets {
+			if accept(tagSet) && context.Authorizer.Visible(context.Principal, api.TaggedMetric{MetricKey: metric, TagSet: tagSet}) {
+				visible = append(visible, metric)
+				break
+			}
+		}
+	}
+	return visible, nil
+}
+
 func (cmd *DescribeMetricsCommand) Name() string {
 	return "describe metrics"
 }
@@ -186,21 +234,27 @@ func (cmd *DescribeMetricsCommand) Name() string {
 type QueryResult struct {
 	Query string `json:"query"`
 	Name  string `json:"name"`
-	Type  string `json:"type"` // one of "series" or "scalars"
+	Type  string `json:"type"` // one of "series", "scalars", "events" or "strings"
 	// for "series" type
-	Series    []api.Timeseries `json:"series"`
-	Timerange api.Timerange    `json:"timerange,omitempty"`
+	Series      []api.Timeseries  `json:"series"`
+	Timerange   api.Timerange     `json:"timerange,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"` // attached by transform.annotate
 	// for "scalar" type
 	Scalars []function.TaggedScalar `json:"scalars,omitempty"`
+	// for "events" type
+	Events []function.Event `json:"events,omitempty"`
+	// for "strings" type
+	Strings []string `json:"strings,omitempty"`
 }
 
 // Execute performs the query represented by the given query string, and returs the result.
 func (cmd *SelectCommand) Execute(context ExecutionContext) (Result, error) {
-	userTimerange, err := api.NewSnappedTimerange(cmd.Context.Start, cmd.Context.End, cmd.Context.Resolution)
+	userTimerange, err := api.NewAlignedTimerange(cmd.Context.Start, cmd.Context.End, cmd.Context.Resolution)
 	if err != nil {
 		return Result{}, err
 	}
-	slotLimit := context.SlotLimit
+	limits := context.ResolveLimits()
+	slotLimit := limits.SlotLimit
 	defaultLimit := 1000
 	if slotLimit == 0 {
 		slotLimit = defaultLimit // the default limit
@@ -220,7 +274,8 @@ func (cmd *SelectCommand) Execute(context ExecutionContext) (Result, error) {
 		return Result{}, err
 	}
 
-	chosenTimerange, err := api.NewSnappedTimerange(userTimerange.StartMillis(), userTimerange.EndMillis(), int64(chosenResolution/time.Millisecond))
+	// Every fetch shares the chosen timerange, so they all agree on where the buckets lie.
+	chosenTimerange, err := api.NewAlignedTimerange(cmd.Context.Start, cmd.Context.End, int64(chosenResolution/time.Millisecond))
 	if err != nil {
 		return Result{}, err
 	}
@@ -248,21 +303,45 @@ func (cmd *SelectCommand) Execute(context ExecutionContext) (Result, error) {
 		r = registry.Default()
 	}
 
+	fetchTimeout := context.FetchTimeout
+	if fetchTimeout == 0 {
+		fetchTimeout = context.Timeout
+	}
+
+	fetchCounter := function.NewFetchCounter(limits.FetchLimit)
+	if context.FetchCounter != nil {
+		fetchCounter = *context.FetchCounter
+	}
+
 	evaluationContext := function.EvaluationContextBuilder{
 		MetricMetadataAPI:    context.MetricMetadataAPI,
-		FetchLimit:           function.NewFetchCounter(context.FetchLimit),
+		FetchLimit:           fetchCounter,
 		TimeseriesStorageAPI: context.TimeseriesStorageAPI,
 		Predicate:            predicate.All(cmd.Predicate, context.AdditionalConstraints),
 		SampleMethod:         cmd.Context.SampleMethod,
 		Timerange:            chosenTimerange,
 
-		Registry:        r,
-		Profiler:        context.Profiler,
-		EvaluationNotes: new(function.EvaluationNotes),
+		Registry:            r,
+		Profiler:            context.Profiler,
+		EvaluationNotes:     new(function.EvaluationNotes),
+		FetchTimeout:        fetchTimeout,
+		HighCardinalityTags: context.HighCardinalityTags,
+		StreamAggregations:  context.StreamAggregations,
+		Authorizer:          context.Authorizer,
+		Principal:           context.Principal,
+		EventSource:         context.EventSource,
+		Location:            context.Location,
+		EmptyResults:        context.EmptyResults,
+		LenientFetches:      context.LenientFetches,
+		Provenance:          context.Provenance,
 
 		Ctx: ctx,
 	}.Build()
 
+	if chosenTimerange.StartMillis() != cmd.Context.Start || chosenTimerange.EndMillis() != cmd.Context.End {
+		evaluationContext.AddNote(fmt.Sprintf("The timerange was widened from [%d, %d] to [%d, %d] to align with the resolution %+v", cmd.Context.Start, cmd.Context.End, chosenTimerange.StartMillis(), chosenTimerange.EndMillis(), chosenResolution))
+	}
+
 	results := make(chan []function.Value, 1)
 	errors := make(chan error, 1)
 	// Goroutines are never garbage collected, so we need to provide capacity so that the send always succeeds.
@@ -277,7 +356,7 @@ func (cmd *SelectCommand) Execute(context ExecutionContext) (Result, error) {
 	}()
 	select {
 	case <-ctx.Done():
-		return Result{}, function.NewLimitError("Timeout while executing the query.", context.Timeout, context.Timeout)
+		return Result{}, function.TimeoutError{Message: "Timeout while executing the query.", Timeout: context.Timeout}
 	case err := <-errors:
 		return Result{}, err
 	case result := <-results:
@@ -311,15 +390,53 @@ func (cmd *SelectCommand) Execute(context ExecutionContext) (Result, error) {
 		body := make([]QueryResult, len(result))
 		for i := range body {
 			if list, ok := result[i].(function.SeriesListValue); ok {
+				if !context.Provenance {
+					list = function.SeriesListValue(withoutProvenance(api.SeriesList(list)))
+				}
+				body[i] = QueryResult{
+					Query:       cmd.Expressions[i].ExpressionString(function.StringQuery),
+					Name:        cmd.Expressions[i].ExpressionString(function.StringName),
+					Type:        "series",
+					Series:      list.Series,
+					Timerange:   chosenTimerange,
+					Annotations: list.Annotations,
+				}
+				continue
+			}
+			if trimmed, ok := result[i].(function.TrimmedSeriesListValue); ok {
+				list := trimmed.List
+				if !context.Provenance {
+					list = withoutProvenance(list)
+				}
+				body[i] = QueryResult{
+					Query:       cmd.Expressions[i].ExpressionString(function.StringQuery),
+					Name:        cmd.Expressions[i].ExpressionString(function.StringName),
+					Type:        "series",
+					Series:      list.Series,
+					Timerange:   trimmed.Timerange,
+					Annotations: list.Annotations,
+				}
+				continue
+			}
+			if events, ok := result[i].(function.EventListValue); ok {
 				body[i] = QueryResult{
 					Query:     cmd.Expressions[i].ExpressionString(function.StringQuery),
 					Name:      cmd.Expressions[i].ExpressionString(function.StringName),
-					Type:      "series",
-					Series:    list.Series,
+					Type:      "events",
+					Events:    events,
 					Timerange: chosenTimerange,
 				}
 				continue
 			}
+			if keys, ok := result[i].(function.StringSetValue); ok {
+				body[i] = QueryResult{
+					Query:   cmd.Expressions[i].ExpressionString(function.StringQuery),
+					Name:    cmd.Expressions[i].ExpressionString(function.StringName),
+					Type:    "strings",
+					Strings: keys,
+				}
+				continue
+			}
 			if scalars, err := result[i].ToScalarSet(); err == nil {
 				body[i] = QueryResult{
 					Query:   cmd.Expressions[i].ExpressionString(function.StringQuery),
@@ -346,6 +463,17 @@ func (cmd *SelectCommand) Name() string {
 	return "select"
 }
 
+// withoutProvenance returns a copy of the list whose series have no provenance.
+func withoutProvenance(list api.SeriesList) api.SeriesList {
+	series := make([]api.Timeseries, len(list.Series))
+	for i := range list.Series {
+		series[i] = list.Series[i]
+		series[i].Provenance = ""
+	}
+	list.Series = series
+	return list
+}
+
 //ProfilingCommand is a Command that also performs profiling actions.
 type ProfilingCommand struct {
 	Profiler *inspect.Profiler
@@ -0,0 +1,55 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+// Limits bounds the resources which a single command may use. A zero limit
+// defers to the ExecutionContext's own FetchLimit or SlotLimit.
+type Limits struct {
+	FetchLimit int `yaml:"fetch_limit"` // the maximum number of fetches
+	SlotLimit  int `yaml:"slot_limit"`  // the maximum number of points in each series
+}
+
+// A LimitsProvider gives the limits for the principal (such as a tenant) which
+// a command is executed for, so that one principal's heavy queries can't
+// exhaust a budget shared with everyone else.
+type LimitsProvider func(principal string) Limits
+
+// StaticLimits provides the limits listed for each principal. Principals which
+// aren't listed (including requests without a principal) get the `unlisted`
+// limits, so that a tenant can't escape tighter limits by claiming to be
+// someone else; its zero limits in turn defer to the context's defaults.
+func StaticLimits(limits map[string]Limits, unlisted Limits) LimitsProvider {
+	return func(principal string) Limits {
+		if listed, ok := limits[principal]; ok {
+			return listed
+		}
+		return unlisted
+	}
+}
+
+// ResolveLimits returns the limits which apply to the context's principal.
+func (context ExecutionContext) ResolveLimits() Limits {
+	limits := Limits{}
+	if context.TenantLimits != nil {
+		limits = context.TenantLimits(context.Principal)
+	}
+	if limits.FetchLimit == 0 {
+		limits.FetchLimit = context.FetchLimit
+	}
+	if limits.SlotLimit == 0 {
+		limits.SlotLimit = context.SlotLimit
+	}
+	return limits
+}
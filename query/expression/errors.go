@@ -14,6 +14,8 @@
 
 package expression
 
+import "fmt"
+
 // SyntaxError is raised when the user query is invalid.
 // This can happen for two reasons:
 // * The query does not generate a valid AST.
@@ -25,3 +27,15 @@ type SyntaxError struct {
 func (err SyntaxError) Error() string {
 	return err.message
 }
+
+// UnboundedFetchError is raised when a fetch places no constraint on one of
+// the configured high-cardinality tags, and so would scan every series of the metric.
+type UnboundedFetchError struct {
+	MetricName string
+	Tag        string
+	Values     int // the number of distinct values of the tag
+}
+
+func (err UnboundedFetchError) Error() string {
+	return fmt.Sprintf("fetch of metric %s places no constraint on the high-cardinality tag %q (%d distinct values); restrict it in a where clause or wrap the expression in fetch.unbounded(...)", err.MetricName, err.Tag, err.Values)
+}
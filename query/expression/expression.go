@@ -16,7 +16,10 @@ package expression
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/square/metrics/api"
@@ -25,6 +28,8 @@ import (
 	"github.com/square/metrics/query/predicate"
 	"github.com/square/metrics/timeseries"
 	"github.com/square/metrics/util"
+
+	netcontext "golang.org/x/net/context"
 )
 
 // Implementations
@@ -82,17 +87,92 @@ type MetricFetchExpression struct {
 }
 
 func (expr *MetricFetchExpression) ActualEvaluate(context function.EvaluationContext) (function.Value, error) {
+	metrics, err := expr.resolve(context)
+	if err != nil {
+		return nil, err
+	}
+	seriesList, err := fetchWithFallback(context, fetchRequest(context, metrics))
+	if err != nil {
+		return nil, err
+	}
+	provenance := expr.ExpressionString(function.StringQuery)
+	result := api.SeriesList{Series: make([]api.Timeseries, len(seriesList.Series))}
+	for i, series := range seriesList.Series {
+		series.Provenance = provenance
+		result.Series[i] = series
+	}
+	return function.SeriesListValue(result), nil
+}
+
+// streamBatchSize is the number of series requested at once by Stream. It
+// bounds the number of series in memory, while still letting the storage API
+// fetch several series in parallel.
+const streamBatchSize = 64
+
+// Stream fetches the same series as ActualEvaluate, but passes them to the
+// visitor a batch at a time instead of collecting them into a single list, so
+// that each batch can be released before the next is fetched.
+func (expr *MetricFetchExpression) Stream(context function.EvaluationContext, visit func(api.Timeseries)) error {
+	metrics, err := expr.resolve(context)
+	if err != nil {
+		return err
+	}
+	provenance := expr.ExpressionString(function.StringQuery)
+	for start := 0; start < len(metrics); start += streamBatchSize {
+		end := start + streamBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		batch, err := fetchWithFallback(context, fetchRequest(context, metrics[start:end]))
+		if err != nil {
+			return err
+		}
+		for _, series := range batch.Series {
+			series.Provenance = provenance
+			visit(series)
+		}
+	}
+	return nil
+}
+
+// resolve finds the metrics which the expression fetches (and which are visible
+// to the context's principal), charging them to the context's fetch limit. If
+// there are none (including when the metric doesn't exist), the context's
+// EmptyResultPolicy is applied.
+func (expr *MetricFetchExpression) resolve(context function.EvaluationContext) ([]api.TaggedMetric, error) {
 	// Merge predicates appropriately
 	p := predicate.All(expr.Predicate, context.Predicate())
 
-	metricTagSets, err := context.MetricMetadataAPI().GetAllTags(api.MetricKey(expr.MetricName), metadata.Context{
+	metadataContext := metadata.Context{
 		Profiler: context.Profiler(),
-	})
-
+	}
+	if ctx := context.Ctx(); ctx != nil {
+		metadataContext.Deadline, _ = ctx.Deadline()
+	}
+	metricTagSets, err := context.MetricMetadataAPI().GetAllTags(api.MetricKey(expr.MetricName), metadataContext)
+	if _, ok := err.(metadata.NoSuchMetricError); ok {
+		// An unknown metric is just a fetch which matches no series.
+		metricTagSets, err = nil, nil
+	}
 	if err != nil {
+		return nil, function.WrapBackendError("metadata", err)
+	}
+	// Series hidden by the authorizer are dropped silently, as though they didn't exist.
+	visible := make([]api.TagSet, 0, len(metricTagSets))
+	for _, tagSet := range metricTagSets {
+		if context.Visible(api.TaggedMetric{MetricKey: api.MetricKey(expr.MetricName), TagSet: tagSet}) {
+			visible = append(visible, tagSet)
+		}
+	}
+	if err := checkBounded(context, expr.MetricName, p, visible); err != nil {
 		return nil, err
 	}
-	filtered := applyPredicates(metricTagSets, p)
+	filtered := applyPredicates(visible, p)
+	if len(filtered) == 0 {
+		if err := context.CheckEmptyResult("fetch of " + expr.ExpressionString(function.StringQuery)); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := context.FetchLimitConsume(len(filtered)); err != nil {
 		return nil, err
@@ -102,22 +182,116 @@ func (expr *MetricFetchExpression) ActualEvaluate(context function.EvaluationCon
 	for i := range metrics {
 		metrics[i] = api.TaggedMetric{MetricKey: api.MetricKey(expr.MetricName), TagSet: filtered[i]}
 	}
+	return metrics, nil
+}
 
-	seriesList, err := context.TimeseriesStorageAPI().FetchMultipleTimeseries(
-		timeseries.FetchMultipleRequest{
-			Metrics: metrics,
-			RequestDetails: timeseries.RequestDetails{
-				SampleMethod: context.SampleMethod(),
-				Timerange:    context.Timerange(),
-				Ctx:          context.Ctx(),
-				Profiler:     context.Profiler(),
-			},
+// fetchRequest asks for the metrics over the context's timerange.
+func fetchRequest(context function.EvaluationContext, metrics []api.TaggedMetric) timeseries.FetchMultipleRequest {
+	return timeseries.FetchMultipleRequest{
+		Metrics: metrics,
+		RequestDetails: timeseries.RequestDetails{
+			SampleMethod: context.SampleMethod(),
+			Timerange:    context.Timerange(),
+			Ctx:          context.Ctx(),
+			Profiler:     context.Profiler(),
 		},
-	)
-	if err != nil {
-		return nil, err
 	}
-	return function.SeriesListValue(seriesList), nil
+}
+
+// checkBounded rejects the fetch if the predicate leaves any of the context's
+// high-cardinality tags unconstrained. Tags with at most one value for this
+// metric are exempt, since leaving them unconstrained costs nothing.
+func checkBounded(context function.EvaluationContext, metricName string, p predicate.Predicate, tagSets []api.TagSet) error {
+	for _, tag := range context.HighCardinalityTags() {
+		if predicate.Constrains(p, tag) {
+			continue
+		}
+		values := map[string]bool{}
+		for _, tagSet := range tagSets {
+			if value, ok := tagSet[tag]; ok {
+				values[value] = true
+			}
+		}
+		if len(values) > 1 {
+			return UnboundedFetchError{MetricName: metricName, Tag: tag, Values: len(values)}
+		}
+	}
+	return nil
+}
+
+// fetchWithTimeout performs the fetch, but abandons it if it takes longer than
+// the context's FetchTimeout. An abandoned fetch results in NaN series (and a
+// note) so that the rest of the query can still be evaluated; `abandoned`
+// reports whether this happened. The fetched series are made to match the
+// request's timerange, in case the storage returns too few or too many values.
+func fetchWithTimeout(context function.EvaluationContext, request timeseries.FetchMultipleRequest) (list api.SeriesList, abandoned bool, err error) {
+	request.OnNote = context.AddNote
+	if context.LenientFetches() {
+		failures := &seriesFailures{}
+		request.OnSeriesError = failures.add
+		defer failures.note(context)
+	}
+	if context.FetchTimeout() == 0 || request.Ctx == nil {
+		list, err := context.TimeseriesStorageAPI().FetchMultipleTimeseries(request)
+		return list.FillTimerange(request.Timerange), false, function.WrapBackendError("storage", err)
+	}
+	parent := request.Ctx
+	ctx, cancel := netcontext.WithTimeout(parent, context.FetchTimeout())
+	defer cancel()
+	request.Ctx = ctx
+
+	type result struct {
+		list api.SeriesList
+		err  error
+	}
+	// The channel is buffered so that the send succeeds even after the fetch is abandoned.
+	results := make(chan result, 1)
+	go func() {
+		list, err := context.TimeseriesStorageAPI().FetchMultipleTimeseries(request)
+		results <- result{list, err}
+	}()
+	select {
+	case r := <-results:
+		if r.err == nil || !expired(ctx) || expired(parent) {
+			return r.list.FillTimerange(request.Timerange), false, function.WrapBackendError("storage", r.err)
+		}
+		// The storage gave up because the fetch timed out (and may have done
+		// so before the timeout was noticed here), so it's abandoned.
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			// The whole query has run out of time, not just this fetch.
+			return api.SeriesList{}, false, parent.Err()
+		}
+	}
+	if len(request.Metrics) == 0 {
+		return api.SeriesList{}, true, nil
+	}
+	context.AddNote(fmt.Sprintf("fetch of %d series for metric %s exceeded the fetch timeout of %+v; using NaN instead", len(request.Metrics), request.Metrics[0].MetricKey, context.FetchTimeout()))
+	list = api.SeriesList{
+		Series: make([]api.Timeseries, len(request.Metrics)),
+	}
+	for i, metric := range request.Metrics {
+		values := make([]float64, request.Timerange.Slots())
+		for j := range values {
+			values[j] = math.NaN()
+		}
+		list.Series[i] = api.Timeseries{
+			Values: values,
+			TagSet: metric.TagSet,
+		}
+	}
+	return list, true, nil
+}
+
+// expired reports whether the context has expired, or is about to because its
+// deadline has passed (a storage may enforce it with its own timer, and so
+// fail just before the context itself expires).
+func expired(ctx netcontext.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
 }
 
 func (expr *MetricFetchExpression) ExpressionString(mode function.DescriptionMode) string {
@@ -142,6 +316,9 @@ func (expr *FunctionExpression) ActualEvaluate(context function.EvaluationContex
 	if !ok {
 		return nil, SyntaxError{fmt.Sprintf("no such function %s", expr.FunctionName)}
 	}
+	if profiler := context.Profiler(); profiler != nil {
+		defer profiler.RecordWithDescription(fmt.Sprintf("%s.Evaluate", expr.FunctionName), expr.ExpressionString(function.StringQuery))()
+	}
 
 	return fun.Run(context, expr.Arguments, function.Groups{List: expr.GroupBy, Collapses: expr.GroupByCollapses})
 }
@@ -203,6 +380,37 @@ func (expr *AnnotationExpression) ExpressionString(mode function.DescriptionMode
 // Auxiliary functions
 // ===================
 
+// Fetches returns the distinct metric fetches which appear in the expression.
+// Fetches performed internally by functions (rather than written in the query)
+// are not included.
+func Fetches(e function.Expression) []*MetricFetchExpression {
+	seen := map[string]bool{}
+	result := []*MetricFetchExpression{}
+	var walk func(function.Expression)
+	walk = func(e function.Expression) {
+		var actual interface{} = e
+		if unmemoized, ok := function.Unmemoize(e); ok {
+			actual = unmemoized
+		}
+		switch expr := actual.(type) {
+		case *MetricFetchExpression:
+			identity := expr.ExpressionString(function.StringMemoization)
+			if !seen[identity] {
+				seen[identity] = true
+				result = append(result, expr)
+			}
+		case *FunctionExpression:
+			for _, argument := range expr.Arguments {
+				walk(argument)
+			}
+		case *AnnotationExpression:
+			walk(expr.Expression)
+		}
+	}
+	walk(e)
+	return result
+}
+
 func applyPredicates(tagSets []api.TagSet, predicate predicate.Predicate) []api.TagSet {
 	output := []api.TagSet{}
 	for _, ts := range tagSets {
@@ -212,3 +420,26 @@ func applyPredicates(tagSets []api.TagSet, predicate predicate.Predicate) []api.
 	}
 	return output
 }
+
+// seriesFailures collects the series which a lenient fetch left out.
+type seriesFailures struct {
+	mutex    sync.Mutex
+	failures []string
+}
+
+func (f *seriesFailures) add(metric api.TaggedMetric, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failures = append(f.failures, fmt.Sprintf("%s[%s]: %s", metric.MetricKey, metric.TagSet.Serialize(), err.Error()))
+}
+
+// note adds a note to the context listing the series which were left out.
+func (f *seriesFailures) note(context function.EvaluationContext) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.failures) == 0 {
+		return
+	}
+	sort.Strings(f.failures)
+	context.AddNote(fmt.Sprintf("left out %d series which failed to fetch: %s", len(f.failures), strings.Join(f.failures, "; ")))
+}
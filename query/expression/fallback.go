@@ -0,0 +1,115 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/timeseries"
+)
+
+// minimumDensity is the fraction of points which a fetch must have for its
+// resolution to be trusted. Sparser fetches are retried at a coarser resolution,
+// since the storage may lack the finer rollup for the timerange.
+const minimumDensity = 0.1
+
+// maximumFallbacks bounds the number of coarser resolutions which are tried
+// for a single fetch.
+const maximumFallbacks = 2
+
+// fetchWithFallback performs the fetch, and if almost none of the points are
+// defined, retries it at successively coarser resolutions offered by the
+// storage API. The coarser values are spread back over the requested
+// timerange. Fetches which were abandoned for taking too long aren't retried.
+// Each retry is charged to the fetch limit, and is skipped (keeping
+// the sparse result) if the limit doesn't allow it.
+func fetchWithFallback(context function.EvaluationContext, request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	list, abandoned, err := fetchWithTimeout(context, request)
+	if err != nil || abandoned || len(request.Metrics) == 0 {
+		return list, err
+	}
+	requested := request.Timerange
+	best := density(list)
+	retry := request
+	for attempt := 0; attempt < maximumFallbacks && best < minimumDensity; attempt++ {
+		resolution, err := context.TimeseriesStorageAPI().ChooseResolution(requested, retry.Timerange.Resolution()+time.Millisecond)
+		if err != nil || resolution <= retry.Timerange.Resolution() {
+			break // there's no coarser resolution available
+		}
+		coarser, err := api.NewAlignedTimerange(requested.StartMillis(), requested.EndMillis(), int64(resolution/time.Millisecond))
+		if err != nil {
+			break
+		}
+		if !context.FetchLimitTryConsume(len(request.Metrics)) {
+			context.AddNote(fmt.Sprintf("fetch of %d series for metric %s has only %.0f%% of its points, but the fetch limit doesn't allow retrying at a coarser resolution", len(request.Metrics), request.Metrics[0].MetricKey, best*100))
+			break
+		}
+		retry.Timerange = coarser
+		coarse, abandoned, err := fetchWithTimeout(context, retry)
+		if err != nil || abandoned {
+			break // the sparse result is better than none
+		}
+		spread := spreadOver(coarse, coarser, requested)
+		if fallbackDensity := density(spread); fallbackDensity > best {
+			context.AddNote(fmt.Sprintf("fetch of %d series for metric %s has only %.0f%% of its points at resolution %+v; using resolution %+v instead", len(request.Metrics), request.Metrics[0].MetricKey, best*100, requested.Resolution(), resolution))
+			list, best = spread, fallbackDensity
+		}
+	}
+	return list, nil
+}
+
+// density is the fraction of the list's points which aren't NaN.
+func density(list api.SeriesList) float64 {
+	total, defined := 0, 0
+	for _, series := range list.Series {
+		for _, value := range series.Values {
+			total++
+			if !math.IsNaN(value) {
+				defined++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(defined) / float64(total)
+}
+
+// spreadOver resamples the list, fetched over the coarse timerange, onto the
+// finer timerange, giving each point the value of the coarse bucket which
+// contains it.
+func spreadOver(list api.SeriesList, coarse api.Timerange, timerange api.Timerange) api.SeriesList {
+	result := api.SeriesList{Series: make([]api.Timeseries, len(list.Series))}
+	for i, series := range list.Series {
+		values := make([]float64, timerange.Slots())
+		for j := range values {
+			values[j] = math.NaN()
+			// The coarse timerange is aligned to its resolution, so it starts
+			// no later than the finer one.
+			millis := timerange.StartMillis() + int64(j)*timerange.ResolutionMillis()
+			bucket := int((millis - coarse.StartMillis()) / coarse.ResolutionMillis())
+			if bucket < len(series.Values) {
+				values[j] = series.Values[bucket]
+			}
+		}
+		series.Values = values
+		result.Series[i] = series
+	}
+	return result
+}
@@ -0,0 +1,302 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package macro expands named fragments of query text, so that recurring
+// expressions needn't be repeated in every query. A reference such as
+// `$standard_rate(requests)` is replaced by the macro's body, with each of its
+// parameters (written `$metric` in the body) replaced by the given argument.
+//
+// Expansion happens before parsing, but it can't change the structure of the
+// surrounding query: every argument and every expansion is parenthesized, so
+// each must be a complete expression by itself. References inside quoted
+// strings and identifiers are left alone.
+package macro
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// MaxExpansionLength bounds the length of an expanded query. A macro which
+// uses a parameter more than once doubles its argument, so a short query
+// nesting it could otherwise expand to gigabytes.
+const MaxExpansionLength = 1 << 20
+
+// A Macro is a named fragment of query text.
+type Macro struct {
+	Name       string   `yaml:"name"`
+	Parameters []string `yaml:"parameters"` // the names of its arguments, which the body refers to as $name
+	Body       string   `yaml:"body"`
+}
+
+// Registry holds the macros which can be referred to by queries.
+type Registry struct {
+	macros map[string]Macro
+}
+
+// NewRegistry checks the macros, returning a Registry to expand them.
+func NewRegistry(macros []Macro) (*Registry, error) {
+	registry := &Registry{macros: map[string]Macro{}}
+	for _, macro := range macros {
+		if !isName(macro.Name) {
+			return nil, fmt.Errorf("invalid macro name %q", macro.Name)
+		}
+		if _, ok := registry.macros[macro.Name]; ok {
+			return nil, fmt.Errorf("macro %s is defined more than once", macro.Name)
+		}
+		parameters := map[string]bool{}
+		for _, parameter := range macro.Parameters {
+			if !isName(parameter) {
+				return nil, fmt.Errorf("macro %s has an invalid parameter name %q", macro.Name, parameter)
+			}
+			if parameters[parameter] {
+				return nil, fmt.Errorf("macro %s has more than one parameter named %s", macro.Name, parameter)
+			}
+			parameters[parameter] = true
+		}
+		registry.macros[macro.Name] = macro
+	}
+	// Every reference in a body must be to a parameter or another macro.
+	for _, macro := range registry.macros {
+		for _, reference := range references(macro.Body) {
+			if _, ok := registry.macros[reference]; ok {
+				continue
+			}
+			if !contains(macro.Parameters, reference) {
+				return nil, fmt.Errorf("macro %s refers to $%s, which is neither a parameter nor a macro", macro.Name, reference)
+			}
+		}
+	}
+	return registry, nil
+}
+
+// Expand replaces every macro reference in the query with its expansion. It
+// fails if a macro refers to itself, directly or through others, or if the
+// expansion would be longer than MaxExpansionLength. A nil Registry leaves the
+// query unchanged.
+func (r *Registry) Expand(query string) (string, error) {
+	if r == nil {
+		return query, nil
+	}
+	return r.expand(query, nil)
+}
+
+// expand expands the references in the text, within the expansions of the
+// macros on the stack (which therefore can't be expanded again).
+func (r *Registry) expand(text string, stack []string) (string, error) {
+	var result bytes.Buffer
+	for i := 0; i < len(text); {
+		if end, ok := skipQuoted(text, i); ok {
+			result.WriteString(text[i:end])
+			i = end
+			continue
+		}
+		if text[i] != '$' {
+			result.WriteByte(text[i])
+			i++
+			continue
+		}
+		name, end := readName(text, i+1)
+		if name == "" {
+			return "", fmt.Errorf("expected a macro name to follow \"$\" at position %d", i)
+		}
+		macro, ok := r.macros[name]
+		if !ok {
+			return "", fmt.Errorf("unknown macro $%s", name)
+		}
+		if contains(stack, name) {
+			return "", fmt.Errorf("macro $%s expands to itself (via %s)", name, strings.Join(append(stack, name), " -> "))
+		}
+		arguments := []string{}
+		if end < len(text) && text[end] == '(' {
+			var err error
+			arguments, end, err = readArguments(text, end)
+			if err != nil {
+				return "", fmt.Errorf("in the arguments to $%s: %s", name, err.Error())
+			}
+		}
+		if len(arguments) != len(macro.Parameters) {
+			return "", fmt.Errorf("macro $%s expects %d arguments but got %d", name, len(macro.Parameters), len(arguments))
+		}
+		values := map[string]string{}
+		for j, argument := range arguments {
+			expanded, err := r.expand(argument, stack)
+			if err != nil {
+				return "", err
+			}
+			values[macro.Parameters[j]] = "(" + expanded + ")"
+		}
+		body := substitute(macro.Body, values)
+		if len(body) > MaxExpansionLength {
+			return "", expansionTooLong(name)
+		}
+		expanded, err := r.expand(body, append(stack, name))
+		if err != nil {
+			return "", err
+		}
+		result.WriteString("(" + expanded + ")")
+		if result.Len() > MaxExpansionLength {
+			return "", expansionTooLong(name)
+		}
+		i = end
+	}
+	return result.String(), nil
+}
+
+func expansionTooLong(name string) error {
+	return fmt.Errorf("expanding $%s makes the query longer than %d bytes", name, MaxExpansionLength)
+}
+
+// substitute replaces the references to parameters in the body with their
+// values, leaving references to macros to be expanded.
+func substitute(body string, values map[string]string) string {
+	var result bytes.Buffer
+	for i := 0; i < len(body); {
+		if end, ok := skipQuoted(body, i); ok {
+			result.WriteString(body[i:end])
+			i = end
+			continue
+		}
+		if body[i] == '$' {
+			name, end := readName(body, i+1)
+			if value, ok := values[name]; ok {
+				result.WriteString(value)
+				i = end
+				continue
+			}
+		}
+		result.WriteByte(body[i])
+		i++
+	}
+	return result.String()
+}
+
+// references lists the names referred to (with "$") in the text.
+func references(text string) []string {
+	names := []string{}
+	for i := 0; i < len(text); {
+		if end, ok := skipQuoted(text, i); ok {
+			i = end
+			continue
+		}
+		if text[i] == '$' {
+			name, end := readName(text, i+1)
+			names = append(names, name)
+			i = end
+			continue
+		}
+		i++
+	}
+	return names
+}
+
+// readArguments reads the comma-separated arguments in the parentheses which
+// open at the given position, returning them along with the position after
+// the closing parenthesis. Commas within nested parentheses, brackets or
+// quotes don't separate arguments.
+func readArguments(text string, open int) ([]string, int, error) {
+	arguments := []string{}
+	closers := []byte{}
+	start := open + 1
+	for i := open; i < len(text); {
+		if end, ok := skipQuoted(text, i); ok {
+			i = end
+			continue
+		}
+		switch c := text[i]; c {
+		case '(':
+			closers = append(closers, ')')
+		case '[':
+			closers = append(closers, ']')
+		case ')', ']':
+			if closers[len(closers)-1] != c {
+				return nil, 0, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			closers = closers[:len(closers)-1]
+			if len(closers) == 0 {
+				last := strings.TrimSpace(text[start:i])
+				if last != "" || len(arguments) > 0 {
+					arguments = append(arguments, last)
+				}
+				for _, argument := range arguments {
+					if argument == "" {
+						return nil, 0, fmt.Errorf("empty argument")
+					}
+				}
+				return arguments, i + 1, nil
+			}
+		case ',':
+			if len(closers) == 1 {
+				arguments = append(arguments, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+		i++
+	}
+	return nil, 0, fmt.Errorf("expected \")\" to close the arguments")
+}
+
+// skipQuoted returns the position after the string or quoted identifier which
+// begins at the given position, if one does.
+func skipQuoted(text string, i int) (int, bool) {
+	quote := text[i]
+	if quote != '\'' && quote != '"' && quote != '`' {
+		return 0, false
+	}
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1, true
+		}
+	}
+	return len(text), true
+}
+
+// readName reads the name beginning at the given position, returning it along
+// with the position after it. The name is empty if there isn't one.
+func readName(text string, start int) (string, int) {
+	end := start
+	for end < len(text) && isNameCharacter(text[end], end == start) {
+		end++
+	}
+	return text[start:end], end
+}
+
+func isName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameCharacter(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameCharacter(c byte, first bool) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (!first && '0' <= c && c <= '9')
+}
+
+func contains(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package macro
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func TestExpand(t *testing.T) {
+	registry, err := NewRegistry([]Macro{
+		{Name: "rate", Parameters: []string{"metric"}, Body: "transform.rate($metric)"},
+		{Name: "standard_rate", Parameters: []string{"metric"}, Body: "aggregate.sum($rate($metric) group by dc)"},
+		{Name: "web", Body: "cpu[dc = 'west' and host match '^web$']"},
+		{Name: "quoted", Parameters: []string{"x"}, Body: "transform.alias($x, '$x')"},
+		{Name: "loop", Body: "$loop"},
+		{Name: "ping", Body: "$pong"},
+		{Name: "pong", Body: "$ping"},
+		{Name: "double", Parameters: []string{"m"}, Body: "$m + $m"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating the registry: %s", err.Error())
+	}
+	tests := []struct {
+		query    string
+		expected string
+		fails    string
+	}{
+		{query: "select cpu from -1h to now", expected: "select cpu from -1h to now"},
+		{query: "select $rate(requests)", expected: "select (transform.rate((requests)))"},
+		{query: "select $rate(requests) * 2", expected: "select (transform.rate((requests))) * 2"},
+		// Arguments may themselves contain commas, calls and macros.
+		{query: "select $rate(aggregate.max(a, b))", expected: "select (transform.rate((aggregate.max(a, b))))"},
+		{query: "select $rate(cpu[dc in ('a', 'b')])", expected: "select (transform.rate((cpu[dc in ('a', 'b')])))"},
+		{query: "select $rate($rate(x))", expected: "select (transform.rate(((transform.rate((x))))))"},
+		{query: "select $standard_rate(requests)", expected: "select (aggregate.sum((transform.rate(((requests)))) group by dc))"},
+		{query: "select $web", expected: "select (cpu[dc = 'west' and host match '^web$'])"},
+		{query: "select $web()", expected: "select (cpu[dc = 'west' and host match '^web$'])"},
+		// References inside strings and quoted identifiers are left alone.
+		{query: "select '$rate(x)', `$rate`", expected: "select '$rate(x)', `$rate`"},
+		{query: `select "it\"s $rate(x)"`, expected: `select "it\"s $rate(x)"`},
+		{query: "select $quoted(a)", expected: "select (transform.alias((a), '$x'))"},
+		// An argument can't break out of the expression it's given to.
+		{query: "select $rate(x) from 0 to 1)", expected: "select (transform.rate((x))) from 0 to 1)"},
+		{query: "select $rate(x))", expected: "select (transform.rate((x))))"},
+		{query: "select $rate(x]", fails: "unexpected"},
+		{query: "select $rate(x", fails: "expected \")\""},
+		{query: "select $rate('x)", fails: "expected \")\""},
+		{query: "select $rate", fails: "expects 1 arguments but got 0"},
+		{query: "select $rate(a, b)", fails: "expects 1 arguments but got 2"},
+		{query: "select $rate(a, )", fails: "empty argument"},
+		{query: "select $unknown(a)", fails: "unknown macro $unknown"},
+		{query: "select $ (a)", fails: "expected a macro name"},
+		{query: "select $loop", fails: "loop -> loop"},
+		{query: "select $ping", fails: "ping -> pong -> ping"},
+		{query: "select $double($double(x))", expected: "select ((((x) + (x))) + (((x) + (x))))"},
+		// Nesting a macro which doubles its argument grows exponentially.
+		{query: "select " + strings.Repeat("$double(", 30) + "x" + strings.Repeat(")", 30), fails: "longer than"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		expanded, err := registry.Expand(test.query)
+		if test.fails != "" {
+			if err == nil {
+				a.Errorf("Expected expansion to fail, but got %s", expanded)
+			} else if !strings.Contains(err.Error(), test.fails) {
+				a.Errorf("Expected error to mention %q, but got: %s", test.fails, err.Error())
+			}
+			continue
+		}
+		a.CheckError(err)
+		a.EqString(expanded, test.expected)
+	}
+
+	// Without a registry, queries are unchanged.
+	var none *Registry
+	expanded, err := none.Expand("select $rate(x)")
+	assert.New(t).CheckError(err)
+	assert.New(t).EqString(expanded, "select $rate(x)")
+}
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		macros []Macro
+		fails  string
+	}{
+		{macros: []Macro{{Name: "a", Body: "x"}, {Name: "b", Parameters: []string{"y"}, Body: "$a + $y"}}},
+		{macros: []Macro{{Name: "a-b", Body: "x"}}, fails: "invalid macro name"},
+		{macros: []Macro{{Name: "", Body: "x"}}, fails: "invalid macro name"},
+		{macros: []Macro{{Name: "a", Body: "x"}, {Name: "a", Body: "y"}}, fails: "more than once"},
+		{macros: []Macro{{Name: "a", Parameters: []string{"1x"}, Body: "x"}}, fails: "invalid parameter name"},
+		{macros: []Macro{{Name: "a", Parameters: []string{"x", "x"}, Body: "$x"}}, fails: "more than one parameter"},
+		{macros: []Macro{{Name: "a", Body: "$y"}}, fails: "neither a parameter nor a macro"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v", test.macros)
+		_, err := NewRegistry(test.macros)
+		if test.fails == "" {
+			a.CheckError(err)
+		} else if err == nil || !strings.Contains(err.Error(), test.fails) {
+			a.Errorf("Expected error to mention %q, but got: %v", test.fails, err)
+		}
+	}
+}
@@ -183,3 +183,42 @@ func (p RegexMatcher) Apply(tagset api.TagSet) bool {
 func (p RegexMatcher) Query() string {
 	return fmt.Sprintf("%s match %q", util.EscapeIdentifier(p.Tag), p.Regex.String())
 }
+
+// Constrains reports whether the predicate only accepts tagsets whose value
+// for the given tag is drawn from a bounded set. Negations and wildcard regexes
+// never constrain a tag, since they still admit arbitrarily many values.
+func Constrains(p Predicate, tag string) bool {
+	switch p := p.(type) {
+	case FalsePredicate:
+		return true
+	case ListMatcher:
+		return p.Tag == tag
+	case RegexMatcher:
+		return p.Tag == tag && !isWildcard(p.Regex)
+	case AndPredicate:
+		for _, child := range p.Predicates {
+			if Constrains(child, tag) {
+				return true
+			}
+		}
+		return false
+	case OrPredicate:
+		for _, child := range p.Predicates {
+			if !Constrains(child, tag) {
+				return false
+			}
+		}
+		return len(p.Predicates) > 0
+	default:
+		return false
+	}
+}
+
+// isWildcard reports whether the regex is one of the spellings of "match anything".
+func isWildcard(regex *regexp.Regexp) bool {
+	switch regex.String() {
+	case "", ".*", "^.*", ".*$", "^.*$", ".+", "^.+$":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+// tenantAuthorizer lets each principal see only the series tagged with its tenant.
+type tenantAuthorizer struct{}
+
+func (tenantAuthorizer) Visible(principal string, metric api.TaggedMetric) bool {
+	return metric.TagSet["tenant"] == principal
+}
+
+func TestCommandSelectAuthorizer(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "tenant": "a", "host": "a1"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "tenant": "a", "host": "a2"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "cpu", "tenant": "b", "host": "b1"}},
+		api.Timeseries{Values: []float64{8, 8, 8, 8, 8}, TagSet: api.TagSet{"metric": "cpu", "host": "shared"}},
+	)
+	tests := []struct {
+		query     string
+		principal string
+		limit     int
+		expected  []string // the hosts of the resulting series
+		fails     bool
+	}{
+		{query: `select cpu from 0 to 120 resolution 30ms`, principal: "a", expected: []string{"a1", "a2"}},
+		{query: `select cpu from 0 to 120 resolution 30ms`, principal: "b", expected: []string{"b1"}},
+		{query: `select cpu from 0 to 120 resolution 30ms`, principal: "c", expected: []string{}},
+		{query: `select cpu where host = "b1" from 0 to 120 resolution 30ms`, principal: "a", expected: []string{}},
+		{query: `select fetch.by_tag("name=cpu") from 0 to 120 resolution 30ms`, principal: "b", expected: []string{"b1"}},
+		// Only visible series count against the fetch limit.
+		{query: `select cpu from 0 to 120 resolution 30ms`, principal: "b", limit: 1, expected: []string{"b1"}},
+		{query: `select cpu from 0 to 120 resolution 30ms`, principal: "a", limit: 1, fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s as %s", test.query, test.principal)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		limit := test.limit
+		if limit == 0 {
+			limit = 1000
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           limit,
+			Authorizer:           tenantAuthorizer{},
+			Principal:            test.principal,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		hosts := []string{}
+		for _, series := range result.Body.([]command.QueryResult)[0].Series {
+			hosts = append(hosts, series.TagSet["host"])
+		}
+		a.Eq(hosts, test.expected)
+	}
+}
+
+func TestCommandSelectAuthorizerEstimateAndDescribe(t *testing.T) {
+	a := assert.New(t)
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "tenant": "a", "host": "a1"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "cpu", "tenant": "b", "host": "b1"}},
+	)
+	execution := command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Authorizer:           tenantAuthorizer{},
+		Principal:            "b",
+		Ctx:                  context.Background(),
+	}
+
+	estimate, err := parser.Parse(`select fetch.estimate_cost(cpu) from 0 to 120 resolution 30ms`)
+	a.CheckError(err)
+	result, err := estimate.Execute(execution)
+	a.CheckError(err)
+	for _, scalar := range result.Body.([]command.QueryResult)[0].Scalars {
+		if scalar.TagSet["estimate"] == "series" {
+			a.EqFloat(scalar.Value, 1, 0)
+		}
+	}
+
+	describe, err := parser.Parse(`describe cpu`)
+	a.CheckError(err)
+	result, err = describe.Execute(execution)
+	a.CheckError(err)
+	a.Eq(result.Body, map[string][]string{"tenant": {"b"}, "host": {"b1"}})
+}
+
+func TestCommandDescribeMetricsAuthorizer(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "tenant": "a", "host": "a1", "dc": "west"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "tenant": "b", "host": "b1", "dc": "west"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "memory", "tenant": "a", "host": "a1", "dc": "east"}},
+		api.Timeseries{Values: []float64{8, 8, 8, 8, 8}, TagSet: api.TagSet{"metric": "disk", "host": "shared", "dc": "west"}},
+	)
+	tests := []struct {
+		query     string
+		principal string
+		expected  []api.MetricKey
+	}{
+		{query: `describe all`, principal: "a", expected: []api.MetricKey{"cpu", "memory"}},
+		{query: `describe all`, principal: "b", expected: []api.MetricKey{"cpu"}},
+		{query: `describe all`, principal: "c", expected: []api.MetricKey{}},
+		{query: `describe all match 'mem'`, principal: "b", expected: []api.MetricKey{}},
+		{query: `describe metrics where dc = 'west'`, principal: "a", expected: []api.MetricKey{"cpu"}},
+		{query: `describe metrics where dc = 'east'`, principal: "a", expected: []api.MetricKey{"memory"}},
+		{query: `describe metrics where dc = 'east'`, principal: "b", expected: []api.MetricKey{}},
+		// b may see a cpu series, but not the one on host a1.
+		{query: `describe metrics where host = 'a1'`, principal: "b", expected: []api.MetricKey{}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s as %s", test.query, test.principal)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Authorizer:           tenantAuthorizer{},
+			Principal:            test.principal,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		metrics := result.Body.([]api.MetricKey)
+		sort.Sort(api.MetricKeys(metrics))
+		a.Eq(metrics, test.expected)
+		a.Eq(result.Metadata["count"], len(test.expected))
+	}
+}
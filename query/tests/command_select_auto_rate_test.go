@@ -0,0 +1,130 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+// kindMetadataAPI records the kinds of some metrics.
+type kindMetadataAPI struct {
+	mocks.FakeComboAPI
+	kinds map[api.MetricKey]metadata.MetricKind
+}
+
+func (k kindMetadataAPI) GetMetricKind(metricKey api.MetricKey, context metadata.Context) (metadata.MetricKind, error) {
+	if kind, ok := k.kinds[metricKey]; ok {
+		return kind, nil
+	}
+	return metadata.KindUnknown, nil
+}
+
+func TestSelectAutoRate(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 40, 10)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	n := math.NaN()
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 10, 30, 60, 100}, TagSet: api.TagSet{"metric": "requests", "dc": "east"}},
+		api.Timeseries{Values: []float64{5, 6, 5, 6, 5}, TagSet: api.TagSet{"metric": "temperature", "dc": "east"}},
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "mystery", "dc": "east"}},
+	)
+	metadataAPI := kindMetadataAPI{
+		FakeComboAPI: comboAPI,
+		kinds: map[api.MetricKey]metadata.MetricKind{
+			"requests":    metadata.KindCounter,
+			"temperature": metadata.KindGauge,
+		},
+	}
+	tests := []struct {
+		query    string
+		expected map[string][]float64 // by metric name
+		note     string
+	}{
+		{
+			query:    "select transform.auto_rate(requests) from 10 to 40 resolution 10ms",
+			expected: map[string][]float64{"": {1000, 2000, 3000, 4000}},
+		},
+		{
+			query:    "select transform.auto_rate(temperature) from 10 to 40 resolution 10ms",
+			expected: map[string][]float64{"": {6, 5, 6, 5}},
+		},
+		{
+			query:    "select transform.auto_rate(mystery) from 10 to 40 resolution 10ms",
+			expected: map[string][]float64{"": {2, 3, 4, 5}},
+			note:     `the kind of metric "mystery" is unknown`,
+		},
+		{
+			query: `select transform.auto_rate(fetch.by_tag("dc=east")) from 10 to 40 resolution 10ms`,
+			expected: map[string][]float64{
+				"requests":    {1000, 2000, 3000, 4000},
+				"temperature": {6, 5, 6, 5},
+				"mystery":     {2, 3, 4, 5},
+			},
+			note: `the kind of metric "mystery" is unknown`,
+		},
+		{
+			query:    "select transform.auto_rate(aggregate.sum(requests)) from 10 to 40 resolution 10ms",
+			expected: map[string][]float64{"": {10, 30, 60, 100}},
+			note:     "no known metric",
+		},
+		{
+			query:    "select transform.auto_rate(requests) from 0 to 20 resolution 10ms",
+			expected: map[string][]float64{"": {n, 1000, 2000}},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    metadataAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.EqInt(len(value.Series), len(test.expected))
+		for _, series := range value.Series {
+			a.EqFloatArray(series.Values, test.expected[series.TagSet["name"]], 1e-9)
+		}
+		notes := strings.Join(result.Metadata["notes"].([]string), "\n")
+		if test.note == "" && notes != "" {
+			a.Errorf("Expected no notes but got %s", notes)
+		}
+		if !strings.Contains(notes, test.note) {
+			a.Errorf("Expected a note containing %q but got %s", test.note, notes)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectByTag(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "role": "web-1"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "role": "db-1"}},
+		api.Timeseries{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"metric": "cpu", "dc": "nyc", "role": "web-2"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "memory", "dc": "sfo", "role": "web-1"}},
+		api.Timeseries{Values: []float64{5, 5, 5, 5, 5}, TagSet: api.TagSet{"metric": "disk", "dc": "sfo"}},
+	)
+	tests := []struct {
+		query    string
+		expected []api.Timeseries
+		fails    bool
+	}{
+		{
+			query: `select fetch.by_tag("name=cpu", "dc=sfo", "role=~web.*") from 0 to 120 resolution 30ms`,
+			expected: []api.Timeseries{
+				{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"name": "cpu", "dc": "sfo", "role": "web-1"}},
+			},
+		},
+		{
+			query: `select fetch.by_tag("dc=sfo", "role=~web") from 0 to 120 resolution 30ms`,
+			expected: []api.Timeseries{
+				{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"name": "cpu", "dc": "sfo", "role": "web-1"}},
+				{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"name": "memory", "dc": "sfo", "role": "web-1"}},
+			},
+		},
+		{
+			query: `select fetch.by_tag("dc=sfo", "name!=cpu", "role!~web") from 0 to 120 resolution 30ms`,
+			expected: []api.Timeseries{
+				{Values: []float64{5, 5, 5, 5, 5}, TagSet: api.TagSet{"name": "disk", "dc": "sfo"}},
+			},
+		},
+		{
+			query: `select fetch.by_tag("dc!=sfo") from 0 to 120 resolution 30ms`,
+			fails: true,
+		},
+		{
+			query: `select fetch.by_tag("dc") from 0 to 120 resolution 30ms`,
+			fails: true,
+		},
+		{
+			query: `select fetch.by_tag("dc=sfo", "role=~(") from 0 to 120 resolution 30ms`,
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Timeout:              0,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)
+		a.EqInt(len(body), 1)
+		a.Eq(body[0].Series, test.expected)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectCollapseTags(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "app": "web", "instance": "1"}},
+		api.Timeseries{Values: []float64{10, 20, 30}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "app": "web", "instance": "2"}},
+		api.Timeseries{Values: []float64{5, 5, 5}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "app": "db", "instance": "3"}},
+		api.Timeseries{Values: []float64{7, 7, 7}, TagSet: api.TagSet{"metric": "cpu", "dc": "nyc", "app": "web", "instance": "4"}},
+	)
+	tests := []struct {
+		query    string
+		expected map[string][]float64 // by serialized tagset
+		fails    bool
+	}{
+		{
+			query: `select aggregate.collapse_tags(cpu, "sum", "instance") from 0 to 60 resolution 30ms`,
+			expected: map[string][]float64{
+				"app=web,dc=sfo": {11, 22, 33},
+				"app=db,dc=sfo":  {5, 5, 5},
+				"app=web,dc=nyc": {7, 7, 7},
+			},
+		},
+		{
+			query: `select aggregate.collapse_tags(cpu, "max", "instance, dc") from 0 to 60 resolution 30ms`,
+			expected: map[string][]float64{
+				"app=web": {10, 20, 30},
+				"app=db":  {5, 5, 5},
+			},
+		},
+		{
+			query: `select aggregate.collapse_tags(cpu, "median", "instance") from 0 to 60 resolution 30ms`,
+			fails: true,
+		},
+		{
+			query: `select aggregate.collapse_tags(cpu, "sum", " , ") from 0 to 60 resolution 30ms`,
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		series := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(series), len(test.expected))
+		for _, s := range series {
+			expected, ok := test.expected[s.TagSet.Serialize()]
+			if !ok {
+				a.Errorf("Unexpected series %s", s.TagSet.Serialize())
+				continue
+			}
+			a.EqFloatArray(s.Values, expected, 1e-10)
+		}
+	}
+}
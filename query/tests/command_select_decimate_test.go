@@ -0,0 +1,88 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectDecimate(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(30, 270, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	nan := math.NaN()
+	tests := []struct {
+		query      string
+		expected   []float64
+		start      int64 // of the result's timerange
+		resolution int64 // of the result's timerange
+		fails      string
+	}{
+		// The values at multiples of 120ms are kept.
+		{query: `select transform.decimate(cpu, 4) from 30 to 270 resolution 30ms`, expected: []float64{4, 8}, start: 120, resolution: 120},
+		{query: `select transform.decimate(cpu, 1) from 30 to 270 resolution 30ms`, expected: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9}, start: 30, resolution: 30},
+		// Combined with other series, the dropped values are NaN.
+		{query: `select transform.decimate(cpu, 4) + 0 from 30 to 270 resolution 30ms`, expected: []float64{nan, nan, nan, 4, nan, nan, nan, 8, nan}, start: 30, resolution: 30},
+		{query: `select transform.decimate(cpu, 0) from 30 to 270 resolution 30ms`, fails: "positive integer"},
+		{query: `select transform.decimate(cpu, 2.5) from 30 to 270 resolution 30ms`, fails: "positive integer"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if test.fails != "" {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			} else if !strings.Contains(err.Error(), test.fails) {
+				a.Errorf("Expected error to mention %q, but got: %s", test.fails, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)[0]
+		a.EqInt(len(body.Series), 1)
+		if len(body.Series) == 1 {
+			a.EqFloatArray(body.Series[0].Values, test.expected, 0)
+		}
+		a.EqInt(int(body.Timerange.StartMillis()), int(test.start))
+		a.EqInt(int(body.Timerange.ResolutionMillis()), int(test.resolution))
+	}
+}
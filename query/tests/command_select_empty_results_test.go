@@ -0,0 +1,108 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectEmptyResults(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{4, 5, 6}, TagSet: api.TagSet{"metric": "memory", "host": "a"}},
+	)
+	tests := []struct {
+		query  string
+		policy function.EmptyResultPolicy
+		series int
+		notes  []string
+		fails  bool
+	}{
+		{query: `select cpu[host = "b"] from 0 to 60 resolution 30ms`, notes: []string{`fetch of cpu[host = "b"] matched no series`}},
+		{query: `select cpu[host = "b"] from 0 to 60 resolution 30ms`, policy: function.NoteEmptyResults, notes: []string{`fetch of cpu[host = "b"] matched no series`}},
+		{query: `select cpu[host = "b"] from 0 to 60 resolution 30ms`, policy: function.AllowEmptyResults},
+		{query: `select cpu[host = "b"] from 0 to 60 resolution 30ms`, policy: function.RejectEmptyResults, fails: true},
+		{query: `select cpu[host = "a"] from 0 to 60 resolution 30ms`, policy: function.RejectEmptyResults, series: 1},
+		// An unknown metric is treated like any other fetch which matches no series.
+		{query: `select disk from 0 to 60 resolution 30ms`, notes: []string{`fetch of disk matched no series`}},
+		{query: `select disk from 0 to 60 resolution 30ms`, policy: function.AllowEmptyResults},
+		{query: `select disk from 0 to 60 resolution 30ms`, policy: function.RejectEmptyResults, fails: true},
+		// The metrics fetched by fetch.by_tag are only checked together.
+		{query: `select fetch.by_tag("host=a") from 0 to 60 resolution 30ms`, policy: function.RejectEmptyResults, series: 2},
+		{query: `select fetch.by_tag("host=b") from 0 to 60 resolution 30ms`, notes: []string{`fetch.by_tag("host=b") matched no series`}},
+		{query: `select fetch.matching("c*") from 0 to 60 resolution 30ms`, policy: function.RejectEmptyResults, series: 1},
+		{query: `select fetch.matching("disk.*") from 0 to 60 resolution 30ms`, policy: function.RejectEmptyResults, fails: true},
+		// fetch.coalesce expects its primary to be empty.
+		{
+			query:  `select fetch.coalesce(cpu[host = "b"], memory) from 0 to 60 resolution 30ms`,
+			policy: function.RejectEmptyResults,
+			series: 1,
+			notes:  []string{"fetch.coalesce used memory for 1 series"},
+		},
+		{
+			query:  `select fetch.coalesce(disk, memory) from 0 to 60 resolution 30ms`,
+			policy: function.RejectEmptyResults,
+			series: 1,
+			notes:  []string{"fetch.coalesce used memory for 1 series"},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s with policy %q", test.query, test.policy)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			EmptyResults:         test.policy,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if _, ok := err.(function.EmptyResultError); !ok {
+				a.Errorf("expected an EmptyResultError but got %+v", err)
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)
+		a.EqInt(len(body[0].Series), test.series)
+		notes := result.Metadata["notes"].([]string)
+		a.EqInt(len(notes), len(test.notes))
+		for i := range test.notes {
+			if i < len(notes) {
+				a.EqString(notes[i], test.notes[i])
+			}
+		}
+	}
+}
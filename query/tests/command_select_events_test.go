@@ -0,0 +1,99 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestSelectEvents(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu"}},
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "tenant": "a"}},
+	)
+	source := mocks.EventSource{
+		"deploy": {
+			{Timestamp: 90, Name: "deploy", TagSet: api.TagSet{"app": "web"}, Description: "v2"},
+			{Timestamp: 45, Name: "deploy", TagSet: api.TagSet{"app": "web"}, Description: "v1"},
+			{Timestamp: 500, Name: "deploy", TagSet: api.TagSet{"app": "web"}, Description: "v3"},
+			{Timestamp: 60, Name: "deploy", TagSet: api.TagSet{"app": "web", "tenant": "a"}, Description: "a1"},
+			{Timestamp: 75, Name: "deploy", TagSet: api.TagSet{"app": "web", "tenant": "b"}, Description: "b1"},
+		},
+	}
+	tests := []struct {
+		query     string
+		source    function.EventSource
+		principal string   // if set, only the principal's tenant is visible
+		expected  []string // the descriptions of the events
+		fails     bool
+	}{
+		{query: `select cpu, events.fetch("deploy") from 0 to 120 resolution 30ms`, source: source, expected: []string{"v1", "a1", "b1", "v2"}},
+		{query: `select cpu[tenant = "a"], events.fetch("deploy") from 0 to 120 resolution 30ms`, source: source, principal: "a", expected: []string{"a1"}},
+		{query: `select cpu, events.fetch("restart") from 0 to 120 resolution 30ms`, source: source, expected: []string{}},
+		{query: `select cpu, events.fetch("deploy") from 0 to 120 resolution 30ms`, fails: true},
+		{query: `select cpu + events.fetch("deploy") from 0 to 120 resolution 30ms`, source: source, fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		executionContext := command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			EventSource:          test.source,
+			Ctx:                  context.Background(),
+		}
+		if test.principal != "" {
+			executionContext.Authorizer = tenantAuthorizer{}
+			executionContext.Principal = test.principal
+		}
+		result, err := commandObject.Execute(executionContext)
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)
+		a.EqString(body[0].Type, "series")
+		a.EqString(body[1].Type, "events")
+		descriptions := []string{}
+		for _, event := range body[1].Events {
+			descriptions = append(descriptions, event.Description)
+		}
+		a.Eq(descriptions, test.expected)
+	}
+}
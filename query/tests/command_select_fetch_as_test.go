@@ -0,0 +1,120 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// samplingStorage reports the sample method it was asked to use as the value
+// of every point, so that tests can tell which method each fetch used.
+type samplingStorage struct{}
+
+func (s samplingStorage) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
+	return requested.Resolution(), nil
+}
+
+func (s samplingStorage) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
+	values := make([]float64, request.Timerange.Slots())
+	for i := range values {
+		values[i] = float64(request.SampleMethod)
+	}
+	return api.Timeseries{Values: values, TagSet: request.Metric.TagSet}, nil
+}
+
+func (s samplingStorage) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	list := api.SeriesList{}
+	for _, single := range request.ToSingle() {
+		series, err := s.FetchSingleTimeseries(single)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		list.Series = append(list.Series, series)
+	}
+	return list, nil
+}
+
+func (s samplingStorage) CheckHealthy() error {
+	return nil
+}
+
+func TestCommandSelectFetchAs(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{0, 0, 0, 0, 0}, TagSet: api.TagSet{"metric": "requests", "host": "a"}},
+		api.Timeseries{Values: []float64{0, 0, 0, 0, 0}, TagSet: api.TagSet{"metric": "latency", "host": "a"}},
+	)
+	max, min, mean := float64(timeseries.SampleMax), float64(timeseries.SampleMin), float64(timeseries.SampleMean)
+	tests := []struct {
+		query    string
+		expected float64
+		fails    string
+	}{
+		{query: `select requests from 0 to 120 resolution 30ms`, expected: mean},
+		{query: `select requests from 0 to 120 resolution 30ms sample by 'min'`, expected: min},
+		{query: `select fetch.as(requests, "max") from 0 to 120 resolution 30ms`, expected: max},
+		{query: `select fetch.as(requests, "mean") from 0 to 120 resolution 30ms sample by 'min'`, expected: mean},
+		{query: `select fetch.as(requests, "max") + latency from 0 to 120 resolution 30ms sample by 'min'`, expected: max + min},
+		// The same fetch with different sample methods isn't shared.
+		{query: `select fetch.as(requests, "max") + requests from 0 to 120 resolution 30ms`, expected: max + mean},
+		{query: `select fetch.as(fetch.as(requests, "min"), "max") from 0 to 120 resolution 30ms`, expected: min},
+		{query: `select fetch.as(requests, "median") from 0 to 120 resolution 30ms`, fails: "median"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: samplingStorage{},
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if test.fails != "" {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			} else if !strings.Contains(err.Error(), test.fails) {
+				a.Errorf("Expected error to mention %q, but got: %s", test.fails, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		list := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(list), 1)
+		if len(list) == 1 {
+			a.EqFloatArray(list[0].Values, []float64{test.expected, test.expected, test.expected, test.expected, test.expected}, 0)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectEstimateCost(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "host": "a"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "host": "b"}},
+		api.Timeseries{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"metric": "cpu", "dc": "nyc", "host": "c"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "memory", "dc": "sfo", "host": "a"}},
+	)
+	tests := []struct {
+		query  string
+		series float64
+	}{
+		{`select fetch.estimate_cost(cpu) from 0 to 120 resolution 30ms`, 3},
+		{`select fetch.estimate_cost(cpu[dc = "sfo"]) from 0 to 120 resolution 30ms`, 2},
+		{`select fetch.estimate_cost(aggregate.sum(cpu group by dc) + memory) from 0 to 120 resolution 30ms`, 4},
+		{`select fetch.estimate_cost(cpu + cpu {again}) from 0 to 120 resolution 30ms`, 3},
+		{`select fetch.estimate_cost(cpu) where host = "a" from 0 to 120 resolution 30ms`, 1},
+		{`select fetch.estimate_cost(nosuch) from 0 to 120 resolution 30ms`, 0},
+		{`select fetch.estimate_cost(cpu + nosuch) from 0 to 120 resolution 30ms`, 3},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		scalars := result.Body.([]command.QueryResult)[0].Scalars
+		a.EqInt(len(scalars), 2)
+		for _, scalar := range scalars {
+			switch scalar.TagSet["estimate"] {
+			case "series":
+				a.EqFloat(scalar.Value, test.series, 0)
+			case "points":
+				a.EqFloat(scalar.Value, test.series*5, 0)
+			default:
+				a.Errorf("Unexpected scalar %+v", scalar)
+			}
+		}
+	}
+}
+
+func TestCommandSelectHighCardinalityGuard(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "host": "a"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "dc": "sfo", "host": "b"}},
+		api.Timeseries{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"metric": "cpu", "dc": "nyc", "host": "c"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "memory", "dc": "sfo", "host": "a"}},
+		api.Timeseries{Values: []float64{5, 5, 5, 5, 5}, TagSet: api.TagSet{"metric": "disk", "dc": "sfo"}},
+	)
+	tests := []struct {
+		query  string
+		series int
+		fails  bool
+	}{
+		{query: `select cpu from 0 to 120 resolution 30ms`, fails: true},
+		{query: `select cpu where host = "a" from 0 to 120 resolution 30ms`, series: 1},
+		{query: `select cpu[host in ("a", "b")] from 0 to 120 resolution 30ms`, series: 2},
+		{query: `select cpu where host match "[ab]" from 0 to 120 resolution 30ms`, series: 2},
+		{query: `select cpu where host match ".*" from 0 to 120 resolution 30ms`, fails: true},
+		{query: `select cpu where not host = "a" from 0 to 120 resolution 30ms`, fails: true},
+		{query: `select cpu where host = "a" or host = "c" from 0 to 120 resolution 30ms`, series: 2},
+		{query: `select cpu where host = "a" or dc = "nyc" from 0 to 120 resolution 30ms`, fails: true},
+		{query: `select cpu where dc = "sfo" and host = "b" from 0 to 120 resolution 30ms`, series: 1},
+		{query: `select memory from 0 to 120 resolution 30ms`, series: 1}, // a single host can't be unbounded
+		{query: `select disk from 0 to 120 resolution 30ms`, series: 1},   // disk has no host tag at all
+		{query: `select fetch.unbounded(cpu) from 0 to 120 resolution 30ms`, series: 3},
+		{query: `select fetch.unbounded(cpu + 1) from 0 to 120 resolution 30ms`, series: 3},
+		{query: `select fetch.by_tag("dc=sfo") from 0 to 120 resolution 30ms`, fails: true},
+		{query: `select fetch.unbounded(fetch.by_tag("dc=sfo")) from 0 to 120 resolution 30ms`, series: 4},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			HighCardinalityTags:  []string{"host"},
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			} else if !strings.Contains(err.Error(), `"host"`) {
+				a.Errorf("Expected error to name the unconstrained tag, but got: %s", err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		a.EqInt(len(result.Body.([]command.QueryResult)[0].Series), test.series)
+	}
+}
+
+func TestCommandSelectCoalesce(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	n := math.NaN()
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1}, TagSet: api.TagSet{"metric": "requests.new", "host": "a"}},
+		api.Timeseries{Values: []float64{n, n, n}, TagSet: api.TagSet{"metric": "requests.new", "host": "b"}},
+		api.Timeseries{Values: []float64{7, 7, 7}, TagSet: api.TagSet{"metric": "requests.old", "host": "a"}},
+		api.Timeseries{Values: []float64{8, 8, 8}, TagSet: api.TagSet{"metric": "requests.old", "host": "b"}},
+		api.Timeseries{Values: []float64{9, 9, 9}, TagSet: api.TagSet{"metric": "requests.old", "host": "c"}},
+	)
+	tests := []struct {
+		query    string
+		expected map[string][]float64 // by host
+		notes    int
+	}{
+		{
+			query:    "select fetch.coalesce(requests.new[host = 'a'], requests.old) from 0 to 60 resolution 30ms",
+			expected: map[string][]float64{"a": {1, 1, 1}},
+		},
+		{
+			query:    "select fetch.coalesce(requests.new, requests.old) from 0 to 60 resolution 30ms",
+			expected: map[string][]float64{"a": {1, 1, 1}, "b": {8, 8, 8}, "c": {9, 9, 9}},
+			notes:    1,
+		},
+		{
+			query:    "select fetch.coalesce(requests.new[host = 'z'], requests.old[host = 'c']) from 0 to 60 resolution 30ms",
+			expected: map[string][]float64{"c": {9, 9, 9}},
+			notes:    1,
+		},
+		{
+			query:    "select fetch.coalesce(requests.renamed, requests.old[host = 'a']) from 0 to 60 resolution 30ms",
+			expected: map[string][]float64{"a": {7, 7, 7}},
+			notes:    1,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		series := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(series), len(test.expected))
+		for _, s := range series {
+			if expected, ok := test.expected[s.TagSet["host"]]; ok {
+				a.EqFloatArray(s.Values, expected, 0)
+			} else {
+				a.Errorf("Unexpected series %+v", s.TagSet)
+			}
+		}
+		notes, _ := result.Metadata["notes"].([]string)
+		a.EqInt(len(notes), test.notes)
+	}
+}
+
+func TestCommandSelectMatching(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "requests.http", "dc": "sfo"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "requests.http", "dc": "nyc"}},
+		api.Timeseries{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"metric": "requests.grpc.v2", "dc": "sfo"}},
+		api.Timeseries{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"metric": "errors.http", "dc": "sfo"}},
+	)
+	tests := []struct {
+		query      string
+		fetchLimit int
+		expected   []api.Timeseries
+		fails      bool
+	}{
+		{
+			query: `select fetch.matching("requests.*") from 0 to 120 resolution 30ms`,
+			expected: []api.Timeseries{
+				{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"name": "requests.grpc.v2", "dc": "sfo"}},
+				{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"name": "requests.http", "dc": "sfo"}},
+				{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"name": "requests.http", "dc": "nyc"}},
+			},
+		},
+		{
+			query: `select fetch.matching("*.http", "source") | aggregate.sum(group by source) from 0 to 120 resolution 30ms`,
+			expected: []api.Timeseries{
+				{Values: []float64{4, 4, 4, 4, 4}, TagSet: api.TagSet{"source": "errors.http"}},
+				{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"source": "requests.http"}},
+			},
+		},
+		{
+			query:    `select fetch.matching("latency.*") from 0 to 120 resolution 30ms`,
+			expected: []api.Timeseries{},
+		},
+		{
+			// Every fetch counts against the fetch limit.
+			query:      `select fetch.matching("requests.*") from 0 to 120 resolution 30ms`,
+			fetchLimit: 2,
+			fails:      true,
+		},
+		{
+			query: `select fetch.matching("requests.[") from 0 to 120 resolution 30ms`,
+			fails: true,
+		},
+		{
+			query: `select fetch.matching("requests.*", "") from 0 to 120 resolution 30ms`,
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		fetchLimit := test.fetchLimit
+		if fetchLimit == 0 {
+			fetchLimit = 1000
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           fetchLimit,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)
+		a.EqInt(len(body), 1)
+		a.Eq(body[0].Series, test.expected)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectFetchTimeout(t *testing.T) {
+	a := assert.New(t)
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	// series_timeout is slow to fetch, but series_1 is not.
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "series_1", "dc": "west"}},
+	)
+	testCommand, err := parser.Parse("select series_1, series_timeout from 0 to 120 resolution 30ms")
+	if err != nil {
+		t.Fatalf("Unexpected error while parsing: %s", err.Error())
+	}
+
+	// With only an overall timeout, the slow fetch fails the entire query.
+	_, err = testCommand.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Timeout:              50 * time.Millisecond,
+		Ctx:                  context.Background(),
+	})
+	if err == nil {
+		t.Fatalf("Expected the query to time out, but it succeeded")
+	}
+
+	// With a shorter fetch timeout, only the slow fetch is abandoned. The
+	// storage fails with the fetch's context's error as soon as it expires, so
+	// its error may arrive before the timeout is noticed; it's repeated so that
+	// both orders are seen.
+	for attempt := 0; attempt < 20; attempt++ {
+		a := a.Contextf("attempt %d", attempt)
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Timeout:              5 * time.Second,
+			FetchTimeout:         10 * time.Millisecond,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error on attempt %d: %s", attempt, err.Error())
+		}
+		body := result.Body.([]command.QueryResult)
+		a.EqInt(len(body), 2)
+		a.Eq(body[0].Series, []api.Timeseries{{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"dc": "west"}}})
+		a.EqInt(len(body[1].Series), 1)
+		nan := math.NaN()
+		a.EqFloatArray(body[1].Series[0].Values, []float64{nan, nan, nan, nan, nan}, 0)
+		a.EqInt(len(result.Metadata["notes"].([]string)), 1)
+	}
+}
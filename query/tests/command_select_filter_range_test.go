@@ -166,6 +166,65 @@ func TestCommandSelectFilterRange(t *testing.T) {
 			Query:    `select A | filter.mean_below(12) from 3000000 to 3270000 resolution 30s`,
 			Expected: []string{"falling", "rising", "medium", "high"},
 		},
+		// Min above and below (A)
+		{
+			Query:    `select A | filter.min_above(3) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"high", "medium"},
+		},
+		{
+			Query:    `select A | filter.min_below(0) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"falling", "rising"},
+		},
+		// Current above and below (A)
+		{
+			Query:    `select A | filter.current_above(6) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"rising", "high"},
+		},
+		{
+			Query:    `select A | filter.current_below(5) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"falling", "medium"},
+		},
+		{
+			Query:    `select A | filter.current_below(-2) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{},
+		},
+		// Graphite's names (A)
+		{
+			Query:    `select A | averageAbove(4.55) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"high", "medium"},
+		},
+		{
+			Query:    `select A | averageBelow(4.55) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"falling", "rising"},
+		},
+		{
+			Query:    `select A | maximumAbove(8.5) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"rising", "falling"},
+		},
+		{
+			Query:    `select A | maximumBelow(8.5) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"medium", "high"},
+		},
+		{
+			Query:    `select A | minimumAbove(3) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"high", "medium"},
+		},
+		{
+			Query:    `select A | minimumBelow(0) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"falling", "rising"},
+		},
+		{
+			Query:    `select A | currentAbove(6) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"rising", "high"},
+		},
+		{
+			Query:    `select A | currentBelow(5) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"falling", "medium"},
+		},
+		{
+			Query:    `select B | averageAbove(4.45, 150s) from 3000000 to 3270000 resolution 30s`,
+			Expected: []string{"high", "low-high"},
+		},
 		// Mean above recent (B)
 		{
 			Query:    `select B | filter.mean_above(0, 150s) from 3000000 to 3270000 resolution 30s`,
@@ -217,3 +276,86 @@ func TestCommandSelectFilterRange(t *testing.T) {
 		a.Contextf("Query %q", test.Query).Eq(tags, test.Expected)
 	}
 }
+
+func TestCommandSelectFilterLimit(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error constructing test timerange: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 1, 1}, TagSet: api.TagSet{"metric": "A", "host": "c"}},
+		api.Timeseries{Values: []float64{2, 2, 2}, TagSet: api.TagSet{"metric": "A", "host": "a"}},
+		api.Timeseries{Values: []float64{3, 3, 3}, TagSet: api.TagSet{"metric": "A", "host": "b"}},
+	)
+	tests := []struct {
+		query    string
+		expected []string // hosts
+		notes    int
+		fails    bool
+	}{
+		{query: "select A | filter.limit(2) from 0 to 60 resolution 30ms", expected: []string{"a", "b"}, notes: 1},
+		{query: "select A | filter.limit(3) from 0 to 60 resolution 30ms", expected: []string{"a", "b", "c"}},
+		{query: "select A | filter.limit(0) from 0 to 60 resolution 30ms", expected: []string{}, notes: 1},
+		{query: "select A | filter.limit(1.5) from 0 to 60 resolution 30ms", fails: true},
+		{query: "select A | filter.limit(-1) from 0 to 60 resolution 30ms", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		hosts := []string{}
+		for _, series := range result.Body.([]command.QueryResult)[0].Series {
+			hosts = append(hosts, series.TagSet["host"])
+		}
+		a.Eq(hosts, test.expected)
+		a.EqInt(len(result.Metadata["notes"].([]string)), test.notes)
+	}
+}
+
+func TestCommandSelectFilterDedupe(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error constructing test timerange: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 1, 1}, TagSet: api.TagSet{"metric": "A", "host": "a", "dc": "east"}},
+		api.Timeseries{Values: []float64{2, 2, 2}, TagSet: api.TagSet{"metric": "A", "host": "b", "dc": "east"}},
+		api.Timeseries{Values: []float64{3, 3, 3}, TagSet: api.TagSet{"metric": "A", "host": "c", "dc": "west"}},
+	)
+	commandObject, err := parser.Parse("select A | tag.drop('host') | filter.dedupe from 0 to 60 resolution 30ms")
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err.Error())
+	}
+	result, err := commandObject.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           100,
+		Ctx:                  context.Background(),
+	})
+	if err != nil {
+		t.Fatalf("Error evaluating command: %s", err.Error())
+	}
+	a.EqInt(len(result.Body.([]command.QueryResult)[0].Series), 2)
+	a.Eq(result.Metadata["notes"], []string{"filter.dedupe collapsed 1 duplicate series"})
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Integration test for the query execution.
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectFilterUnion(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error constructing test timerange: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{3, 3, 3}, TagSet: api.TagSet{"metric": "memory", "host": "a"}},
+	)
+	tests := []struct {
+		query   string
+		tagSets []api.TagSet
+		notes   []string
+		fails   bool
+	}{
+		{
+			query:   "select filter.union(cpu, memory) from 0 to 60 resolution 30ms",
+			tagSets: []api.TagSet{{"host": "a", "metric": "cpu"}, {"host": "b"}, {"host": "a", "metric": "memory"}},
+			notes:   []string{`filter.union found 1 tagsets in both lists; their series were tagged with "metric"`},
+		},
+		{
+			query:   "select filter.union(cpu, memory, 'tag', 'source') from 0 to 60 resolution 30ms",
+			tagSets: []api.TagSet{{"host": "a", "source": "cpu"}, {"host": "b"}, {"host": "a", "source": "memory"}},
+			notes:   []string{`filter.union found 1 tagsets in both lists; their series were tagged with "source"`},
+		},
+		{
+			query:   "select filter.union(cpu, memory, 'dedupe') from 0 to 60 resolution 30ms",
+			tagSets: []api.TagSet{{"host": "a"}, {"host": "b"}},
+			notes:   []string{"filter.union found 1 tagsets in both lists; their series were collapsed"},
+		},
+		{
+			query:   "select filter.union(cpu[host = 'b'], memory, 'error') from 0 to 60 resolution 30ms",
+			tagSets: []api.TagSet{{"host": "b"}, {"host": "a"}},
+		},
+		{
+			query:   "select filter.union(cpu, cpu) from 0 to 60 resolution 30ms",
+			tagSets: []api.TagSet{{"host": "a", "metric": "1"}, {"host": "b", "metric": "1"}, {"host": "a", "metric": "2"}, {"host": "b", "metric": "2"}},
+			notes:   []string{`filter.union found 2 tagsets in both lists; their series were tagged with "metric"`},
+		},
+		{query: "select filter.union(cpu, memory, 'error') from 0 to 60 resolution 30ms", fails: true},
+		{query: "select filter.union(cpu, memory, 'merge') from 0 to 60 resolution 30ms", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		list := result.Body.([]command.QueryResult)[0].Series
+		tagSets := []api.TagSet{}
+		for _, series := range list {
+			tagSets = append(tagSets, series.TagSet)
+		}
+		a.Eq(tagSets, test.tagSets)
+		a.Eq(result.Metadata["notes"], test.notes)
+	}
+}
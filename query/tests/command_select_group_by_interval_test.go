@@ -0,0 +1,91 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectGroupByInterval(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(30000, 180000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5, 6}, TagSet: api.TagSet{"metric": "requests", "dc": "sfo", "host": "a"}},
+		api.Timeseries{Values: []float64{10, 20, 30, 40, 50, 60}, TagSet: api.TagSet{"metric": "requests", "dc": "sfo", "host": "b"}},
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "requests", "dc": "nyc", "host": "c"}},
+	)
+	tests := []struct {
+		query    string
+		expected map[string][]float64 // by dc
+		fails    bool
+	}{
+		{
+			// Buckets are aligned to whole minutes, so the first holds only the point at 30s.
+			query:    `select aggregate.group_by_interval(requests, 1m, "sum" group by dc) from 30000 to 180000 resolution 30s`,
+			expected: map[string][]float64{"sfo": {11, 55, 55, 99, 99, 66}, "nyc": {1, 2, 2, 2, 2, 1}},
+		},
+		{
+			query:    `select aggregate.group_by_interval(requests, 90s, "max" group by dc) from 30000 to 180000 resolution 30s`,
+			expected: map[string][]float64{"sfo": {20, 20, 50, 50, 50, 60}, "nyc": {1, 1, 1, 1, 1, 1}},
+		},
+		{
+			query: `select aggregate.group_by_interval(requests, 1m, "median" group by dc) from 30000 to 180000 resolution 30s`,
+			fails: true,
+		},
+		{
+			query: `select aggregate.group_by_interval(requests, 45s, "sum" group by dc) from 30000 to 180000 resolution 30s`,
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		series := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(series), len(test.expected))
+		for _, s := range series {
+			a.EqFloatArray(s.Values, test.expected[s.TagSet["dc"]], 1e-10)
+		}
+	}
+}
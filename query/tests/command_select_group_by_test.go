@@ -0,0 +1,207 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectGroupByAbsentTagNote(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "requests", "host": "a", "app": "web"}},
+		api.Timeseries{Values: []float64{4, 5, 6}, TagSet: api.TagSet{"metric": "requests", "host": "b"}},
+	)
+	tests := []struct {
+		query  string
+		series int
+		notes  []string
+	}{
+		{
+			query:  "select aggregate.sum(requests group by dc) from 0 to 60 resolution 30ms",
+			series: 1,
+			notes:  []string{"group-by tag 'dc' not present on any series; results collapsed"},
+		},
+		{
+			query:  "select aggregate.sum(requests group by dc, host, zone) from 0 to 60 resolution 30ms",
+			series: 2,
+			notes: []string{
+				"group-by tag 'dc' not present on any series; results collapsed",
+				"group-by tag 'zone' not present on any series; results collapsed",
+			},
+		},
+		{
+			// A tag which only some series have is fine.
+			query:  "select aggregate.sum(requests group by app) from 0 to 60 resolution 30ms",
+			series: 2,
+		},
+		{
+			query:  "select aggregate.sum(requests collapse by dc) from 0 to 60 resolution 30ms",
+			series: 2,
+		},
+		{
+			query:  "select aggregate.group_by_interval(requests, 60ms, 'sum' group by dc) from 0 to 60 resolution 30ms",
+			series: 1,
+			notes:  []string{"group-by tag 'dc' not present on any series; results collapsed"},
+		},
+	}
+	for _, test := range tests {
+		for _, streaming := range []bool{false, true} {
+			a := assert.New(t).Contextf("%s (streaming: %t)", test.query, streaming)
+			commandObject, err := parser.Parse(test.query)
+			if err != nil {
+				t.Fatalf("Error parsing command: %s", err.Error())
+			}
+			result, err := commandObject.Execute(command.ExecutionContext{
+				TimeseriesStorageAPI: comboAPI,
+				MetricMetadataAPI:    comboAPI,
+				FetchLimit:           100,
+				StreamAggregations:   streaming,
+				Ctx:                  context.Background(),
+			})
+			if err != nil {
+				a.Errorf("Error evaluating command: %s", err.Error())
+				continue
+			}
+			a.EqInt(len(result.Body.([]command.QueryResult)[0].Series), test.series)
+			notes, _ := result.Metadata["notes"].([]string)
+			if test.notes == nil {
+				a.EqInt(len(notes), 0)
+			} else {
+				a.Eq(notes, test.notes)
+			}
+		}
+	}
+}
+
+func TestCommandSelectGroupKeys(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "requests", "host": "a10", "dc": "north", "env": "production"}},
+		api.Timeseries{Values: []float64{4, 5, 6}, TagSet: api.TagSet{"metric": "requests", "host": "a9", "dc": "north", "env": "staging"}},
+		api.Timeseries{Values: []float64{7, 8, 9}, TagSet: api.TagSet{"metric": "requests", "host": "b1", "dc": "south", "env": "production"}},
+		api.Timeseries{Values: []float64{1, 1, 1}, TagSet: api.TagSet{"metric": "requests", "host": "c1"}},
+	)
+	tests := []struct {
+		query    string
+		expected []string
+		fails    bool
+	}{
+		{query: "select tag.group_keys(requests, 'dc') from 0 to 60 resolution 30ms", expected: []string{"", "north", "south"}},
+		// Keys are in natural order.
+		{query: "select tag.group_keys(requests, 'host') from 0 to 60 resolution 30ms", expected: []string{"a9", "a10", "b1", "c1"}},
+		{query: "select tag.group_keys(requests group by env) from 0 to 60 resolution 30ms", expected: []string{"", "production", "staging"}},
+		{
+			query:    "select tag.group_keys(requests[dc = 'north'] group by dc, env) from 0 to 60 resolution 30ms",
+			expected: []string{"dc=north,env=production", "dc=north,env=staging"},
+		},
+		{query: "select tag.group_keys(requests) from 0 to 60 resolution 30ms", fails: true},
+		{query: "select tag.group_keys(requests collapse by dc) from 0 to 60 resolution 30ms", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)
+		a.EqString(body[0].Type, "strings")
+		a.Eq(body[0].Strings, test.expected)
+	}
+}
+
+func TestCommandSelectAggregateCoalesce(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 90, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	nan := math.NaN()
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, nan, nan, 4}, TagSet: api.TagSet{"metric": "requests", "collector": "primary", "dc": "north"}},
+		api.Timeseries{Values: []float64{10, 20, nan, 40}, TagSet: api.TagSet{"metric": "requests", "collector": "backup", "dc": "north"}},
+		api.Timeseries{Values: []float64{nan, 200, nan, nan}, TagSet: api.TagSet{"metric": "requests", "collector": "primary", "dc": "south"}},
+	)
+	tests := []struct {
+		query    string
+		expected []api.Timeseries
+	}{
+		{
+			query: "select aggregate.coalesce(requests group by dc) from 0 to 90 resolution 30ms",
+			expected: []api.Timeseries{
+				{Values: []float64{1, 20, nan, 4}, TagSet: api.TagSet{"dc": "north"}},
+				{Values: []float64{nan, 200, nan, nan}, TagSet: api.TagSet{"dc": "south"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		series := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(series), len(test.expected))
+		for i := range test.expected {
+			if i < len(series) {
+				a.Eq(series[i].TagSet, test.expected[i].TagSet)
+				a.EqFloatArray(series[i].Values, test.expected[i].Values, 0)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectLastDuration(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+	)
+	nan := math.NaN()
+	tests := []struct {
+		query    string
+		expected []float64
+		start    int64 // of the result's timerange
+		fails    string
+	}{
+		{query: `select transform.last_duration(cpu, 60ms) from 0 to 120 resolution 30ms`, expected: []float64{3, 4, 5}, start: 60},
+		{query: `select transform.last_duration(cpu, 0ms) from 0 to 120 resolution 30ms`, expected: []float64{5}, start: 120},
+		// A duration longer than the query keeps everything.
+		{query: `select transform.last_duration(cpu, 1h) from 0 to 120 resolution 30ms`, expected: []float64{1, 2, 3, 4, 5}, start: 0},
+		// Combined with other series, the trimmed series is padded with NaN.
+		{query: `select transform.last_duration(cpu, 30ms) + cpu from 0 to 120 resolution 30ms`, expected: []float64{nan, nan, nan, 8, 10}, start: 0},
+		{query: `select transform.last_duration(cpu, -30ms) from 0 to 120 resolution 30ms`, fails: "non-negative"},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		testCommand, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		result, err := testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		})
+		if test.fails != "" {
+			if err == nil {
+				a.Errorf("Expected query to fail, but it succeeded")
+			} else if !strings.Contains(err.Error(), test.fails) {
+				a.Errorf("Expected error to mention %q, but got: %s", test.fails, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		body := result.Body.([]command.QueryResult)[0]
+		a.EqInt(len(body.Series), 1)
+		if len(body.Series) == 1 {
+			a.EqFloatArray(body.Series[0].Values, test.expected, 0)
+		}
+		a.EqInt(int(body.Timerange.StartMillis()), int(test.start))
+		a.EqInt(int(body.Timerange.EndMillis()), 120)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// corruptStorage fails to fetch the series of the given hosts.
+type corruptStorage struct {
+	mocks.FakeComboAPI
+	corrupt map[string]bool
+}
+
+func (s corruptStorage) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	list := api.SeriesList{}
+	for _, single := range request.ToSingle() {
+		if host := single.Metric.TagSet["host"]; s.corrupt[host] {
+			err := fmt.Errorf("malformed response for host %s", host)
+			if request.LeaveOut(single.Metric, err) {
+				continue
+			}
+			return api.SeriesList{}, err
+		}
+		series, err := s.FakeComboAPI.FetchSingleTimeseries(single)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		list.Series = append(list.Series, series)
+	}
+	return list, nil
+}
+
+func TestCommandSelectLenientFetches(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{2, 2, 2, 2, 2}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{3, 3, 3, 3, 3}, TagSet: api.TagSet{"metric": "cpu", "host": "c"}},
+	)
+	storage := corruptStorage{FakeComboAPI: comboAPI, corrupt: map[string]bool{"b": true, "c": true}}
+	query := "select cpu | aggregate.sum from 0 to 120 resolution 30ms"
+	execute := func(lenient bool) (command.Result, error) {
+		testCommand, err := parser.Parse(query)
+		if err != nil {
+			t.Fatalf("Unexpected error while parsing: %s", err.Error())
+		}
+		return testCommand.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: storage,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           1000,
+			LenientFetches:       lenient,
+			Ctx:                  context.Background(),
+		})
+	}
+
+	a := assert.New(t).Contextf("strict")
+	if _, err := execute(false); err == nil {
+		a.Errorf("Expected the query to fail")
+	} else if !strings.Contains(err.Error(), "malformed response") {
+		a.Errorf("Expected the backend's error, but got %s", err.Error())
+	}
+
+	a = assert.New(t).Contextf("lenient")
+	result, err := execute(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	list := result.Body.([]command.QueryResult)[0].Series
+	a.EqInt(len(list), 1)
+	a.EqFloatArray(list[0].Values, []float64{1, 1, 1, 1, 1}, 0)
+	notes, _ := result.Metadata["notes"].([]string)
+	a.Eq(notes, []string{"left out 2 series which failed to fetch: cpu[host=b]: malformed response for host b; cpu[host=c]: malformed response for host c"})
+}
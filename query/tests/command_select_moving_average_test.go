@@ -173,3 +173,230 @@ func TestSelectMovingAverage(t *testing.T) {
 	}
 
 }
+
+func TestSelectEnvelope(t *testing.T) {
+	a := assert.New(t)
+	testTimerange, err := api.NewSnappedTimerange(0, 70, 10) // inclusive: 8 slots
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+
+	n := math.NaN()
+
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 2, 3, 4, 6, 7, 8, 9}, TagSet: api.TagSet{"metric": "series_a", "line": "a"}},
+		api.Timeseries{Values: []float64{n, n, 5, 6, 4, n, n, 1}, TagSet: api.TagSet{"metric": "series_a", "line": "na"}},
+		api.Timeseries{Values: []float64{n, n, n, n, n, n, n, n}, TagSet: api.TagSet{"metric": "series_a", "line": "nc"}},
+	)
+
+	expected := map[string][]float64{
+		"a/upper":  {6, 7, 8, 9},
+		"a/lower":  {3, 4, 6, 7},
+		"na/upper": {6, 6, 4, 1},
+		"na/lower": {4, 4, 4, 1},
+		"nc/upper": {n, n, n, n},
+		"nc/lower": {n, n, n, n},
+	}
+
+	commandObject, err := parser.Parse("select series_a | transform.envelope(30ms) from 40 to 70 resolution 10ms")
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err.Error())
+	}
+	result, err := commandObject.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           100,
+		Ctx:                  context.Background(),
+	})
+	if err != nil {
+		t.Fatalf("Error evaluating command: %s", err.Error())
+	}
+	value := result.Body.([]command.QueryResult)[0]
+	a.Contextf("number of results").EqInt(len(value.Series), len(expected))
+	for _, series := range value.Series {
+		name := series.TagSet["line"] + "/" + series.TagSet["envelope"]
+		if correct, ok := expected[name]; ok {
+			a.Contextf("value for %s", name).EqFloatArray(series.Values, correct, 1e-3)
+		} else {
+			a.Errorf("Unexpected tag set in result: %+v", series)
+		}
+	}
+
+	commandObject, err = parser.Parse("select series_a | transform.envelope(-2ms) from 40 to 70 resolution 10ms")
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err.Error())
+	}
+	if _, err := commandObject.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           100,
+		Ctx:                  context.Background(),
+	}); err == nil {
+		t.Errorf("Expected a negative envelope window to produce an error")
+	}
+}
+
+func TestSelectRateMaxGap(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 70, 10) // inclusive: 8 slots
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+
+	n := math.NaN()
+
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 10, n, n, 40, n, n, n}, TagSet: api.TagSet{"metric": "counters", "line": "gap"}},
+		api.Timeseries{Values: []float64{0, 10, 20, n, 5, 15, n, n}, TagSet: api.TagSet{"metric": "counters", "line": "reset"}},
+	)
+
+	tests := []struct {
+		query    string
+		expected map[string][]float64
+		fails    bool
+	}{
+		{
+			query: "select counters | transform.rate from 20 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"gap":   {n, n, n, n, n, n},
+				"reset": {1000, n, n, 1000, n, n},
+			},
+		},
+		{
+			query: "select counters | transform.rate(30ms) from 20 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"gap":   {n, n, 1000, n, n, n},
+				"reset": {1000, n, 250, 1000, n, n},
+			},
+		},
+		{
+			// The gap in the first series is 30ms, which is too long.
+			query: "select counters | transform.rate(20ms) from 20 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"gap":   {n, n, n, n, n, n},
+				"reset": {1000, n, 250, 1000, n, n},
+			},
+		},
+		{
+			query: "select counters | transform.rate(5ms) from 20 to 70 resolution 10ms",
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Contextf("number of results").EqInt(len(value.Series), len(test.expected))
+		for _, series := range value.Series {
+			a.Contextf("value for %s", series.TagSet["line"]).EqFloatArray(series.Values, test.expected[series.TagSet["line"]], 1e-6)
+		}
+	}
+}
+
+func TestSelectPercentileOverTime(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 70, 10) // inclusive: 8 slots
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+
+	n := math.NaN()
+
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 2, 3, 4, 6, 7, 8, 9}, TagSet: api.TagSet{"metric": "series_a", "line": "a"}},
+		api.Timeseries{Values: []float64{n, n, 5, 6, 4, n, n, 1}, TagSet: api.TagSet{"metric": "series_a", "line": "na"}},
+		api.Timeseries{Values: []float64{n, n, n, n, n, n, n, n}, TagSet: api.TagSet{"metric": "series_a", "line": "nc"}},
+	)
+
+	tests := []struct {
+		query    string
+		expected map[string][]float64
+		err      bool
+	}{
+		{
+			query: "select series_a | transform.percentile_over_time(30ms, 50) from 40 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"a":  {4, 6, 7, 8},
+				"na": {5, 5, 4, 1},
+				"nc": {n, n, n, n},
+			},
+		},
+		{
+			query: "select series_a | transform.percentile_over_time(30ms, 25) from 40 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"a":  {3.5, 5, 6.5, 7.5},
+				"na": {4.5, 4.5, 4, 1},
+				"nc": {n, n, n, n},
+			},
+		},
+		{
+			query: "select series_a | transform.percentile_over_time(30ms, 100) from 40 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"a":  {6, 7, 8, 9},
+				"na": {6, 6, 4, 1},
+				"nc": {n, n, n, n},
+			},
+		},
+		{
+			query: "select series_a | transform.percentile_over_time(-2ms, 50) from 40 to 70 resolution 10ms",
+			err:   true,
+		},
+		{
+			query: "select series_a | transform.percentile_over_time(30ms, 101) from 40 to 70 resolution 10ms",
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if test.err {
+			if err == nil {
+				a.Errorf("Expected an error")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Contextf("number of results").EqInt(len(value.Series), len(test.expected))
+		for _, series := range value.Series {
+			if correct, ok := test.expected[series.TagSet["line"]]; ok {
+				a.Contextf("value for %s", series.TagSet["line"]).EqFloatArray(series.Values, correct, 1e-3)
+			} else {
+				a.Errorf("Unexpected tag set in result: %+v", series)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectProvenance(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{4, 5, 6}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{7, 8, 9}, TagSet: api.TagSet{"metric": "mem", "host": "a"}},
+	)
+	tests := []struct {
+		query      string
+		provenance []string
+		tagged     bool // whether tag.provenance put the provenance into the tagsets
+	}{
+		{query: "select cpu from 0 to 60 resolution 30ms", provenance: []string{"cpu", "cpu"}},
+		{query: "select cpu[host = 'a'] from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select transform.abs(cpu[host = 'a']) from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select transform.moving_average(cpu[host = 'a'], 60ms) from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select aggregate.sum(cpu) from 0 to 60 resolution 30ms", provenance: []string{"cpu"}},
+		{query: "select cpu + mem from 0 to 60 resolution 30ms", provenance: []string{"cpu, mem"}},
+		{query: "select tag.set(cpu[host = 'b'], 'host', 'c') from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "b"]`}},
+		{query: "select cpu[host = 'a'] | transform.rate from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select cpu[host = 'a'] | transform.rate_per(1s) from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select cpu[host = 'a'] | transform.auto_rate from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select cpu[host = 'a'] | transform.derivative from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}},
+		{query: "select tag.provenance(mem) from 0 to 60 resolution 30ms", provenance: []string{"mem"}, tagged: true},
+		{query: "select cpu[host = 'a'] | transform.rate | tag.provenance from 0 to 60 resolution 30ms", provenance: []string{`cpu[host = "a"]`}, tagged: true},
+		// A series computed from no fetch has no provenance to tag.
+		{query: "select 1 | tag.provenance from 0 to 60 resolution 30ms", provenance: []string{""}, tagged: true},
+	}
+	for _, test := range tests {
+		for _, include := range []bool{false, true} {
+			a := assert.New(t).Contextf("%s (provenance: %t)", test.query, include)
+			commandObject, err := parser.Parse(test.query)
+			if err != nil {
+				t.Fatalf("Error parsing command: %s", err.Error())
+			}
+			result, err := commandObject.Execute(command.ExecutionContext{
+				TimeseriesStorageAPI: comboAPI,
+				MetricMetadataAPI:    comboAPI,
+				FetchLimit:           1000,
+				Provenance:           include,
+				Ctx:                  context.Background(),
+			})
+			if err != nil {
+				a.Errorf("Unexpected error: %s", err.Error())
+				continue
+			}
+			series := result.Body.([]command.QueryResult)[0].Series
+			a.EqInt(len(series), len(test.provenance))
+			for i := range series {
+				if i >= len(test.provenance) {
+					break
+				}
+				if include {
+					a.EqString(series[i].Provenance, test.provenance[i])
+				} else {
+					a.EqString(series[i].Provenance, "")
+				}
+				if test.tagged {
+					tag, ok := series[i].TagSet["provenance"]
+					a.EqBool(ok, test.provenance[i] != "")
+					a.EqString(tag, test.provenance[i])
+				}
+			}
+		}
+	}
+}
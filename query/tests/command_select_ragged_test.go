@@ -0,0 +1,100 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// raggedStorage returns series with too few values for host "short" and too
+// many for host "long", and series starting after the requested timerange for
+// host "late" and before it for host "early".
+type raggedStorage struct {
+	mocks.FakeComboAPI
+}
+
+func (r raggedStorage) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	list, err := r.FakeComboAPI.FetchMultipleTimeseries(request)
+	if err != nil {
+		return api.SeriesList{}, err
+	}
+	for i, series := range list.Series {
+		switch series.TagSet["host"] {
+		case "short":
+			list.Series[i].Values = series.Values[:len(series.Values)-2]
+		case "long":
+			list.Series[i].Values = append(append([]float64{}, series.Values...), 100, 200)
+		case "late":
+			list.Series[i].Values = series.Values[2:]
+			list.Series[i].Start = request.Timerange.Start().Add(2 * request.Timerange.Resolution())
+		case "early":
+			list.Series[i].Values = append([]float64{100}, series.Values...)
+			list.Series[i].Start = request.Timerange.Start().Add(-request.Timerange.Resolution())
+		}
+	}
+	return list, nil
+}
+
+func TestCommandSelectRagged(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "short"}},
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "exact"}},
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "long"}},
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "late"}},
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "early"}},
+	)
+	nan := math.NaN()
+	a := assert.New(t)
+	testCommand, err := parser.Parse(`select cpu + 0 from 0 to 120 resolution 30ms`)
+	if err != nil {
+		t.Fatalf("Unexpected error while parsing: %s", err.Error())
+	}
+	result, err := testCommand.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: raggedStorage{comboAPI},
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           1000,
+		Ctx:                  context.Background(),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := map[string][]float64{
+		"short": {1, 2, 3, nan, nan},
+		"exact": {1, 2, 3, 4, 5},
+		"long":  {1, 2, 3, 4, 5},
+		"late":  {nan, nan, 3, 4, 5},
+		"early": {1, 2, 3, 4, 5},
+	}
+	list := result.Body.([]command.QueryResult)[0].Series
+	a.EqInt(len(list), len(expected))
+	for _, series := range list {
+		a.Contextf("host %s", series.TagSet["host"]).EqFloatArray(series.Values, expected[series.TagSet["host"]], 0)
+	}
+}
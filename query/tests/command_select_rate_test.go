@@ -0,0 +1,166 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Integration test for the query execution.
+package tests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestSelectRatePer(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 70, 10) // inclusive: 8 slots
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+
+	n := math.NaN()
+
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 10, n, n, 40, n, n, n}, TagSet: api.TagSet{"metric": "counters", "line": "gap"}},
+		api.Timeseries{Values: []float64{0, 10, 20, n, 5, 15, n, n}, TagSet: api.TagSet{"metric": "counters", "line": "reset"}},
+	)
+
+	tests := []struct {
+		query    string
+		expected map[string][]float64
+		fails    bool
+	}{
+		{
+			query: "select counters | transform.rate_per(1m) from 20 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"gap":   {n, n, n, n, n, n},
+				"reset": {60000, n, n, 60000, n, n},
+			},
+		},
+		{
+			query: "select counters | transform.rate_per(100ms, 30ms) from 20 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"gap":   {n, n, 100, n, n, n},
+				"reset": {100, n, 25, 100, n, n},
+			},
+		},
+		{
+			query: "select counters | transform.rate_per(1s, 30ms) from 20 to 70 resolution 10ms",
+			expected: map[string][]float64{
+				"gap":   {n, n, 1000, n, n, n},
+				"reset": {1000, n, 250, 1000, n, n},
+			},
+		},
+		{
+			query: "select counters | transform.rate_per(0ms) from 20 to 70 resolution 10ms",
+			fails: true,
+		},
+		{
+			query: "select counters | transform.rate_per(1m, 5ms) from 20 to 70 resolution 10ms",
+			fails: true,
+		},
+		{
+			query: "select counters | transform.rate_per from 20 to 70 resolution 10ms",
+			fails: true,
+		},
+		{
+			// transform.rate's only optional argument is the maximum gap, so a unit can't be mistaken for one.
+			query: "select counters | transform.rate(10ms, 1m) from 20 to 70 resolution 10ms",
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Contextf("number of results").EqInt(len(value.Series), len(test.expected))
+		for _, series := range value.Series {
+			a.Contextf("value for %s", series.TagSet["line"]).EqFloatArray(series.Values, test.expected[series.TagSet["line"]], 1e-6)
+		}
+	}
+}
+
+func TestSelectDerivativeUnit(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 30, 10)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 5, 3, 3}, TagSet: api.TagSet{"metric": "gauge"}},
+	)
+	tests := []struct {
+		query    string
+		expected []float64
+		fails    bool
+	}{
+		{query: "select gauge | transform.derivative from 10 to 30 resolution 10ms", expected: []float64{500, -200, 0}},
+		{query: "select gauge | transform.derivative(1m) from 10 to 30 resolution 10ms", expected: []float64{30000, -12000, 0}},
+		{query: "select gauge | transform.derivative(10ms) from 10 to 30 resolution 10ms", expected: []float64{5, -2, 0}},
+		{query: "select gauge | transform.derivative(-1s) from 10 to 30 resolution 10ms", fails: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if test.fails {
+			if err == nil {
+				a.Errorf("Expected the query to fail")
+			}
+			continue
+		}
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		series := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(series), 1)
+		if len(series) == 1 {
+			a.EqFloatArray(series[0].Values, test.expected, 1e-6)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// rollupStorage offers a 30ms resolution which has no data, and a 60ms rollup
+// which does (like a storage missing the finer rollup for the timerange).
+type rollupStorage struct {
+	coarse map[string][]float64 // host => values at 60ms
+}
+
+func (s rollupStorage) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
+	for _, resolution := range []time.Duration{30 * time.Millisecond, 60 * time.Millisecond} {
+		if resolution >= lowerBound {
+			return resolution, nil
+		}
+	}
+	return 0, fmt.Errorf("no resolution as coarse as %+v", lowerBound)
+}
+
+func (s rollupStorage) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
+	values := make([]float64, request.Timerange.Slots())
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	if request.Timerange.Resolution() == 60*time.Millisecond {
+		copy(values, s.coarse[request.Metric.TagSet["host"]])
+	}
+	return api.Timeseries{Values: values, TagSet: request.Metric.TagSet}, nil
+}
+
+func (s rollupStorage) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	list := api.SeriesList{}
+	for _, single := range request.ToSingle() {
+		series, err := s.FetchSingleTimeseries(single)
+		if err != nil {
+			return api.SeriesList{}, err
+		}
+		list.Series = append(list.Series, series)
+	}
+	return list, nil
+}
+
+func (s rollupStorage) CheckHealthy() error {
+	return nil
+}
+
+func TestCommandSelectResolutionFallback(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{0, 0, 0, 0, 0}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{0, 0, 0, 0, 0}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+	)
+	storage := rollupStorage{coarse: map[string][]float64{
+		"a": {1, 2, 3},
+		"b": {4, 5, 6},
+	}}
+	nan := math.NaN()
+	tests := []struct {
+		fetchLimit int
+		expected   map[string][]float64
+		note       string
+	}{
+		{
+			fetchLimit: 4,
+			expected:   map[string][]float64{"a": {1, 1, 2, 2, 3}, "b": {4, 4, 5, 5, 6}},
+			note:       "using resolution 60ms instead",
+		},
+		{
+			// The retry would exceed the fetch limit, so the sparse result is kept.
+			fetchLimit: 3,
+			expected:   map[string][]float64{"a": {nan, nan, nan, nan, nan}, "b": {nan, nan, nan, nan, nan}},
+			note:       "the fetch limit doesn't allow retrying",
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("fetch limit %d", test.fetchLimit)
+		commandObject, err := parser.Parse("select cpu from 0 to 120 resolution 30ms")
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: storage,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           test.fetchLimit,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+			continue
+		}
+		series := result.Body.([]command.QueryResult)[0].Series
+		a.EqInt(len(series), len(test.expected))
+		for _, s := range series {
+			a.Contextf("host %s", s.TagSet["host"]).EqFloatArray(s.Values, test.expected[s.TagSet["host"]], 0)
+		}
+		notes := strings.Join(result.Metadata["notes"].([]string), "\n")
+		if !strings.Contains(notes, test.note) {
+			a.Errorf("expected a note mentioning %q but got %q", test.note, notes)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// batchRecordingAPI records the size of the largest fetch made through it.
+type batchRecordingAPI struct {
+	mocks.FakeComboAPI
+	mutex   sync.Mutex
+	largest int
+}
+
+func (b *batchRecordingAPI) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	b.mutex.Lock()
+	if len(request.Metrics) > b.largest {
+		b.largest = len(request.Metrics)
+	}
+	b.mutex.Unlock()
+	return b.FakeComboAPI.FetchMultipleTimeseries(request)
+}
+
+func TestCommandSelectStreamingAggregation(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	series := []api.Timeseries{}
+	for i := 0; i < 200; i++ {
+		series = append(series, api.Timeseries{
+			Values: []float64{float64(i), float64(i % 7), float64(-i), float64(i * i), float64(i % 3)},
+			TagSet: api.TagSet{"metric": "requests", "dc": fmt.Sprintf("dc%d", i%3), "host": fmt.Sprintf("host%d", i)},
+		})
+	}
+	comboAPI := mocks.NewComboAPI(testTimerange, series...)
+
+	tests := []struct {
+		query   string
+		streams bool // whether the aggregation can be streamed
+	}{
+		{`select aggregate.sum(requests) from 0 to 120 resolution 30ms`, true},
+		{`select aggregate.max(requests group by dc) from 0 to 120 resolution 30ms`, true},
+		{`select aggregate.min(requests collapse by host) from 0 to 120 resolution 30ms`, true},
+		{`select aggregate.count(requests[dc = "dc1"] group by dc) from 0 to 120 resolution 30ms`, true},
+		{`select aggregate.total(requests group by dc) where dc != "dc0" from 0 to 120 resolution 30ms`, true},
+		{`select aggregate.mean(requests group by dc) from 0 to 120 resolution 30ms`, false},
+		{`select aggregate.sum(requests + 1 group by dc) from 0 to 120 resolution 30ms`, false},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		results := map[bool][]api.Timeseries{}
+		for _, streaming := range []bool{false, true} {
+			testCommand, err := parser.Parse(test.query)
+			if err != nil {
+				t.Fatalf("Unexpected error while parsing: %s", err.Error())
+			}
+			backend := &batchRecordingAPI{FakeComboAPI: comboAPI}
+			result, err := testCommand.Execute(command.ExecutionContext{
+				TimeseriesStorageAPI: backend,
+				MetricMetadataAPI:    comboAPI,
+				FetchLimit:           1000,
+				StreamAggregations:   streaming,
+				Ctx:                  context.Background(),
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			results[streaming] = result.Body.([]command.QueryResult)[0].Series
+			if streaming && test.streams && backend.largest > 64 {
+				a.Errorf("Expected fetches in batches of at most 64 series, but one had %d", backend.largest)
+			}
+			if !test.streams && backend.largest != 200 {
+				a.Errorf("Expected a single fetch of all 200 series, but the largest had %d", backend.largest)
+			}
+		}
+		a.EqInt(len(results[true]), len(results[false]))
+		for i := range results[false] {
+			a.Eq(results[true][i].TagSet, results[false][i].TagSet)
+			a.EqFloatArray(results[true][i].Values, results[false][i].Values, 1e-9)
+		}
+	}
+
+	// The series still count against the fetch limit.
+	testCommand, err := parser.Parse(`select aggregate.sum(requests) from 0 to 120 resolution 30ms`)
+	if err != nil {
+		t.Fatalf("Unexpected error while parsing: %s", err.Error())
+	}
+	if _, err := testCommand.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           100,
+		StreamAggregations:   true,
+		Ctx:                  context.Background(),
+	}); err == nil {
+		t.Errorf("Expected the fetch limit to be enforced while streaming")
+	}
+}
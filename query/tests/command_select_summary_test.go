@@ -120,6 +120,30 @@ func TestSelectSummary(t *testing.T) {
 				api.TagSet{"app": "fun", "dc": "north"}.Serialize(): 5,
 			},
 		},
+		{
+			query: "select series_a | summarize.percent_change from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"app": "web", "dc": "west"}.Serialize():  n, // starts at zero
+				api.TagSet{"app": "web", "dc": "east"}.Serialize():  100,
+				api.TagSet{"app": "fun", "dc": "north"}.Serialize(): -20,
+			},
+		},
+		{
+			query: "select series_b | summarize.percent_change from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 400.0 / 3,
+				api.TagSet{"dc": "east"}.Serialize(): -60,
+				api.TagSet{"dc": "miss"}.Serialize(): n,
+			},
+		},
+		{
+			query: "select series_a | summarize.percent_change(1m) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"app": "web", "dc": "west"}.Serialize():  100,
+				api.TagSet{"app": "web", "dc": "east"}.Serialize():  100,
+				api.TagSet{"app": "fun", "dc": "north"}.Serialize(): -20,
+			},
+		},
 		{
 			query: "select series_a | summarize.count from 0 to 120000",
 			expected: map[string]float64{
@@ -136,6 +160,22 @@ func TestSelectSummary(t *testing.T) {
 				api.TagSet{"app": "fun", "dc": "north"}.Serialize(): 5,
 			},
 		},
+		{
+			query: "select series_a | summarize.crossings_above(1) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"app": "web", "dc": "west"}.Serialize():  1,
+				api.TagSet{"app": "web", "dc": "east"}.Serialize():  1,
+				api.TagSet{"app": "fun", "dc": "north"}.Serialize(): 0,
+			},
+		},
+		{
+			query: "select series_a | summarize.crossings_above(5) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"app": "web", "dc": "west"}.Serialize():  1,
+				api.TagSet{"app": "web", "dc": "east"}.Serialize():  0,
+				api.TagSet{"app": "fun", "dc": "north"}.Serialize(): 1,
+			},
+		},
 		// recent
 		{
 			query: "select series_a | summarize.mean(60s) from 0 to 120000",
@@ -242,6 +282,106 @@ func TestSelectSummary(t *testing.T) {
 				api.TagSet{"dc": "miss"}.Serialize(): 5,
 			},
 		},
+		{
+			// The gap between 3 and 7 breaks the crossing.
+			query: "select series_b | summarize.crossings_above(4) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 0,
+				api.TagSet{"dc": "east"}.Serialize(): 0,
+				api.TagSet{"dc": "miss"}.Serialize(): 0,
+			},
+		},
+		// value histogram
+		{
+			query: "select series_a | summarize.value_histogram(3) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"app": "web", "dc": "west", "bin_low": "0", "bin_high": "2"}.Serialize():                                   1,
+				api.TagSet{"app": "web", "dc": "west", "bin_low": "2", "bin_high": "4"}.Serialize():                                   2,
+				api.TagSet{"app": "web", "dc": "west", "bin_low": "4", "bin_high": "6"}.Serialize():                                   2,
+				api.TagSet{"app": "web", "dc": "east", "bin_low": "0", "bin_high": "0.6666666666666666"}.Serialize():                  1,
+				api.TagSet{"app": "web", "dc": "east", "bin_low": "0.6666666666666666", "bin_high": "1.3333333333333333"}.Serialize(): 3,
+				api.TagSet{"app": "web", "dc": "east", "bin_low": "1.3333333333333333", "bin_high": "2"}.Serialize():                  1,
+				api.TagSet{"app": "fun", "dc": "north", "bin_low": "4", "bin_high": "4.666666666666667"}.Serialize():                  1,
+				api.TagSet{"app": "fun", "dc": "north", "bin_low": "4.666666666666667", "bin_high": "5.333333333333333"}.Serialize():  3,
+				api.TagSet{"app": "fun", "dc": "north", "bin_low": "5.333333333333333", "bin_high": "6"}.Serialize():                  1,
+			},
+		},
+		{
+			// NaNs are excluded; series without samples have no bins, and
+			// empty bins are still reported.
+			query: "select series_b | summarize.value_histogram(2) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"dc": "west", "bin_low": "3", "bin_high": "5"}.Serialize():   1,
+				api.TagSet{"dc": "west", "bin_low": "5", "bin_high": "7"}.Serialize():   1,
+				api.TagSet{"dc": "east", "bin_low": "2", "bin_high": "3.5"}.Serialize(): 2,
+				api.TagSet{"dc": "east", "bin_low": "3.5", "bin_high": "5"}.Serialize(): 1,
+			},
+		},
+		{
+			query: "select series_b[dc = 'west'] | transform.nan_fill(3) | summarize.value_histogram(4) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"dc": "west", "bin_low": "3", "bin_high": "4"}.Serialize(): 4,
+				api.TagSet{"dc": "west", "bin_low": "4", "bin_high": "5"}.Serialize(): 0,
+				api.TagSet{"dc": "west", "bin_low": "5", "bin_high": "6"}.Serialize(): 0,
+				api.TagSet{"dc": "west", "bin_low": "6", "bin_high": "7"}.Serialize(): 1,
+			},
+		},
+		{
+			// Infinities are excluded, like NaNs.
+			query: "select (series_a[dc = 'east'] - 2) / series_a[dc = 'east'] | summarize.value_histogram(2) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"app": "web", "dc": "east", "bin_low": "-1", "bin_high": "-0.5"}.Serialize(): 3,
+				api.TagSet{"app": "web", "dc": "east", "bin_low": "-0.5", "bin_high": "0"}.Serialize():  1,
+			},
+		},
+		{
+			// A constant series has a single bin.
+			query: "select series_b[dc = 'west'] | transform.nan_fill(3) | transform.bound(3, 3) | summarize.value_histogram(4) from 0 to 120000",
+			expected: map[string]float64{
+				api.TagSet{"dc": "west", "bin_low": "3", "bin_high": "3"}.Serialize(): 5,
+			},
+		},
+		// chosen statistic
+		{
+			query: `select series_b | summarize.stat("min") from 0 to 120000`,
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 3,
+				api.TagSet{"dc": "east"}.Serialize(): 2,
+				api.TagSet{"dc": "miss"}.Serialize(): n,
+			},
+		},
+		{
+			query: `select series_b | summarize.stat("max") from 0 to 120000`,
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 7,
+				api.TagSet{"dc": "east"}.Serialize(): 5,
+				api.TagSet{"dc": "miss"}.Serialize(): n,
+			},
+		},
+		{
+			query: `select series_b | summarize.stat("mean") from 0 to 120000`,
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 5,
+				api.TagSet{"dc": "east"}.Serialize(): 3,
+				api.TagSet{"dc": "miss"}.Serialize(): n,
+			},
+		},
+		{
+			query: `select series_b | summarize.stat("last") from 0 to 120000`,
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 7,
+				api.TagSet{"dc": "east"}.Serialize(): 2,
+				api.TagSet{"dc": "miss"}.Serialize(): n,
+			},
+		},
+		{
+			query: `select series_b | summarize.stat("count") from 0 to 120000`,
+			expected: map[string]float64{
+				api.TagSet{"dc": "west"}.Serialize(): 2,
+				api.TagSet{"dc": "east"}.Serialize(): 3,
+				api.TagSet{"dc": "miss"}.Serialize(): 0,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -274,3 +414,322 @@ func TestSelectSummary(t *testing.T) {
 	}
 
 }
+
+func TestSelectSummaryCrossCorrelation(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 9*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	n := math.NaN()
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 1, 4, 2, 6, 3, 5, 1, 2, 0}, TagSet: api.TagSet{"metric": "leader", "host": "a"}},
+		// follower is leader, two buckets later.
+		api.Timeseries{Values: []float64{n, n, 0, 1, 4, 2, 6, 3, 5, 1}, TagSet: api.TagSet{"metric": "follower", "host": "a"}},
+		api.Timeseries{Values: []float64{3, 3, 3, 3, 3, 3, 3, 3, 3, 3}, TagSet: api.TagSet{"metric": "constant", "host": "a"}},
+	)
+	tests := []struct {
+		query    string
+		expected map[string]float64
+	}{
+		{
+			query: "select summarize.cross_correlation(leader, follower, 2) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a", "lag": "-2"}.Serialize(): -0.3330374315143977,
+				api.TagSet{"host": "a", "lag": "-1"}.Serialize(): -0.6134346610139774,
+				api.TagSet{"host": "a", "lag": "0"}.Serialize():  0.3896023728532285,
+				api.TagSet{"host": "a", "lag": "1"}.Serialize():  -0.07273929674533079,
+				api.TagSet{"host": "a", "lag": "2"}.Serialize():  1,
+			},
+		},
+		{
+			query: "select summarize.cross_correlation(follower, leader, 2) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a", "lag": "-2"}.Serialize(): 1,
+				api.TagSet{"host": "a", "lag": "-1"}.Serialize(): -0.07273929674533079,
+				api.TagSet{"host": "a", "lag": "0"}.Serialize():  0.3896023728532285,
+				api.TagSet{"host": "a", "lag": "1"}.Serialize():  -0.6134346610139774,
+				api.TagSet{"host": "a", "lag": "2"}.Serialize():  -0.3330374315143977,
+			},
+		},
+		{
+			query: "select summarize.cross_correlation(leader, leader, 0) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a", "lag": "0"}.Serialize(): 1,
+			},
+		},
+		{
+			// A constant series has no correlation with anything.
+			query: "select summarize.cross_correlation(leader, constant, 1) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a", "lag": "-1"}.Serialize(): n,
+				api.TagSet{"host": "a", "lag": "0"}.Serialize():  n,
+				api.TagSet{"host": "a", "lag": "1"}.Serialize():  n,
+			},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("Query %s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command %s: %s", test.query, err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating %s: %s", test.query, err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Eq(value.Type, "scalars")
+		a.Contextf("number of results").Eq(len(value.Scalars), len(test.expected))
+		for _, scalar := range value.Scalars {
+			if correct, ok := test.expected[scalar.TagSet.Serialize()]; ok {
+				a.Contextf("value for %+v", scalar.TagSet).EqFloat(scalar.Value, correct, 1e-10)
+			} else {
+				a.Errorf("Unexpected tag set in result: %+v", scalar)
+			}
+		}
+	}
+}
+
+func TestSelectSummaryByTimeOfDay(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 6*21600000, 21600000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5, 6, 7}, TagSet: api.TagSet{"metric": "series_a", "dc": "west"}},
+	)
+	tests := []struct {
+		query    string
+		expected []float64 // the profile, starting at midnight
+	}{
+		{
+			// The last day is partial, so 18:00 only has one sample.
+			query:    `select series_a | summarize.by_time_of_day(6h, "mean") from 0 to 129600000 resolution "6h"`,
+			expected: []float64{3, 4, 5, 4},
+		},
+		{
+			query:    `select series_a | summarize.by_time_of_day(6h, "avg") from 0 to 129600000 resolution "6h"`,
+			expected: []float64{3, 4, 5, 4},
+		},
+		{
+			// Midnight in Etc/GMT-6 is 18:00 UTC.
+			query:    `select series_a | summarize.by_time_of_day(6h, "count", "Etc/GMT-6") from 0 to 129600000 resolution "6h"`,
+			expected: []float64{1, 2, 2, 2},
+		},
+		{
+			query:    `select series_a | summarize.by_time_of_day(12h, "max", "Etc/GMT-6") from 0 to 129600000 resolution "6h"`,
+			expected: []float64{5, 7},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("Query %s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command %s: %s", test.query, err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Provenance:           true,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating %s: %s", test.query, err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Eq(value.Type, "series")
+		// The profile always covers the day starting at the epoch.
+		a.Contextf("timerange start").Eq(value.Timerange.StartMillis(), int64(0))
+		a.Contextf("timerange resolution").Eq(value.Timerange.ResolutionMillis(), int64(86400000/len(test.expected)))
+		a.Contextf("timerange slots").EqInt(value.Timerange.Slots(), len(test.expected))
+		if len(value.Series) != 1 {
+			a.Errorf("Expected one series but got %d", len(value.Series))
+			continue
+		}
+		a.Eq(value.Series[0].TagSet, api.TagSet{"dc": "west"})
+		a.EqString(value.Series[0].Provenance, "series_a")
+		a.EqFloatArray(value.Series[0].Values, test.expected, 1e-10)
+	}
+}
+
+func TestSelectSummaryValueAt(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 9*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	n := math.NaN()
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, TagSet: api.TagSet{"metric": "series_a", "host": "a"}},
+		api.Timeseries{Values: []float64{0, 1, 2, 3, n, n, n, n, 8, 9}, TagSet: api.TagSet{"metric": "series_a", "host": "b"}},
+		api.Timeseries{Values: []float64{n, n, n, n, n, n, n, n, n, n}, TagSet: api.TagSet{"metric": "series_a", "host": "c"}},
+	)
+	tests := []struct {
+		query    string
+		expected map[string]float64
+	}{
+		{
+			query: "select series_a | summarize.value_at(0s) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a"}.Serialize(): 9,
+				api.TagSet{"host": "b"}.Serialize(): 9,
+				api.TagSet{"host": "c"}.Serialize(): n,
+			},
+		},
+		{
+			// 100s before the end rounds to the point at 180s.
+			query: "select series_a | summarize.value_at(100s) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a"}.Serialize(): 6,
+				api.TagSet{"host": "b"}.Serialize(): 8,
+				api.TagSet{"host": "c"}.Serialize(): n,
+			},
+		},
+		{
+			// The point at 150s is missing for b, so the nearest present one is used.
+			query: "select series_a | summarize.value_at(120s) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a"}.Serialize(): 5,
+				api.TagSet{"host": "b"}.Serialize(): 3,
+				api.TagSet{"host": "c"}.Serialize(): n,
+			},
+		},
+		{
+			query: "select series_a | summarize.value_at(120s, 30s) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a"}.Serialize(): 5,
+				api.TagSet{"host": "b"}.Serialize(): n,
+				api.TagSet{"host": "c"}.Serialize(): n,
+			},
+		},
+		{
+			query: "select series_a | summarize.value_at(270s, 0s) from 0 to 270000",
+			expected: map[string]float64{
+				api.TagSet{"host": "a"}.Serialize(): 0,
+				api.TagSet{"host": "b"}.Serialize(): 0,
+				api.TagSet{"host": "c"}.Serialize(): n,
+			},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("Query %s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command %s: %s", test.query, err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating %s: %s", test.query, err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Eq(value.Type, "scalars")
+		a.Contextf("number of results").Eq(len(value.Scalars), len(test.expected))
+		for _, scalar := range value.Scalars {
+			if correct, ok := test.expected[scalar.TagSet.Serialize()]; ok {
+				a.Contextf("value for %+v", scalar.TagSet).EqFloat(scalar.Value, correct, 1e-10)
+			} else {
+				a.Errorf("Unexpected tag set in result: %+v", scalar)
+			}
+		}
+	}
+}
+
+func TestSelectSummaryInvalidStat(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 2, 3, 4, 6}, TagSet: api.TagSet{"metric": "series_a", "dc": "west"}},
+	)
+	commandObject, err := parser.Parse(`select series_a | summarize.stat("median") from 0 to 120000`)
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err.Error())
+	}
+	_, err = commandObject.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           100,
+		Ctx:                  context.Background(),
+	})
+	if err == nil {
+		t.Fatalf("Expected an unknown statistic to produce an error")
+	}
+}
+
+// checkSummaryErrors checks that each of the queries of series_a fails.
+func checkSummaryErrors(t *testing.T, queries []string) {
+	testTimerange, err := api.NewSnappedTimerange(0, 4*30000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{0, 2, 3, 4, 6}, TagSet: api.TagSet{"metric": "series_a", "dc": "west"}},
+	)
+	for _, query := range queries {
+		commandObject, err := parser.Parse(query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		_, err = commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err == nil {
+			t.Errorf("Expected %s to produce an error", query)
+		}
+	}
+}
+
+func TestSelectSummaryInvalidHistogram(t *testing.T) {
+	checkSummaryErrors(t, []string{
+		`select series_a | summarize.value_histogram(0) from 0 to 120000`,
+		`select series_a | summarize.value_histogram(2.5) from 0 to 120000`,
+		`select series_a | summarize.value_histogram(100000) from 0 to 120000`,
+	})
+}
+
+func TestSelectSummaryInvalidCrossCorrelation(t *testing.T) {
+	checkSummaryErrors(t, []string{
+		`select summarize.cross_correlation(series_a, series_a, -1) from 0 to 120000`,
+		`select summarize.cross_correlation(series_a, series_a, 1.5) from 0 to 120000`,
+		`select summarize.cross_correlation(series_a, series_a, 5) from 0 to 120000`,
+	})
+}
+
+func TestSelectSummaryInvalidTimeOfDay(t *testing.T) {
+	checkSummaryErrors(t, []string{
+		`select series_a | summarize.by_time_of_day(7h, "mean") from 0 to 120000`,
+		`select series_a | summarize.by_time_of_day(1s, "mean") from 0 to 120000`,
+		`select series_a | summarize.by_time_of_day(1h, "median") from 0 to 120000`,
+		`select series_a | summarize.by_time_of_day(1h, "mean", "Nowhere/Special") from 0 to 120000`,
+	})
+}
+
+func TestSelectSummaryInvalidValueAt(t *testing.T) {
+	checkSummaryErrors(t, []string{
+		`select series_a | summarize.value_at(-30s) from 0 to 120000`,
+		`select series_a | summarize.value_at(1h) from 0 to 120000`,
+		`select series_a | summarize.value_at(0s, -30s) from 0 to 120000`,
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+func TestCommandSelectTenantLimits(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b"}},
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "cpu", "host": "c"}},
+	)
+	limits := command.StaticLimits(map[string]command.Limits{
+		"interactive": {FetchLimit: 2, SlotLimit: 4},
+		"reports":     {FetchLimit: 10},
+	}, command.Limits{})
+	// Unlisted principals may have tighter limits than the defaults.
+	restricted := command.StaticLimits(map[string]command.Limits{
+		"reports": {FetchLimit: 10},
+	}, command.Limits{FetchLimit: 2})
+	tests := []struct {
+		principal   string
+		query       string
+		unlisted    bool // whether to use the restricted limits
+		expectError bool
+	}{
+		{principal: "interactive", query: "select cpu[host = 'a'] from 0 to 90 resolution 30ms"},
+		{principal: "interactive", query: "select cpu from 0 to 90 resolution 30ms", expectError: true},              // too many fetches
+		{principal: "interactive", query: "select cpu[host = 'a'] from 0 to 120 resolution 30ms", expectError: true}, // too many points
+		{principal: "reports", query: "select cpu from 0 to 120 resolution 30ms"},
+		{principal: "reports", query: "select cpu, cpu[host != 'a'], cpu[host != 'b'], cpu[host != 'c'], cpu[host = 'a'], cpu[host = 'b'] from 0 to 120 resolution 30ms", expectError: true},
+		// Unlisted principals get the defaults.
+		{principal: "", query: "select cpu, cpu[host != 'a'], cpu[host != 'b'], cpu[host != 'c'], cpu[host = 'a'], cpu[host = 'b'] from 0 to 120 resolution 30ms"},
+		{principal: "someone", query: "select cpu, cpu[host != 'a'], cpu[host != 'b'], cpu[host != 'c'], cpu[host = 'a'], cpu[host = 'b'] from 0 to 120 resolution 30ms"},
+		{principal: "", query: "select cpu[host = 'a'] from 0 to 120 resolution 30ms", unlisted: true},
+		{principal: "", query: "select cpu from 0 to 120 resolution 30ms", unlisted: true, expectError: true},
+		{principal: "someone", query: "select cpu from 0 to 120 resolution 30ms", unlisted: true, expectError: true},
+		{principal: "reports", query: "select cpu from 0 to 120 resolution 30ms", unlisted: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s for %q", test.query, test.principal)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		provider := limits
+		if test.unlisted {
+			provider = restricted
+		}
+		_, err = commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			TenantLimits:         provider,
+			Principal:            test.principal,
+			Ctx:                  context.Background(),
+		})
+		if test.expectError && err == nil {
+			a.Errorf("expected an error but got none")
+		}
+		if !test.expectError && err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+		}
+	}
+}
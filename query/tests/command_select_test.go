@@ -53,7 +53,8 @@ func TestCommand_Select(t *testing.T) {
 		expectError bool
 		expected    []api.SeriesList
 	}{
-		{"select does_not_exist from 0 to 120 resolution 30ms", true, []api.SeriesList{}},
+		// An unknown metric matches no series, which by default is only noted.
+		{"select does_not_exist from 0 to 120 resolution 30ms", false, []api.SeriesList{{}}},
 		{"select series_1 from 0 to 120 resolution 30ms", false, []api.SeriesList{{
 			Series: []api.Timeseries{{
 				Values: []float64{1, 2, 3, 4, 5},
@@ -314,6 +315,37 @@ func TestCommand_Select(t *testing.T) {
 			},
 		}}},
 		{"select series_1 from -1000d to now resolution 30ms", true, []api.SeriesList{}},
+		{"select transform.compare_to_past(series_1, 60ms) from 60 to 120 resolution 30ms", false, []api.SeriesList{{
+			Series: []api.Timeseries{
+				{
+					Values: []float64{3, 4, 5},
+					TagSet: api.TagSet{"dc": "west", "period": "current"},
+				},
+				{
+					Values: []float64{1, 2, 3},
+					TagSet: api.TagSet{"dc": "west", "period": "-60ms"},
+				},
+			},
+		}}},
+		{"select transform.compare_to_past(series_1, 0ms) from 60 to 120 resolution 30ms", true, []api.SeriesList{}},
+		{"select transform.diff_from_baseline(series_1, 60ms) from 60 to 120 resolution 30ms", false, []api.SeriesList{{
+			Series: []api.Timeseries{
+				{
+					Values: []float64{2, 2, 2},
+					TagSet: api.TagSet{"dc": "west", "delta": "difference", "baseline": "-60ms"},
+				},
+			},
+		}}},
+		{"select transform.diff_from_baseline(series_1, 60ms, 'percent') from 60 to 120 resolution 30ms", false, []api.SeriesList{{
+			Series: []api.Timeseries{
+				{
+					Values: []float64{200, 100, 66.6667},
+					TagSet: api.TagSet{"dc": "west", "delta": "percent", "baseline": "-60ms"},
+				},
+			},
+		}}},
+		{"select transform.diff_from_baseline(series_1, 60ms, 'ratio') from 60 to 120 resolution 30ms", true, []api.SeriesList{}},
+		{"select transform.diff_from_baseline(series_1, 0ms) from 60 to 120 resolution 30ms", true, []api.SeriesList{}},
 	} {
 		a := assert.New(t).Contextf("query=%s", test.query)
 		expected := test.expected
@@ -520,3 +552,93 @@ func TestTag(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectAlignsTimerange(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "series"}},
+	)
+	tests := []struct {
+		query    string
+		start    int64
+		end      int64
+		expected []float64
+		widened  bool
+	}{
+		{query: "select series from 0 to 120 resolution 30ms", start: 0, end: 120, expected: []float64{1, 2, 3, 4, 5}},
+		{query: "select series from 10 to 100 resolution 30ms", start: 0, end: 120, expected: []float64{1, 2, 3, 4, 5}, widened: true},
+		{query: "select series from 44 to 46 resolution 30ms", start: 30, end: 60, expected: []float64{2, 3}, widened: true},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Eq(value.Timerange.StartMillis(), test.start)
+		a.Eq(value.Timerange.EndMillis(), test.end)
+		a.EqFloatArray(value.Series[0].Values, test.expected, 0)
+		a.EqBool(len(result.Metadata["notes"].([]string)) > 0, test.widened)
+	}
+}
+
+func TestSelectAnnotate(t *testing.T) {
+	testTimerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		testTimerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "latency"}},
+	)
+	tests := []struct {
+		query    string
+		expected map[string]string
+	}{
+		{query: "select latency from 0 to 120 resolution 30ms", expected: nil},
+		{query: "select transform.annotate(latency, 'unit', 'ms') from 0 to 120 resolution 30ms", expected: map[string]string{"unit": "ms"}},
+		{
+			query:    "select transform.annotate(transform.annotate(latency, 'unit', 'ms'), 'description', 'p99 latency') from 0 to 120 resolution 30ms",
+			expected: map[string]string{"unit": "ms", "description": "p99 latency"},
+		},
+		{
+			query:    "select transform.annotate(transform.annotate(latency, 'unit', 'ms'), 'unit', 's') from 0 to 120 resolution 30ms",
+			expected: map[string]string{"unit": "s"},
+		},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%s", test.query)
+		commandObject, err := parser.Parse(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err.Error())
+		}
+		result, err := commandObject.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    comboAPI,
+			FetchLimit:           100,
+			Ctx:                  context.Background(),
+		})
+		if err != nil {
+			a.Errorf("Error evaluating command: %s", err.Error())
+			continue
+		}
+		value := result.Body.([]command.QueryResult)[0]
+		a.Eq(value.Annotations, test.expected)
+		a.EqFloatArray(value.Series[0].Values, []float64{1, 2, 3, 4, 5}, 0)
+	}
+}
@@ -92,6 +92,7 @@ func TestProfilerIntegration(t *testing.T) {
 				"Mock FetchMultipleTimeseries": 1,
 				"Mock GetAllTags":              1,
 				"Mock FetchSingleTimeseries":   3,
+				"+.Evaluate":                   1,
 			},
 		},
 		{
@@ -101,6 +102,7 @@ func TestProfilerIntegration(t *testing.T) {
 				"Mock FetchMultipleTimeseries": 2,
 				"Mock GetAllTags":              2,
 				"Mock FetchSingleTimeseries":   6,
+				"+.Evaluate":                   1,
 			},
 		},
 		{
@@ -110,6 +112,7 @@ func TestProfilerIntegration(t *testing.T) {
 				"Mock FetchMultipleTimeseries": 1,
 				"Mock GetAllTags":              1,
 				"Mock FetchSingleTimeseries":   3,
+				"+.Evaluate":                   1,
 			},
 		},
 		{
@@ -149,6 +152,8 @@ func TestProfilerIntegration(t *testing.T) {
 				"Mock FetchMultipleTimeseries": 1,
 				"Mock GetAllTags":              1,
 				"Mock FetchSingleTimeseries":   3,
+				"+.Evaluate":                   1,
+				"transform.timeshift.Evaluate": 1, // memoized
 			},
 		},
 		{
@@ -158,6 +163,8 @@ func TestProfilerIntegration(t *testing.T) {
 				"Mock FetchMultipleTimeseries": 1,
 				"Mock GetAllTags":              1,
 				"Mock FetchSingleTimeseries":   3,
+				"+.Evaluate":                   1,
+				"transform.timeshift.Evaluate": 3,
 			},
 		},
 	}
@@ -0,0 +1,135 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+
+	"golang.org/x/net/context"
+)
+
+// longQueryTerms is the size of the generated queries, such as those built by
+// dashboards combining hundreds of metrics.
+const longQueryTerms = 500
+
+// longQueryTarget is how long a long query may take to parse and execute.
+// They take tens of milliseconds; the target is generous so that the test only
+// fails when they become much slower, such as by taking quadratic time.
+const longQueryTarget = time.Second
+
+// longQuery builds a query joining longQueryTerms fetches with the separator,
+// and the API to execute it.
+func longQuery(separator string) (string, mocks.FakeComboAPI, error) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		return "", mocks.FakeComboAPI{}, err
+	}
+	series := make([]api.Timeseries, longQueryTerms)
+	terms := make([]string, longQueryTerms)
+	for i := range terms {
+		series[i] = api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": fmt.Sprintf("metric_%d", i), "host": "a"}}
+		terms[i] = fmt.Sprintf("metric_%d[host = 'a']", i)
+	}
+	query := fmt.Sprintf("select %s from 0 to 120 resolution 30ms", strings.Join(terms, separator))
+	return query, mocks.NewComboAPI(timerange, series...), nil
+}
+
+// executeLongQuery parses and executes the query.
+func executeLongQuery(query string, comboAPI mocks.FakeComboAPI) ([]command.QueryResult, error) {
+	commandObject, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	result, err := commandObject.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: comboAPI,
+		MetricMetadataAPI:    comboAPI,
+		FetchLimit:           longQueryTerms,
+		Ctx:                  context.Background(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Body.([]command.QueryResult), nil
+}
+
+func TestLongQuery(t *testing.T) {
+	sum := make([]float64, 5)
+	for i := range sum {
+		sum[i] = float64(longQueryTerms * (i + 1))
+	}
+	tests := []struct {
+		separator string
+		results   int
+		values    []float64 // of the first series of the first result
+	}{
+		{separator: ", ", results: longQueryTerms, values: []float64{1, 2, 3, 4, 5}},
+		{separator: " + ", results: 1, values: sum},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("separator %q", test.separator)
+		query, comboAPI, err := longQuery(test.separator)
+		if err != nil {
+			t.Fatalf("Error creating query: %s", err.Error())
+		}
+		start := time.Now()
+		results, err := executeLongQuery(query, comboAPI)
+		elapsed := time.Since(start)
+		if err != nil {
+			a.Errorf("Error executing query: %s", err.Error())
+			continue
+		}
+		if elapsed > longQueryTarget {
+			a.Errorf("Expected the query to take at most %s, but it took %s", longQueryTarget, elapsed)
+		}
+		a.EqInt(len(results), test.results)
+		if len(results) == 0 || len(results[0].Series) != 1 {
+			a.Errorf("Expected a single series in the first result, but got %+v", results)
+			continue
+		}
+		a.EqFloatArray(results[0].Series[0].Values, test.values, 1e-10)
+	}
+}
+
+// benchmarkLongQuery parses and executes a query joining longQueryTerms fetches
+// with the separator.
+func benchmarkLongQuery(b *testing.B, separator string) {
+	query, comboAPI, err := longQuery(separator)
+	if err != nil {
+		b.Fatalf("Error creating query for benchmark: %s", err.Error())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := executeLongQuery(query, comboAPI); err != nil {
+			b.Fatalf("Error executing command: %s", err.Error())
+		}
+	}
+}
+
+func BenchmarkLongQueryList(b *testing.B) {
+	benchmarkLongQuery(b, ", ")
+}
+
+func BenchmarkLongQuerySum(b *testing.B) {
+	benchmarkLongQuery(b, " + ")
+}
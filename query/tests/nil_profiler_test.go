@@ -0,0 +1,92 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+	"github.com/square/metrics/function/registry"
+	"github.com/square/metrics/metric_metadata/cached"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// TestEvaluateWithoutProfiler checks that queries can be evaluated by an
+// embedding which constructs neither a profiler nor a place for notes.
+func TestEvaluateWithoutProfiler(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 120, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	comboAPI := mocks.NewComboAPI(
+		timerange,
+		api.Timeseries{Values: []float64{1, 2, 3, 4, 5}, TagSet: api.TagSet{"metric": "cpu", "host": "a", "dc": "north"}},
+		api.Timeseries{Values: []float64{6, 7, 8, 9, 10}, TagSet: api.TagSet{"metric": "cpu", "host": "b", "dc": "south"}},
+		api.Timeseries{Values: []float64{1, 1, 1, 1, 1}, TagSet: api.TagSet{"metric": "memory", "host": "a"}},
+	)
+	metadataAPI := cached.NewMetricMetadataAPI(comboAPI, cached.Config{TimeToLive: time.Minute, RequestLimit: 10})
+	queries := []string{
+		"select cpu from 0 to 120 resolution 30ms",
+		"select aggregate.sum(cpu group by dc, zone) from 0 to 120 resolution 30ms",
+		"select cpu | transform.moving_average(60ms) from 0 to 120 resolution 30ms",
+		"select cpu | transform.timeshift(-30ms) from 0 to 120 resolution 30ms",
+		"select cpu[host = 'c'] from 0 to 120 resolution 30ms",
+		"select fetch.by_tag('host=a') from 0 to 120 resolution 30ms",
+		"select fetch.coalesce(disk, memory) from 0 to 120 resolution 30ms",
+		"select cpu | summarize.mean from 0 to 120 resolution 30ms",
+		"select cpu + memory from 0 to 120 resolution 30ms",
+	}
+	for _, query := range queries {
+		a := assert.New(t).Contextf("%s", query)
+		parsed, err := parser.Parse(query)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %s", query, err.Error())
+		}
+		// Through the command, with no profiler:
+		if _, err := parsed.Execute(command.ExecutionContext{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    metadataAPI,
+			FetchLimit:           1000,
+			Ctx:                  context.Background(),
+		}); err != nil {
+			a.Errorf("unexpected error: %s", err.Error())
+		}
+		// Directly, with neither a profiler nor notes:
+		selectCommand := parsed.(*command.SelectCommand)
+		evaluationContext := function.EvaluationContextBuilder{
+			TimeseriesStorageAPI: comboAPI,
+			MetricMetadataAPI:    metadataAPI,
+			Registry:             registry.Default(),
+			SampleMethod:         timeseries.SampleMean,
+			FetchLimit:           function.NewFetchCounter(1000),
+			Timerange:            timerange,
+			Ctx:                  context.Background(),
+		}.Build()
+		for _, expression := range selectCommand.Expressions {
+			if _, err := expression.Evaluate(evaluationContext); err != nil {
+				a.Errorf("unexpected error: %s", err.Error())
+			}
+		}
+		a.EqInt(len(evaluationContext.Notes()), 0)
+	}
+}
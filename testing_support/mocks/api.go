@@ -32,9 +32,11 @@ type FakeMetricMetadataAPI struct {
 		key   string
 		value string
 	}][]api.MetricKey
+	metricKinds map[api.MetricKey]metadata.MetricKind
 }
 
 var _ metadata.MetricAPI = (*FakeMetricMetadataAPI)(nil)
+var _ metadata.MetricKindUpdateAPI = (*FakeMetricMetadataAPI)(nil)
 
 func NewFakeMetricMetadataAPI() *FakeMetricMetadataAPI {
 	return &FakeMetricMetadataAPI{
@@ -43,6 +45,7 @@ func NewFakeMetricMetadataAPI() *FakeMetricMetadataAPI {
 			key   string
 			value string
 		}][]api.MetricKey),
+		metricKinds: make(map[api.MetricKey]metadata.MetricKind),
 	}
 }
 
@@ -90,6 +93,20 @@ MetricLoop:
 	return list, nil
 }
 
+func (fa *FakeMetricMetadataAPI) GetMetricKind(metricKey api.MetricKey, context metadata.Context) (metadata.MetricKind, error) {
+	defer context.Profiler.Record("Mock GetMetricKind")()
+	if kind, ok := fa.metricKinds[metricKey]; ok {
+		return kind, nil
+	}
+	return metadata.KindUnknown, nil
+}
+
+func (fa *FakeMetricMetadataAPI) SetMetricKind(metricKey api.MetricKey, kind metadata.MetricKind, context metadata.Context) error {
+	defer context.Profiler.Record("Mock SetMetricKind")()
+	fa.metricKinds[metricKey] = kind
+	return nil
+}
+
 // CheckHealthy checks if the FakeMetricMetadataAPI is healthy
 func (fa *FakeMetricMetadataAPI) CheckHealthy() error {
 	return nil
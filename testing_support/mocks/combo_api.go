@@ -22,6 +22,8 @@ import (
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/metric_metadata"
 	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
 )
 
 type FakeComboAPI struct {
@@ -38,7 +40,7 @@ func (fapi FakeComboAPI) AddMetrics(metrics []api.TaggedMetric, context metadata
 func (fapi FakeComboAPI) GetAllTags(metric api.MetricKey, context metadata.Context) ([]api.TagSet, error) {
 	list, ok := fapi.metrics[metric]
 	if !ok {
-		return nil, fmt.Errorf("no such metric `%s`", metric)
+		return nil, metadata.NewNoSuchMetricError(string(metric))
 	}
 	tagsets := []api.TagSet{}
 	for _, timeseries := range list {
@@ -80,9 +82,25 @@ func (fapi FakeComboAPI) ChooseResolution(requested api.Timerange, smallestResol
 
 func (fapi FakeComboAPI) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
 	if request.Metric.MetricKey == "series_timeout" {
-		// This is a special-case.
-		<-time.After(30 * time.Second)
-		return api.Timeseries{}, fmt.Errorf("timeout occurred")
+		// This is a special-case. Like a real backend, it gives up when the
+		// request is cancelled, and (like an HTTP client) enforces the request's
+		// deadline with its own timer, which may fire before the context's.
+		var done <-chan struct{}
+		var expired <-chan time.Time
+		if request.Ctx != nil {
+			done = request.Ctx.Done()
+			if deadline, ok := request.Ctx.Deadline(); ok {
+				expired = time.After(deadline.Sub(time.Now()))
+			}
+		}
+		select {
+		case <-time.After(30 * time.Second):
+			return api.Timeseries{}, fmt.Errorf("timeout occurred")
+		case <-expired:
+			return api.Timeseries{}, context.DeadlineExceeded
+		case <-done:
+			return api.Timeseries{}, request.Ctx.Err()
+		}
 	}
 	if _, ok := fapi.metrics[request.Metric.MetricKey]; !ok {
 		return api.Timeseries{}, fmt.Errorf("no such metric `%s`", request.Metric.MetricKey)
@@ -114,21 +132,25 @@ func (fapi FakeComboAPI) FetchSingleTimeseries(request timeseries.FetchRequest)
 func (fapi FakeComboAPI) FetchMultipleTimeseries(multiRequest timeseries.FetchMultipleRequest) (api.SeriesList, error) {
 	requests := multiRequest.ToSingle()
 	seriesList := api.SeriesList{
-		Series: make([]api.Timeseries, len(requests)),
+		Series: make([]api.Timeseries, 0, len(requests)),
 	}
-	for i, request := range requests {
+	for _, request := range requests {
 		timeseries, err := fapi.FetchSingleTimeseries(request)
 		if err != nil {
+			if multiRequest.LeaveOut(request.Metric, err) {
+				continue
+			}
 			return api.SeriesList{}, err
 		}
-		seriesList.Series[i] = timeseries
+		seriesList.Series = append(seriesList.Series, timeseries)
 	}
 	return seriesList, nil
 }
 
 // NewComboAPI asks for a list of timeseries.
 // Each must have a `metric` tag which is used to set their metric key.
-// If you query a metric called `series_timeout` then the fetch will time-out.
+// If you query a metric called `series_timeout` then the fetch will time-out,
+// failing with the request's context's error once it expires.
 func NewComboAPI(timerange api.Timerange, timeseries ...api.Timeseries) FakeComboAPI {
 	result := FakeComboAPI{
 		timerange,
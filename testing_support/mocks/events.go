@@ -0,0 +1,29 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mocks
+
+import (
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/function"
+)
+
+// EventSource holds events in memory, by name.
+type EventSource map[string][]function.Event
+
+// FetchEvents returns every event with the given name; it's up to the caller
+// to discard those outside of the timerange.
+func (source EventSource) FetchEvents(name string, timerange api.Timerange) ([]function.Event, error) {
+	return source[name], nil
+}
@@ -67,10 +67,13 @@ func (r Resolution) String() string {
 }
 
 type Config struct {
-	BaseURL                 string       `yaml:"base_url"`
-	TenantID                string       `yaml:"tenant_id"`
-	Resolutions             []Resolution `yaml:"resolutions"`           // Resolutions are ordered by priority: best (typically finest) first.
-	MaxSimultaneousRequests int          `yaml:"simultaneous_requests"` // simultaneous requests limits the number of concurrent single-fetches for each multi-fetch
+	BaseURL                 string        `yaml:"base_url"`
+	TenantID                string        `yaml:"tenant_id"`
+	Resolutions             []Resolution  `yaml:"resolutions"`           // Resolutions are ordered by priority: best (typically finest) first.
+	MaxSimultaneousRequests int           `yaml:"simultaneous_requests"` // simultaneous requests limits the number of concurrent single-fetches for each multi-fetch
+	LocalDownsampling       bool          `yaml:"local_downsampling"`    // local downsampling serves resolutions coarser than every rollup by downsampling the coarsest one
+	IngestURL               string        `yaml:"ingest_url"`            // the URL of Blueflood's ingestion endpoint for Write (empty => BaseURL)
+	IngestTimeToLive        time.Duration `yaml:"ingest_ttl"`            // how long written points are kept (0 => 7 days)
 
 	GraphiteMetricConverter util.GraphiteConverter
 
@@ -115,10 +118,21 @@ func (b *Blueflood) CheckHealthy() error {
 
 // ChooseResolution will choose the finest-grained resolution for which an
 // interval fetch plan exists that is at least as coarse as the lower bound.
+// Since the plan fetches each part of the timerange from the coarsest rollup
+// available for it, points are only downsampled locally where no rollup at
+// the chosen resolution exists yet.
+//
+// If no rollup is coarse enough and LocalDownsampling is enabled, the
+// resolution is instead rounded up to a multiple of the coarsest rollup, and
+// the points fetched are downsampled locally.
 func (b *Blueflood) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
 	now := b.config.TimeSource.Now()
+	target := lowerBound
+	if requested.Resolution() > target {
+		target = requested.Resolution()
+	}
 	for i, current := range b.config.Resolutions {
-		if current.Resolution < lowerBound || current.Resolution < requested.Resolution() {
+		if current.Resolution < target {
 			continue
 		}
 		_, err := planFetchIntervals(b.config.Resolutions[:i+1], now, requested.Interval())
@@ -126,6 +140,15 @@ func (b *Blueflood) ChooseResolution(requested api.Timerange, lowerBound time.Du
 			return current.Resolution, nil
 		}
 	}
+	if b.config.LocalDownsampling && len(b.config.Resolutions) > 0 {
+		coarsest := b.config.Resolutions[len(b.config.Resolutions)-1].Resolution
+		if target > coarsest {
+			_, err := planFetchIntervals(b.config.Resolutions, now, requested.Interval())
+			if err == nil {
+				return (target + coarsest - 1) / coarsest * coarsest, nil
+			}
+		}
+	}
 	return 0, fmt.Errorf("cannot choose resolution for timerange %+v; available resolutions do not live long enough or are not available soon enough", requested)
 }
 
@@ -151,12 +174,17 @@ func (b *Blueflood) FetchMultipleTimeseries(request timeseries.FetchMultipleRequ
 
 	singleRequests := request.ToSingle()
 	results := make([]api.Timeseries, len(singleRequests))
+	omitted := make([]bool, len(singleRequests)) // series left out by a lenient fetch
 	queue := tasks.NewParallelQueue(b.config.MaxSimultaneousRequests, request.Ctx)
 	for i := range singleRequests {
 		i := i // Captures it in a new local for the closure.
 		queue.Do(func() error {
 			result, err := b.fetchTimeseries(singleRequests[i].Metric, plan, request.Profiler, request.Ctx)
 			if err != nil {
+				if request.LeaveOut(singleRequests[i].Metric, err) {
+					omitted[i] = true
+					return nil
+				}
 				return err
 			}
 			results[i] = result
@@ -168,9 +196,15 @@ func (b *Blueflood) FetchMultipleTimeseries(request timeseries.FetchMultipleRequ
 		return api.SeriesList{}, err
 	}
 
-	return api.SeriesList{
-		Series: results,
-	}, nil
+	list := api.SeriesList{
+		Series: make([]api.Timeseries, 0, len(results)),
+	}
+	for i := range results {
+		if !omitted[i] {
+			list.Series = append(list.Series, results[i])
+		}
+	}
+	return list, nil
 }
 
 // fetchPlan contains data required to fetch a timeseries by stitching together
@@ -221,6 +221,7 @@ func TestPlanChooseResolution(t *testing.T) {
 	type test struct {
 		requested  api.Timerange
 		lowerBound time.Duration
+		local      bool
 		expected   time.Duration
 		error      bool
 	}
@@ -265,10 +266,33 @@ func TestPlanChooseResolution(t *testing.T) {
 			lowerBound: 0,
 			error:      true,
 		},
+		{
+			requested:  makeRange(100*day, 0, 30*time.Second),
+			lowerBound: 36 * time.Hour,
+			error:      true,
+		},
+		{
+			requested:  makeRange(100*day, 0, 30*time.Second),
+			lowerBound: 36 * time.Hour,
+			local:      true,
+			expected:   2 * day,
+		},
+		{
+			requested:  makeRange(100*day, 0, 30*time.Second),
+			lowerBound: 2 * time.Hour,
+			local:      true,
+			expected:   day,
+		},
+		{
+			requested:  makeRange(901*day, 0, 30*time.Second),
+			lowerBound: 36 * time.Hour,
+			local:      true,
+			error:      true,
+		},
 	}
 	for i, test := range testcases {
 		a := a.Contextf("test #%d (input %+v)", i+1, test.requested)
-		actual, err := (&Blueflood{config: Config{TimeSource: nowFunc, Resolutions: testResolutions}}).ChooseResolution(test.requested, test.lowerBound)
+		actual, err := (&Blueflood{config: Config{TimeSource: nowFunc, Resolutions: testResolutions, LocalDownsampling: test.local}}).ChooseResolution(test.requested, test.lowerBound)
 		if test.error {
 			if err == nil {
 				a.Errorf("Expected error but got: %+v", actual)
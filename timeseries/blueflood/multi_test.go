@@ -16,6 +16,7 @@ package blueflood
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -326,3 +327,122 @@ func TestBluefloodHTTPQueriesMultiResolutionMulti(t *testing.T) {
 	}
 	assert.New(t).Contextf("request for timerange").Eq(result, expected)
 }
+
+func TestBluefloodLenientMulti(t *testing.T) {
+	nowMillis := int64(739908000000)
+	timerange, err := api.NewTimerange(nowMillis-2*60*1000, nowMillis, 30*1000)
+	if err != nil {
+		t.Fatalf("Problem creating timerange for test: %s", err.Error())
+	}
+	testClient := mocks.NewFakeHTTPClient()
+	testClient.SetResponse("https://blueflood.url/v2.0/square/views/good.key.graphite?from=739907880000&resolution=FULL&select=numPoints%2Caverage&to=739907999999", mocks.Response{
+		Body:       `{"unit": "unknown", "values": [{"numPoints": 1, "timestamp": 739907880000, "average": 5}]}`,
+		StatusCode: 200,
+	})
+	testClient.SetResponse("https://blueflood.url/v2.0/square/views/bad.key.graphite?from=739907880000&resolution=FULL&select=numPoints%2Caverage&to=739907999999", mocks.Response{
+		Body:       `{"unit": "unknown", "values": [{"numPoints": 1, "timestamp": `,
+		StatusCode: 200,
+	})
+	blueflood := NewBlueflood(Config{
+		BaseURL:                 "https://blueflood.url",
+		TenantID:                "square",
+		Resolutions:             []Resolution{resolutionFull, resolution5Min, resolution60Min, resolution1440Min},
+		MaxSimultaneousRequests: 2,
+		GraphiteMetricConverter: &mocks.FakeGraphiteConverter{
+			MetricMap: map[util.GraphiteMetric]api.TaggedMetric{
+				"good.key.graphite": {MetricKey: "some.key", TagSet: api.TagSet{"tag": "good"}},
+				"bad.key.graphite":  {MetricKey: "some.key", TagSet: api.TagSet{"tag": "bad"}},
+			},
+		},
+		HTTPClient: testClient,
+		TimeSource: TimeSource{GetTime: func() time.Time { return time.Unix(nowMillis/1000, 0) }},
+	})
+	request := timeseries.FetchMultipleRequest{
+		Metrics: []api.TaggedMetric{
+			{MetricKey: "some.key", TagSet: api.TagSet{"tag": "bad"}},
+			{MetricKey: "some.key", TagSet: api.TagSet{"tag": "good"}},
+		},
+		RequestDetails: timeseries.RequestDetails{
+			SampleMethod: timeseries.SampleMean,
+			Timerange:    timerange,
+			Ctx:          context.Background(),
+		},
+	}
+	a := assert.New(t)
+	if _, err := blueflood.FetchMultipleTimeseries(request); err == nil {
+		a.Errorf("Expected a strict fetch to fail")
+	}
+
+	failed := []api.TaggedMetric{}
+	request.OnSeriesError = func(metric api.TaggedMetric, err error) {
+		failed = append(failed, metric)
+	}
+	result, err := blueflood.FetchMultipleTimeseries(request)
+	a.CheckError(err)
+	a.Eq(failed, []api.TaggedMetric{{MetricKey: "some.key", TagSet: api.TagSet{"tag": "bad"}}})
+	a.EqInt(len(result.Series), 1)
+	a.Eq(result.Series[0].TagSet, api.TagSet{"tag": "good"})
+}
+
+func TestBluefloodLocalDownsampling(t *testing.T) {
+	nowMillis := int64(739908000000)
+	start := nowMillis - int64((2*day+time.Hour)/time.Millisecond)
+	requested, err := api.NewTimerange(start, nowMillis-int64(2*day/time.Millisecond), 30*1000)
+	if err != nil {
+		t.Fatalf("Problem creating timerange for test: %s", err.Error())
+	}
+	// The 5MIN rollup is requested directly, and pairs of its points are
+	// averaged into each 10 minute slot.
+	points := []string{}
+	for i := 0; i < 16; i++ {
+		points = append(points, fmt.Sprintf(`{"numPoints": 1, "timestamp": %d, "average": %d}`, start+int64(i)*5*60*1000, i))
+	}
+	testClient := mocks.NewFakeHTTPClient()
+	testClient.SetResponse("https://blueflood.url/v2.0/square/views/some.key.graphite?from=739731600000&resolution=5MIN&select=numPoints%2Caverage&to=739735799999", mocks.Response{
+		Body:       fmt.Sprintf(`{"unit": "unknown", "values": [%s]}`, strings.Join(points, ",")),
+		StatusCode: 200,
+	})
+	config := Config{
+		BaseURL:                 "https://blueflood.url",
+		TenantID:                "square",
+		Resolutions:             []Resolution{resolutionFull, resolution5Min},
+		MaxSimultaneousRequests: 2,
+		GraphiteMetricConverter: &mocks.FakeGraphiteConverter{
+			MetricMap: map[util.GraphiteMetric]api.TaggedMetric{
+				"some.key.graphite": {MetricKey: "some.key", TagSet: api.TagSet{"tag": "value"}},
+			},
+		},
+		HTTPClient: testClient,
+		TimeSource: TimeSource{GetTime: func() time.Time { return time.Unix(nowMillis/1000, 0) }},
+	}
+	a := assert.New(t)
+	if _, err := NewBlueflood(config).ChooseResolution(requested, 10*time.Minute); err == nil {
+		a.Errorf("Expected an error choosing a resolution coarser than every rollup without local downsampling")
+	}
+	config.LocalDownsampling = true
+	blueflood := NewBlueflood(config)
+	resolution, err := blueflood.ChooseResolution(requested, 10*time.Minute)
+	a.CheckError(err)
+	a.Eq(resolution, 10*time.Minute)
+
+	timerange, err := api.NewTimerange(requested.StartMillis(), requested.EndMillis(), int64(resolution/time.Millisecond))
+	if err != nil {
+		t.Fatalf("Problem creating timerange for test: %s", err.Error())
+	}
+	details := timeseries.RequestDetails{
+		SampleMethod: timeseries.SampleMean,
+		Timerange:    timerange,
+		Ctx:          context.Background(),
+	}
+	metric := api.TaggedMetric{MetricKey: "some.key", TagSet: api.TagSet{"tag": "value"}}
+	expected := api.Timeseries{
+		Values: []float64{0.5, 2.5, 4.5, 6.5, 8.5, 10.5, 12.5},
+		TagSet: api.TagSet{"tag": "value"},
+	}
+	single, err := blueflood.FetchSingleTimeseries(timeseries.FetchRequest{Metric: metric, RequestDetails: details})
+	a.CheckError(err)
+	a.Contextf("single").Eq(single, expected)
+	multiple, err := blueflood.FetchMultipleTimeseries(timeseries.FetchMultipleRequest{Metrics: []api.TaggedMetric{metric}, RequestDetails: details})
+	a.CheckError(err)
+	a.Contextf("multiple").Eq(multiple, api.SeriesList{Series: []api.Timeseries{expected}})
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueflood
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/square/metrics/api"
+
+	"golang.org/x/net/context"
+)
+
+// defaultIngestTimeToLive is how long written points are kept, unless configured otherwise.
+const defaultIngestTimeToLive = 7 * 24 * time.Hour
+
+// A Point is a single timestamped value to be written.
+type Point struct {
+	Timestamp int64 // in milliseconds since the epoch
+	Value     float64
+}
+
+// ingestPoint is the form of a point expected by Blueflood's ingestion endpoint.
+type ingestPoint struct {
+	CollectionTime int64   `json:"collectionTime"`
+	TimeToLive     int64   `json:"ttlInSeconds"`
+	MetricValue    float64 `json:"metricValue"`
+	MetricName     string  `json:"metricName"`
+}
+
+// Write sends the points of the tagged metric to Blueflood's ingestion
+// endpoint, so that (for example) tests and backfills can seed a real backend.
+// The metric is named by the GraphiteMetricConverter, as for fetches. NaN
+// points can't be stored, so they're skipped.
+func (b *Blueflood) Write(metric api.TaggedMetric, points []Point, ctx context.Context) error {
+	graphiteName, err := b.config.GraphiteMetricConverter.ToGraphiteName(metric)
+	if err != nil {
+		return fmt.Errorf("cannot convert %s to a graphite name: %s", metric.String(), err.Error())
+	}
+	timeToLive := b.config.IngestTimeToLive
+	if timeToLive == 0 {
+		timeToLive = defaultIngestTimeToLive
+	}
+	body := []ingestPoint{}
+	for _, point := range points {
+		if math.IsNaN(point.Value) {
+			continue
+		}
+		body = append(body, ingestPoint{
+			CollectionTime: point.Timestamp,
+			TimeToLive:     int64(timeToLive / time.Second),
+			MetricValue:    point.Value,
+			MetricName:     string(graphiteName),
+		})
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	baseURL := b.config.IngestURL
+	if baseURL == "" {
+		baseURL = b.config.BaseURL
+	}
+	ingestURL := fmt.Sprintf("%s/v2.0/%s/ingest", baseURL, b.config.TenantID)
+	request, err := http.NewRequest("POST", ingestURL, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if ctx != nil {
+		request.Cancel = ctx.Done()
+	}
+	response, err := b.config.HTTPClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error writing to Blueflood at URL %q: %s", ingestURL, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Blueflood at URL %q rejected the write with status %d: %s", ingestURL, response.StatusCode, string(message))
+	}
+	return nil
+}
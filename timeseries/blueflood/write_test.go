@@ -0,0 +1,77 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueflood
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/testing_support/mocks"
+	"github.com/square/metrics/util"
+
+	"golang.org/x/net/context"
+)
+
+func TestBluefloodWrite(t *testing.T) {
+	a := assert.New(t)
+	var received []ingestPoint
+	var path string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		path = request.Method + " " + request.URL.Path
+		received = nil
+		if err := json.NewDecoder(request.Body).Decode(&received); err != nil {
+			t.Errorf("Unexpected error decoding the ingested points: %s", err.Error())
+		}
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	metric := api.TaggedMetric{MetricKey: "some.key", TagSet: api.TagSet{"tag": "value"}}
+	b := NewBlueflood(Config{
+		BaseURL:          "http://blueflood.invalid",
+		IngestURL:        server.URL,
+		TenantID:         "square",
+		IngestTimeToLive: time.Hour,
+		GraphiteMetricConverter: &mocks.FakeGraphiteConverter{
+			MetricMap: map[util.GraphiteMetric]api.TaggedMetric{"some.key.graphite": metric},
+		},
+	}).(*Blueflood)
+
+	err := b.Write(metric, []Point{{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: math.NaN()}, {Timestamp: 3000, Value: -1.5}}, context.Background())
+	a.CheckError(err)
+	a.EqString(path, "POST /v2.0/square/ingest")
+	a.Eq(received, []ingestPoint{
+		{CollectionTime: 1000, TimeToLive: 3600, MetricValue: 5, MetricName: "some.key.graphite"},
+		{CollectionTime: 3000, TimeToLive: 3600, MetricValue: -1.5, MetricName: "some.key.graphite"},
+	})
+
+	// Metrics without a graphite name can't be written.
+	if err := b.Write(api.TaggedMetric{MetricKey: "other.key"}, []Point{{Timestamp: 1000, Value: 5}}, context.Background()); err == nil {
+		t.Errorf("Expected an error for a metric without a graphite name")
+	}
+
+	// Errors from Blueflood are reported.
+	status = http.StatusBadRequest
+	if err := b.Write(metric, []Point{{Timestamp: 1000, Value: 5}}, context.Background()); err == nil {
+		t.Errorf("Expected an error when Blueflood rejects the write")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coalesced provides a timeseries.StorageAPI wrapper which shares a
+// single backend call between concurrent identical fetches.
+package coalesced
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/inspect"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// call is a fetch which is currently in flight. It runs under its own context
+// and profiler rather than any one caller's, so that a caller which gives up
+// doesn't fail the fetch for the others; it's only cancelled once every caller
+// sharing it has given up.
+type call struct {
+	done    chan struct{} // closed once the fetch is complete
+	callers int           // the number of callers still waiting for the fetch
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	profiler *inspect.Profiler // the profiles of the fetch, which are passed on to each caller sharing it
+
+	single api.Timeseries
+	list   api.SeriesList
+	err    error
+
+	mutex sync.Mutex
+	notes []string // the notes from the fetch, which are passed on to each caller sharing it
+}
+
+// record keeps a note from the fetch for the callers sharing it.
+func (c *call) record(note string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.notes = append(c.notes, note)
+}
+
+// replay passes the fetch's notes and profiles on to a caller which shared it.
+func (c *call) replay(details timeseries.RequestDetails) {
+	for _, profile := range c.profiler.All() {
+		details.Profiler.AddProfile(profile)
+	}
+	if details.OnNote == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, note := range c.notes {
+		details.OnNote(note)
+	}
+}
+
+// storageAPI coalesces concurrent identical fetches. It does not cache: once a
+// fetch completes, the next identical request goes to the backend again.
+type storageAPI struct {
+	timeseries.StorageAPI // the underlying StorageAPI that performs the fetches
+
+	mutex    sync.Mutex
+	inflight map[string]*call
+}
+
+// NewStorageAPI wraps the given StorageAPI so that identical fetches which are
+// made concurrently (for the same metrics, timerange and sample method) result
+// in a single call to the underlying API, whose result is shared.
+//
+// Since the FetchCounter for each query is consumed before the StorageAPI is
+// called, each query is still charged once for its fetch.
+func NewStorageAPI(underlying timeseries.StorageAPI) timeseries.StorageAPI {
+	return &storageAPI{
+		StorageAPI: underlying,
+		inflight:   map[string]*call{},
+	}
+}
+
+// join returns the in-flight call for the key, and whether the caller is the
+// one responsible for starting it. A call which every caller has given up on
+// (and so has been cancelled) isn't joined; a new one replaces it instead.
+func (s *storageAPI) join(key string) (*call, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c, ok := s.inflight[key]; ok && c.callers > 0 {
+		c.callers++
+		return c, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &call{
+		done:     make(chan struct{}),
+		callers:  1,
+		ctx:      ctx,
+		cancel:   cancel,
+		profiler: inspect.New(),
+	}
+	s.inflight[key] = c
+	return c, true
+}
+
+// run performs the fetch for the call, marking it complete when it's done,
+// even if the fetch panics.
+func (s *storageAPI) run(key string, c *call, fetch func()) {
+	defer s.finish(key, c)
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("coalesced fetch panicked: %v", r)
+		}
+	}()
+	fetch()
+}
+
+// finish marks the call as complete, waking everyone waiting on it.
+func (s *storageAPI) finish(key string, c *call) {
+	s.mutex.Lock()
+	if s.inflight[key] == c {
+		delete(s.inflight, key)
+	}
+	s.mutex.Unlock()
+	c.cancel()
+	close(c.done)
+}
+
+// wait blocks until the call is done, or until the caller's context expires;
+// in the latter case, the caller stops sharing the call, which is cancelled
+// if nobody else is waiting for it.
+func (s *storageAPI) wait(c *call, ctx context.Context) error {
+	if ctx == nil {
+		<-c.done
+		return nil
+	}
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		s.mutex.Lock()
+		c.callers--
+		abandoned := c.callers == 0
+		s.mutex.Unlock()
+		if abandoned {
+			c.cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+// shared returns the details to make the call's fetch with, which belong to
+// the call rather than the caller which happened to start it.
+func (c *call) shared(details timeseries.RequestDetails) timeseries.RequestDetails {
+	details.Ctx = c.ctx
+	details.Profiler = c.profiler
+	details.OnNote = c.record
+	return details
+}
+
+func (s *storageAPI) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
+	key := "single:" + detailsKey(request.RequestDetails) + metricKey(request.Metric)
+	c, leader := s.join(key)
+	if leader {
+		shared := request
+		shared.RequestDetails = c.shared(request.RequestDetails)
+		go s.run(key, c, func() {
+			c.single, c.err = s.StorageAPI.FetchSingleTimeseries(shared)
+		})
+	}
+	if err := s.wait(c, request.Ctx); err != nil {
+		return api.Timeseries{}, err
+	}
+	c.replay(request.RequestDetails)
+	return copySeries(c.single), c.err
+}
+
+func (s *storageAPI) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	if request.OnSeriesError != nil {
+		// Each lenient fetch must hear about its own failures, so it isn't shared.
+		return s.StorageAPI.FetchMultipleTimeseries(request)
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("multiple:")
+	buffer.WriteString(detailsKey(request.RequestDetails))
+	for _, metric := range request.Metrics {
+		buffer.WriteString(metricKey(metric))
+	}
+	key := buffer.String()
+	c, leader := s.join(key)
+	if leader {
+		shared := request
+		shared.RequestDetails = c.shared(request.RequestDetails)
+		go s.run(key, c, func() {
+			c.list, c.err = s.StorageAPI.FetchMultipleTimeseries(shared)
+		})
+	}
+	if err := s.wait(c, request.Ctx); err != nil {
+		return api.SeriesList{}, err
+	}
+	c.replay(request.RequestDetails)
+	list := api.SeriesList{Series: make([]api.Timeseries, len(c.list.Series)), Annotations: c.list.Annotations}
+	for i := range list.Series {
+		list.Series[i] = copySeries(c.list.Series[i])
+	}
+	return list, c.err
+}
+
+// copySeries copies the series' values, so that the callers sharing a fetch
+// cannot interfere with one another.
+func copySeries(series api.Timeseries) api.Timeseries {
+	if series.Values != nil {
+		values := make([]float64, len(series.Values))
+		copy(values, series.Values)
+		series.Values = values
+	}
+	return series
+}
+
+func detailsKey(details timeseries.RequestDetails) string {
+	timerange := details.Timerange
+	return fmt.Sprintf("%d:%d:%d:%d;", timerange.StartMillis(), timerange.EndMillis(), timerange.ResolutionMillis(), details.SampleMethod)
+}
+
+func metricKey(metric api.TaggedMetric) string {
+	return fmt.Sprintf("%q%q;", metric.MetricKey, metric.TagSet.Serialize())
+}
@@ -0,0 +1,387 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coalesced
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+// blockingAPI counts fetches, and holds each of them until released or
+// cancelled.
+type blockingAPI struct {
+	timeseries.StorageAPI
+	calls   int32
+	release chan struct{}
+	err     error
+	panics  bool
+}
+
+func (b *blockingAPI) block(ctx context.Context) error {
+	atomic.AddInt32(&b.calls, 1)
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if b.panics {
+		panic("backend panic")
+	}
+	return nil
+}
+
+func (b *blockingAPI) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
+	if err := b.block(request.Ctx); err != nil {
+		return api.Timeseries{}, err
+	}
+	return api.Timeseries{Values: []float64{1, 2, 3}, TagSet: request.Metric.TagSet}, b.err
+}
+
+func (b *blockingAPI) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	if err := b.block(request.Ctx); err != nil {
+		return api.SeriesList{}, err
+	}
+	if request.OnNote != nil {
+		request.OnNote(fmt.Sprintf("fetched %d series", len(request.Metrics)))
+	}
+	list := api.SeriesList{}
+	for _, metric := range request.Metrics {
+		list.Series = append(list.Series, api.Timeseries{Values: []float64{1, 2, 3}, TagSet: metric.TagSet})
+	}
+	return list, b.err
+}
+
+// waitForCallers blocks until the in-flight calls have the given number of callers between them.
+func waitForCallers(t *testing.T, s *storageAPI, callers int) {
+	for attempt := 0; attempt < 1000; attempt++ {
+		s.mutex.Lock()
+		total := 0
+		for _, c := range s.inflight {
+			total += c.callers
+		}
+		s.mutex.Unlock()
+		if total == callers {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d callers", callers)
+}
+
+func multipleRequest(timerange api.Timerange, hosts ...string) timeseries.FetchMultipleRequest {
+	request := timeseries.FetchMultipleRequest{
+		RequestDetails: timeseries.RequestDetails{
+			Timerange:    timerange,
+			SampleMethod: timeseries.SampleMean,
+			Ctx:          context.Background(),
+		},
+	}
+	for _, host := range hosts {
+		request.Metrics = append(request.Metrics, api.TaggedMetric{MetricKey: "cpu", TagSet: api.TagSet{"host": host}})
+	}
+	return request
+}
+
+func TestCoalescedMultiple(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{})}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+
+	const callers = 10
+	results := make([]api.SeriesList, callers)
+	errors := make([]error, callers)
+	waiter := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		i := i
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			results[i], errors[i] = coalesced.FetchMultipleTimeseries(multipleRequest(timerange, "a", "b"))
+		}()
+	}
+	waitForCallers(t, coalesced, callers)
+	close(backend.release)
+	waiter.Wait()
+
+	a.EqInt(int(atomic.LoadInt32(&backend.calls)), 1)
+	for i := range results {
+		a := a.Contextf("caller %d", i)
+		a.CheckError(errors[i])
+		a.EqInt(len(results[i].Series), 2)
+		a.Eq(results[i].Series[0].TagSet, api.TagSet{"host": "a"})
+		a.EqFloatArray(results[i].Series[1].Values, []float64{1, 2, 3}, 0)
+	}
+	// The callers don't share the underlying values.
+	results[0].Series[0].Values[0] = 100
+	a.EqFloat(results[1].Series[0].Values[0], 1, 0)
+
+	// The fetch is no longer in flight, so the next one goes to the backend.
+	_, err = coalesced.FetchMultipleTimeseries(multipleRequest(timerange, "a", "b"))
+	a.CheckError(err)
+	a.EqInt(int(atomic.LoadInt32(&backend.calls)), 2)
+	a.EqInt(len(coalesced.inflight), 0)
+}
+
+func TestCoalescedNotes(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{})}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+
+	const callers = 3
+	notes := make([][]string, callers)
+	waiter := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		i := i
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			request := multipleRequest(timerange, "a", "b")
+			request.OnNote = func(note string) { notes[i] = append(notes[i], note) }
+			_, err := coalesced.FetchMultipleTimeseries(request)
+			a.CheckError(err)
+		}()
+	}
+	waitForCallers(t, coalesced, callers)
+	close(backend.release)
+	waiter.Wait()
+
+	a.EqInt(int(atomic.LoadInt32(&backend.calls)), 1)
+	// Every caller sharing the fetch hears its notes.
+	for i := range notes {
+		a.Contextf("caller %d", i).Eq(notes[i], []string{"fetched 2 series"})
+	}
+}
+
+func TestCoalescedDistinct(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	shifted := timerange.Shift(time.Minute)
+	backend := &blockingAPI{release: make(chan struct{})}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+
+	requests := []timeseries.FetchMultipleRequest{
+		multipleRequest(timerange, "a", "b"),
+		multipleRequest(timerange, "b", "a"),
+		multipleRequest(timerange, "a"),
+		multipleRequest(shifted, "a", "b"),
+	}
+	max := multipleRequest(timerange, "a", "b")
+	max.SampleMethod = timeseries.SampleMax
+	requests = append(requests, max)
+
+	waiter := sync.WaitGroup{}
+	for _, request := range requests {
+		request := request
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			coalesced.FetchMultipleTimeseries(request)
+		}()
+	}
+	for attempt := 0; atomic.LoadInt32(&backend.calls) < int32(len(requests)) && attempt < 1000; attempt++ {
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.release)
+	waiter.Wait()
+	a.EqInt(int(atomic.LoadInt32(&backend.calls)), len(requests))
+}
+
+func TestCoalescedSingleError(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{}), err: fmt.Errorf("backend failure")}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+	request := timeseries.FetchRequest{
+		Metric: api.TaggedMetric{MetricKey: "cpu", TagSet: api.TagSet{"host": "a"}},
+		RequestDetails: timeseries.RequestDetails{
+			Timerange: timerange,
+			Ctx:       context.Background(),
+		},
+	}
+
+	const callers = 5
+	errors := make([]error, callers)
+	waiter := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		i := i
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			_, errors[i] = coalesced.FetchSingleTimeseries(request)
+		}()
+	}
+	waitForCallers(t, coalesced, callers)
+	close(backend.release)
+	waiter.Wait()
+
+	a.EqInt(int(atomic.LoadInt32(&backend.calls)), 1)
+	for i := range errors {
+		if errors[i] == nil || errors[i].Error() != "backend failure" {
+			t.Errorf("Expected caller %d to receive the backend's error but got %+v", i, errors[i])
+		}
+	}
+}
+
+func TestCoalescedWaiterTimeout(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{})}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+	defer close(backend.release)
+
+	go coalesced.FetchMultipleTimeseries(multipleRequest(timerange, "a"))
+	for attempt := 0; atomic.LoadInt32(&backend.calls) < 1 && attempt < 1000; attempt++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A waiter whose context expires gives up without waiting for the shared fetch.
+	request := multipleRequest(timerange, "a")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request.Ctx = ctx
+	if _, err := coalesced.FetchMultipleTimeseries(request); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the waiter to time out, but got %+v", err)
+	}
+}
+
+func TestCoalescedLeaderTimeout(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{})}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+
+	// The caller which starts the fetch gives up on it.
+	leader := multipleRequest(timerange, "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	leader.Ctx = ctx
+	leaderErr := make(chan error)
+	go func() {
+		_, err := coalesced.FetchMultipleTimeseries(leader)
+		leaderErr <- err
+	}()
+	for attempt := 0; atomic.LoadInt32(&backend.calls) < 1 && attempt < 1000; attempt++ {
+		time.Sleep(time.Millisecond)
+	}
+	var list api.SeriesList
+	waiterErr := make(chan error)
+	go func() {
+		var err error
+		list, err = coalesced.FetchMultipleTimeseries(multipleRequest(timerange, "a"))
+		waiterErr <- err
+	}()
+	waitForCallers(t, coalesced, 2)
+	cancel()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Fatalf("Expected the leader to be cancelled, but got %+v", err)
+	}
+
+	// The fetch carries on for the caller still waiting on it.
+	close(backend.release)
+	a.CheckError(<-waiterErr)
+	a.EqInt(len(list.Series), 1)
+	a.EqInt(int(atomic.LoadInt32(&backend.calls)), 1)
+}
+
+func TestCoalescedAbandoned(t *testing.T) {
+	a := assert.New(t)
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{})}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+
+	request := multipleRequest(timerange, "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	request.Ctx = ctx
+	c, _ := coalesced.join("abandoned")
+	cancel()
+	// Once every caller has given up, the shared fetch is cancelled.
+	if err := coalesced.wait(c, request.Ctx); err != context.Canceled {
+		t.Fatalf("Expected the caller to be cancelled, but got %+v", err)
+	}
+	if c.ctx.Err() != context.Canceled {
+		t.Fatalf("Expected the abandoned fetch to be cancelled, but got %+v", c.ctx.Err())
+	}
+	// A later caller starts a new fetch rather than sharing the cancelled one.
+	next, leader := coalesced.join("abandoned")
+	a.Eq(leader, true)
+	if next == c {
+		t.Errorf("Expected a new call to replace the abandoned one")
+	}
+	coalesced.finish("abandoned", c)
+	coalesced.mutex.Lock()
+	a.Eq(coalesced.inflight["abandoned"] == next, true)
+	coalesced.mutex.Unlock()
+	coalesced.finish("abandoned", next)
+}
+
+func TestCoalescedPanic(t *testing.T) {
+	timerange, err := api.NewSnappedTimerange(0, 60000, 30000)
+	if err != nil {
+		t.Fatalf("Error creating timerange for test: %s", err.Error())
+	}
+	backend := &blockingAPI{release: make(chan struct{}), panics: true}
+	coalesced := NewStorageAPI(backend).(*storageAPI)
+
+	const callers = 3
+	errors := make([]error, callers)
+	waiter := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		i := i
+		waiter.Add(1)
+		go func() {
+			defer waiter.Done()
+			_, errors[i] = coalesced.FetchMultipleTimeseries(multipleRequest(timerange, "a"))
+		}()
+	}
+	waitForCallers(t, coalesced, callers)
+	close(backend.release)
+	// Nobody is left waiting when the fetch panics.
+	waiter.Wait()
+	for i := range errors {
+		if errors[i] == nil {
+			t.Errorf("Expected caller %d to receive an error from the panicking fetch", i)
+		}
+	}
+}
@@ -38,6 +38,32 @@ type RequestDetails struct {
 	Timerange    api.Timerange   // time range to fetch data from.
 	Ctx          context.Context // context includes timeout details
 	Profiler     *inspect.Profiler
+
+	// OnSeriesError, if set, makes the fetch lenient: a series which can't be
+	// fetched (other than because the request was cancelled or timed out) is
+	// left out of the result, and reported to OnSeriesError, instead of failing
+	// the whole fetch. It may be called concurrently.
+	OnSeriesError func(metric api.TaggedMetric, err error)
+
+	// OnNote, if set, receives notes about the fetch to be shown alongside the
+	// query's results, such as backends disagreeing about a series. It may be
+	// called concurrently.
+	OnNote func(note string)
+}
+
+// LeaveOut reports whether the series which failed to fetch with the given
+// error should be left out of a lenient fetch's result, passing the error to
+// OnSeriesError if so. A fetch which isn't lenient, or whose request has been
+// cancelled or timed out, should fail instead.
+func (details RequestDetails) LeaveOut(metric api.TaggedMetric, err error) bool {
+	if details.OnSeriesError == nil {
+		return false
+	}
+	if details.Ctx != nil && details.Ctx.Err() != nil {
+		return false
+	}
+	details.OnSeriesError(metric, err)
+	return true
 }
 
 type FetchRequest struct {
@@ -118,3 +144,18 @@ func (r FetchMultipleRequest) ToSingle() []FetchRequest {
 	}
 	return fetchSingleRequests
 }
+
+// QueryRange chooses a timerange covering [start, end] (in milliseconds) with
+// at most about maxPoints slots, as api.QueryRange does, but at a resolution
+// which the storage can serve.
+func QueryRange(storage StorageAPI, start, end int64, maxPoints int) (api.Timerange, error) {
+	nice, err := api.QueryRange(start, end, maxPoints)
+	if err != nil {
+		return api.Timerange{}, err
+	}
+	resolution, err := storage.ChooseResolution(nice, nice.Resolution())
+	if err != nil {
+		return api.Timerange{}, err
+	}
+	return api.NewAlignedTimerange(start, end, int64(resolution/time.Millisecond))
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timeseries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+// coarseStorage serves no resolution finer than its own.
+type coarseStorage struct {
+	StorageAPI
+	resolution time.Duration
+}
+
+func (s coarseStorage) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
+	if lowerBound > s.resolution {
+		return lowerBound, nil
+	}
+	return s.resolution, nil
+}
+
+func TestQueryRange(t *testing.T) {
+	a := assert.New(t)
+	hour := int64(time.Hour / time.Millisecond)
+	// The nice resolution is used if the storage can serve it.
+	timerange, err := QueryRange(coarseStorage{resolution: 30 * time.Second}, 0, hour, 100)
+	a.CheckError(err)
+	a.Eq(timerange.Resolution(), time.Minute)
+	// Otherwise the storage's is.
+	timerange, err = QueryRange(coarseStorage{resolution: 5 * time.Minute}, 10, hour+10, 100)
+	a.CheckError(err)
+	a.Eq(timerange.Resolution(), 5*time.Minute)
+	a.Eq(timerange.StartMillis(), int64(0))
+	a.Eq(timerange.EndMillis(), hour+5*60000)
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recorded
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/square/metrics/api"
+)
+
+// point is a sample belonging to a particular tagset.
+type point struct {
+	TagSet api.TagSet
+	sample
+}
+
+// parsers read the contents of a file in the format given by its extension.
+var parsers = map[string]func([]byte) ([]point, error){
+	".csv":    parseCSV,
+	".ndjson": parseNDJSON,
+}
+
+func parseCSV(contents []byte) ([]point, error) {
+	reader := csv.NewReader(bytes.NewReader(contents))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < 2 || header[0] != "timestamp" || header[1] != "value" {
+		return nil, fmt.Errorf("the header must begin with the columns `timestamp,value`")
+	}
+	points := []point{}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp `%s`", line, row[0])
+		}
+		value, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value `%s`", line, row[1])
+		}
+		tagSet := api.TagSet{}
+		for i := 2; i < len(row); i++ {
+			if row[i] != "" {
+				tagSet[header[i]] = row[i]
+			}
+		}
+		points = append(points, point{TagSet: tagSet, sample: sample{Timestamp: timestamp, Value: value}})
+	}
+}
+
+func parseNDJSON(contents []byte) ([]point, error) {
+	points := []point{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record struct {
+			Timestamp *int64            `json:"timestamp"`
+			Value     *float64          `json:"value"`
+			Tags      map[string]string `json:"tags"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if record.Timestamp == nil || record.Value == nil {
+			return nil, fmt.Errorf("line %d: each record must have a timestamp and a value", line)
+		}
+		tagSet := api.TagSet{}
+		for key, value := range record.Tags {
+			tagSet[key] = value
+		}
+		points = append(points, point{TagSet: tagSet, sample: sample{Timestamp: *record.Timestamp, Value: *record.Value}})
+	}
+	return points, scanner.Err()
+}
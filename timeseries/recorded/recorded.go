@@ -0,0 +1,260 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recorded provides an API which serves metrics recorded in local
+// files, so that queries can be run offline (for example, to analyze an
+// incident after the fact, or to reproduce a query bug deterministically).
+//
+// Each file in the directory holds the samples for one metric, which is named
+// by the file's name without its extension. Two formats are understood:
+//
+// A ".csv" file has a header row naming its columns: "timestamp" (in
+// milliseconds since the epoch), "value", and then one column per tag. Each
+// following row is one sample. Empty tag cells are omitted from the tagset.
+//
+// A ".ndjson" file has one JSON object per line, such as
+// {"timestamp": 1460000000000, "value": 4.5, "tags": {"host": "a"}}.
+//
+// Other files are ignored.
+package recorded
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/timeseries"
+)
+
+// sample is a single recorded point.
+type sample struct {
+	Timestamp int64 // milliseconds since the epoch
+	Value     float64
+}
+
+type byTimestamp []sample
+
+func (samples byTimestamp) Len() int           { return len(samples) }
+func (samples byTimestamp) Less(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp }
+func (samples byTimestamp) Swap(i, j int)      { samples[i], samples[j] = samples[j], samples[i] }
+
+// series is the recorded samples for a single tagset, in order of time.
+type series struct {
+	tagSet  api.TagSet
+	samples []sample
+}
+
+// API serves recorded series. It implements both the timeseries.StorageAPI
+// and the metadata.MetricAPI, so it can stand in for a whole deployment.
+type API struct {
+	metrics map[api.MetricKey]map[string]*series // series by metric, then by serialized tagset
+}
+
+var _ timeseries.StorageAPI = (*API)(nil)
+var _ metadata.MetricAPI = (*API)(nil)
+
+// NewAPI loads every recorded metric in the directory. The files are only read
+// here, so later changes to them aren't seen.
+func NewAPI(directory string) (*API, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	result := &API{metrics: map[api.MetricKey]map[string]*series{}}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		extension := filepath.Ext(file.Name())
+		parse, ok := parsers[extension]
+		if !ok {
+			continue
+		}
+		metric := api.MetricKey(file.Name()[:len(file.Name())-len(extension)])
+		if _, ok := result.metrics[metric]; ok {
+			return nil, fmt.Errorf("metric `%s` is recorded in more than one file", metric)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		points, err := parse(contents)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", file.Name(), err.Error())
+		}
+		result.metrics[metric] = collect(points)
+	}
+	return result, nil
+}
+
+// collect groups the points by tagset and orders each group's samples by time.
+func collect(points []point) map[string]*series {
+	result := map[string]*series{}
+	for _, point := range points {
+		key := point.TagSet.Serialize()
+		if _, ok := result[key]; !ok {
+			result[key] = &series{tagSet: point.TagSet}
+		}
+		result[key].samples = append(result[key].samples, point.sample)
+	}
+	for _, series := range result {
+		sort.Stable(byTimestamp(series.samples))
+	}
+	return result
+}
+
+// GetAllTags returns the tagsets recorded for the metric.
+func (recorded *API) GetAllTags(metric api.MetricKey, context metadata.Context) ([]api.TagSet, error) {
+	list, ok := recorded.metrics[metric]
+	if !ok {
+		return nil, metadata.NewNoSuchMetricError(string(metric))
+	}
+	tagSets := make([]api.TagSet, 0, len(list))
+	for _, series := range list {
+		tagSets = append(tagSets, series.tagSet)
+	}
+	return tagSets, nil
+}
+
+// GetAllMetrics returns every recorded metric.
+func (recorded *API) GetAllMetrics(context metadata.Context) ([]api.MetricKey, error) {
+	metrics := make([]api.MetricKey, 0, len(recorded.metrics))
+	for metric := range recorded.metrics {
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// GetMetricsForTag returns the metrics with a series having the given tag.
+func (recorded *API) GetMetricsForTag(tagKey, tagValue string, context metadata.Context) ([]api.MetricKey, error) {
+	metrics := []api.MetricKey{}
+	for metric, list := range recorded.metrics {
+		for _, series := range list {
+			if series.tagSet[tagKey] == tagValue {
+				metrics = append(metrics, metric)
+				break
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// CheckHealthy always succeeds, since everything was loaded up front.
+func (recorded *API) CheckHealthy() error {
+	return nil
+}
+
+// ChooseResolution accepts any resolution, since recorded samples are
+// resampled to whatever resolution is requested.
+func (recorded *API) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
+	if requested.Resolution() < lowerBound {
+		return lowerBound, nil
+	}
+	return requested.Resolution(), nil
+}
+
+// FetchSingleTimeseries resamples the recorded series to the requested timerange.
+func (recorded *API) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
+	series, ok := recorded.metrics[request.Metric.MetricKey][request.Metric.TagSet.Serialize()]
+	if !ok {
+		return api.Timeseries{}, timeseries.Error{
+			Metric:  request.Metric,
+			Code:    timeseries.InvalidSeriesError,
+			Message: "no such series was recorded",
+		}
+	}
+	reduce, ok := sampleBucket[request.SampleMethod]
+	if !ok {
+		reduce = sampleBucket[timeseries.SampleMean]
+	}
+	timerange := request.Timerange
+	buckets := make([][]float64, timerange.Slots())
+	for _, sample := range series.samples {
+		index := (sample.Timestamp - timerange.StartMillis()) / timerange.ResolutionMillis()
+		if sample.Timestamp < timerange.StartMillis() || int(index) >= len(buckets) {
+			continue
+		}
+		buckets[index] = append(buckets[index], sample.Value)
+	}
+	values := make([]float64, len(buckets))
+	for i, bucket := range buckets {
+		values[i] = reduce(bucket)
+	}
+	return api.Timeseries{
+		Values: values,
+		TagSet: request.Metric.TagSet,
+	}, nil
+}
+
+// FetchMultipleTimeseries fetches each of the requested series.
+func (recorded *API) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	requests := request.ToSingle()
+	list := api.SeriesList{
+		Series: make([]api.Timeseries, 0, len(requests)),
+	}
+	for i := range requests {
+		series, err := recorded.FetchSingleTimeseries(requests[i])
+		if err != nil {
+			if request.LeaveOut(requests[i].Metric, err) {
+				continue
+			}
+			return api.SeriesList{}, err
+		}
+		list.Series = append(list.Series, series)
+	}
+	return list, nil
+}
+
+// sampleBucket combines the samples which fall in one slot. Empty slots, and
+// slots holding only NaNs, are NaN.
+var sampleBucket = map[timeseries.SampleMethod]func([]float64) float64{
+	timeseries.SampleMean: func(bucket []float64) float64 {
+		sum := 0.0
+		count := 0
+		for _, value := range bucket {
+			if !math.IsNaN(value) {
+				sum += value
+				count++
+			}
+		}
+		if count == 0 {
+			return math.NaN()
+		}
+		return sum / float64(count)
+	},
+	timeseries.SampleMin: extreme(math.Min),
+	timeseries.SampleMax: extreme(math.Max),
+}
+
+func extreme(pick func(float64, float64) float64) func([]float64) float64 {
+	return func(bucket []float64) float64 {
+		result := math.NaN()
+		for _, value := range bucket {
+			if math.IsNaN(value) {
+				continue
+			}
+			if math.IsNaN(result) {
+				result = value
+				continue
+			}
+			result = pick(result, value)
+		}
+		return result
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recorded
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/metric_metadata"
+	"github.com/square/metrics/query/command"
+	"github.com/square/metrics/query/parser"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/timeseries"
+
+	"golang.org/x/net/context"
+)
+
+var recordedFiles = map[string]string{
+	"latency.csv": `timestamp,value,host,dc
+0,1,a,east
+15,3,a,east
+30,5,a,east
+60,7,a,east
+30,10,b,
+0,2,b,
+`,
+	"requests.ndjson": `{"timestamp": 0, "value": 4, "tags": {"host": "a"}}
+
+{"timestamp": 30, "value": 8, "tags": {"host": "a"}}
+`,
+	"README.txt": "ignored",
+}
+
+func writeRecording(t *testing.T, files map[string]string) string {
+	directory, err := ioutil.TempDir("", "recorded")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", name, err.Error())
+		}
+	}
+	return directory
+}
+
+func TestMetadata(t *testing.T) {
+	a := assert.New(t)
+	directory := writeRecording(t, recordedFiles)
+	defer os.RemoveAll(directory)
+	recorded, err := NewAPI(directory)
+	a.CheckError(err)
+
+	metrics, err := recorded.GetAllMetrics(metadata.Context{})
+	a.CheckError(err)
+	a.EqInt(len(metrics), 2)
+
+	tagSets, err := recorded.GetAllTags("latency", metadata.Context{})
+	a.CheckError(err)
+	a.EqInt(len(tagSets), 2)
+
+	metrics, err = recorded.GetMetricsForTag("dc", "east", metadata.Context{})
+	a.CheckError(err)
+	a.Eq(metrics, []api.MetricKey{"latency"})
+
+	_, err = recorded.GetAllTags("missing", metadata.Context{})
+	if _, ok := err.(metadata.NoSuchMetricError); !ok {
+		a.Errorf("Expected a NoSuchMetricError but got %+v", err)
+	}
+}
+
+func TestFetchSingleTimeseries(t *testing.T) {
+	directory := writeRecording(t, recordedFiles)
+	defer os.RemoveAll(directory)
+	recorded, err := NewAPI(directory)
+	if err != nil {
+		t.Fatalf("Error loading recording: %s", err.Error())
+	}
+	timerange, err := api.NewTimerange(0, 60, 30)
+	if err != nil {
+		t.Fatalf("Error creating timerange: %s", err.Error())
+	}
+	nan := math.NaN()
+	tests := []struct {
+		metric   api.TaggedMetric
+		method   timeseries.SampleMethod
+		expected []float64
+	}{
+		{api.TaggedMetric{MetricKey: "latency", TagSet: api.TagSet{"host": "a", "dc": "east"}}, timeseries.SampleMean, []float64{2, 5, 7}},
+		{api.TaggedMetric{MetricKey: "latency", TagSet: api.TagSet{"host": "a", "dc": "east"}}, timeseries.SampleMax, []float64{3, 5, 7}},
+		{api.TaggedMetric{MetricKey: "latency", TagSet: api.TagSet{"host": "a", "dc": "east"}}, timeseries.SampleMin, []float64{1, 5, 7}},
+		{api.TaggedMetric{MetricKey: "latency", TagSet: api.TagSet{"host": "b"}}, timeseries.SampleMean, []float64{2, 10, nan}},
+		{api.TaggedMetric{MetricKey: "requests", TagSet: api.TagSet{"host": "a"}}, timeseries.SampleMean, []float64{4, 8, nan}},
+	}
+	for _, test := range tests {
+		a := assert.New(t).Contextf("%+v %s", test.metric, test.method)
+		series, err := recorded.FetchSingleTimeseries(timeseries.FetchRequest{
+			Metric:         test.metric,
+			RequestDetails: timeseries.RequestDetails{Timerange: timerange, SampleMethod: test.method},
+		})
+		a.CheckError(err)
+		a.EqFloatArray(series.Values, test.expected, 1e-6)
+	}
+
+	_, err = recorded.FetchSingleTimeseries(timeseries.FetchRequest{
+		Metric:         api.TaggedMetric{MetricKey: "latency", TagSet: api.TagSet{"host": "c"}},
+		RequestDetails: timeseries.RequestDetails{Timerange: timerange},
+	})
+	if err == nil {
+		t.Errorf("Expected fetching an unrecorded series to fail")
+	}
+}
+
+func TestNewAPIErrors(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"bad header":    {"a.csv": "time,value\n0,1\n"},
+		"bad timestamp": {"a.csv": "timestamp,value\nnow,1\n"},
+		"bad value":     {"a.csv": "timestamp,value\n0,one\n"},
+		"bad json":      {"a.ndjson": "{\"timestamp\": 0, \n"},
+		"missing value": {"a.ndjson": "{\"timestamp\": 0}\n"},
+		"duplicate":     {"a.csv": "timestamp,value\n", "a.ndjson": ""},
+	} {
+		directory := writeRecording(t, files)
+		if _, err := NewAPI(directory); err == nil {
+			t.Errorf("Expected loading a recording with %s to fail", name)
+		}
+		os.RemoveAll(directory)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	a := assert.New(t)
+	directory := writeRecording(t, recordedFiles)
+	defer os.RemoveAll(directory)
+	recorded, err := NewAPI(directory)
+	if err != nil {
+		t.Fatalf("Error loading recording: %s", err.Error())
+	}
+	commandObject, err := parser.Parse("select latency[host = 'a'] + requests from 0 to 60 resolution 30ms")
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err.Error())
+	}
+	result, err := commandObject.Execute(command.ExecutionContext{
+		TimeseriesStorageAPI: recorded,
+		MetricMetadataAPI:    recorded,
+		FetchLimit:           100,
+		Ctx:                  context.Background(),
+	})
+	if err != nil {
+		t.Fatalf("Error evaluating command: %s", err.Error())
+	}
+	series := result.Body.([]command.QueryResult)[0].Series
+	a.EqInt(len(series), 1)
+	if len(series) == 1 {
+		a.EqFloatArray(series[0].Values, []float64{6, 13, math.NaN()}, 1e-6)
+	}
+}
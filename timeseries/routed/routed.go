@@ -0,0 +1,295 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package routed provides a timeseries.StorageAPI which sends each fetch only
+// to the backend responsible for the series, such as the cluster for its
+// datacenter, rather than to every backend.
+package routed
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/timeseries"
+)
+
+// A Router names the backend responsible for the given series. It returns
+// false if the series isn't known to belong to any particular backend.
+type Router func(metric api.TaggedMetric) (string, bool)
+
+// ByTag routes each series to the backend named by the value of its tag.
+// For example, ByTag("dc") sends a series tagged dc=sfo to the "sfo" backend.
+func ByTag(tag string) Router {
+	return func(metric api.TaggedMetric) (string, bool) {
+		value, ok := metric.TagSet[tag]
+		return value, ok
+	}
+}
+
+// storageAPI dispatches fetches to its backends according to the router.
+type storageAPI struct {
+	backends map[string]timeseries.StorageAPI
+	names    []string // the sorted names of the backends, which determine the merge order
+	router   Router
+	compare  bool // whether to report how much the backends disagree on series fetched from several of them
+}
+
+// NewStorageAPI creates a StorageAPI which sends each series to the backend
+// chosen by the router. Series which the router can't place (for example,
+// because the predicate didn't constrain them to a datacenter, and they have no
+// dc tag) are fetched from every backend, and the results are merged point by
+// point, preferring the first backend (by name) with a value.
+func NewStorageAPI(backends map[string]timeseries.StorageAPI, router Router) timeseries.StorageAPI {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &storageAPI{
+		backends: backends,
+		names:    names,
+		router:   router,
+	}
+}
+
+// NewComparingStorageAPI is like NewStorageAPI, but when a series is fetched
+// from more than one backend (as during a migration between clusters), it
+// also reports how much the backends disagree on it, as the mean absolute
+// difference over the points where both have a value. Each series on which
+// they disagree results in a note passed to the request's OnNote.
+//
+// This is intended for debugging, since it costs an extra pass over the data.
+func NewComparingStorageAPI(backends map[string]timeseries.StorageAPI, router Router) timeseries.StorageAPI {
+	s := NewStorageAPI(backends, router).(*storageAPI)
+	s.compare = true
+	return s
+}
+
+// route returns the backend responsible for the metric, or false if every
+// backend must be consulted.
+func (s *storageAPI) route(metric api.TaggedMetric) (string, bool) {
+	name, ok := s.router(metric)
+	if !ok {
+		return "", false
+	}
+	if _, ok := s.backends[name]; !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// ChooseResolution picks the coarsest of the backends' resolutions, so that
+// the chosen resolution is available from whichever backends are consulted.
+func (s *storageAPI) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
+	chosen := time.Duration(0)
+	for _, name := range s.names {
+		resolution, err := s.backends[name].ChooseResolution(requested, lowerBound)
+		if err != nil {
+			return 0, err
+		}
+		if resolution > chosen {
+			chosen = resolution
+		}
+	}
+	return chosen, nil
+}
+
+// CheckHealthy reports the first unhealthy backend, if there is one.
+func (s *storageAPI) CheckHealthy() error {
+	for _, name := range s.names {
+		if err := s.backends[name].CheckHealthy(); err != nil {
+			return fmt.Errorf("backend %s is unhealthy: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *storageAPI) FetchSingleTimeseries(request timeseries.FetchRequest) (api.Timeseries, error) {
+	list, err := s.FetchMultipleTimeseries(timeseries.FetchMultipleRequest{
+		Metrics:        []api.TaggedMetric{request.Metric},
+		RequestDetails: request.RequestDetails,
+	})
+	if err != nil {
+		return api.Timeseries{}, err
+	}
+	return list.Series[0], nil
+}
+
+// FetchMultipleTimeseries makes at most one request to each backend, holding
+// the series routed to it along with every series that couldn't be routed.
+func (s *storageAPI) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	// indices[name] lists the positions in request.Metrics of the series sent to the backend.
+	indices := map[string][]int{}
+	unrouted := []int{}
+	for i, metric := range request.Metrics {
+		if name, ok := s.route(metric); ok {
+			indices[name] = append(indices[name], i)
+		} else {
+			unrouted = append(unrouted, i)
+		}
+	}
+	if len(unrouted) > 0 {
+		for _, name := range s.names {
+			indices[name] = append(indices[name], unrouted...)
+		}
+	}
+
+	results := map[string]api.SeriesList{}
+	// In a lenient fetch, failed[name] holds the errors for the positions of the
+	// series which the backend left out.
+	failed := map[string]map[int]error{}
+	if request.OnSeriesError != nil {
+		// The maps are made up front, since the goroutines write to them as they
+		// start.
+		for name := range indices {
+			failed[name] = map[int]error{}
+		}
+	}
+	var mutex sync.Mutex
+	var firstErr error
+	var wait sync.WaitGroup
+	for name, positions := range indices {
+		metrics := make([]api.TaggedMetric, len(positions))
+		for i, position := range positions {
+			metrics[i] = request.Metrics[position]
+		}
+		details := request.RequestDetails
+		if request.OnSeriesError != nil {
+			byMetric := map[string]int{}
+			for _, position := range positions {
+				byMetric[metricKey(request.Metrics[position])] = position
+			}
+			name := name
+			details.OnSeriesError = func(metric api.TaggedMetric, err error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				failed[name][byMetric[metricKey(metric)]] = fmt.Errorf("backend %s: %s", name, err.Error())
+			}
+		}
+		wait.Add(1)
+		go func(name string, metrics []api.TaggedMetric, details timeseries.RequestDetails) {
+			defer wait.Done()
+			list, err := s.backends[name].FetchMultipleTimeseries(timeseries.FetchMultipleRequest{
+				Metrics:        metrics,
+				RequestDetails: details,
+			})
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			results[name] = list
+		}(name, metrics, details)
+	}
+	wait.Wait()
+	if firstErr != nil {
+		return api.SeriesList{}, firstErr
+	}
+
+	series := make([]api.Timeseries, len(request.Metrics))
+	filled := make([]bool, len(request.Metrics))
+	// When comparing, first[position] names the first backend to return the
+	// series, and original[position] holds its values from before any merging.
+	first := make([]string, len(request.Metrics))
+	original := make([][]float64, len(request.Metrics))
+	compare := s.compare && request.OnNote != nil
+	for _, name := range s.names {
+		next := 0 // the backend's results leave out the series it failed to fetch
+		for _, position := range indices[name] {
+			if _, ok := failed[name][position]; ok {
+				continue
+			}
+			if next >= len(results[name].Series) {
+				return api.SeriesList{}, fmt.Errorf("backend %s returned %d series but more were expected", name, len(results[name].Series))
+			}
+			fetched := results[name].Series[next]
+			next++
+			if !filled[position] {
+				series[position] = fetched
+				filled[position] = true
+				first[position] = name
+				original[position] = fetched.Values
+				continue
+			}
+			if compare {
+				if difference, points := disagreement(original[position], fetched.Values); difference > 0 {
+					metric := request.Metrics[position]
+					request.OnNote(fmt.Sprintf("backends %s and %s disagree on %s[%s] by %g on average over %d points", first[position], name, metric.MetricKey, metric.TagSet.Serialize(), difference, points))
+				}
+			}
+			series[position].Values = merge(series[position].Values, fetched.Values)
+		}
+	}
+	if request.OnSeriesError == nil {
+		return api.SeriesList{Series: series}, nil
+	}
+	// A series is only left out if every backend it was sent to failed to fetch
+	// it, in which case the first backend's error is reported.
+	list := api.SeriesList{Series: make([]api.Timeseries, 0, len(series))}
+	for position := range series {
+		if !filled[position] {
+			for _, name := range s.names {
+				if err, ok := failed[name][position]; ok {
+					request.OnSeriesError(request.Metrics[position], err)
+					break
+				}
+			}
+			continue
+		}
+		list.Series = append(list.Series, series[position])
+	}
+	return list, nil
+}
+
+// merge fills the NaN points of the first series with the second's values.
+func merge(values []float64, other []float64) []float64 {
+	result := make([]float64, len(values))
+	for i := range values {
+		result[i] = values[i]
+		if math.IsNaN(result[i]) && i < len(other) {
+			result[i] = other[i]
+		}
+	}
+	return result
+}
+
+// disagreement returns the mean absolute difference between the two series
+// over the points where both have a value, along with the number of such points.
+func disagreement(values []float64, other []float64) (float64, int) {
+	total := 0.0
+	points := 0
+	for i := range values {
+		if i >= len(other) || math.IsNaN(values[i]) || math.IsNaN(other[i]) {
+			continue
+		}
+		total += math.Abs(values[i] - other[i])
+		points++
+	}
+	if points == 0 {
+		return 0, 0
+	}
+	return total / float64(points), points
+}
+
+// metricKey identifies the series uniquely.
+func metricKey(metric api.TaggedMetric) string {
+	return fmt.Sprintf("%q%q", metric.MetricKey, metric.TagSet.Serialize())
+}
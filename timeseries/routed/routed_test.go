@@ -0,0 +1,208 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routed
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+	"github.com/square/metrics/timeseries"
+)
+
+// recordingAPI serves fixed values for each host, and records the hosts it was asked for.
+type recordingAPI struct {
+	timeseries.StorageAPI
+	values     map[string][]float64
+	broken     map[string]bool // hosts whose series fail to fetch
+	dropped    map[string]bool // hosts whose series are left out without an error
+	resolution time.Duration
+
+	mutex     sync.Mutex
+	requested []string
+}
+
+func (r *recordingAPI) FetchMultipleTimeseries(request timeseries.FetchMultipleRequest) (api.SeriesList, error) {
+	list := api.SeriesList{}
+	for _, metric := range request.Metrics {
+		r.mutex.Lock()
+		r.requested = append(r.requested, metric.TagSet["host"])
+		r.mutex.Unlock()
+		if r.broken[metric.TagSet["host"]] {
+			err := fmt.Errorf("corrupt series for host %s", metric.TagSet["host"])
+			if request.LeaveOut(metric, err) {
+				continue
+			}
+			return api.SeriesList{}, err
+		}
+		if r.dropped[metric.TagSet["host"]] {
+			continue
+		}
+		values, ok := r.values[metric.TagSet["host"]]
+		if !ok {
+			values = []float64{math.NaN(), math.NaN()}
+		}
+		list.Series = append(list.Series, api.Timeseries{Values: values, TagSet: metric.TagSet})
+	}
+	return list, nil
+}
+
+func (r *recordingAPI) ChooseResolution(requested api.Timerange, lowerBound time.Duration) (time.Duration, error) {
+	return r.resolution, nil
+}
+
+func (r *recordingAPI) CheckHealthy() error {
+	if r.values == nil {
+		return fmt.Errorf("no data")
+	}
+	return nil
+}
+
+func TestRouting(t *testing.T) {
+	a := assert.New(t)
+	sfo := &recordingAPI{values: map[string][]float64{"a": {1, 2}, "c": {5, math.NaN()}}, resolution: time.Minute}
+	nyc := &recordingAPI{values: map[string][]float64{"b": {3, 4}, "c": {6, 7}}, resolution: time.Hour}
+	routed := NewStorageAPI(map[string]timeseries.StorageAPI{"sfo": sfo, "nyc": nyc}, ByTag("dc"))
+
+	list, err := routed.FetchMultipleTimeseries(timeseries.FetchMultipleRequest{
+		Metrics: []api.TaggedMetric{
+			{MetricKey: "cpu", TagSet: api.TagSet{"dc": "sfo", "host": "a"}},
+			{MetricKey: "cpu", TagSet: api.TagSet{"dc": "nyc", "host": "b"}},
+			{MetricKey: "cpu", TagSet: api.TagSet{"host": "c"}},              // no dc, so it's fetched from both
+			{MetricKey: "cpu", TagSet: api.TagSet{"dc": "lax", "host": "d"}}, // an unknown dc is fetched from both
+		},
+	})
+	a.CheckError(err)
+	a.EqInt(len(list.Series), 4)
+	a.EqFloatArray(list.Series[0].Values, []float64{1, 2}, 0)
+	a.EqFloatArray(list.Series[1].Values, []float64{3, 4}, 0)
+	a.EqFloatArray(list.Series[2].Values, []float64{6, 7}, 0) // nyc sorts first, so its values win
+	a.EqFloatArray(list.Series[3].Values, []float64{math.NaN(), math.NaN()}, 0)
+	a.Eq(list.Series[2].TagSet, api.TagSet{"host": "c"})
+	a.Eq(sfo.requested, []string{"a", "c", "d"})
+	a.Eq(nyc.requested, []string{"b", "c", "d"})
+
+	single, err := routed.FetchSingleTimeseries(timeseries.FetchRequest{
+		Metric: api.TaggedMetric{MetricKey: "cpu", TagSet: api.TagSet{"dc": "sfo", "host": "c"}},
+	})
+	a.CheckError(err)
+	a.EqFloatArray(single.Values, []float64{5, math.NaN()}, 0)
+	a.Eq(nyc.requested, []string{"b", "c", "d"}) // nyc wasn't consulted
+
+	resolution, err := routed.ChooseResolution(api.Timerange{}, 0)
+	a.CheckError(err)
+	a.Eq(resolution, time.Hour)
+
+	a.CheckError(routed.CheckHealthy())
+	nyc.values = nil
+	if routed.CheckHealthy() == nil {
+		a.Errorf("expected an unhealthy backend to be reported")
+	}
+}
+
+func TestRoutingLenient(t *testing.T) {
+	a := assert.New(t)
+	sfo := &recordingAPI{values: map[string][]float64{"a": {1, 2}, "c": {5, math.NaN()}}, broken: map[string]bool{"b": true, "d": true}}
+	nyc := &recordingAPI{values: map[string][]float64{"b": {3, 4}, "c": {6, 7}}, broken: map[string]bool{"a": true, "c": true, "d": true}}
+	routed := NewStorageAPI(map[string]timeseries.StorageAPI{"sfo": sfo, "nyc": nyc}, ByTag("dc"))
+	metrics := []api.TaggedMetric{
+		{MetricKey: "cpu", TagSet: api.TagSet{"dc": "sfo", "host": "a"}},
+		{MetricKey: "cpu", TagSet: api.TagSet{"dc": "nyc", "host": "b"}},
+		{MetricKey: "cpu", TagSet: api.TagSet{"host": "c"}}, // broken in nyc, but sfo has it
+		{MetricKey: "cpu", TagSet: api.TagSet{"host": "d"}}, // broken in both
+		{MetricKey: "cpu", TagSet: api.TagSet{"dc": "nyc", "host": "a"}},
+	}
+
+	if _, err := routed.FetchMultipleTimeseries(timeseries.FetchMultipleRequest{Metrics: metrics}); err == nil {
+		a.Errorf("expected a strict fetch to fail")
+	}
+
+	failures := map[string]string{}
+	list, err := routed.FetchMultipleTimeseries(timeseries.FetchMultipleRequest{
+		Metrics: metrics,
+		RequestDetails: timeseries.RequestDetails{
+			OnSeriesError: func(metric api.TaggedMetric, err error) {
+				failures[metric.TagSet.Serialize()] = err.Error()
+			},
+		},
+	})
+	a.CheckError(err)
+	a.EqInt(len(list.Series), 3)
+	a.Eq(list.Series[0].TagSet, api.TagSet{"dc": "sfo", "host": "a"})
+	a.EqFloatArray(list.Series[0].Values, []float64{1, 2}, 0)
+	a.Eq(list.Series[1].TagSet, api.TagSet{"dc": "nyc", "host": "b"})
+	a.EqFloatArray(list.Series[1].Values, []float64{3, 4}, 0)
+	a.Eq(list.Series[2].TagSet, api.TagSet{"host": "c"})
+	a.EqFloatArray(list.Series[2].Values, []float64{5, math.NaN()}, 0)
+	a.EqInt(len(failures), 2)
+	a.EqString(failures["host=d"], "backend nyc: corrupt series for host d")
+	a.EqString(failures["dc=nyc,host=a"], "backend nyc: corrupt series for host a")
+}
+
+func TestRoutingShortResult(t *testing.T) {
+	a := assert.New(t)
+	sfo := &recordingAPI{values: map[string][]float64{"a": {1, 2}}, dropped: map[string]bool{"b": true}}
+	routed := NewStorageAPI(map[string]timeseries.StorageAPI{"sfo": sfo}, ByTag("dc"))
+	_, err := routed.FetchMultipleTimeseries(timeseries.FetchMultipleRequest{
+		Metrics: []api.TaggedMetric{
+			{MetricKey: "cpu", TagSet: api.TagSet{"dc": "sfo", "host": "a"}},
+			{MetricKey: "cpu", TagSet: api.TagSet{"dc": "sfo", "host": "b"}},
+		},
+	})
+	if err == nil {
+		a.Errorf("expected an error when a backend returns too few series")
+	}
+}
+
+func TestMergePrefersFirst(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	a.EqFloatArray(merge([]float64{1, nan, nan}, []float64{2, 3, nan}), []float64{1, 3, nan}, 0)
+}
+
+func TestRoutingCompare(t *testing.T) {
+	a := assert.New(t)
+	nan := math.NaN()
+	sfo := &recordingAPI{values: map[string][]float64{"a": {1, 2, nan}, "b": {3, 4, 5}, "c": {nan, 8, 9}}}
+	nyc := &recordingAPI{values: map[string][]float64{"a": {2, 4, 6}, "b": {3, 4, 5}, "c": {7, nan, nan}}}
+	backends := map[string]timeseries.StorageAPI{"sfo": sfo, "nyc": nyc}
+	metrics := []api.TaggedMetric{
+		{MetricKey: "cpu", TagSet: api.TagSet{"host": "a"}}, // differs by 1 and 2
+		{MetricKey: "cpu", TagSet: api.TagSet{"host": "b"}}, // the backends agree
+		{MetricKey: "cpu", TagSet: api.TagSet{"host": "c"}}, // the backends never overlap
+	}
+	notes := []string{}
+	request := timeseries.FetchMultipleRequest{
+		Metrics: metrics,
+		RequestDetails: timeseries.RequestDetails{
+			OnNote: func(note string) { notes = append(notes, note) },
+		},
+	}
+
+	list, err := NewComparingStorageAPI(backends, ByTag("dc")).FetchMultipleTimeseries(request)
+	a.CheckError(err)
+	a.EqFloatArray(list.Series[0].Values, []float64{2, 4, 6}, 0)
+	a.EqFloatArray(list.Series[2].Values, []float64{7, 8, 9}, 0)
+	a.Eq(notes, []string{"backends nyc and sfo disagree on cpu[host=a] by 1.5 on average over 2 points"})
+
+	notes = []string{}
+	_, err = NewStorageAPI(backends, ByTag("dc")).FetchMultipleTimeseries(request)
+	a.CheckError(err)
+	a.EqInt(len(notes), 0)
+}
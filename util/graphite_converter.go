@@ -20,6 +20,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 
 	"github.com/square/metrics/api"
 	"github.com/square/metrics/log"
@@ -36,17 +37,24 @@ var _ GraphiteConverter = (*RuleBasedGraphiteConverter)(nil)
 
 type RuleBasedGraphiteConverter struct {
 	Ruleset RuleSet
+	mutex   sync.RWMutex // guards Ruleset, which Reload replaces
 }
 
 func (g *RuleBasedGraphiteConverter) EnableStats() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 	g.Ruleset.EnableStats()
 }
 
 func (g *RuleBasedGraphiteConverter) ToGraphiteName(metric api.TaggedMetric) (GraphiteMetric, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
 	return g.Ruleset.ToGraphiteName(metric)
 }
 
 func (g *RuleBasedGraphiteConverter) ToTaggedName(metric GraphiteMetric) (api.TaggedMetric, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
 	match, matched := g.Ruleset.MatchRule(string(metric))
 	if matched {
 		return match, nil
@@ -54,6 +62,24 @@ func (g *RuleBasedGraphiteConverter) ToTaggedName(metric GraphiteMetric) (api.Ta
 	return api.TaggedMetric{}, newNoMatch()
 }
 
+// Reload loads the rules in the given directory and swaps them in for the
+// current ones, keeping statistics enabled if they were. Conversions already
+// under way finish with the old rules. If the rules can't be loaded, the
+// current ones are kept and the error is returned.
+func (g *RuleBasedGraphiteConverter) Reload(conversionRulesPath string) error {
+	ruleset, err := LoadRules(conversionRulesPath)
+	if err != nil {
+		return err
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.Ruleset.statisticsEnabled {
+		ruleset.EnableStats()
+	}
+	g.Ruleset = ruleset
+	return nil
+}
+
 func LoadRules(conversionRulesPath string) (RuleSet, error) {
 	ruleSet := RuleSet{
 		Rules: []Rule{},
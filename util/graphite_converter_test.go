@@ -0,0 +1,76 @@
+// Copyright 2015 - 2016 Square Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/square/metrics/api"
+	"github.com/square/metrics/testing_support/assert"
+)
+
+func TestRuleBasedGraphiteConverterReload(t *testing.T) {
+	a := assert.New(t)
+	directory, err := ioutil.TempDir("", "rules")
+	a.CheckError(err)
+	defer os.RemoveAll(directory)
+	write := func(contents string) {
+		a.CheckError(ioutil.WriteFile(filepath.Join(directory, "rules.yaml"), []byte(contents), 0644))
+	}
+
+	write(`
+rules:
+  -
+    pattern: foo.%host%
+    metric_key: foo
+`)
+	ruleset, err := LoadRules(directory)
+	a.CheckError(err)
+	converter := &RuleBasedGraphiteConverter{Ruleset: ruleset}
+	converter.EnableStats()
+	metric, err := converter.ToTaggedName("foo.a")
+	a.CheckError(err)
+	a.Eq(metric, api.TaggedMetric{MetricKey: "foo", TagSet: api.TagSet{"host": "a"}})
+	if _, err := converter.ToTaggedName("bar.a"); err == nil {
+		a.Errorf("Expected bar.a not to match the original rules")
+	}
+
+	write(`
+rules:
+  -
+    pattern: bar.%host%
+    metric_key: bar
+`)
+	a.CheckError(converter.Reload(directory))
+	metric, err = converter.ToTaggedName("bar.a")
+	a.CheckError(err)
+	a.Eq(metric, api.TaggedMetric{MetricKey: "bar", TagSet: api.TagSet{"host": "a"}})
+	if _, err := converter.ToTaggedName("foo.a"); err == nil {
+		a.Errorf("Expected foo.a not to match the reloaded rules")
+	}
+	a.EqBool(converter.Ruleset.statisticsEnabled, true)
+
+	// Rules which can't be loaded leave the current ones in place.
+	write(`rules: [`)
+	if err := converter.Reload(directory); err == nil {
+		a.Errorf("Expected an error reloading invalid rules")
+	}
+	metric, err = converter.ToTaggedName("bar.a")
+	a.CheckError(err)
+	a.Eq(metric, api.TaggedMetric{MetricKey: "bar", TagSet: api.TagSet{"host": "a"}})
+}